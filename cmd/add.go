@@ -44,8 +44,15 @@ var cmdRet int
 var cmdFile string
 var cmdCwdMatters bool
 var cmdChangeHome bool
+var cmdSkipIfUpToDate bool
+var cmdStdCapture string
+var cmdStdCompress bool
+var cmdKillGrace string
+var cmdTimeLimit string
+var cmdRetryPolicy string
 var cmdRepGroup string
 var cmdDepGroups string
+var cmdLimitGroups string
 var cmdCmdDeps string
 var cmdGroupDeps string
 var cmdOnFailure string
@@ -58,6 +65,13 @@ var cmdOsPrefix string
 var cmdOsUsername string
 var cmdPostCreationScript string
 var cmdOsRAM int
+var cmdLSFQueue string
+var cmdLSFResources string
+var cmdLSFHosts string
+var cmdLSFProject string
+var cmdLSFGroup string
+var cmdLSFMisc string
+var cmdParams []string
 
 // addCmd represents the add command
 var addCmd = &cobra.Command{
@@ -71,9 +85,11 @@ specific options using a JSON object in (tab separated) column 2, or
 alternatively have only a JSON object in column 1 that also specifies the
 command as one of the name:value pairs. The possible options are:
 
-cmd cwd cwd_matters change_home on_failure on_success on_exit mounts req_grp
-memory time override cpus disk priority retries rep_grp dep_grps deps cmd_deps
-cloud_os cloud_username cloud_ram cloud_script env
+cmd cwd cwd_matters change_home on_failure on_success on_exit mounts inputs
+outputs skip_if_up_to_date std_capture std_compress req_grp memory time
+override cpus disk priority retries retry_policy kill_grace time_limit rep_grp
+dep_grps deps cmd_deps limit_grps cloud_os cloud_username cloud_ram cloud_script
+lsf_queue lsf_resources lsf_hosts lsf_project lsf_group lsf_misc env params
 
 If any of these will be the same for all your commands, you can instead specify
 them as flags (which are treated as defaults in the case that they are
@@ -120,12 +136,18 @@ currently available behaviours are: "cleanup_all", which takes a boolean value
 and if true will completely delete the actual working directory created when
 cwd_matters is false (no effect when cwd_matters is true); "cleanup", which is
 like cleanup_all except that it doesn't delete files that have been specified as
-inputs or outputs [since you can't currently specify this, the current behaviour
-is identical to cleanup_all]; and "run", which takes a string command to run
-after the main cmd runs. For example [{"run":"cp error.log
+outputs; "run", which takes a string command to run after the main cmd runs;
+and "copy_to_manager", which takes an array of paths relative to the actual
+working directory, and copies those files back to the machine that wr manager
+is running on. For example [{"run":"cp error.log
 /shared/logs/this.log"},{"cleanup":true}] would copy a log file that your cmd
 generated to describe its problems to some shared location and then delete all
-files created by your cmd.
+files created by your cmd. [{"copy_to_manager":["error.log"]},{"cleanup":true}]
+would instead copy the log file back to the manager, where it will be stored
+beneath the managercopydir configured for wr (in a directory named after your
+rep_grp, in a sub-directory named after the job's id), and be viewable from
+'wr status' and the web interface. Files bigger than the configured
+managercopymaxmb can't be copied.
 
 "on_success" is exactly like on_failure, except that the behaviours trigger when
 your cmd exits 0.
@@ -144,6 +166,26 @@ your mounted directories and any mount cache directories, so that nothing on
 your remote file systems gets deleted. Unmounting will get rid of them though,
 so you would still end up with a "cleaned" workspace.
 
+"inputs" and "outputs" are arrays of the paths of the files your cmd reads and
+writes. Relative paths are relative to the actual working directory, so with
+mounts at the default mount point they can refer to files in your remote file
+systems. The "cleanup" behaviour won't delete your outputs, and if your cmd
+exits 0 but any of its outputs don't exist, it will be treated as having failed
+(and retried as normal). If "skip_if_up_to_date" is true, make-style, if all of
+your outputs already exist and are newer than all of your inputs, your cmd is
+marked as complete without being run. 'wr status' shows the declared files and
+whether they exist.
+
+Normally only the first and last 4KB of your cmd's STDOUT and STDERR are kept
+(and only if it fails). "std_capture" lets you keep all of it: set it to a local
+directory (relative paths are relative to cwd) or an S3 location like
+s3://[profile@]bucket/path (using the same credentials as mounts), and the
+complete STDOUT and STDERR will be written to files there named after the
+command's key. Setting "std_compress" to true gzip compresses them. For a local
+directory to be useful it should be on a file system that the manager can also
+see, since 'wr status --std' and the web interface get ranges of these files
+from the manager.
+
 "req_grp" is an arbitrary string that identifies the kind of commands you are
 adding, such that future commands you add with this same requirements group are
 likely to have similar memory and time requirements. It defaults to the basename
@@ -157,10 +199,13 @@ values should do the same, eg. "30m" for 30 minutes, or "1h" for 1 hour.
 
 The manager learns how much memory and time commands in the same req_grp
 actually used in the past, and will use its own values unless you set an
-override. For this learning to work well, you should have reason to believe that
-all the commands you add with the same req_grp will have similar memory and time
-requirements, and you should pick the name in a consistent way such that you'll
-use it again in the future.
+override. It also learns how many CPU cores they effectively used (their CPU
+time divided by their wall time) and, for commands that weren't cwd_matters, how
+much disk space they used in their working directory and $TMPDIR; these are
+used in the same way as memory and time. For this learning to work well, you
+should have reason to believe that all the commands you add with the same
+req_grp will have similar resource requirements, and you should pick the name in
+a consistent way such that you'll use it again in the future.
 
 For example, if you want to run an executable called "exop", and you know that
 the memory and time requirements of exop vary with the size of its input file,
@@ -173,13 +218,13 @@ sizes in a certain range, and then provide a req_grp that describes this, eg.
 only learning about how good your estimates are! The name of your executable
 should almost always be part of the req_grp name.)
 
-"override" defines if your memory and time should be used instead of the
-manager's estimate. Possible values are:
-0 = do not override wr's learned values for memory and time (if any)
+"override" defines if your memory, time, cpus and disk should be used instead of
+the manager's estimate. Possible values are:
+0 = do not override wr's learned values for memory, time, cpus and disk (if any)
 1 = override if yours are higher
 2 = always override
 
-"cpus" tells wr manager exactly how many CPU cores your command needs.
+"cpus" tells wr manager how many CPU cores your command needs.
 
 "disk" tells wr manager how much free disk space (in GB) your command needs. If
 you know that where your command will store its outputs to will not run out of
@@ -201,6 +246,41 @@ more memory/time reserved). Once this number of retries is reached, the command
 will be 'buried' until you take manual action to fix the problem and press the
 retry button in the web interface.
 
+"retry_policy" (or the --retry_policy option, which takes a JSON string) lets
+you control which failures get retried, and when and where. It is an object
+like:
+{"exit_codes":[75],"fail_reasons":["signal","lost"],"backoff":"1m",
+"max_backoff":"1h","jitter":0.5,"other_host":true}
+where every key is optional. If exit_codes and/or fail_reasons are supplied,
+commands are only retried if they exit with one of those exit codes, or fail
+for one of those reasons; other failures get the command buried immediately.
+The possible reasons are: env, cwd, start, cperm, cfound, cexit, exit (any
+non-0 exit code), ram, time, abnormal, lost, signal, resource, mount, upload,
+killed, outputs and capture. backoff (default 30s) is how long to wait before
+retrying, and if max_backoff is greater, the wait doubles after each failure up
+to max_backoff. jitter (between 0 and 1) randomly shortens each wait by up to
+that proportion, so that lots of commands that failed together don't all retry
+together. other_host true means that for a short while after waiting, the
+command prefers not to run on any host it failed on, giving other hosts a chance
+to run it first (if no other host picks it up, or there is only one host, it
+runs on a host it failed on anyway). The outcome of each attempt can be seen
+with 'wr status'.
+
+"kill_grace" is how long your command gets to clean up when it has to be killed
+(because you ran 'wr kill', it used more memory than it was allowed, or the
+runner running it was told to stop by the job scheduler). Your command and every
+process it started are first sent SIGTERM, and then, if any of them are still
+running after kill_grace, SIGKILL. Values should specify a unit, eg. "30s" for
+30 seconds. It defaults to 10s.
+
+"time_limit" is an optional hard limit on how long your command can run for.
+Normally commands are allowed to run for longer than their expected time, but
+with a time_limit they are killed (as described for kill_grace) once they reach
+it, and then retried with more time reserved (subject to "retries"). It can be
+an absolute duration such as "3h", or a multiple of the expected time such as
+"2x" (in which case the limit grows along with the time reserved on retry). By
+default there is no limit.
+
 "rep_grp" is an arbitrary group you can give your commands so you can query
 their status later. This is only used for reporting and presentation purposes
 when viewing status.
@@ -219,6 +299,12 @@ name:value pairs (if cwd doesn't matter for a cmd, provide it as an empty
 string). These are static dependencies; once resolved they do not get re-
 evaluated.
 
+"limit_grps" is an array of arbitrary names you can associate with a command,
+for when it uses a scarce resource. On its own this does nothing, but if you use
+"wr limit set" to set a limit on one of the names, no more than that many
+commands with that name in their limit_grps will run at once (see "wr limit -h"
+for details).
+
 The "cloud_*" related options let you override the defaults of your cloud
 deployment. For example, if you do 'wr cloud deploy --os "Ubuntu 16" --os_ram
 2048 -u ubuntu -s ~/my_ubuntu_post_creation_script.sh', any commands you add
@@ -227,6 +313,18 @@ will by default run on cloud nodes running Ubuntu. If you set "cloud_os" to
 "cloud_script" to "~/my_centos_post_creation_script.sh", then this command will
 run on a cloud node running CentOS (with at least 4GB ram).
 
+The "lsf_*" related options only apply when the manager is using the LSF
+scheduler, and let you constrain where and how your commands run. Their values
+become the corresponding bsub options: "lsf_queue" is -q (normally the manager
+picks the best queue for you), "lsf_resources" is an extra -R resource
+requirement string, eg. "select[type==X86_64]", "lsf_hosts" is -m (space
+separated host names or host groups), "lsf_project" is -P and "lsf_group" is -G.
+"lsf_misc" is for anything else: whitespace separated bsub options that are
+passed through as-is (quoting is not supported). Commands with different lsf_*
+options are run by different runners. If the queue you ask for doesn't exist,
+you aren't allowed to use it, it can't accommodate your commands' memory and
+time, or doesn't include the hosts you ask for, your commands will be buried.
+
 "env" is an array of "key=value" environment variables, which override or add to
 the environment variables the command will see when it runs. The base variables
 that are overwritten depend on if you run 'wr add' on the same machine as you
@@ -235,7 +333,25 @@ base variables as they were at the moment in time you run 'wr add', so to set a
 certain environment variable for all commands, you could instead just set it
 prior to calling 'wr add'. In the remote case the command will use base
 variables as they were on the machine where the command is executed when that
-machine was started.`,
+machine was started.
+
+"params" turns your command in to a template for an array of commands (a
+parameter sweep). It is an array of "name=spec" strings, where spec is a range
+like "1-100" or "1-100:5" (start-end:step), "@/path/to/file" to take values
+from a file with one value per line, or a comma-separated list of values. Every
+{{name}} in your cmd (and cwd, if cwd_matters) is replaced with each of the
+values in turn, and {{index}} is replaced with the (1-based) index of the
+command within the array. If you specify more than one param you get every
+combination of their values (the first param varies slowest). Each param must be
+used in cmd or cwd. {{name}} and {{index}} can also be used in dep_grps, deps,
+inputs and outputs.
+For example, --param chr=1-22 --param sample=@samples.txt with the command
+"caller -c {{chr}} {{sample}}.bam > {{sample}}.{{chr}}.vcf" would add 22
+commands for every sample in samples.txt. The commands all get the same
+rep_grp, and an individual command can later be referred to in 'wr status' as
+rep_grp[index], or a range of them as rep_grp[first-last]. The same params can
+be given to every command in your file with the --param flag (which can be
+repeated).`,
 	Run: func(combraCmd *cobra.Command, args []string) {
 		// check the command line options
 		if cmdFile == "" {
@@ -243,20 +359,29 @@ machine was started.`,
 		}
 
 		jd := &jobqueue.JobDefaults{
-			RepGrp:      cmdRepGroup,
-			ReqGrp:      reqGroup,
-			CwdMatters:  cmdCwdMatters,
-			ChangeHome:  cmdChangeHome,
-			CPUs:        cmdCPUs,
-			Disk:        cmdDisk,
-			Override:    cmdOvr,
-			Priority:    cmdPri,
-			Retries:     cmdRet,
-			Env:         cmdEnv,
-			CloudOS:     cmdOsPrefix,
-			CloudUser:   cmdOsUsername,
-			CloudScript: cmdPostCreationScript,
-			CloudOSRam:  cmdOsRAM,
+			RepGrp:         cmdRepGroup,
+			ReqGrp:         reqGroup,
+			CwdMatters:     cmdCwdMatters,
+			ChangeHome:     cmdChangeHome,
+			SkipIfUpToDate: cmdSkipIfUpToDate,
+			StdCapture:     cmdStdCapture,
+			StdCompress:    cmdStdCompress,
+			CPUs:           cmdCPUs,
+			Disk:           cmdDisk,
+			Override:       cmdOvr,
+			Priority:       cmdPri,
+			Retries:        cmdRet,
+			Env:            cmdEnv,
+			CloudOS:        cmdOsPrefix,
+			CloudUser:      cmdOsUsername,
+			CloudScript:    cmdPostCreationScript,
+			CloudOSRam:     cmdOsRAM,
+			LSFQueue:       cmdLSFQueue,
+			LSFResources:   cmdLSFResources,
+			LSFHosts:       cmdLSFHosts,
+			LSFProject:     cmdLSFProject,
+			LSFGroup:       cmdLSFGroup,
+			LSFMisc:        cmdLSFMisc,
 		}
 
 		if jd.RepGrp == "" {
@@ -281,11 +406,38 @@ machine was started.`,
 				die("--time was not specified correctly: %s", err)
 			}
 		}
+		if cmdKillGrace != "" {
+			jd.KillGrace, err = time.ParseDuration(cmdKillGrace)
+			if err != nil {
+				die("--kill_grace was not specified correctly: %s", err)
+			}
+		}
+		if cmdTimeLimit != "" {
+			jd.TimeLimit, jd.TimeLimitMult, err = jobqueue.ParseTimeLimit(cmdTimeLimit)
+			if err != nil {
+				die("--time_limit was not specified correctly: %s", err)
+			}
+		}
+		if cmdRetryPolicy != "" {
+			var rpj jobqueue.RetryPolicyViaJSON
+			err = json.Unmarshal([]byte(cmdRetryPolicy), &rpj)
+			if err != nil {
+				die("bad --retry_policy: %s", err)
+			}
+			jd.RetryPolicy, err = rpj.RetryPolicy()
+			if err != nil {
+				die("bad --retry_policy: %s", err)
+			}
+		}
 
 		if cmdDepGroups != "" {
 			jd.DepGroups = strings.Split(cmdDepGroups, ",")
 		}
 
+		if cmdLimitGroups != "" {
+			jd.LimitGroups = strings.Split(cmdLimitGroups, ",")
+		}
+
 		if cmdCmdDeps != "" {
 			cols := strings.Split(cmdCmdDeps, ",")
 			if len(cols)%2 != 0 {
@@ -326,6 +478,13 @@ machine was started.`,
 			jd.MountConfigs = mountParse(mountJSON, mountSimple)
 		}
 
+		if len(cmdParams) > 0 {
+			jd.ArrayParams, err = jobqueue.ParseArrayParams(cmdParams)
+			if err != nil {
+				die("bad --param: %s", err)
+			}
+		}
+
 		// open file or set up to read from STDIN
 		var reader io.Reader
 		if cmdFile == "-" {
@@ -341,7 +500,7 @@ machine was started.`,
 		// we'll default to pwd if the manager is on the same host as us, or if
 		// cwd matters, /tmp otherwise
 		timeout := time.Duration(timeoutint) * time.Second
-		jq, err := jobqueue.Connect(addr, timeout)
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
 		if err != nil {
 			die("%s", err)
 		}
@@ -449,17 +608,24 @@ func init() {
 	addCmd.Flags().StringVarP(&cmdFile, "file", "f", "-", "file containing your commands; - means read from STDIN")
 	addCmd.Flags().StringVarP(&cmdRepGroup, "report_grp", "i", "manually_added", "reporting group for your commands")
 	addCmd.Flags().StringVarP(&cmdDepGroups, "dep_grps", "e", "", "comma-separated list of dependency groups")
+	addCmd.Flags().StringVar(&cmdLimitGroups, "limit_grps", "", "comma-separated list of limit groups")
 	addCmd.Flags().StringVarP(&cmdCwd, "cwd", "c", "", "base for the command's working dir")
 	addCmd.Flags().BoolVar(&cmdCwdMatters, "cwd_matters", false, "--cwd should be used as the actual working directory")
 	addCmd.Flags().BoolVar(&cmdChangeHome, "change_home", false, "when not --cwd_matters, set $HOME to the actual working directory")
+	addCmd.Flags().BoolVar(&cmdSkipIfUpToDate, "skip_if_up_to_date", false, "don't run commands whose declared outputs are newer than their inputs")
+	addCmd.Flags().StringVar(&cmdStdCapture, "std_capture", "", "local directory or S3 location to write the complete STDOUT and STDERR of commands to")
+	addCmd.Flags().BoolVar(&cmdStdCompress, "std_compress", false, "gzip compress the files written to --std_capture")
 	addCmd.Flags().StringVarP(&reqGroup, "req_grp", "g", "", "group name for commands with similar reqs")
 	addCmd.Flags().StringVarP(&cmdMem, "memory", "m", "1G", "peak mem est. [specify units such as M for Megabytes or G for Gigabytes]")
 	addCmd.Flags().StringVarP(&cmdTime, "time", "t", "1h", "max time est. [specify units such as m for minutes or h for hours]")
 	addCmd.Flags().IntVar(&cmdCPUs, "cpus", 1, "cpu cores needed")
 	addCmd.Flags().IntVar(&cmdDisk, "disk", 0, "number of GB of disk space required [0 means do not check disk space] (default 0)")
-	addCmd.Flags().IntVarP(&cmdOvr, "override", "o", 0, "[0|1|2] should your mem/time estimates override? (default 0)")
+	addCmd.Flags().IntVarP(&cmdOvr, "override", "o", 0, "[0|1|2] should your mem/time/cpus/disk estimates override? (default 0)")
 	addCmd.Flags().IntVarP(&cmdPri, "priority", "p", 0, "[0-255] command priority (default 0)")
 	addCmd.Flags().IntVarP(&cmdRet, "retries", "r", 3, "[0-255] number of automatic retries for failed commands")
+	addCmd.Flags().StringVar(&cmdRetryPolicy, "retry_policy", "", "which failures to retry, with what backoff, in JSON format")
+	addCmd.Flags().StringVar(&cmdKillGrace, "kill_grace", "", "time commands get between SIGTERM and SIGKILL when killed [specify units such as s for seconds] (default 10s)")
+	addCmd.Flags().StringVar(&cmdTimeLimit, "time_limit", "", "hard limit on command run time, after which they are killed and retried with more time [eg. 3h, or a multiple of --time such as 2x] (default none)")
 	addCmd.Flags().StringVar(&cmdCmdDeps, "cmd_deps", "", "dependencies of your commands, in the form \"command1,cwd1,command2,cwd2...\"")
 	addCmd.Flags().StringVarP(&cmdGroupDeps, "deps", "d", "", "dependencies of your commands, in the form \"dep_grp1,dep_grp2...\"")
 	addCmd.Flags().StringVar(&cmdOnFailure, "on_failure", "", "behaviours to carry out when cmds fails, in JSON format")
@@ -471,7 +637,14 @@ func init() {
 	addCmd.Flags().StringVar(&cmdOsUsername, "cloud_username", "", "in the cloud, username needed to log in to the OS image specified by --cloud_os")
 	addCmd.Flags().IntVar(&cmdOsRAM, "cloud_ram", 0, "in the cloud, ram (MB) needed by the OS image specified by --cloud_os")
 	addCmd.Flags().StringVar(&cmdPostCreationScript, "cloud_script", "", "in the cloud, path to a start-up script that will be run on the servers created to run these commands")
+	addCmd.Flags().StringVar(&cmdLSFQueue, "lsf_queue", "", "in LSF, the queue to submit the commands to (bsub -q)")
+	addCmd.Flags().StringVar(&cmdLSFResources, "lsf_resources", "", "in LSF, an additional resource requirement string (bsub -R)")
+	addCmd.Flags().StringVar(&cmdLSFHosts, "lsf_hosts", "", "in LSF, space separated hosts or host groups to run the commands on (bsub -m)")
+	addCmd.Flags().StringVar(&cmdLSFProject, "lsf_project", "", "in LSF, the project to assign the commands to (bsub -P)")
+	addCmd.Flags().StringVar(&cmdLSFGroup, "lsf_group", "", "in LSF, the user group to submit the commands under (bsub -G)")
+	addCmd.Flags().StringVar(&cmdLSFMisc, "lsf_misc", "", "in LSF, whitespace separated additional bsub options")
 	addCmd.Flags().StringVar(&cmdEnv, "env", "", "comma-separated list of key=value environment variables to set before running the commands")
+	addCmd.Flags().StringArrayVar(&cmdParams, "param", []string{}, "make your commands templates for job arrays, with a name=spec parameter [repeatable]")
 	addCmd.Flags().BoolVar(&cmdReRun, "rerun", false, "re-run any commands that you add that had been previously added and have since completed")
 
 	addCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
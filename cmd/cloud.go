@@ -340,7 +340,7 @@ within OpenStack.`,
 		}
 
 		info("should you need to, you can ssh to this server using `ssh -i %s %s@%s`", keyPath, osUsername, server.IP)
-		info("wr's web interface can be reached locally at http://localhost:%s", jq.ServerInfo.WebPort)
+		info("wr's web interface can be reached locally at https://localhost:%s/ (the first time, add ?token=xxx to the URL, where xxx is the token in %s)", jq.ServerInfo.WebPort, config.ManagerTokenFile)
 	},
 }
 
@@ -576,7 +576,37 @@ func bootstrapOnRemote(provider *cloud.Provider, server *cloud.Server, exe strin
 			die("failed to access the local database: %s", errf)
 		}
 	}
-	if err = server.CreateFile(fmt.Sprintf("managerport: \"%d\"\nmanagerweb: \"%d\"\nmanagerdbbkfile: \"%s\"\n", mp, wp, dbBk), wrConfigFileName); err != nil {
+
+	// copy over our token and certificates, so that the remote manager can
+	// use them and we can still talk to it, creating them first if we've never
+	// started a manager locally
+	_, err = internal.ReadOrCreateToken(config.ManagerTokenFile)
+	if err != nil {
+		teardown(provider)
+		die("failed to create a token: %s", err)
+	}
+	if internal.CheckCerts(config.ManagerCertFile, config.ManagerKeyFile) != nil {
+		err = internal.GenerateCerts(config.ManagerCAFile, config.ManagerCertFile, config.ManagerKeyFile, config.ManagerCertDomain)
+		if err != nil {
+			teardown(provider)
+			die("failed to create certificates: %s", err)
+		}
+	}
+	authFiles := []string{config.ManagerTokenFile, config.ManagerCAFile, config.ManagerCertFile, config.ManagerKeyFile}
+	for _, authFile := range authFiles {
+		remoteAuthFile := filepath.Join("./.wr_"+config.Deployment, filepath.Base(authFile))
+		if err = server.UploadFile(authFile, remoteAuthFile); err != nil && !wrMayHaveStarted {
+			teardown(provider)
+			die("failed to upload %s to the server at %s: %s", authFile, server.IP, err)
+		}
+		_, _, err = server.RunCmd("chmod 600 "+remoteAuthFile, false)
+		if err != nil {
+			warn("failed to chmod 600 %s: %s", remoteAuthFile, err)
+		}
+	}
+
+	// now create that config file, pointing to the files we copied over
+	if err = server.CreateFile(fmt.Sprintf("managerport: \"%d\"\nmanagerweb: \"%d\"\nmanagerdbbkfile: \"%s\"\nmanagertokenfile: \"%s\"\nmanagercafile: \"%s\"\nmanagercertfile: \"%s\"\nmanagerkeyfile: \"%s\"\nmanagercertdomain: \"%s\"\n", mp, wp, dbBk, filepath.Base(config.ManagerTokenFile), filepath.Base(config.ManagerCAFile), filepath.Base(config.ManagerCertFile), filepath.Base(config.ManagerKeyFile), config.ManagerCertDomain), wrConfigFileName); err != nil {
 		teardown(provider)
 		die("failed to create our config file on the server at %s: %s", server.IP, err)
 	}
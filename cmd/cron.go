@@ -0,0 +1,314 @@
+// Copyright © 2016-2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
+	"github.com/VertebrateResequencing/wr/jobqueue"
+	"github.com/spf13/cobra"
+)
+
+// options for this cmd; these are separate from the equivalent options of add
+// since they have different defaults
+var cronName string
+var cronSpec string
+var cronOverlap string
+var cronJSON string
+var cronCwd string
+var cronCwdMatters bool
+var cronRepGroup string
+var cronReqGroup string
+var cronMem string
+var cronTime string
+var cronCPUs int
+var cronDisk int
+var cronOvr int
+var cronPri int
+var cronRet int
+var cronEnv string
+var cronOnExit string
+
+// cronCmd represents the cron command
+var cronCmd = &cobra.Command{
+	Use:   "cron",
+	Short: "Run commands on a schedule",
+	Long: `Have the manager add commands to the queue at regular times.
+
+Instead of using your own crontab to call "wr add" (which fails silently if the
+manager is down or running on a different machine), you can have the manager
+itself add a command to the queue at the times described by a cron expression.
+Schedules are stored in the manager's database, so survive it being restarted
+(though firings that would have happened while it was down are not made up
+for).
+
+Use the 'add' sub-command to create a schedule, 'list' to see your schedules
+and when they will next fire, and 'remove' to stop a schedule. The history of
+each schedule's firings can be seen with 'wr status --cron [name]'.`,
+}
+
+// add sub-command adds a cron schedule
+var cronAddCmd = &cobra.Command{
+	Use:   "add 'command'",
+	Short: "Add a command to run on a schedule",
+	Long: `Add a command that the manager will add to the queue at the times you
+describe.
+
+Supply the command as a single (quoted) argument, or as the "cmd" in --json, a
+JSON object in the same format as the per-command JSON accepted by "wr add"
+(see "wr add -h" for details of all the possible options). Common options can
+also be given as flags, which have the same meaning as for "wr add" and take
+precedence over the JSON. The report group defaults to the --name of the
+schedule, so that "wr status -i [name]" shows the scheduled command.
+
+--schedule is a standard 5 field cron expression: minute hour day-of-month
+month day-of-week. Each field can be *, a number, a range like 1-5, a list like
+1,3,5, or a step like */15 or 0-30/10. Months and days of the week can also be
+given as 3 letter names like jan or mon. If day-of-month and day-of-week are
+both restricted, the command runs when either matches. Instead of 5 fields you
+can supply one of @yearly, @monthly, @weekly, @daily or @hourly. Times are in
+the manager's local time zone. For example, "0 2 * * *" runs every night at
+2am, and "30 6 * * mon-fri" runs at 6:30am on weekdays.
+
+--overlap determines what happens when the schedule fires but the command it
+added last time is still incomplete (ie. still queued, running or buried):
+"skip" (the default) does nothing, "queue" adds the command again once the
+previous one completes or is removed, and "kill" kills the previous one if it is
+running (or removes it if not) and adds the command again as soon as possible.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if cronName == "" {
+			die("--name is required")
+		}
+		if cronSpec == "" {
+			die("--schedule is required")
+		}
+		if len(args) > 1 {
+			die("supply your command as a single quoted argument")
+		}
+
+		jvj := &jobqueue.JobViaJSON{}
+		if cronJSON != "" {
+			err := json.Unmarshal([]byte(cronJSON), jvj)
+			if err != nil {
+				die("bad --json: %s", err)
+			}
+		}
+		if len(args) == 1 {
+			jvj.Cmd = args[0]
+		}
+		if jvj.Cmd == "" {
+			die("you must supply the command to schedule")
+		}
+
+		jd := &jobqueue.JobDefaults{
+			RepGrp:     cronRepGroup,
+			ReqGrp:     cronReqGroup,
+			Cwd:        cronCwd,
+			CwdMatters: cronCwdMatters,
+			CPUs:       cronCPUs,
+			Disk:       cronDisk,
+			Override:   cronOvr,
+			Priority:   cronPri,
+			Retries:    cronRet,
+			Env:        cronEnv,
+		}
+		if jd.RepGrp == "" {
+			jd.RepGrp = cronName
+		}
+		if cronMem != "" {
+			mb, err := bytefmt.ToMegabytes(cronMem)
+			if err != nil {
+				die("--memory was not specified correctly: %s", err)
+			}
+			jd.Memory = int(mb)
+		}
+		if cronTime != "" {
+			var err error
+			jd.Time, err = time.ParseDuration(cronTime)
+			if err != nil {
+				die("--time was not specified correctly: %s", err)
+			}
+		}
+		if cronOnExit != "" {
+			var bjs jobqueue.BehavioursViaJSON
+			err := json.Unmarshal([]byte(cronOnExit), &bjs)
+			if err != nil {
+				die("bad --on_exit: %s", err)
+			}
+			jd.OnExit = bjs.Behaviours(jobqueue.OnExit)
+		}
+
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		// as per add, we default to pwd and our current environment if the
+		// manager is on the same host as us, or if cwd matters, /tmp otherwise
+		var envVars []string
+		currentIP, err := jobqueue.CurrentIP("")
+		if err != nil {
+			warn("Could not get current IP: %s", err)
+		}
+		if jvj.Cwd == "" && jd.Cwd == "" {
+			local := currentIP+":"+config.ManagerPort == jq.ServerInfo.Addr
+			if local || cronCwdMatters {
+				wd, errw := os.Getwd()
+				if errw != nil {
+					die("%s", errw)
+				}
+				jd.Cwd = wd
+			} else {
+				warn("command working directory defaulting to /tmp since the manager is running remotely")
+				jd.Cwd = "/tmp"
+			}
+		}
+		if currentIP+":"+config.ManagerPort == jq.ServerInfo.Addr {
+			envVars = os.Environ()
+		}
+
+		job, err := jvj.Convert(jd)
+		if err != nil {
+			die("there was a problem with your command: %s", err)
+		}
+
+		sc, err := jobqueue.NewCronSchedule(cronName, cronSpec, jobqueue.CronOverlap(cronOverlap), job)
+		if err != nil {
+			die("%s", err)
+		}
+
+		err = jq.AddSchedule(sc, envVars)
+		if err != nil {
+			die("failed to add the schedule: %s", err)
+		}
+		info("Added cron schedule '%s'", cronName)
+	},
+}
+
+// list sub-command lists cron schedules
+var cronListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List scheduled commands",
+	Long: `List the schedules you've added with "wr cron add", along with when
+they will next fire and what happened the last time they fired.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		schedules, err := jq.GetSchedules()
+		if err != nil {
+			die("failed to get the schedules: %s", err)
+		}
+		if len(schedules) == 0 {
+			info("There are no cron schedules")
+			return
+		}
+
+		for _, sc := range schedules {
+			last := "never"
+			if lf := sc.LastFiring(); lf != nil {
+				last = fmt.Sprintf("%s (%s)", lf.Time.Format(shortTimeFormat), lf.Outcome)
+			}
+			fmt.Printf("\n# %s\nSchedule: %s; Overlap: %s\nCmd: %s\nNext: %s; Last: %s\n", sc.Name, sc.Spec, sc.Overlap, sc.Job.Cmd, sc.Next.Format(shortTimeFormat), last)
+		}
+		fmt.Printf("\n")
+	},
+}
+
+// remove sub-command removes cron schedules
+var cronRemoveCmd = &cobra.Command{
+	Use:   "remove name [name...]",
+	Short: "Stop running scheduled commands",
+	Long: `Remove schedules you've added with "wr cron add", so that they no
+longer fire. Commands the schedules previously added to the queue are not
+affected; use "wr remove" or "wr kill" on those if desired.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			die("you must supply the name of at least one schedule to remove")
+		}
+
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		removed, err := jq.RemoveSchedules(args)
+		if err != nil {
+			die("failed to remove the schedules: %s", err)
+		}
+		info("Removed %d cron schedules (out of %d requested)", removed, len(args))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(cronCmd)
+	cronCmd.AddCommand(cronAddCmd)
+	cronCmd.AddCommand(cronListCmd)
+	cronCmd.AddCommand(cronRemoveCmd)
+
+	// flags specific to these sub-commands
+	cronAddCmd.Flags().StringVarP(&cronName, "name", "n", "", "unique name for the schedule")
+	cronAddCmd.Flags().StringVarP(&cronSpec, "schedule", "s", "", "cron expression describing when to run the command")
+	cronAddCmd.Flags().StringVar(&cronOverlap, "overlap", string(jobqueue.CronOverlapSkip), "[skip|queue|kill] what to do if the previous run is incomplete")
+	cronAddCmd.Flags().StringVarP(&cronJSON, "json", "j", "", "command and its options, in JSON format")
+	cronAddCmd.Flags().StringVarP(&cronCwd, "cwd", "c", "", "base for the command's working dir")
+	cronAddCmd.Flags().BoolVar(&cronCwdMatters, "cwd_matters", false, "--cwd should be used as the actual working directory")
+	cronAddCmd.Flags().StringVarP(&cronRepGroup, "report_grp", "i", "", "reporting group for the command (defaults to --name)")
+	cronAddCmd.Flags().StringVarP(&cronReqGroup, "req_grp", "g", "", "group name for commands with similar reqs")
+	cronAddCmd.Flags().StringVarP(&cronMem, "memory", "m", "1G", "peak mem est. [specify units such as M for Megabytes or G for Gigabytes]")
+	cronAddCmd.Flags().StringVarP(&cronTime, "time", "t", "1h", "max time est. [specify units such as m for minutes or h for hours]")
+	cronAddCmd.Flags().IntVar(&cronCPUs, "cpus", 1, "cpu cores needed")
+	cronAddCmd.Flags().IntVar(&cronDisk, "disk", 0, "number of GB of disk space required [0 means do not check disk space] (default 0)")
+	cronAddCmd.Flags().IntVarP(&cronOvr, "override", "o", 0, "[0|1|2] should your mem/time estimates override? (default 0)")
+	cronAddCmd.Flags().IntVarP(&cronPri, "priority", "p", 0, "[0-255] command priority (default 0)")
+	cronAddCmd.Flags().IntVarP(&cronRet, "retries", "r", 3, "[0-255] number of automatic retries for failed commands")
+	cronAddCmd.Flags().StringVar(&cronEnv, "env", "", "comma-separated list of key=value environment variables to set before running the command")
+	cronAddCmd.Flags().StringVar(&cronOnExit, "on_exit", `[{"cleanup":true}]`, "behaviours to carry out when the command finishes running, in JSON format")
+
+	cronAddCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+	cronListCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+	cronRemoveCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+}
@@ -0,0 +1,190 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/VertebrateResequencing/wr/jobqueue"
+	"github.com/spf13/cobra"
+)
+
+// options for this cmd
+var historyFrom string
+var historyTo string
+var historyCmdLine string
+var historyRegex bool
+var historyRepGroup string
+var historyHost string
+var historyExitcode string
+var historyFailReason string
+var historyFailed bool
+var historyOffset int
+var historyLimit int
+var historyOutputFormat string
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Search the history of commands that have run",
+	Long: `Search the history of every command that has finished running.
+
+Unlike "wr status", which tells you about the current state of your commands,
+this tells you about every time any of your commands ran and exited, whether it
+completed successfully or failed, even if the command has since been retried or
+removed. A command that failed twice before completing will have 3 entries in
+the history.
+
+With no options you get the most recent runs. Narrow down the runs with any
+combination of the options; for example, to see what failed on node1 yesterday:
+
+wr history --host node1 --failed --from 48h --to 24h
+
+--from and --to take either a time (like 2018-06-15T13:00:00Z, or a date like
+2018-06-15 to mean midnight on that day in your local time zone) or a duration
+(like 24h) to mean that long ago, and restrict the runs to those that ended in
+that time range.
+
+--cmd restricts the runs to commands that contain the given text, or with
+--regex, to those that match the given regular expression.
+
+Runs are output most recently ended first, up to --limit of them; use --offset
+to page through more. --output works as for "wr status" (see "wr status -h"),
+with the details of each command being as they were at the end of that run.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		switch historyOutputFormat {
+		case "", "json", "jsonl", "tsv":
+		default:
+			die("--output must be one of json, jsonl or tsv")
+		}
+		if historyRegex && historyCmdLine == "" {
+			die("--regex can only be used with --cmd")
+		}
+
+		now := time.Now()
+		q := &jobqueue.HistoryQuery{
+			Cmd:        historyCmdLine,
+			CmdRegex:   historyRegex,
+			RepGroup:   historyRepGroup,
+			Host:       historyHost,
+			FailReason: historyFailReason,
+			Failed:     historyFailed,
+			Offset:     historyOffset,
+			Limit:      historyLimit,
+		}
+		var err error
+		if historyFrom != "" {
+			q.From, err = parseHistoryTime(historyFrom, now)
+			if err != nil {
+				die("--from was not specified correctly: %s", err)
+			}
+		}
+		if historyTo != "" {
+			q.To, err = parseHistoryTime(historyTo, now)
+			if err != nil {
+				die("--to was not specified correctly: %s", err)
+			}
+		}
+		if historyExitcode != "" {
+			exitcode, errc := strconv.Atoi(historyExitcode)
+			if errc != nil {
+				die("--exitcode must be a number")
+			}
+			q.Exitcode = &exitcode
+		}
+
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		jobs, err := jq.History(q)
+		if err != nil {
+			die("failed to search the history: %s", err)
+		}
+
+		if historyOutputFormat != "" {
+			err = outputJobs(os.Stdout, jobs, historyOutputFormat, false, false, jq.ServerInfo.CopyDir)
+			if err != nil {
+				die("failed to output jobs: %s", err)
+			}
+			return
+		}
+
+		if len(jobs) == 0 {
+			info("No runs matched")
+			return
+		}
+
+		for _, job := range jobs {
+			var problem string
+			if job.FailReason != "" {
+				problem = fmt.Sprintf("; Problem: %s", job.FailReason)
+			}
+			fmt.Printf("\n# %s\nCwd: %s; Id: %s\nEnded: %s; Exit code: %d%s\nHost: %s; Peak memory: %dMB; Wall time: %s; CPU time: %s\n", job.Cmd, job.Cwd, job.RepGroup, job.EndTime.Format(shortTimeFormat), job.Exitcode, problem, job.Host, job.PeakRAM, job.WallTime(), job.CPUtime)
+		}
+		fmt.Printf("\n")
+		if historyLimit > 0 && len(jobs) == historyLimit {
+			info("Only the first %d matching runs were shown; use --offset %d to see more", historyLimit, historyOffset+historyLimit)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+
+	// flags specific to this sub-command
+	historyCmd.Flags().StringVarP(&historyFrom, "from", "f", "", "only runs that ended at or after this time, or this duration ago")
+	historyCmd.Flags().StringVarP(&historyTo, "to", "t", "", "only runs that ended at or before this time, or this duration ago")
+	historyCmd.Flags().StringVarP(&historyCmdLine, "cmd", "l", "", "only runs of commands containing this text")
+	historyCmd.Flags().BoolVarP(&historyRegex, "regex", "r", false, "treat --cmd as a regular expression")
+	historyCmd.Flags().StringVarP(&historyRepGroup, "identifier", "i", "", "only runs of commands with this identifier")
+	historyCmd.Flags().StringVar(&historyHost, "host", "", "only runs on this host")
+	historyCmd.Flags().StringVarP(&historyExitcode, "exitcode", "x", "", "only runs that exited with this exit code")
+	historyCmd.Flags().StringVar(&historyFailReason, "fail_reason", "", "only runs that failed for this reason")
+	historyCmd.Flags().BoolVar(&historyFailed, "failed", false, "only runs that failed")
+	historyCmd.Flags().IntVar(&historyOffset, "offset", 0, "skip this many of the matching runs")
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 100, "maximum number of runs to show; 0 shows all")
+	historyCmd.Flags().StringVarP(&historyOutputFormat, "output", "o", "", "output format: json|jsonl|tsv (default human readable text)")
+
+	historyCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+}
+
+// parseHistoryTime parses a user-supplied time, which can be a duration to
+// mean that long before now, an RFC3339 time, or a date in the local time
+// zone.
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
@@ -0,0 +1,96 @@
+// Copyright © 2016-2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/VertebrateResequencing/wr/jobqueue"
+	"github.com/spf13/cobra"
+)
+
+// killCmd represents the kill command
+var killCmd = &cobra.Command{
+	Use:   "kill",
+	Short: "Kill running commands",
+	Long: `You can kill commands you've previously added using "wr add" that
+are currently running using this command.
+
+Specify one of the flags -f, -l, -i or -a to choose which commands you want to
+kill. Amongst those, only currently running jobs will be affected.
+
+-i is the report group (-i) you supplied to "wr add" when you added the job(s)
+you want to now kill.
+
+The file to provide -f is in the format cmd\tcwd\tmounts, with the last 2
+columns optional.
+
+In -f and -l mode you must provide the cwd the commands were set to run in, if
+CwdMatters (and must NOT be provided otherwise). Likewise provide the mounts
+JSON that was used when the command was added, if any. You can do this by using
+the -c and --mounts options, or in -f mode your file can specify the cwd and
+mounts, in case it's different for each command.
+
+Having killed a command, it will become buried, at which point you could use
+"wr retry" or "wr remove" on it. Note that killing is not instantaneous: the
+command will be killed the next time its runner checks in with the manager.
+
+Commands in the "lost contact" state will also be affected: this is how you
+confirm that they are really dead, after which they will be buried (or retried
+if they have retries left).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		checkJobSelectionFlags()
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		jes := getJobsToManipulate(jq, "", false, jobqueue.JobStateReserved, jobqueue.JobStateRunning, jobqueue.JobStateLost)
+		if len(jes) == 0 {
+			die("No matching jobs found")
+		}
+
+		killed, err := jq.Kill(jes)
+		if err != nil {
+			die("failed to kill desired jobs: %s", err)
+		}
+		info("Initiated the termination of %d running commands (out of %d eligible)", killed, len(jes))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(killCmd)
+
+	// flags specific to this sub-command
+	killCmd.Flags().BoolVarP(&cmdAll, "all", "a", false, "kill all of your running commands")
+	killCmd.Flags().StringVarP(&cmdFileStatus, "file", "f", "", "file containing commands you want to kill; - means read from STDIN")
+	killCmd.Flags().StringVarP(&cmdIDStatus, "identifier", "i", "", "identifier of the commands you want to kill")
+	killCmd.Flags().StringVarP(&cmdLine, "cmdline", "l", "", "a command line you want to kill")
+	killCmd.Flags().StringVarP(&cmdCwd, "cwd", "c", "", "working dir that the command(s) specified by -l or -f were set to run in")
+	killCmd.Flags().StringVar(&cmdMounts, "mounts", "", "mounts that the command(s) specified by -l or -f were set to use")
+
+	killCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+}
@@ -0,0 +1,181 @@
+// Copyright © 2016-2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VertebrateResequencing/wr/jobqueue"
+	"github.com/spf13/cobra"
+)
+
+// options for this cmd
+var limitMax int
+var limitDelay string
+
+// limitCmd represents the limit command
+var limitCmd = &cobra.Command{
+	Use:   "limit",
+	Short: "Limit how many commands in a group run at once",
+	Long: `Limit how many commands that use a scarce resource run at once.
+
+Commands can be put in to limit groups by giving them limit_grps when you "wr
+add" them (see "wr add -h"). By default this does nothing, but if you set a
+limit on a limit group, then no more than the maximum number of commands in
+that group will run at the same time, across all the machines running your
+commands. You can also require a minimum delay between the start of each
+command in the group, to avoid spamming the resource with a flood of new
+connections. For example, to say that at most 20 commands that access iRODS
+can run at once, and that they should start at least 1 second apart, you could
+add your commands with --limit_grps irods and then:
+
+wr limit set irods --max 20 --delay 1s
+
+Commands that are over the limit remain in the ready state until other commands
+in the group finish. A command in multiple limit groups only runs when it is
+within the limits of all of them.
+
+Use the 'set' sub-command to create a limit or change an existing one (taking
+effect immediately for commands that have yet to start), 'list' to see your
+limits and how many commands in each group are currently running, and 'remove'
+to stop limiting a group. Limits are stored in the manager's database, so
+survive it being restarted.`,
+}
+
+// set sub-command creates or changes a limit
+var limitSetCmd = &cobra.Command{
+	Use:   "set name",
+	Short: "Set the limit of a limit group",
+	Long: `Set the maximum number of commands in the named limit group that can
+run at once, and the minimum delay between the start of each of them.
+
+A --max of 0 stops any more commands in the group from starting, until you set a
+higher limit or remove the limit.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			die("you must supply the name of exactly one limit group")
+		}
+		if limitMax < 0 {
+			die("--max must not be negative")
+		}
+		delay, err := time.ParseDuration(limitDelay)
+		if err != nil {
+			die("--delay was not specified correctly: %s", err)
+		}
+
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		err = jq.SetLimitGroup(&jobqueue.LimitGroup{Name: args[0], Max: limitMax, Delay: delay})
+		if err != nil {
+			die("failed to set the limit: %s", err)
+		}
+		info("Limit group %s can now run %d commands at once, started at least %s apart", args[0], limitMax, delay)
+	},
+}
+
+// list sub-command lists the limits
+var limitListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List limit groups",
+	Long: `List the limits you've set with "wr limit set", along with how many
+commands in each group are currently running.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		limits, err := jq.GetLimitGroups()
+		if err != nil {
+			die("failed to get the limit groups: %s", err)
+		}
+		if len(limits) == 0 {
+			info("There are no limit groups")
+			return
+		}
+
+		for _, lg := range limits {
+			fmt.Printf("%s: %d/%d running; delay %s\n", lg.Name, lg.Running, lg.Max, lg.Delay)
+		}
+	},
+}
+
+// remove sub-command removes limits
+var limitRemoveCmd = &cobra.Command{
+	Use:   "remove name [name...]",
+	Short: "Stop limiting limit groups",
+	Long: `Remove limits you've set with "wr limit set", so that commands in those
+limit groups are no longer limited.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			die("you must supply the name of at least one limit group to remove")
+		}
+
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		removed, err := jq.RemoveLimitGroups(args)
+		if err != nil {
+			die("failed to remove the limit groups: %s", err)
+		}
+		info("Removed %d limit groups (out of %d requested)", removed, len(args))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(limitCmd)
+	limitCmd.AddCommand(limitSetCmd)
+	limitCmd.AddCommand(limitListCmd)
+	limitCmd.AddCommand(limitRemoveCmd)
+
+	// flags specific to these sub-commands
+	limitSetCmd.Flags().IntVarP(&limitMax, "max", "m", 1, "maximum number of commands in the group that can run at once")
+	limitSetCmd.Flags().StringVarP(&limitDelay, "delay", "d", "0s", "minimum time between the start of each command in the group [specify units such as ms for milliseconds or s for seconds]")
+
+	limitSetCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+	limitListCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+	limitRemoveCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+}
@@ -21,6 +21,7 @@ package cmd
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -28,6 +29,7 @@ import (
 	"syscall"
 	"time"
 
+	"code.cloudfoundry.org/bytefmt"
 	"github.com/VertebrateResequencing/wr/internal"
 	"github.com/VertebrateResequencing/wr/jobqueue"
 	jqs "github.com/VertebrateResequencing/wr/jobqueue/scheduler"
@@ -40,11 +42,19 @@ import (
 
 // options for this cmd
 var foreground bool
+var standby bool
 var scheduler string
 var localUsername string
 var backupPath string
 var managerTimeoutSeconds int
 var managerDebug bool
+var exportPath string
+var exportRepGroup string
+var exportFrom string
+var exportTo string
+var exportNoStd bool
+var exportNoEnv bool
+var compactTimeout int
 
 // managerCmd represents the manager command
 var managerCmd = &cobra.Command{
@@ -68,6 +78,26 @@ stalled until you run the 'start' sub-command again.
 If the manager fails to start or dies unexpectedly, you can check the logs which
 are by default found in ~/.wr_[deployment]/log.
 
+All communication with the manager is encrypted using TLS. Unless you configure
+your own, the manager creates a certificate (valid for the managercertdomain
+config option, default "localhost") signed by its own CA, whose certificate is
+stored in ~/.wr_[deployment]/ca.pem; you'll need to tell your web browser to
+trust that certificate to avoid warnings when using the web interface. Only
+those with the secret token stored in ~/.wr_[deployment]/client.token can use
+the manager: other wr commands read it from there, but the first time you use
+the web interface you must add it to the URL, like https://host:port/?token=xxx
+(your browser is then given a cookie and the token is removed from the URL),
+and to use the REST API you must supply it in an "Authorization: Bearer xxx"
+header.
+
+For monitoring, the manager's web port also serves Prometheus metrics at
+/metrics (supply the token as for the REST API; in Prometheus use the
+bearer_token_file option pointing at the token file), covering job counts per
+state and RepGroup, client request rates and latencies, runners per scheduler
+group, cloud servers, and database size and backups. /healthz needs no token
+and returns status 503 (listing the problems) if the database or job scheduler
+are not healthy.
+
 If using the OpenStack scheduler, note that you must be running on an OpenStack
 server already. Be sure to set --local_username to your username outside of the
 cloud, so that resources created will not conflict with anyone else in your
@@ -89,7 +119,24 @@ var managerStartCmd = &cobra.Command{
 	Use:   "start",
 	Short: "Start workflow management",
 	Long: `Start the workflow manager, daemonizing it in to the background
-(unless --foreground option is supplied).`,
+(unless --foreground option is supplied).
+
+With --standby, the manager is instead started as a hot standby for the manager
+running on the configured managerhost (the "primary"). Start it on a different
+host with the same manager_port, and first copy the primary's token and
+certificate files (client.token, ca.pem, cert.pem and key.pem in the primary's
+managerdir) to the same place on the standby's host; the primary's certificate
+must be valid for the managercertdomain used to reach either host.
+
+The standby keeps a copy of the primary's database up to date, and if the
+primary is unreachable for 30s, it takes over: runners running commands for the
+primary carry on running them, reporting to the standby instead. Add the
+standby's host to the managerstandby config option so that other wr commands
+can reach it if it takes over.
+
+If you stop the primary deliberately, stop its standbys first, or one of them
+will take over. After a standby has taken over, you can start the old primary
+again as a standby of the new one.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// first we need our working directory to exist
 		createWorkingDir()
@@ -134,6 +181,10 @@ var managerStartCmd = &cobra.Command{
 			if child != nil {
 				// parent; wait a while for our child to bring up the manager
 				// before exiting
+				if standby {
+					waitForStandby()
+					return
+				}
 				jq := connect(time.Duration(managerTimeoutSeconds) * time.Second)
 				if jq == nil {
 					die("wr manager failed to start on port %s after %ds", config.ManagerPort, managerTimeoutSeconds)
@@ -333,7 +384,7 @@ somewhere.)`,
 		}
 		timeout := time.Duration(timeoutint) * time.Second
 
-		jq, err := jobqueue.Connect(addr, timeout)
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
 		if err != nil {
 			die("%s", err)
 		}
@@ -351,6 +402,233 @@ somewhere.)`,
 	},
 }
 
+// compact sub-command shrinks the database file
+var managerCompactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Shrink wr's database",
+	Long: `Shrink wr's job database file.
+
+The manager's database file never gets any smaller by itself, even when the
+manager removes old commands from it according to the managerretention and
+managerretainrgs config options (see the example config file).
+
+You can use this command to rewrite the database file so that it takes up no
+more space than it needs to, which can take a while for large databases.
+
+If the manager is running, it does this itself, and can't do anything else
+that needs its database (like adding commands, or commands completing) until it
+is done. If the manager is not running, the database file is rewritten directly.
+Either way, you are told the size of the file before and after.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var before, after int64
+		if jq := connect(1 * time.Second); jq != nil {
+			err := jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+
+			timeout := time.Duration(compactTimeout) * time.Second
+			jq, err = jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+			if err != nil {
+				die("%s", err)
+			}
+			defer func() {
+				err = jq.Disconnect()
+				if err != nil {
+					warn("Disconnecting from the server failed: %s", err)
+				}
+			}()
+
+			before, after, err = jq.CompactDB()
+			if err != nil {
+				die("failed to compact the manager's database: %s", err)
+			}
+		} else {
+			var err error
+			before, after, err = jobqueue.CompactDBFile(config.ManagerDbFile)
+			if err != nil {
+				die("failed to compact %s: %s", config.ManagerDbFile, err)
+			}
+		}
+		info("Database compacted from %s to %s", bytefmt.ByteSize(uint64(before)), bytefmt.ByteSize(uint64(after)))
+	},
+}
+
+// export sub-command writes the database to a portable archive
+var managerExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export wr's database to a portable archive",
+	Long: `Export wr's job database to a portable archive.
+
+This lets you move your commands and their history between deployments (eg.
+from development to production, or from an old cloud deployment to a new one),
+or between versions of wr, without copying the database file itself. Use
+'wr manager import' to merge the archive in to another database.
+
+The archive (written to --path, or STDOUT if that is "-") is a gzip compressed
+file of JSON lines: a header line stating its format version, followed by one
+line for each incomplete command, completed command, run in the history (see
+'wr history'), environment, std output, and resource usage statistic (that wr
+learns from to decide how much memory and time new commands will need).
+
+You can restrict the export to commands with a particular --identifier (which
+can be a glob like "myid*"), and completed commands and runs to those that
+ended within a time range given by --from and --to (taking a time like
+2018-06-15T13:00:00Z, a date like 2018-06-15, or a duration ago like 24h).
+Use --no_std and --no_env to leave out the std output and environment variables
+of incomplete commands, which may be large or sensitive.
+
+If the manager is running, it is asked to make a backup of its database, which
+is then exported; otherwise the database file is exported directly.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if exportPath == "" {
+			die("--path is required")
+		}
+		opts := &jobqueue.ExportOptions{
+			RepGroup: exportRepGroup,
+			NoStd:    exportNoStd,
+			NoEnv:    exportNoEnv,
+		}
+		now := time.Now()
+		var err error
+		if exportFrom != "" {
+			opts.From, err = parseHistoryTime(exportFrom, now)
+			if err != nil {
+				die("--from was not specified correctly: %s", err)
+			}
+		}
+		if exportTo != "" {
+			opts.To, err = parseHistoryTime(exportTo, now)
+			if err != nil {
+				die("--to was not specified correctly: %s", err)
+			}
+		}
+
+		dbPath := config.ManagerDbFile
+		if jq := connect(1 * time.Second); jq != nil {
+			tmpDir, errt := ioutil.TempDir(filepath.Dir(config.ManagerDbFile), "wr_export")
+			if errt != nil {
+				die("could not create a temporary directory: %s", errt)
+			}
+			defer func() {
+				errr := os.RemoveAll(tmpDir)
+				if errr != nil {
+					warn("failed to remove %s: %s", tmpDir, errr)
+				}
+			}()
+			dbPath = filepath.Join(tmpDir, "db")
+			err = jq.BackupDB(dbPath)
+			errd := jq.Disconnect()
+			if errd != nil {
+				warn("Disconnecting from the server failed: %s", errd)
+			}
+			if err != nil {
+				die("failed to backup the manager's database: %s", err)
+			}
+		}
+
+		out := os.Stdout
+		if exportPath != "-" {
+			out, err = os.Create(exportPath)
+			if err != nil {
+				die("could not create %s: %s", exportPath, err)
+			}
+		}
+		counts, err := jobqueue.ExportDB(dbPath, out, opts)
+		if out != os.Stdout {
+			errc := out.Close()
+			if err == nil {
+				err = errc
+			}
+		}
+		if err != nil {
+			die("failed to export the database: %s", err)
+		}
+		info("Exported %d incomplete commands, %d completed commands, %d runs, %d environments, %d std outputs and %d statistics", counts.Live, counts.Complete, counts.Runs, counts.Envs, counts.Std, counts.Stats)
+	},
+}
+
+// import sub-command merges an archive in to the database
+var managerImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import an archive in to wr's database",
+	Long: `Import an archive made by 'wr manager export' in to wr's job database.
+
+The archive at --path (or read from STDIN if that is "-") is merged in to the
+database of the current deployment: nothing already in the database is lost or
+replaced, except that completed commands replace older records of the same
+commands that completed earlier. Incomplete commands are only added if the
+database doesn't already know about them. Archives made by older versions of wr
+can be imported.
+
+The manager must not be running (stop it first with 'wr manager stop'). The
+next time it is started, any imported incomplete commands will be added to its
+queue.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if exportPath == "" {
+			die("--path is required")
+		}
+		if jq := connect(1 * time.Second); jq != nil {
+			err := jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+			die("the manager is running; stop it before importing")
+		}
+
+		in := os.Stdin
+		if exportPath != "-" {
+			var err error
+			in, err = os.Open(exportPath)
+			if err != nil {
+				die("could not open %s: %s", exportPath, err)
+			}
+			defer func() {
+				errc := in.Close()
+				if errc != nil {
+					warn("failed to close %s: %s", exportPath, errc)
+				}
+			}()
+		}
+		counts, err := jobqueue.ImportDB(config.ManagerDbFile, in)
+		if err != nil {
+			die("failed to import %s: %s", exportPath, err)
+		}
+		info("Imported %d incomplete commands, %d completed commands, %d runs, %d environments, %d std outputs and %d statistics", counts.Live, counts.Complete, counts.Runs, counts.Envs, counts.Std, counts.Stats)
+	},
+}
+
+// waitForStandby waits for the standby manager we just started to start
+// replicating the primary manager, dying if it doesn't do so within
+// managerTimeoutSeconds.
+func waitForStandby() {
+	primary := config.ManagerHost + ":" + config.ManagerPort
+	currentIP, err := jobqueue.CurrentIP("")
+	if err != nil {
+		die("could not get current IP: %s", err)
+	}
+	me := currentIP + ":" + config.ManagerPort
+	deadline := time.Now().Add(time.Duration(managerTimeoutSeconds) * time.Second)
+	for time.Now().Before(deadline) {
+		jq, errc := jobqueue.Connect(primary, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), 1*time.Second)
+		if errc == nil {
+			standbys := jq.ServerInfo.Standbys
+			errd := jq.Disconnect()
+			if errd != nil {
+				warn("Disconnecting from the server failed: %s", errd)
+			}
+			for _, s := range standbys {
+				if s == me {
+					info("wr manager started on %s as a standby for the manager on %s", me, primary)
+					return
+				}
+			}
+		}
+		<-time.After(1 * time.Second)
+	}
+	die("wr manager failed to start as a standby for the manager on %s after %ds (see the log file for why)", primary, managerTimeoutSeconds)
+}
+
 // reportLiveStatus is used by the status command on a working connection to
 // distinguish between the server being in a normal 'started' state or the
 // 'drain' state.
@@ -365,11 +643,15 @@ func init() {
 	managerCmd.AddCommand(managerStopCmd)
 	managerCmd.AddCommand(managerStatusCmd)
 	managerCmd.AddCommand(managerBackupCmd)
+	managerCmd.AddCommand(managerCompactCmd)
+	managerCmd.AddCommand(managerExportCmd)
+	managerCmd.AddCommand(managerImportCmd)
 
 	// flags specific to these sub-commands
 	defaultConfig := internal.DefaultConfig(appLogger)
 	managerStartCmd.Flags().BoolVarP(&foreground, "foreground", "f", false, "do not daemonize")
-	managerStartCmd.Flags().StringVarP(&scheduler, "scheduler", "s", defaultConfig.ManagerScheduler, "['local','lsf','openstack'] job scheduler")
+	managerStartCmd.Flags().BoolVar(&standby, "standby", false, "be a hot standby for the manager on managerhost")
+	managerStartCmd.Flags().StringVarP(&scheduler, "scheduler", "s", defaultConfig.ManagerScheduler, "['local','lsf','slurm','openstack'] job scheduler")
 	managerStartCmd.Flags().IntVarP(&managerTimeoutSeconds, "timeout", "t", 10, "how long to wait in seconds for the manager to start up")
 	managerStartCmd.Flags().StringVarP(&osPrefix, "cloud_os", "o", defaultConfig.CloudOS, "for cloud schedulers, prefix name of the OS image your servers should use")
 	managerStartCmd.Flags().StringVarP(&osUsername, "cloud_username", "u", defaultConfig.CloudUser, "for cloud schedulers, username needed to log in to the OS image specified by --cloud_os")
@@ -387,11 +669,22 @@ func init() {
 	managerStartCmd.Flags().BoolVar(&managerDebug, "debug", false, "include extra debugging information in the logs")
 
 	managerBackupCmd.Flags().StringVarP(&backupPath, "path", "p", "", "backup file path")
+
+	managerCompactCmd.Flags().IntVar(&compactTimeout, "timeout", 600, "how long (seconds) to wait for a running 'wr manager' to compact its database")
+
+	managerExportCmd.Flags().StringVarP(&exportPath, "path", "p", "", "archive file path, or - for STDOUT")
+	managerExportCmd.Flags().StringVarP(&exportRepGroup, "identifier", "i", "", "only export commands with this identifier (glob)")
+	managerExportCmd.Flags().StringVarP(&exportFrom, "from", "f", "", "only export completed commands and runs that ended at or after this time, or this duration ago")
+	managerExportCmd.Flags().StringVarP(&exportTo, "to", "t", "", "only export completed commands and runs that ended at or before this time, or this duration ago")
+	managerExportCmd.Flags().BoolVar(&exportNoStd, "no_std", false, "do not export std output")
+	managerExportCmd.Flags().BoolVar(&exportNoEnv, "no_env", false, "do not export environment variables")
+
+	managerImportCmd.Flags().StringVarP(&exportPath, "path", "p", "", "archive file path, or - for STDIN")
 }
 
 func logStarted(s *jobqueue.ServerInfo) {
 	info("wr manager started on %s, pid %d", sAddr(s), s.PID)
-	info("wr's web interface can be reached at http://%s:%s", s.Host, s.WebPort)
+	info("wr's web interface can be reached at https://%s:%s/ (the first time, add ?token=xxx to the URL, where xxx is the token in %s)", s.Host, s.WebPort, config.ManagerTokenFile)
 }
 
 func startJQ(postCreation []byte) {
@@ -433,6 +726,8 @@ func startJQ(postCreation []byte) {
 		schedulerConfig = &jqs.ConfigLocal{Shell: config.RunnerExecShell}
 	case "lsf":
 		schedulerConfig = &jqs.ConfigLSF{Deployment: config.Deployment, Shell: config.RunnerExecShell}
+	case "slurm":
+		schedulerConfig = &jqs.ConfigSlurm{Deployment: config.Deployment, Shell: config.RunnerExecShell}
 	case "openstack":
 		mport, _ := strconv.Atoi(config.ManagerPort)
 		schedulerConfig = &jqs.ConfigOpenStack{
@@ -445,7 +740,7 @@ func startJQ(postCreation []byte) {
 			OSDisk:               osDisk,
 			FlavorRegex:          flavorRegex,
 			PostCreationScript:   postCreation,
-			ConfigFiles:          cloudConfigFiles,
+			ConfigFiles:          cloudConfigFilesWithAuth(),
 			ServerKeepTime:       time.Duration(serverKeepAlive) * time.Second,
 			StateUpdateFrequency: 1 * time.Minute,
 			MaxInstances:         maxServers,
@@ -457,8 +752,13 @@ func startJQ(postCreation []byte) {
 		serverCIDR = cloudCIDR
 	}
 
+	retention, err := jobqueue.NewRetentionPolicy(config.ManagerRetention, config.ManagerRetainRGs, config.ManagerKeepStats)
+	if err != nil {
+		die("wr manager failed to start : %s", err)
+	}
+
 	// start the jobqueue server
-	server, msg, err := jobqueue.Serve(jobqueue.ServerConfig{
+	serverConfig := jobqueue.ServerConfig{
 		AllowedUsers:    []string{localUsername},
 		Port:            config.ManagerPort,
 		WebPort:         config.ManagerWeb,
@@ -467,10 +767,35 @@ func startJQ(postCreation []byte) {
 		RunnerCmd:       exe + " runner -s '%s' --deployment %s --server '%s' -r %d -m %d",
 		DBFile:          config.ManagerDbFile,
 		DBFileBackup:    config.ManagerDbBkFile,
+		CopyDir:         config.ManagerCopyDir,
+		CopyMaxSize:     int64(config.ManagerCopyMaxMB) * 1024 * 1024,
+		Retention:       retention,
+		TokenFile:       config.ManagerTokenFile,
+		CAFile:          config.ManagerCAFile,
+		CertFile:        config.ManagerCertFile,
+		KeyFile:         config.ManagerKeyFile,
+		CertDomain:      config.ManagerCertDomain,
 		Deployment:      config.Deployment,
 		CIDR:            serverCIDR,
 		Logger:          serverLogger,
-	})
+	}
+	var server *jobqueue.Server
+	var msg string
+	if standby {
+		// only start serving once the primary manager is gone
+		primary := config.ManagerHost + ":" + config.ManagerPort
+		info("wr manager standing by for the manager on %s", primary)
+		server, msg, err = jobqueue.Standby(primary, serverConfig)
+		if jqerr, ok := err.(jobqueue.Error); ok && (jqerr.Err == jobqueue.ErrClosedTerm || jqerr.Err == jobqueue.ErrClosedInt) {
+			info("wr manager standing by for %s gracefully stopped", primary)
+			return
+		}
+		if err == nil {
+			info("wr manager on %s was unreachable, so took over from it", primary)
+		}
+	} else {
+		server, msg, err = jobqueue.Serve(serverConfig)
+	}
 
 	if msg != "" {
 		info("wr manager : %s", msg)
@@ -499,3 +824,23 @@ func startJQ(postCreation []byte) {
 		}
 	}
 }
+
+// cloudConfigFilesWithAuth returns cloudConfigFiles with our token and CA
+// certificate files added, so that runners on spawned cloud servers can
+// connect to us. These files are placed relative to the home directory of the
+// spawned servers if they're in our home directory.
+func cloudConfigFilesWithAuth() string {
+	var files []string
+	if cloudConfigFiles != "" {
+		files = append(files, cloudConfigFiles)
+	}
+	home := os.Getenv("HOME")
+	for _, path := range []string{config.ManagerTokenFile, config.ManagerCAFile} {
+		remotePath := path
+		if home != "" && strings.HasPrefix(path, home+"/") {
+			remotePath = "~/" + strings.TrimPrefix(path, home+"/")
+		}
+		files = append(files, path+":"+remotePath)
+	}
+	return strings.Join(files, ",")
+}
@@ -0,0 +1,304 @@
+// Copyright © 2016-2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/VertebrateResequencing/wr/jobqueue"
+	"github.com/spf13/cobra"
+)
+
+// options for this cmd; these are separate from the equivalent options of add
+// since they have different defaults
+var modJSON string
+var modMem string
+var modTime string
+var modCPUs int
+var modDisk int
+var modOvr int
+var modPri int
+var modRet int
+var modRepGroup string
+var modDepGroups string
+var modLimitGroups string
+var modGroupDeps string
+var modCmdDeps string
+var modEnv string
+var modCloudOS string
+var modCloudUser string
+var modCloudRAM int
+var modCloudScript string
+var modLSFQueue string
+var modLSFResources string
+var modLSFHosts string
+var modLSFProject string
+var modLSFGroup string
+var modLSFMisc string
+
+// modFlags are the flags of this cmd that describe a modification
+var modFlags = []string{"memory", "time", "cpus", "disk", "override", "priority", "retries", "report_grp", "dep_grps", "limit_grps", "deps", "cmd_deps", "env", "cloud_os", "cloud_username", "cloud_ram", "cloud_script", "lsf_queue", "lsf_resources", "lsf_hosts", "lsf_project", "lsf_group", "lsf_misc"}
+
+// modCmd represents the mod command
+var modCmd = &cobra.Command{
+	Use:   "mod",
+	Short: "Modify added commands",
+	Long: `You can modify various aspects of commands you've previously added
+using "wr add" that are currently incomplete and not running using this
+command.
+
+For example, if some of your commands were buried because they used more memory
+than you expected, you could increase their memory requirement before using
+"wr retry" on them.
+
+Specify one of the flags -f, -l, -i or -a to choose which commands you want to
+modify. Amongst those, only currently buried, delayed, dependent and ready jobs
+will be affected.
+
+-i is the report group (-i) you supplied to "wr add" when you added the job(s)
+you want to now modify. Combining with --exitcode and/or --reason lets you
+modify only those jobs in the report group that failed with a particular exit
+code and/or for a particular reason (as shown by "wr status").
+
+The file to provide -f is in the format cmd\tcwd\tmounts, with the last 2
+columns optional.
+
+In -f and -l mode you must provide the cwd the commands were set to run in, if
+CwdMatters (and must NOT be provided otherwise). Likewise provide the mounts
+JSON that was used when the command was added, if any. You can do this by using
+the -c and --mounts options, or in -f mode your file can specify the cwd and
+mounts, in case it's different for each command.
+
+The remaining options describe the modifications you want to make, and have the
+same meaning as the equivalent options of "wr add". Only the options you
+actually supply will result in a change; there are no defaults. To remove all
+dependency groups, limit groups, dependencies or environment variable
+overrides, supply an empty string to --dep_grps, --limit_grps, --deps or --env
+respectively (--cmd_deps with an empty string removes all dependencies as
+well). --env replaces any previous environment variable overrides.
+
+The cloud_* and lsf_* options only change the values you supply, leaving any
+others the commands were added with alone; supply an empty string (or 0 for
+--cloud_ram) to remove a value. Changing these may cause the commands to be
+scheduled differently.
+
+Alternatively (or as well), you can supply --json, a JSON object in the same
+format as the per-command JSON accepted by "wr add", but only the memory, time,
+cpus, disk, override, priority, retries, rep_grp, dep_grps, limit_grps, deps,
+cmd_deps, env, cloud_* and lsf_* keys may be specified. Options supplied on the
+command line take precedence over those in the JSON.
+
+The cmd, cwd, cwd_matters and mounts of a command can not be modified, since
+they determine the identity of the command; instead use "wr remove" and then
+"wr add" the command again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		checkJobSelectionFlags()
+
+		modifying := modJSON != ""
+		for _, flag := range modFlags {
+			if cmd.Flags().Changed(flag) {
+				modifying = true
+				break
+			}
+		}
+		if !modifying {
+			die("you must specify at least one modification to make")
+		}
+
+		jvj := &jobqueue.JobViaJSON{}
+		if modJSON != "" {
+			err := json.Unmarshal([]byte(modJSON), jvj)
+			if err != nil {
+				die("bad --json: %s", err)
+			}
+		}
+
+		if cmd.Flags().Changed("memory") {
+			jvj.Memory = modMem
+		}
+		if cmd.Flags().Changed("time") {
+			jvj.Time = modTime
+		}
+		if cmd.Flags().Changed("cpus") {
+			jvj.CPUs = &modCPUs
+		}
+		if cmd.Flags().Changed("disk") {
+			jvj.Disk = &modDisk
+		}
+		if cmd.Flags().Changed("override") {
+			jvj.Override = &modOvr
+		}
+		if cmd.Flags().Changed("priority") {
+			jvj.Priority = &modPri
+		}
+		if cmd.Flags().Changed("retries") {
+			jvj.Retries = &modRet
+		}
+		if cmd.Flags().Changed("report_grp") {
+			jvj.RepGrp = modRepGroup
+		}
+		if cmd.Flags().Changed("dep_grps") {
+			jvj.DepGrps = splitOrEmpty(modDepGroups)
+		}
+		if cmd.Flags().Changed("limit_grps") {
+			jvj.LimitGrps = splitOrEmpty(modLimitGroups)
+		}
+		if cmd.Flags().Changed("deps") || cmd.Flags().Changed("cmd_deps") {
+			jvj.Deps = []string{}
+			jvj.CmdDeps = jobqueue.Dependencies{}
+		}
+		if cmd.Flags().Changed("deps") {
+			jvj.Deps = splitOrEmpty(modGroupDeps)
+		}
+		if cmd.Flags().Changed("cmd_deps") && modCmdDeps != "" {
+			cols := strings.Split(modCmdDeps, ",")
+			if len(cols)%2 != 0 {
+				die("--cmd_deps must have an even number of comma-separated entries")
+			}
+			jvj.CmdDeps = colsToDeps(cols)
+		}
+		if cmd.Flags().Changed("env") {
+			jvj.Env = splitOrEmpty(modEnv)
+		}
+
+		// scheduler-specific options are removed by supplying empty values
+		var removeOther []string
+		for _, opt := range []struct {
+			flag string
+			key  string
+			val  string
+			dest *string
+		}{
+			{"cloud_os", "cloud_os", modCloudOS, &jvj.CloudOS},
+			{"cloud_username", "cloud_user", modCloudUser, &jvj.CloudUser},
+			{"cloud_script", "cloud_script", modCloudScript, &jvj.CloudScript},
+			{"lsf_queue", "lsf_queue", modLSFQueue, &jvj.LSFQueue},
+			{"lsf_resources", "lsf_resources", modLSFResources, &jvj.LSFResources},
+			{"lsf_hosts", "lsf_hosts", modLSFHosts, &jvj.LSFHosts},
+			{"lsf_project", "lsf_project", modLSFProject, &jvj.LSFProject},
+			{"lsf_group", "lsf_group", modLSFGroup, &jvj.LSFGroup},
+			{"lsf_misc", "lsf_misc", modLSFMisc, &jvj.LSFMisc},
+		} {
+			if !cmd.Flags().Changed(opt.flag) {
+				continue
+			}
+			if opt.val == "" {
+				removeOther = append(removeOther, opt.key)
+			} else {
+				*opt.dest = opt.val
+			}
+		}
+		if cmd.Flags().Changed("cloud_ram") {
+			if modCloudRAM == 0 {
+				removeOther = append(removeOther, "cloud_os_ram")
+			} else {
+				jvj.CloudOSRam = &modCloudRAM
+			}
+		}
+
+		jm, err := jvj.Modifier()
+		if err != nil {
+			die("%s", err)
+		}
+		if len(removeOther) > 0 {
+			if jm.Other == nil {
+				jm.Other = make(map[string]string)
+			}
+			for _, key := range removeOther {
+				jm.Other[key] = ""
+			}
+			jm.OtherSet = true
+		}
+
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		jes := getJobsToManipulate(jq, "", cmd.Flags().Changed("exitcode"), jobqueue.JobStateBuried, jobqueue.JobStateDelayed, jobqueue.JobStateDependent, jobqueue.JobStateReady)
+		if len(jes) == 0 {
+			die("No matching jobs found")
+		}
+
+		modified, err := jq.Modify(jes, jm)
+		if err != nil {
+			die("failed to modify desired jobs: %s", err)
+		}
+		info("Modified %d incomplete, non-running commands (out of %d eligible)", modified, len(jes))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(modCmd)
+
+	// flags specific to this sub-command
+	modCmd.Flags().BoolVarP(&cmdAll, "all", "a", false, "modify all of your incomplete, non-running commands")
+	modCmd.Flags().StringVarP(&cmdFileStatus, "file", "f", "", "file containing commands you want to modify; - means read from STDIN")
+	modCmd.Flags().StringVarP(&cmdIDStatus, "identifier", "i", "", "identifier of the commands you want to modify")
+	modCmd.Flags().StringVarP(&cmdLine, "cmdline", "l", "", "a command line you want to modify")
+	modCmd.Flags().StringVarP(&cmdCwd, "cwd", "c", "", "working dir that the command(s) specified by -l or -f were set to run in")
+	modCmd.Flags().StringVar(&cmdMounts, "mounts", "", "mounts that the command(s) specified by -l or -f were set to use")
+	modCmd.Flags().IntVar(&cmdExitcode, "exitcode", 0, "only modify commands that exited with this exit code")
+	modCmd.Flags().StringVar(&cmdFailReason, "reason", "", "only modify commands that failed for this reason")
+
+	modCmd.Flags().StringVar(&modRepGroup, "report_grp", "", "new reporting group for your commands")
+	modCmd.Flags().StringVarP(&modDepGroups, "dep_grps", "e", "", "new comma-separated list of dependency groups")
+	modCmd.Flags().StringVar(&modLimitGroups, "limit_grps", "", "new comma-separated list of limit groups")
+	modCmd.Flags().StringVarP(&modMem, "memory", "m", "", "new peak mem est. [specify units such as M for Megabytes or G for Gigabytes]")
+	modCmd.Flags().StringVarP(&modTime, "time", "t", "", "new max time est. [specify units such as m for minutes or h for hours]")
+	modCmd.Flags().IntVar(&modCPUs, "cpus", 0, "new cpu cores needed")
+	modCmd.Flags().IntVar(&modDisk, "disk", 0, "new number of GB of disk space required [0 means do not check disk space]")
+	modCmd.Flags().IntVarP(&modOvr, "override", "o", 0, "[0|1|2] should your mem/time estimates override?")
+	modCmd.Flags().IntVarP(&modPri, "priority", "p", 0, "[0-255] new command priority")
+	modCmd.Flags().IntVarP(&modRet, "retries", "r", 0, "[0-255] new number of automatic retries for failed commands")
+	modCmd.Flags().StringVar(&modCmdDeps, "cmd_deps", "", "new dependencies of your commands, in the form \"command1,cwd1,command2,cwd2...\"")
+	modCmd.Flags().StringVarP(&modGroupDeps, "deps", "d", "", "new dependencies of your commands, in the form \"dep_grp1,dep_grp2...\"")
+	modCmd.Flags().StringVar(&modEnv, "env", "", "new comma-separated list of key=value environment variables to set before running the commands")
+	modCmd.Flags().StringVar(&modCloudOS, "cloud_os", "", "in the cloud, new prefix name of the OS image servers that run the commands must use")
+	modCmd.Flags().StringVar(&modCloudUser, "cloud_username", "", "in the cloud, new username needed to log in to the OS image specified by --cloud_os")
+	modCmd.Flags().IntVar(&modCloudRAM, "cloud_ram", 0, "in the cloud, new ram (MB) needed by the OS image specified by --cloud_os")
+	modCmd.Flags().StringVar(&modCloudScript, "cloud_script", "", "in the cloud, path to a new start-up script that will be run on the servers created to run these commands")
+	modCmd.Flags().StringVar(&modLSFQueue, "lsf_queue", "", "in LSF, new queue to submit the commands to (bsub -q)")
+	modCmd.Flags().StringVar(&modLSFResources, "lsf_resources", "", "in LSF, new additional resource requirement string (bsub -R)")
+	modCmd.Flags().StringVar(&modLSFHosts, "lsf_hosts", "", "in LSF, new space separated hosts or host groups to run the commands on (bsub -m)")
+	modCmd.Flags().StringVar(&modLSFProject, "lsf_project", "", "in LSF, new project to assign the commands to (bsub -P)")
+	modCmd.Flags().StringVar(&modLSFGroup, "lsf_group", "", "in LSF, new user group to submit the commands under (bsub -G)")
+	modCmd.Flags().StringVar(&modLSFMisc, "lsf_misc", "", "in LSF, new whitespace separated additional bsub options")
+	modCmd.Flags().StringVarP(&modJSON, "json", "j", "", "modifications to make, in JSON format")
+
+	modCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+}
+
+// splitOrEmpty splits a comma-separated string, returning an empty (but not
+// nil) slice for the empty string.
+func splitOrEmpty(str string) []string {
+	if str == "" {
+		return []string{}
+	}
+	return strings.Split(str, ",")
+}
@@ -0,0 +1,246 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VertebrateResequencing/wr/jobqueue"
+	"github.com/spf13/cobra"
+)
+
+// options for this cmd
+var notifyName string
+var notifyURL string
+var notifyEvents string
+var notifyRepGroup string
+var notifyStates string
+var notifyDebounce string
+
+// notifyCmd represents the notify command
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Get notified of events via webhooks",
+	Long: `Have the manager tell you when things happen by POSTing to a URL.
+
+Instead of polling the web interface or "wr status", you can have the manager
+POST JSON to a webhook URL of your choice when your jobs get buried, when all
+the jobs in a report group finish, when cloud servers go bad, or when the job
+scheduler has problems. Subscriptions are stored in the manager's database, so
+survive it being restarted.
+
+Use the 'add' sub-command to create a subscription, 'list' to see your
+subscriptions and how their deliveries have gone, and 'remove' to stop one.`,
+}
+
+// add sub-command adds a subscription
+var notifyAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Subscribe a webhook to events",
+	Long: `Subscribe a webhook URL to be notified of events.
+
+--events is a comma separated list of the kinds of event you want to hear
+about:
+job: a job changed to one of the --states (by default, when it got buried).
+repgroup: all of the jobs in a report group that were in the queue have now
+  completed, been removed or been buried (you are told how many were buried).
+server: a cloud server went bad.
+scheduler: the job scheduler reported a problem.
+By default you hear about all of them.
+
+--report_grp limits job and repgroup events to those report groups that match
+the given glob, eg. "myproject*".
+
+--states is a comma separated list of the states jobs must change to for job
+events to be sent, from: delayed, ready, running, buried, dependent, complete,
+deleted.
+
+--debounce lets you receive events in batches instead of one at a time: after
+an event happens, further events are collected for this long before they are
+all sent together. Use 0 to send every event straight away.
+
+What gets POSTed is a JSON object with the keys "Subscription" (the --name),
+"Manager" (the host:port of the manager's web interface) and "Notifications",
+an array of objects, each with an "Event", a "Time", and depending on the
+event, some of "JobKey", "Cmd", "RepGroup", "FromState", "State", "Exitcode",
+"FailReason", "Buried", "ServerID", "ServerName", "ServerIP" and "Msg".
+
+If the URL doesn't respond with a 2xx status code, the POST is retried a few
+times with increasing delays, after which the notifications are dropped.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if notifyName == "" {
+			die("--name is required")
+		}
+		if notifyURL == "" {
+			die("--url is required")
+		}
+
+		var events []jobqueue.NotifyEvent
+		for _, event := range splitCommaList(notifyEvents) {
+			events = append(events, jobqueue.NotifyEvent(event))
+		}
+		var states []jobqueue.JobState
+		for _, state := range splitCommaList(notifyStates) {
+			states = append(states, jobqueue.JobState(state))
+		}
+		debounce, err := time.ParseDuration(notifyDebounce)
+		if err != nil {
+			die("--debounce was not specified correctly: %s", err)
+		}
+
+		sub, err := jobqueue.NewSubscription(notifyName, notifyURL, events, notifyRepGroup, states, debounce)
+		if err != nil {
+			die("%s", err)
+		}
+
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		err = jq.AddSubscription(sub)
+		if err != nil {
+			die("failed to add the subscription: %s", err)
+		}
+		info("Added subscription '%s'", notifyName)
+	},
+}
+
+// list sub-command lists subscriptions
+var notifyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List webhook subscriptions",
+	Long: `List the subscriptions you've added with "wr notify add", along with
+how many of their POSTs have succeeded and failed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		subs, err := jq.GetSubscriptions()
+		if err != nil {
+			die("failed to get the subscriptions: %s", err)
+		}
+		if len(subs) == 0 {
+			info("There are no subscriptions")
+			return
+		}
+
+		for _, sub := range subs {
+			events := make([]string, len(sub.Events))
+			for i, event := range sub.Events {
+				events[i] = string(event)
+			}
+			states := make([]string, len(sub.States))
+			for i, state := range sub.States {
+				states[i] = string(state)
+			}
+			rg := sub.RepGroup
+			if rg == "" {
+				rg = "*"
+			}
+			fmt.Printf("\n# %s\nURL: %s\nEvents: %s; Report groups: %s; States: %s; Debounce: %s\nSent: %d; Failed: %d\n", sub.Name, sub.URL, strings.Join(events, ","), rg, strings.Join(states, ","), sub.Debounce, sub.Sent, sub.Failed)
+			if sub.LastErr != "" {
+				fmt.Printf("Last error: %s\n", sub.LastErr)
+			}
+		}
+		fmt.Printf("\n")
+	},
+}
+
+// remove sub-command removes subscriptions
+var notifyRemoveCmd = &cobra.Command{
+	Use:   "remove name [name...]",
+	Short: "Stop notifying webhooks",
+	Long: `Remove subscriptions you've added with "wr notify add", so that their
+URLs are no longer POSTed to. Any notifications that were waiting to be sent
+are dropped.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			die("you must supply the name of at least one subscription to remove")
+		}
+
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		removed, err := jq.RemoveSubscriptions(args)
+		if err != nil {
+			die("failed to remove the subscriptions: %s", err)
+		}
+		info("Removed %d subscriptions (out of %d requested)", removed, len(args))
+	},
+}
+
+// splitCommaList splits a comma separated list, ignoring empty entries and
+// surrounding whitespace.
+func splitCommaList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func init() {
+	RootCmd.AddCommand(notifyCmd)
+	notifyCmd.AddCommand(notifyAddCmd)
+	notifyCmd.AddCommand(notifyListCmd)
+	notifyCmd.AddCommand(notifyRemoveCmd)
+
+	// flags specific to these sub-commands
+	notifyAddCmd.Flags().StringVarP(&notifyName, "name", "n", "", "unique name for the subscription")
+	notifyAddCmd.Flags().StringVarP(&notifyURL, "url", "u", "", "webhook URL to POST notifications to")
+	notifyAddCmd.Flags().StringVarP(&notifyEvents, "events", "e", "job,repgroup,server,scheduler", "comma separated kinds of event to be notified about")
+	notifyAddCmd.Flags().StringVarP(&notifyRepGroup, "report_grp", "i", "", "glob that report groups must match for job and repgroup events (default all)")
+	notifyAddCmd.Flags().StringVarP(&notifyStates, "states", "s", "buried", "comma separated job states that trigger job events")
+	notifyAddCmd.Flags().StringVarP(&notifyDebounce, "debounce", "d", "10s", "how long to collect events for before sending them together")
+
+	notifyAddCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+	notifyListCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+	notifyRemoveCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+}
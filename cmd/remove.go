@@ -0,0 +1,108 @@
+// Copyright © 2016-2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/VertebrateResequencing/wr/jobqueue"
+	"github.com/spf13/cobra"
+)
+
+// options for this cmd
+var removeBuried bool
+
+// removeCmd represents the remove command
+var removeCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove added commands",
+	Long: `You can remove commands you've previously added using "wr add" that
+are currently incomplete and not running using this command.
+
+For use when you've made a mistake when specifying the command and it will
+never work, or when you no longer want the command to run.
+
+Specify one of the flags -f, -l, -i or -a to choose which commands you want to
+remove. Amongst those, only currently buried, delayed, dependent and ready jobs
+will be affected. Use -b to only remove buried jobs.
+
+-i is the report group (-i) you supplied to "wr add" when you added the job(s)
+you want to now remove. Combining with --exitcode and/or --reason lets you
+remove only those jobs in the report group that failed with a particular exit
+code and/or for a particular reason (as shown by "wr status").
+
+The file to provide -f is in the format cmd\tcwd\tmounts, with the last 2
+columns optional.
+
+In -f and -l mode you must provide the cwd the commands were set to run in, if
+CwdMatters (and must NOT be provided otherwise). Likewise provide the mounts
+JSON that was used when the command was added, if any. You can do this by using
+the -c and --mounts options, or in -f mode your file can specify the cwd and
+mounts, in case it's different for each command.
+
+Commands that other commands depend upon can not be removed; remove the
+dependent commands first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		checkJobSelectionFlags()
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		var jes []*jobqueue.JobEssence
+		if removeBuried {
+			jes = getJobsToManipulate(jq, jobqueue.JobStateBuried, cmd.Flags().Changed("exitcode"), jobqueue.JobStateBuried)
+		} else {
+			jes = getJobsToManipulate(jq, "", cmd.Flags().Changed("exitcode"), jobqueue.JobStateBuried, jobqueue.JobStateDelayed, jobqueue.JobStateDependent, jobqueue.JobStateReady)
+		}
+		if len(jes) == 0 {
+			die("No matching jobs found")
+		}
+
+		removed, err := jq.Delete(jes)
+		if err != nil {
+			die("failed to remove desired jobs: %s", err)
+		}
+		info("Removed %d incomplete, non-running commands (out of %d eligible)", removed, len(jes))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(removeCmd)
+
+	// flags specific to this sub-command
+	removeCmd.Flags().BoolVarP(&cmdAll, "all", "a", false, "remove all of your incomplete, non-running commands")
+	removeCmd.Flags().StringVarP(&cmdFileStatus, "file", "f", "", "file containing commands you want to remove; - means read from STDIN")
+	removeCmd.Flags().StringVarP(&cmdIDStatus, "identifier", "i", "", "identifier of the commands you want to remove")
+	removeCmd.Flags().StringVarP(&cmdLine, "cmdline", "l", "", "a command line you want to remove")
+	removeCmd.Flags().StringVarP(&cmdCwd, "cwd", "c", "", "working dir that the command(s) specified by -l or -f were set to run in")
+	removeCmd.Flags().StringVar(&cmdMounts, "mounts", "", "mounts that the command(s) specified by -l or -f were set to use")
+	removeCmd.Flags().BoolVarP(&removeBuried, "buried", "b", false, "only remove buried commands")
+	removeCmd.Flags().IntVar(&cmdExitcode, "exitcode", 0, "only remove commands that exited with this exit code")
+	removeCmd.Flags().StringVar(&cmdFailReason, "reason", "", "only remove commands that failed for this reason")
+
+	removeCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+}
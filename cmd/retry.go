@@ -0,0 +1,102 @@
+// Copyright © 2016-2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/VertebrateResequencing/wr/jobqueue"
+	"github.com/spf13/cobra"
+)
+
+// options for this cmd (and remove)
+var cmdAll bool
+var cmdExitcode int
+var cmdFailReason string
+
+// retryCmd represents the retry command
+var retryCmd = &cobra.Command{
+	Use:   "retry",
+	Short: "Retry buried commands",
+	Long: `You can retry commands you've previously added using "wr add" that
+have since failed and become "buried" using this command.
+
+Specify one of the flags -f, -l, -i or -a to choose which commands you want to
+retry. Amongst those, only currently buried jobs will be affected.
+
+-i is the report group (-i) you supplied to "wr add" when you added the job(s)
+you want to now retry. Combining with --exitcode and/or --reason lets you retry
+only those jobs in the report group that failed with a particular exit code
+and/or for a particular reason (as shown by "wr status").
+
+The file to provide -f is in the format cmd\tcwd\tmounts, with the last 2
+columns optional.
+
+In -f and -l mode you must provide the cwd the commands were set to run in, if
+CwdMatters (and must NOT be provided otherwise). Likewise provide the mounts
+JSON that was used when the command was added, if any. You can do this by using
+the -c and --mounts options, or in -f mode your file can specify the cwd and
+mounts, in case it's different for each command.
+
+Retried commands get their "retries" count reset, so will be attempted that
+many more times before being buried again. You should fix the underlying
+problem (eg. by correcting the input files of your commands) before retrying
+them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		checkJobSelectionFlags()
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		jes := getJobsToManipulate(jq, jobqueue.JobStateBuried, cmd.Flags().Changed("exitcode"), jobqueue.JobStateBuried)
+		if len(jes) == 0 {
+			die("No matching jobs found")
+		}
+
+		retried, err := jq.Kick(jes)
+		if err != nil {
+			die("failed to retry desired jobs: %s", err)
+		}
+		info("Initiated retry of %d buried commands (out of %d eligible)", retried, len(jes))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(retryCmd)
+
+	// flags specific to this sub-command
+	retryCmd.Flags().BoolVarP(&cmdAll, "all", "a", false, "retry all of your buried commands")
+	retryCmd.Flags().StringVarP(&cmdFileStatus, "file", "f", "", "file containing commands you want to retry; - means read from STDIN")
+	retryCmd.Flags().StringVarP(&cmdIDStatus, "identifier", "i", "", "identifier of the commands you want to retry")
+	retryCmd.Flags().StringVarP(&cmdLine, "cmdline", "l", "", "a command line you want to retry")
+	retryCmd.Flags().StringVarP(&cmdCwd, "cwd", "c", "", "working dir that the command(s) specified by -l or -f were set to run in")
+	retryCmd.Flags().StringVar(&cmdMounts, "mounts", "", "mounts that the command(s) specified by -l or -f were set to use")
+	retryCmd.Flags().IntVar(&cmdExitcode, "exitcode", 0, "only retry commands that exited with this exit code")
+	retryCmd.Flags().StringVar(&cmdFailReason, "reason", "", "only retry commands that failed for this reason")
+
+	retryCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+}
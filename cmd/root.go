@@ -21,8 +21,11 @@ package cmd
 // this is the cobra file that enables subcommands and handles command-line args
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"syscall"
 	"time"
 
@@ -89,6 +92,11 @@ func init() {
 func initConfig() {
 	config = internal.ConfigLoad(deployment, false, appLogger)
 	addr = config.ManagerHost + ":" + config.ManagerPort
+	if config.ManagerStandby != "" {
+		for _, host := range strings.Split(config.ManagerStandby, ",") {
+			addr += "," + strings.TrimSpace(host) + ":" + config.ManagerPort
+		}
+	}
 }
 
 // realUsername returns the username of the current user.
@@ -235,11 +243,22 @@ func sAddr(s *jobqueue.ServerInfo) string {
 	return saddr
 }
 
+// clientToken reads the secret token that the manager stores in its token file,
+// which we need to supply to be allowed to connect to it. Returns nil if the
+// file can't be read (eg. because the manager has never been started).
+func clientToken() []byte {
+	token, err := ioutil.ReadFile(config.ManagerTokenFile)
+	if err != nil {
+		return nil
+	}
+	return bytes.TrimSpace(token)
+}
+
 // connect gives you a client connected to a queue that shouldn't be used; use
 // the client just for calling non-queue-specific methods such as getting
 // server status or shutting it down etc.
 func connect(wait time.Duration) *jobqueue.Client {
-	jq, jqerr := jobqueue.Connect("localhost:"+config.ManagerPort, wait)
+	jq, jqerr := jobqueue.Connect("localhost:"+config.ManagerPort, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), wait)
 	if jqerr == nil {
 		return jq
 	}
@@ -69,7 +69,7 @@ complete.`,
 
 		jobqueue.AppName = "wr"
 
-		jq, err := jobqueue.Connect(rserver, timeout)
+		jq, err := jobqueue.Connect(rserver, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
 		if err != nil {
 			die("%s", err)
 		}
@@ -20,12 +20,16 @@ package cmd
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"code.cloudfoundry.org/bytefmt"
 	"github.com/VertebrateResequencing/wr/internal"
 	"github.com/VertebrateResequencing/wr/jobqueue"
 	"github.com/spf13/cobra"
@@ -39,9 +43,18 @@ var cmdIDStatus string
 var cmdLine string
 var showBuried bool
 var showStd bool
+var statusStdOffset int64
+var statusStdLength int
 var showEnv bool
 var quietMode bool
 var statusLimit int
+var statusOutputFormat string
+var statusCron string
+var statusGraph string
+
+// statusPageSize is how many commands we get from the manager at a time when
+// outputting all of them in one of the --output formats.
+const statusPageSize = 1000
 
 // statusCmd represents the status command
 var statusCmd = &cobra.Command{
@@ -54,6 +67,23 @@ Specify one of the flags -f, -l  or -i to choose which commands you want the
 status of. If none are supplied, it gives you an overview of all your currently
 incomplete commands.
 
+--cron shows you the history of the firings of a schedule you added with "wr
+cron add", along with the current status of the command it adds.
+
+--graph, used with -i, outputs the dependency graph of the commands with that
+identifier instead of their status, including every command upstream of them
+even if those have a different identifier. "dot" gives you the graph in the DOT
+language, which you can render with Graphviz (eg. wr status -i myrg --graph dot
+| dot -Tsvg > myrg.svg); nodes are coloured by state and dependencies that
+have been satisfied are dashed. "json" gives you an object with nodes (key, cmd,
+rep_grp, dep_grps and state) and edges (from, to, via and resolved), where via
+is the dependency group that made the dependency, or "essence" if it was made
+by specifying the command line of the upstream command.
+
+If you added a job array using 'wr add --param', -i can pick out individual
+commands of the array by their index, like -i myrg[17], or a range of them, like
+-i myrg[1-10].
+
 The file to provide -f is in the format cmd\tcwd\tmounts, with the last 2
 columns optional.
 
@@ -68,7 +98,44 @@ grouped together and only a random 1 of them is displayed (and you are told how
 many were skipped). --limit changes how many commands in each of these groups
 are displayed. A limit of 0 turns off grouping and shows all your desired
 commands individually, but you could hit a timeout if retrieving the details of
-very many (tens of thousands+) commands.`,
+very many (tens of thousands+) commands, unless you also use --output.
+
+-s normally shows you the first and last 4KB of the STDOUT and STDERR of failed
+commands. For commands added with std_capture (see 'wr add -h'), it instead
+shows you part of their complete STDOUT and STDERR, whether they failed or not:
+--std_length bytes starting from byte --std_offset.
+
+--output lets you get machine-readable output instead of the default human
+readable text. "json" gives you a JSON array of objects, "jsonl" gives you one
+JSON object per line, and "tsv" gives you tab separated columns with a header
+line. In all cases the same fields are output in the same order for every
+command: key, cmd, cwd, cwd_matters, change_home, actual_cwd, mounts, rep_grp,
+array_index (0 if not part of a job array), req_grp, dep_grps, limit_grps, deps,
+behaviours, memory (MB), time (seconds), cpus, disk (GB), override, priority,
+retries, retry_policy (in the same form as for 'wr add', or null if none),
+kill_grace (seconds, 0 for the default), time_limit (seconds, 0 if none),
+time_limit_mult (the multiple of time, 0 if none), state, attempts,
+attempt_history, until_buried, peak_ram (MB), peak_disk (MB, only measured for
+commands that weren't cwd_matters), exited, exitcode, fail_reason, pid, host,
+host_id, host_ip, started, ended (RFC3339 format, empty if not yet
+started/ended), walltime (seconds), cputime (seconds), io_read and io_write
+(bytes, only known for commands that ran in their own cgroup), similar, stdout,
+stderr, env, copied_files, inputs, outputs, skipped (true if the command was not
+run because its outputs were up to date), captured_stdout and captured_stderr
+(the locations of the complete output of commands added with std_capture, as
+described in 'wr add -h'). similar is the number of other commands in the same
+--limit group that were not output; use --limit 0 to get every command. stdout,
+stderr and env are only filled in if you also supply -s and -e respectively (and
+not in -f mode). copied_files are the absolute paths on the manager's machine of
+any files the command copied there using the copy_to_manager behaviour.
+attempt_history holds the outcomes of the most recent runs of the command, each
+with a host, exitcode, fail_reason, started, ended and walltime. In tsv mode,
+list values are comma separated, retry_policy is given as JSON, and tabs,
+newlines and backslashes in values are backslash escaped; attempt_history
+entries are given as exitcode@host:walltime. With --limit 0, commands are
+retrieved from the manager and written out a batch at a time, so you can safely
+output millions of them (though commands that change state while this happens
+may be missed or output twice).`,
 	Run: func(cmd *cobra.Command, args []string) {
 		set := 0
 		if cmdFileStatus != "" {
@@ -80,8 +147,25 @@ very many (tens of thousands+) commands.`,
 		if cmdLine != "" {
 			set++
 		}
+		if statusCron != "" {
+			set++
+		}
 		if set > 1 {
-			die("-f, -i and -l are mutually exclusive; only specify one of them")
+			die("-f, -i, -l and --cron are mutually exclusive; only specify one of them")
+		}
+		switch statusOutputFormat {
+		case "", "json", "jsonl", "tsv":
+		default:
+			die("--output must be one of json, jsonl or tsv")
+		}
+		switch statusGraph {
+		case "":
+		case "dot", "json":
+			if cmdIDStatus == "" {
+				die("--graph can only be used with -i")
+			}
+		default:
+			die("--graph must be one of dot or json")
 		}
 		var cmdState jobqueue.JobState
 		if showBuried {
@@ -89,12 +173,7 @@ very many (tens of thousands+) commands.`,
 		}
 		timeout := time.Duration(timeoutint) * time.Second
 
-		var defaultMounts jobqueue.MountConfigs
-		if cmdMounts != "" {
-			defaultMounts = mountParseJSON(cmdMounts)
-		}
-
-		jq, err := jobqueue.Connect(addr, timeout)
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
 		if err != nil {
 			die("%s", err)
 		}
@@ -105,70 +184,40 @@ very many (tens of thousands+) commands.`,
 			}
 		}()
 
-		var jobs []*jobqueue.Job
-		showextra := true
-		switch {
-		case set == 0:
-			// get incomplete jobs
-			jobs, err = jq.GetIncomplete(statusLimit, cmdState, showStd, showEnv)
-		case cmdIDStatus != "":
-			// get all jobs with this identifier (repgroup)
-			jobs, err = jq.GetByRepGroup(cmdIDStatus, statusLimit, cmdState, showStd, showEnv)
-		case cmdFileStatus != "":
-			// get jobs that have the supplied commands. We support a cmd\tcwd
-			// format file
-			var reader io.Reader
-			if cmdFileStatus == "-" {
-				reader = os.Stdin
-			} else {
-				reader, err = os.Open(cmdFileStatus)
-				if err != nil {
-					die("could not open file '%s': %s", cmdFileStatus, err)
-				}
-				defer internal.LogClose(appLogger, reader.(*os.File), "cmds file", "path", cmdFileStatus)
-			}
-			scanner := bufio.NewScanner(reader)
-			var jes []*jobqueue.JobEssence
-			desired := 0
-			for scanner.Scan() {
-				cols := strings.Split(scanner.Text(), "\t")
-				colsn := len(cols)
-				if colsn < 1 || cols[0] == "" {
-					continue
-				}
-				var cwd string
-				if colsn < 2 || cols[1] == "" {
-					cwd = cmdCwd
-				} else {
-					cwd = cols[1]
-				}
+		if statusCron != "" {
+			showCronStatus(jq, statusCron)
+			return
+		}
 
-				var mounts jobqueue.MountConfigs
-				if colsn < 3 || cols[2] == "" {
-					mounts = defaultMounts
-				} else {
-					mounts = mountParseJSON(cols[2])
-				}
+		if statusGraph != "" {
+			showGraph(jq, cmdIDStatus, statusGraph)
+			return
+		}
 
-				jes = append(jes, &jobqueue.JobEssence{Cmd: cols[0], Cwd: cwd, MountConfigs: mounts})
-				desired++
+		if statusOutputFormat != "" && statusLimit == 0 {
+			// (std and env are not retrieved in -f mode)
+			showextra := set == 0 || cmdFileStatus == ""
+			jo, erro := newJobOutputter(os.Stdout, statusOutputFormat, showextra && showStd, showextra && showEnv, jq.ServerInfo.CopyDir)
+			if erro == nil {
+				erro = streamJobs(jq, cmdState, set == 0, showStd, showEnv, jo.write)
 			}
-			jobs, err = jq.GetByEssences(jes)
-			if len(jobs) < desired {
-				warn("%d/%d cmds were not found", desired-len(jobs), desired)
+			if erro == nil {
+				erro = jo.close()
 			}
-			showextra = false
-		default:
-			// get job that has the supplied command
-			var job *jobqueue.Job
-			job, err = jq.GetByEssence(&jobqueue.JobEssence{Cmd: cmdLine, Cwd: cmdCwd, MountConfigs: defaultMounts}, showStd, showEnv)
-			if job != nil {
-				jobs = append(jobs, job)
+			if erro != nil {
+				die("failed to output jobs: %s", erro)
 			}
+			return
 		}
 
-		if err != nil {
-			die("failed to get jobs corresponding to your settings: %s", err)
+		jobs, showextra := getJobs(jq, cmdState, set == 0, statusLimit, showStd, showEnv)
+
+		if statusOutputFormat != "" {
+			err = outputJobs(os.Stdout, jobs, statusOutputFormat, showextra && showStd, showextra && showEnv, jq.ServerInfo.CopyDir)
+			if err != nil {
+				die("failed to output jobs: %s", err)
+			}
+			return
 		}
 
 		if quietMode {
@@ -193,6 +242,15 @@ very many (tens of thousands+) commands.`,
 			}
 			fmt.Printf("complete: %d\nrunning: %d\nready: %d\ndependent: %d\nlost contact: %d\ndelayed: %d\nburied: %d\n", c, ru, re, dep, l, d, b)
 		} else {
+			// get the current state of any limit groups our jobs are in
+			var limits map[string]*jobqueue.LimitGroup
+			for _, job := range jobs {
+				if len(job.LimitGroups) > 0 {
+					limits = getLimitGroups(jq)
+					break
+				}
+			}
+
 			// print out status information for each job
 			for _, job := range jobs {
 				cwd := job.Cwd
@@ -211,7 +269,20 @@ very many (tens of thousands+) commands.`,
 				if len(job.Behaviours) > 0 {
 					behaviours = fmt.Sprintf("Behaviours: %s\n", job.Behaviours)
 				}
-				fmt.Printf("\n# %s\nCwd: %s\n%s%s%sId: %s; Requirements group: %s; Priority: %d; Attempts: %d\nExpected requirements: { memory: %dMB; time: %s; cpus: %d disk: %dGB }\n", job.Cmd, cwd, mounts, homeChanged, behaviours, job.RepGroup, job.ReqGroup, job.Priority, job.Attempts, job.Requirements.RAM, job.Requirements.Time, job.Requirements.Cores, job.Requirements.Disk)
+				id := job.RepGroup
+				if job.ArrayIndex > 0 {
+					id = fmt.Sprintf("%s[%d]", id, job.ArrayIndex)
+				}
+				fmt.Printf("\n# %s\nCwd: %s\n%s%s%sId: %s; Requirements group: %s; Priority: %d; Attempts: %d\nExpected requirements: { memory: %dMB; time: %s; cpus: %d disk: %dGB }\n", job.Cmd, cwd, mounts, homeChanged, behaviours, id, job.ReqGroup, job.Priority, job.Attempts, job.Requirements.RAM, job.Requirements.Time, job.Requirements.Cores, job.Requirements.Disk)
+				if len(job.LimitGroups) > 0 {
+					fmt.Printf("Limit groups: %s\n", describeLimitGroups(job.LimitGroups, limits))
+				}
+				if len(job.Inputs) > 0 {
+					fmt.Printf("Inputs: %s\n", describeFiles(job, job.Inputs))
+				}
+				if len(job.Outputs) > 0 {
+					fmt.Printf("Outputs: %s\n", describeFiles(job, job.Outputs))
+				}
 
 				switch job.State {
 				case jobqueue.JobStateDelayed:
@@ -221,13 +292,17 @@ very many (tens of thousands+) commands.`,
 				case jobqueue.JobStateDependent:
 					fmt.Println("Status: dependent on other jobs")
 				case jobqueue.JobStateBuried:
-					fmt.Printf("Status: buried - you need to fix the problem and then `wr kick` (attempted at %s)\n", job.StartTime.Format(shortTimeFormat))
+					fmt.Printf("Status: buried - you need to fix the problem and then `wr retry` (attempted at %s)\n", job.StartTime.Format(shortTimeFormat))
 				case jobqueue.JobStateReserved, jobqueue.JobStateRunning:
 					fmt.Printf("Status: running (started %s)\n", job.StartTime.Format(shortTimeFormat))
 				case jobqueue.JobStateLost:
 					fmt.Printf("Status: lost contact (started %s; lost %s)\n", job.StartTime.Format(shortTimeFormat), job.EndTime.Format(shortTimeFormat))
 				case jobqueue.JobStateComplete:
-					fmt.Printf("Status: complete (started %s; ended %s)\n", job.StartTime.Format(shortTimeFormat), job.EndTime.Format(shortTimeFormat))
+					if job.Skipped {
+						fmt.Printf("Status: complete (skipped because its outputs were up to date; %s)\n", job.EndTime.Format(shortTimeFormat))
+					} else {
+						fmt.Printf("Status: complete (started %s; ended %s)\n", job.StartTime.Format(shortTimeFormat), job.EndTime.Format(shortTimeFormat))
+					}
 				}
 
 				if job.FailReason != "" {
@@ -244,8 +319,18 @@ very many (tens of thousands+) commands.`,
 					if job.State != jobqueue.JobStateComplete {
 						prefix = "Stats of previous attempt"
 					}
-					fmt.Printf("%s: { Exit code: %d; Peak memory: %dMB; Wall time: %s; CPU time: %s }\nHost: %s (IP: %s%s); Pid: %d\n", prefix, job.Exitcode, job.PeakRAM, job.WallTime(), job.CPUtime, job.Host, job.HostIP, hostID, job.Pid)
-					if showextra && showStd && job.Exitcode != 0 {
+					var extraStats string
+					if job.PeakDisk > 0 {
+						extraStats = fmt.Sprintf("; Peak disk: %dMB", job.PeakDisk)
+					}
+					if job.IORead > 0 || job.IOWrite > 0 {
+						extraStats += fmt.Sprintf("; IO read: %s; IO written: %s", bytefmt.ByteSize(job.IORead), bytefmt.ByteSize(job.IOWrite))
+					}
+					fmt.Printf("%s: { Exit code: %d; Peak memory: %dMB; Wall time: %s; CPU time: %s%s }\nHost: %s (IP: %s%s); Pid: %d\n", prefix, job.Exitcode, job.PeakRAM, job.WallTime(), job.CPUtime, extraStats, job.Host, job.HostIP, hostID, job.Pid)
+					if showextra && showStd && job.CapturedStdOut != "" {
+						showCapturedStd(jq, job, false)
+						showCapturedStd(jq, job, true)
+					} else if showextra && showStd && job.Exitcode != 0 {
 						stdout, err := job.StdOut()
 						if err != nil {
 							warn("problem reading the cmd's STDOUT: %s", err)
@@ -278,6 +363,21 @@ very many (tens of thousands+) commands.`,
 					}
 				}
 
+				if len(job.AttemptHistory) > 1 {
+					fmt.Println("Attempt history:")
+					for i, attempt := range job.AttemptHistory {
+						var problem string
+						if attempt.FailReason != "" {
+							problem = "; Problem: " + attempt.FailReason
+						}
+						fmt.Printf("  %d: { Exit code: %d; Host: %s; Wall time: %s; Ended: %s%s }\n", i+1, attempt.Exitcode, attempt.Host, attempt.WallTime(), attempt.EndTime.Format(shortTimeFormat), problem)
+					}
+				}
+
+				for _, copied := range job.CopiedFiles {
+					fmt.Printf("Copied to manager: %s\n", filepath.Join(jq.ServerInfo.CopyDir, copied))
+				}
+
 				if showextra && showEnv {
 					env, err := job.Env()
 					if err != nil {
@@ -320,9 +420,739 @@ func init() {
 	statusCmd.Flags().StringVar(&cmdMounts, "mounts", "", "mounts that the command(s) specified by -l or -f were set to use")
 	statusCmd.Flags().BoolVarP(&showBuried, "buried", "b", false, "in default or -i mode only, only show the status of buried commands")
 	statusCmd.Flags().BoolVarP(&showStd, "std", "s", false, "except in -f mode, also show the most recent STDOUT and STDERR of incomplete commands")
+	statusCmd.Flags().Int64Var(&statusStdOffset, "std_offset", 0, "with -s, for commands that captured their complete output, the byte offset to show it from")
+	statusCmd.Flags().IntVar(&statusStdLength, "std_length", 65536, "with -s, for commands that captured their complete output, the number of bytes of it to show")
 	statusCmd.Flags().BoolVarP(&showEnv, "env", "e", false, "except in -f mode, also show the environment variables the command(s) ran with")
 	statusCmd.Flags().BoolVarP(&quietMode, "quiet", "q", false, "minimal verbosity: just display status counts")
 	statusCmd.Flags().IntVar(&statusLimit, "limit", 1, "number of commands that share the same properties to display; 0 displays all")
+	statusCmd.Flags().StringVar(&statusCron, "cron", "", "name of a cron schedule you want the firing history of")
+	statusCmd.Flags().StringVar(&statusGraph, "graph", "", "with -i, output the dependency graph of the commands instead: dot|json")
+	statusCmd.Flags().StringVarP(&statusOutputFormat, "output", "o", "", "output format: json|jsonl|tsv (default human readable text)")
 
 	statusCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
 }
+
+// showCronStatus prints out the details and firing history of the named cron
+// schedule, along with the current state of the command it adds.
+func showCronStatus(jq *jobqueue.Client, name string) {
+	schedules, err := jq.GetSchedules()
+	if err != nil {
+		die("failed to get cron schedules: %s", err)
+	}
+	var sc *jobqueue.CronSchedule
+	for _, s := range schedules {
+		if s.Name == name {
+			sc = s
+			break
+		}
+	}
+	if sc == nil {
+		die("there is no cron schedule named '%s'", name)
+	}
+
+	fmt.Printf("\n# %s\nSchedule: %s; Overlap: %s; Added: %s; Next: %s\nCmd: %s\n", sc.Name, sc.Spec, sc.Overlap, sc.Created.Format(shortTimeFormat), sc.Next.Format(shortTimeFormat), sc.Job.Cmd)
+	if sc.Pending > 0 {
+		fmt.Printf("Pending firings: %d\n", sc.Pending)
+	}
+
+	if len(sc.History) > 0 {
+		job, errg := jq.GetByEssence(&jobqueue.JobEssence{JobKey: sc.LastFiring().JobKey}, false, false)
+		if errg != nil {
+			warn("failed to get the scheduled command: %s", errg)
+		} else if job != nil {
+			fmt.Printf("Current command status: %s\n", job.State)
+		}
+	}
+
+	fmt.Println("Firings:")
+	if len(sc.History) == 0 {
+		fmt.Println(" none yet")
+	}
+	for _, cf := range sc.History {
+		if cf.Err != "" {
+			fmt.Printf(" %s %s: %s\n", cf.Time.Format(shortTimeFormat), cf.Outcome, cf.Err)
+		} else {
+			fmt.Printf(" %s %s\n", cf.Time.Format(shortTimeFormat), cf.Outcome)
+		}
+	}
+	fmt.Printf("\n")
+}
+
+// getLimitGroups gets the current LimitGroups from the manager, keyed on their
+// names. Failure to get them is only warned about, since they are just extra
+// information for status.
+func getLimitGroups(jq *jobqueue.Client) map[string]*jobqueue.LimitGroup {
+	lgs, err := jq.GetLimitGroups()
+	if err != nil {
+		warn("failed to get the limit groups: %s", err)
+		return nil
+	}
+	limits := make(map[string]*jobqueue.LimitGroup)
+	for _, lg := range lgs {
+		limits[lg.Name] = lg
+	}
+	return limits
+}
+
+// describeLimitGroups returns a description of the given limit group names
+// that includes how many commands are running in each, for those that have had
+// a limit set.
+func describeLimitGroups(names []string, limits map[string]*jobqueue.LimitGroup) string {
+	descs := make([]string, len(names))
+	for i, name := range names {
+		if lg, exists := limits[name]; exists {
+			descs[i] = fmt.Sprintf("%s (%d/%d running)", name, lg.Running, lg.Max)
+		} else {
+			descs[i] = fmt.Sprintf("%s (no limit)", name)
+		}
+	}
+	return strings.Join(descs, "; ")
+}
+
+// describeFiles returns a description of the given input or output file paths
+// of a job that says if each of them currently exists (as seen from this
+// machine).
+func describeFiles(job *jobqueue.Job, paths []string) string {
+	descs := make([]string, len(paths))
+	for i, path := range paths {
+		exists := "exists"
+		if _, err := os.Stat(job.FilePath(path)); err != nil {
+			exists = "missing"
+		}
+		descs[i] = fmt.Sprintf("%s (%s)", job.FilePath(path), exists)
+	}
+	return strings.Join(descs, "; ")
+}
+
+// showCapturedStd prints the --std_offset and --std_length selected part of the
+// complete STDOUT (or STDERR) of a job that had its output captured.
+func showCapturedStd(jq *jobqueue.Client, job *jobqueue.Job, stderr bool) {
+	name := "StdOut"
+	if stderr {
+		name = "StdErr"
+	}
+	data, err := jq.GetCapturedStd(job, stderr, statusStdOffset, statusStdLength)
+	if err != nil {
+		warn("problem reading the cmd's complete %s: %s", name, err)
+		return
+	}
+	if len(data) == 0 {
+		fmt.Printf("%s (from byte %d of complete output): [none]\n", name, statusStdOffset)
+		return
+	}
+	fmt.Printf("%s (bytes %d-%d of complete output):\n%s\n", name, statusStdOffset, statusStdOffset+int64(len(data))-1, data)
+}
+
+// showGraph prints out the dependency graph of the commands with the given
+// RepGroup in the given format (dot or json).
+func showGraph(jq *jobqueue.Client, repGroup string, format string) {
+	graph, err := jq.GetGraph(repGroup)
+	if err != nil {
+		die("failed to get the dependency graph: %s", err)
+	}
+	if graph == nil || len(graph.Nodes) == 0 {
+		die("there are no commands with identifier '%s'", repGroup)
+	}
+
+	if format == "dot" {
+		fmt.Print(graph.DOT())
+		return
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(graph)
+	if err != nil {
+		die("failed to output the dependency graph: %s", err)
+	}
+}
+
+// getJobs gets the jobs the user asked for using the -f, -i, -l and -c and
+// --mounts options (shared by status, kill, retry and remove). If currentJobs
+// is true, all incomplete jobs are returned instead. Limit, state, getStd and
+// getEnv have the same meaning as for jobqueue.Client.GetByRepGroup(), though
+// state only applies in -i or currentJobs mode. The returned bool is false if
+// the jobs were retrieved in -f mode, in which case their std and env were not
+// retrieved.
+func getJobs(jq *jobqueue.Client, cmdState jobqueue.JobState, currentJobs bool, statusLimit int, getStd bool, getEnv bool) ([]*jobqueue.Job, bool) {
+	var defaultMounts jobqueue.MountConfigs
+	if cmdMounts != "" {
+		defaultMounts = mountParseJSON(cmdMounts)
+	}
+
+	var jobs []*jobqueue.Job
+	var err error
+	showextra := true
+	switch {
+	case currentJobs:
+		// get incomplete jobs
+		jobs, err = jq.GetIncomplete(statusLimit, cmdState, getStd, getEnv)
+	case cmdIDStatus != "":
+		// get all jobs with this identifier (repgroup), or just the desired
+		// elements of a job array if given an identifier like repgroup[1-10]
+		if repGroup, first, last, ok := jobqueue.ParseArrayAddress(cmdIDStatus); ok {
+			jobs, err = jq.GetByRepGroup(repGroup, 0, cmdState, getStd, getEnv)
+			jobs = jobqueue.FilterArrayIndices(jobs, first, last)
+		}
+		if err == nil && len(jobs) == 0 {
+			jobs, err = jq.GetByRepGroup(cmdIDStatus, statusLimit, cmdState, getStd, getEnv)
+		}
+	case cmdFileStatus != "":
+		// get jobs that have the supplied commands
+		jes := readCmdsFile(defaultMounts)
+		jobs, err = jq.GetByEssences(jes)
+		if len(jobs) < len(jes) {
+			warn("%d/%d cmds were not found", len(jes)-len(jobs), len(jes))
+		}
+		showextra = false
+	default:
+		// get job that has the supplied command
+		var job *jobqueue.Job
+		job, err = jq.GetByEssence(&jobqueue.JobEssence{Cmd: cmdLine, Cwd: cmdCwd, MountConfigs: defaultMounts}, getStd, getEnv)
+		if job != nil {
+			jobs = append(jobs, job)
+		}
+	}
+
+	if err != nil {
+		die("failed to get jobs corresponding to your settings: %s", err)
+	}
+
+	return jobs, showextra
+}
+
+// readCmdsFile reads the -f file (or STDIN if it is "-"), which is in cmd\tcwd
+// \tmounts format, with the last 2 columns optional, returning JobEssences for
+// the commands in it. -c and --mounts (already parsed as defaultMounts) are
+// used when the cwd and mounts columns are missing.
+func readCmdsFile(defaultMounts jobqueue.MountConfigs) []*jobqueue.JobEssence {
+	var reader io.Reader
+	if cmdFileStatus == "-" {
+		reader = os.Stdin
+	} else {
+		var err error
+		reader, err = os.Open(cmdFileStatus)
+		if err != nil {
+			die("could not open file '%s': %s", cmdFileStatus, err)
+		}
+		defer internal.LogClose(appLogger, reader.(*os.File), "cmds file", "path", cmdFileStatus)
+	}
+	scanner := bufio.NewScanner(reader)
+	var jes []*jobqueue.JobEssence
+	for scanner.Scan() {
+		cols := strings.Split(scanner.Text(), "\t")
+		colsn := len(cols)
+		if colsn < 1 || cols[0] == "" {
+			continue
+		}
+		var cwd string
+		if colsn < 2 || cols[1] == "" {
+			cwd = cmdCwd
+		} else {
+			cwd = cols[1]
+		}
+
+		var mounts jobqueue.MountConfigs
+		if colsn < 3 || cols[2] == "" {
+			mounts = defaultMounts
+		} else {
+			mounts = mountParseJSON(cols[2])
+		}
+
+		jes = append(jes, &jobqueue.JobEssence{Cmd: cols[0], Cwd: cwd, MountConfigs: mounts})
+	}
+	return jes
+}
+
+// streamJobs is like getJobs() with a limit of 0, but instead of returning the
+// jobs, it gets them from the manager statusPageSize at a time and passes each
+// batch to the given function before getting the next, so that neither we nor
+// the manager need to hold all of them in memory at once.
+func streamJobs(jq *jobqueue.Client, cmdState jobqueue.JobState, currentJobs bool, getStd bool, getEnv bool, handle func(jobs []*jobqueue.Job) error) error {
+	// pageThrough calls get with increasing offsets until it returns less than
+	// a full page, passing the jobs (filtered, if filter isn't nil) to handle,
+	// and returns how many jobs were handled
+	pageThrough := func(get func(offset int) ([]*jobqueue.Job, error), filter func(jobs []*jobqueue.Job) []*jobqueue.Job) (int, error) {
+		handled := 0
+		for offset := 0; ; offset += statusPageSize {
+			jobs, err := get(offset)
+			if err != nil {
+				return handled, err
+			}
+			full := len(jobs) == statusPageSize
+			if filter != nil {
+				jobs = filter(jobs)
+			}
+			if len(jobs) > 0 {
+				handled += len(jobs)
+				err = handle(jobs)
+				if err != nil {
+					return handled, err
+				}
+			}
+			if !full {
+				return handled, nil
+			}
+		}
+	}
+
+	switch {
+	case currentJobs:
+		_, err := pageThrough(func(offset int) ([]*jobqueue.Job, error) {
+			return jq.GetIncompletePage(cmdState, offset, statusPageSize, getStd, getEnv)
+		}, nil)
+		return err
+	case cmdIDStatus != "":
+		if repGroup, first, last, ok := jobqueue.ParseArrayAddress(cmdIDStatus); ok {
+			handled, err := pageThrough(func(offset int) ([]*jobqueue.Job, error) {
+				return jq.GetByRepGroupPage(repGroup, cmdState, offset, statusPageSize, getStd, getEnv)
+			}, func(jobs []*jobqueue.Job) []*jobqueue.Job {
+				return jobqueue.FilterArrayIndices(jobs, first, last)
+			})
+			if err != nil || handled > 0 {
+				return err
+			}
+		}
+		_, err := pageThrough(func(offset int) ([]*jobqueue.Job, error) {
+			return jq.GetByRepGroupPage(cmdIDStatus, cmdState, offset, statusPageSize, getStd, getEnv)
+		}, nil)
+		return err
+	case cmdFileStatus != "":
+		var defaultMounts jobqueue.MountConfigs
+		if cmdMounts != "" {
+			defaultMounts = mountParseJSON(cmdMounts)
+		}
+		jes := readCmdsFile(defaultMounts)
+		found := 0
+		for start := 0; start < len(jes); start += statusPageSize {
+			end := start + statusPageSize
+			if end > len(jes) {
+				end = len(jes)
+			}
+			jobs, err := jq.GetByEssences(jes[start:end])
+			if err != nil {
+				return err
+			}
+			found += len(jobs)
+			if len(jobs) > 0 {
+				err = handle(jobs)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if found < len(jes) {
+			warn("%d/%d cmds were not found", len(jes)-found, len(jes))
+		}
+		return nil
+	}
+	jobs, _ := getJobs(jq, cmdState, false, 0, getStd, getEnv)
+	return handle(jobs)
+}
+
+// getJobsToManipulate is used by kill, retry and remove to get the jobs the user
+// selected with exactly one of -a, -f, -i or -l, filtered down to those that are
+// in one of the given states. If filterExitcode is true, jobs must also have
+// exited with cmdExitcode, and if cmdFailReason has been set, jobs must have
+// that FailReason. cmdState is passed through to getJobs() to reduce the number
+// of jobs retrieved in -a and -i mode. Returns the JobEssences of the desired
+// jobs, ready to pass to jobqueue.Client methods.
+func getJobsToManipulate(jq *jobqueue.Client, cmdState jobqueue.JobState, filterExitcode bool, states ...jobqueue.JobState) []*jobqueue.JobEssence {
+	jobs, _ := getJobs(jq, cmdState, cmdAll, 0, false, false)
+
+	allowed := make(map[jobqueue.JobState]bool)
+	for _, state := range states {
+		allowed[state] = true
+	}
+
+	var jes []*jobqueue.JobEssence
+	for _, job := range jobs {
+		if !allowed[job.State] {
+			continue
+		}
+		if filterExitcode && (!job.Exited || job.Exitcode != cmdExitcode) {
+			continue
+		}
+		if cmdFailReason != "" && job.FailReason != cmdFailReason {
+			continue
+		}
+		jes = append(jes, job.ToEssence())
+	}
+	return jes
+}
+
+// checkJobSelectionFlags dies unless exactly one of -a, -f, -i or -l was
+// supplied.
+func checkJobSelectionFlags() {
+	set := 0
+	if cmdAll {
+		set++
+	}
+	if cmdFileStatus != "" {
+		set++
+	}
+	if cmdIDStatus != "" {
+		set++
+	}
+	if cmdLine != "" {
+		set++
+	}
+	if set != 1 {
+		die("exactly one of -a, -f, -i or -l must be specified")
+	}
+}
+
+// jobOutput is the stable schema used to describe a job in the machine
+// readable --output formats of status. The order of fields here is the order
+// of columns in tsv output.
+type jobOutput struct {
+	Key           string                       `json:"key"`
+	Cmd           string                       `json:"cmd"`
+	Cwd           string                       `json:"cwd"`
+	CwdMatters    bool                         `json:"cwd_matters"`
+	ChangeHome    bool                         `json:"change_home"`
+	ActualCwd     string                       `json:"actual_cwd"`
+	Mounts        jobqueue.MountConfigs        `json:"mounts"`
+	RepGroup      string                       `json:"rep_grp"`
+	ArrayIndex    int                          `json:"array_index"`
+	ReqGroup      string                       `json:"req_grp"`
+	DepGroups     []string                     `json:"dep_grps"`
+	LimitGroups   []string                     `json:"limit_grps"`
+	Deps          []string                     `json:"deps"`
+	Behaviours    string                       `json:"behaviours"`
+	Memory        int                          `json:"memory"`
+	Time          int                          `json:"time"`
+	CPUs          int                          `json:"cpus"`
+	Disk          int                          `json:"disk"`
+	Override      uint8                        `json:"override"`
+	Priority      uint8                        `json:"priority"`
+	Retries       uint8                        `json:"retries"`
+	RetryPolicy   *jobqueue.RetryPolicyViaJSON `json:"retry_policy"`
+	KillGrace     float64                      `json:"kill_grace"`
+	TimeLimit     float64                      `json:"time_limit"`
+	TimeLimitMult float64                      `json:"time_limit_mult"`
+	State         jobqueue.JobState            `json:"state"`
+	Attempts      uint32                       `json:"attempts"`
+	History       []*attemptOutput             `json:"attempt_history"`
+	UntilBuried   uint8                        `json:"until_buried"`
+	PeakRAM       int                          `json:"peak_ram"`
+	PeakDisk      int                          `json:"peak_disk"`
+	Exited        bool                         `json:"exited"`
+	Exitcode      int                          `json:"exitcode"`
+	FailReason    string                       `json:"fail_reason"`
+	Pid           int                          `json:"pid"`
+	Host          string                       `json:"host"`
+	HostID        string                       `json:"host_id"`
+	HostIP        string                       `json:"host_ip"`
+	Started       string                       `json:"started"`
+	Ended         string                       `json:"ended"`
+	Walltime      float64                      `json:"walltime"`
+	CPUtime       float64                      `json:"cputime"`
+	IORead        uint64                       `json:"io_read"`
+	IOWrite       uint64                       `json:"io_write"`
+	Similar       int                          `json:"similar"`
+	StdOut        string                       `json:"stdout"`
+	StdErr        string                       `json:"stderr"`
+	Env           []string                     `json:"env"`
+	CopiedFiles   []string                     `json:"copied_files"`
+	Inputs        []string                     `json:"inputs"`
+	Outputs       []string                     `json:"outputs"`
+	Skipped       bool                         `json:"skipped"`
+	CapturedOut   string                       `json:"captured_stdout"`
+	CapturedErr   string                       `json:"captured_stderr"`
+}
+
+// jobOutputTSVHeader is the header line for tsv output, matching the json tags
+// of jobOutput.
+var jobOutputTSVHeader = []string{"key", "cmd", "cwd", "cwd_matters", "change_home", "actual_cwd", "mounts", "rep_grp", "array_index", "req_grp", "dep_grps", "limit_grps", "deps", "behaviours", "memory", "time", "cpus", "disk", "override", "priority", "retries", "retry_policy", "kill_grace", "time_limit", "time_limit_mult", "state", "attempts", "attempt_history", "until_buried", "peak_ram", "peak_disk", "exited", "exitcode", "fail_reason", "pid", "host", "host_id", "host_ip", "started", "ended", "walltime", "cputime", "io_read", "io_write", "similar", "stdout", "stderr", "env", "copied_files", "inputs", "outputs", "skipped", "captured_stdout", "captured_stderr"}
+
+// attemptOutput describes one entry of a job's AttemptHistory in the machine
+// readable --output formats of status.
+type attemptOutput struct {
+	Host       string  `json:"host"`
+	Exitcode   int     `json:"exitcode"`
+	FailReason string  `json:"fail_reason"`
+	Started    string  `json:"started"`
+	Ended      string  `json:"ended"`
+	Walltime   float64 `json:"walltime"`
+}
+
+// tsvEscaper escapes the characters that would break tsv output.
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// jobToOutput converts a Job to a jobOutput. getStd and getEnv should only be
+// true if the job was retrieved with its std and env. copyDir is the manager's
+// CopyDir, which the job's CopiedFiles are relative to.
+func jobToOutput(job *jobqueue.Job, getStd bool, getEnv bool, copyDir string) *jobOutput {
+	jo := &jobOutput{
+		Key:           job.ToEssence().JobKey,
+		Cmd:           job.Cmd,
+		Cwd:           job.Cwd,
+		CwdMatters:    job.CwdMatters,
+		ChangeHome:    job.ChangeHome,
+		ActualCwd:     job.ActualCwd,
+		Mounts:        job.MountConfigs,
+		RepGroup:      job.RepGroup,
+		ArrayIndex:    job.ArrayIndex,
+		ReqGroup:      job.ReqGroup,
+		DepGroups:     job.DepGroups,
+		LimitGroups:   job.LimitGroups,
+		Deps:          job.Dependencies.Stringify(),
+		Behaviours:    job.Behaviours.String(),
+		Override:      job.Override,
+		Priority:      job.Priority,
+		Retries:       job.Retries,
+		KillGrace:     job.KillGrace.Seconds(),
+		TimeLimit:     job.TimeLimit.Seconds(),
+		TimeLimitMult: job.TimeLimitMult,
+		State:         job.State,
+		Attempts:      job.Attempts,
+		UntilBuried:   job.UntilBuried,
+		PeakRAM:       job.PeakRAM,
+		PeakDisk:      job.PeakDisk,
+		Exited:        job.Exited,
+		Exitcode:      job.Exitcode,
+		FailReason:    job.FailReason,
+		Pid:           job.Pid,
+		Host:          job.Host,
+		HostID:        job.HostID,
+		HostIP:        job.HostIP,
+		CPUtime:       job.CPUtime.Seconds(),
+		IORead:        job.IORead,
+		IOWrite:       job.IOWrite,
+		Similar:       job.Similar,
+		Inputs:        job.Inputs,
+		Outputs:       job.Outputs,
+		Skipped:       job.Skipped,
+		CapturedOut:   job.CapturedStdOut,
+		CapturedErr:   job.CapturedStdErr,
+	}
+	for _, attempt := range job.AttemptHistory {
+		jo.History = append(jo.History, &attemptOutput{
+			Host:       attempt.Host,
+			Exitcode:   attempt.Exitcode,
+			FailReason: attempt.FailReason,
+			Started:    attempt.StartTime.Format(time.RFC3339),
+			Ended:      attempt.EndTime.Format(time.RFC3339),
+			Walltime:   attempt.WallTime().Seconds(),
+		})
+	}
+	if job.RetryPolicy != nil {
+		jo.RetryPolicy = job.RetryPolicy.ViaJSON()
+	}
+	if job.Requirements != nil {
+		jo.Memory = job.Requirements.RAM
+		jo.Time = int(job.Requirements.Time.Seconds())
+		jo.CPUs = job.Requirements.Cores
+		jo.Disk = job.Requirements.Disk
+	}
+	if !job.StartTime.IsZero() {
+		jo.Started = job.StartTime.Format(time.RFC3339)
+		jo.Walltime = job.WallTime().Seconds()
+	}
+	if !job.EndTime.IsZero() {
+		jo.Ended = job.EndTime.Format(time.RFC3339)
+	}
+	if getStd {
+		var err error
+		jo.StdOut, err = job.StdOut()
+		if err != nil {
+			warn("problem reading the cmd's STDOUT: %s", err)
+		}
+		jo.StdErr, err = job.StdErr()
+		if err != nil {
+			warn("problem reading the cmd's STDERR: %s", err)
+		}
+	}
+	if getEnv {
+		env, err := job.Env()
+		if err != nil {
+			warn("problem reading the cmd's Env: %s", err)
+		}
+		jo.Env = env
+	}
+	for _, copied := range job.CopiedFiles {
+		jo.CopiedFiles = append(jo.CopiedFiles, filepath.Join(copyDir, copied))
+	}
+	return jo
+}
+
+// tsvColumns returns the values of a jobOutput in the order of
+// jobOutputTSVHeader, escaped for tsv output.
+func (jo *jobOutput) tsvColumns() []string {
+	cols := []string{
+		jo.Key,
+		jo.Cmd,
+		jo.Cwd,
+		strconv.FormatBool(jo.CwdMatters),
+		strconv.FormatBool(jo.ChangeHome),
+		jo.ActualCwd,
+		jo.Mounts.String(),
+		jo.RepGroup,
+		strconv.Itoa(jo.ArrayIndex),
+		jo.ReqGroup,
+		strings.Join(jo.DepGroups, ","),
+		strings.Join(jo.LimitGroups, ","),
+		strings.Join(jo.Deps, ","),
+		jo.Behaviours,
+		strconv.Itoa(jo.Memory),
+		strconv.Itoa(jo.Time),
+		strconv.Itoa(jo.CPUs),
+		strconv.Itoa(jo.Disk),
+		strconv.Itoa(int(jo.Override)),
+		strconv.Itoa(int(jo.Priority)),
+		strconv.Itoa(int(jo.Retries)),
+		jo.retryPolicyColumn(),
+		strconv.FormatFloat(jo.KillGrace, 'f', -1, 64),
+		strconv.FormatFloat(jo.TimeLimit, 'f', -1, 64),
+		strconv.FormatFloat(jo.TimeLimitMult, 'f', -1, 64),
+		string(jo.State),
+		strconv.FormatUint(uint64(jo.Attempts), 10),
+		jo.historyColumn(),
+		strconv.Itoa(int(jo.UntilBuried)),
+		strconv.Itoa(jo.PeakRAM),
+		strconv.Itoa(jo.PeakDisk),
+		strconv.FormatBool(jo.Exited),
+		strconv.Itoa(jo.Exitcode),
+		jo.FailReason,
+		strconv.Itoa(jo.Pid),
+		jo.Host,
+		jo.HostID,
+		jo.HostIP,
+		jo.Started,
+		jo.Ended,
+		strconv.FormatFloat(jo.Walltime, 'f', -1, 64),
+		strconv.FormatFloat(jo.CPUtime, 'f', -1, 64),
+		strconv.FormatUint(jo.IORead, 10),
+		strconv.FormatUint(jo.IOWrite, 10),
+		strconv.Itoa(jo.Similar),
+		jo.StdOut,
+		jo.StdErr,
+		strings.Join(jo.Env, ","),
+		strings.Join(jo.CopiedFiles, ","),
+		strings.Join(jo.Inputs, ","),
+		strings.Join(jo.Outputs, ","),
+		strconv.FormatBool(jo.Skipped),
+		jo.CapturedOut,
+		jo.CapturedErr,
+	}
+	for i, col := range cols {
+		cols[i] = tsvEscaper.Replace(col)
+	}
+	return cols
+}
+
+// retryPolicyColumn returns the retry policy of a jobOutput as JSON, or an
+// empty string if it has none, for tsv output.
+func (jo *jobOutput) retryPolicyColumn() string {
+	if jo.RetryPolicy == nil {
+		return ""
+	}
+	b, err := json.Marshal(jo.RetryPolicy)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// historyColumn returns the attempt history of a jobOutput as a comma
+// separated list of exitcode@host:walltime entries, for tsv output.
+func (jo *jobOutput) historyColumn() string {
+	entries := make([]string, len(jo.History))
+	for i, attempt := range jo.History {
+		entries[i] = fmt.Sprintf("%d@%s:%s", attempt.Exitcode, attempt.Host, strconv.FormatFloat(attempt.Walltime, 'f', -1, 64))
+	}
+	return strings.Join(entries, ",")
+}
+
+// jobOutputter writes jobs to a writer in one of the machine readable --output
+// formats, a batch at a time.
+type jobOutputter struct {
+	bw      *bufio.Writer
+	encoder *json.Encoder
+	format  string
+	getStd  bool
+	getEnv  bool
+	copyDir string
+	written int
+}
+
+// newJobOutputter returns a jobOutputter that writes to the given writer in
+// the given format (json, jsonl or tsv), having written any header the format
+// needs. getStd and getEnv should only be true if the jobs will be retrieved
+// with their std and env. copyDir is the manager's CopyDir.
+func newJobOutputter(w io.Writer, format string, getStd bool, getEnv bool, copyDir string) (*jobOutputter, error) {
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	encoder.SetEscapeHTML(false)
+
+	var err error
+	switch format {
+	case "json":
+		_, err = bw.WriteString("[")
+	case "tsv":
+		_, err = bw.WriteString(strings.Join(jobOutputTSVHeader, "\t") + "\n")
+	}
+	return &jobOutputter{
+		bw:      bw,
+		encoder: encoder,
+		format:  format,
+		getStd:  getStd,
+		getEnv:  getEnv,
+		copyDir: copyDir,
+	}, err
+}
+
+// write writes out the given jobs, one job at a time, flushing afterwards so
+// that they don't accumulate in memory.
+func (o *jobOutputter) write(jobs []*jobqueue.Job) error {
+	var err error
+	for _, job := range jobs {
+		jo := jobToOutput(job, o.getStd, o.getEnv, o.copyDir)
+		switch o.format {
+		case "json":
+			if o.written > 0 {
+				_, err = o.bw.WriteString(",")
+				if err != nil {
+					return err
+				}
+			}
+			err = o.encoder.Encode(jo)
+		case "jsonl":
+			err = o.encoder.Encode(jo)
+		case "tsv":
+			_, err = o.bw.WriteString(strings.Join(jo.tsvColumns(), "\t") + "\n")
+		}
+		if err != nil {
+			return err
+		}
+		o.written++
+	}
+	return o.bw.Flush()
+}
+
+// close writes anything the format needs after the last job.
+func (o *jobOutputter) close() error {
+	if o.format == "json" {
+		_, err := o.bw.WriteString("]\n")
+		if err != nil {
+			return err
+		}
+	}
+	return o.bw.Flush()
+}
+
+// outputJobs writes the given jobs to the given writer in the given format
+// (json, jsonl or tsv), one job at a time. copyDir is the manager's CopyDir.
+func outputJobs(w io.Writer, jobs []*jobqueue.Job, format string, getStd bool, getEnv bool, copyDir string) error {
+	jo, err := newJobOutputter(w, format, getStd, getEnv, copyDir)
+	if err != nil {
+		return err
+	}
+	err = jo.write(jobs)
+	if err != nil {
+		return err
+	}
+	return jo.close()
+}
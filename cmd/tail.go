@@ -0,0 +1,127 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/VertebrateResequencing/wr/jobqueue"
+	"github.com/spf13/cobra"
+)
+
+// tailCmd represents the tail command
+var tailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Follow the output of a running command",
+	Long: `You can see the STDOUT and STDERR of a command you've previously added
+using "wr add" while it runs using this command.
+
+Specify one of the flags -l or -i to choose which command you want to follow.
+-i is the report group (-i) you supplied to "wr add"; if more than one command
+has that identifier, the one that is currently running is chosen (and if more
+than one is running, you'll need to pick one out by index if they're part of a
+job array, like -i myrg[17], or else use -l).
+
+In -l mode you must provide the cwd the command was set to run in, if
+CwdMatters (and must NOT be provided otherwise). Likewise provide the mounts
+JSON that was used when the command was added, if any. You can do this by using
+the -c and --mounts options.
+
+The most recent output of the command (up to 64KB of each of STDOUT and STDERR)
+is shown first, followed by new output as it is produced, until the command
+stops running. The command's STDOUT is written to STDOUT and its STDERR to
+STDERR. If the command hasn't started running yet, this waits for it to start.
+
+Note that it can take up to 15 seconds before the command's runner notices
+that you want its output, and if the command produces output faster than it
+can be sent to you, some of it will be skipped (you'll be told how much). To
+keep everything a command outputs, add it with the std_capture option instead
+(see "wr add -h").`,
+	Run: func(cmd *cobra.Command, args []string) {
+		set := 0
+		if cmdIDStatus != "" {
+			set++
+		}
+		if cmdLine != "" {
+			set++
+		}
+		if set != 1 {
+			die("exactly one of -i or -l must be specified")
+		}
+
+		timeout := time.Duration(timeoutint) * time.Second
+		jq, err := jobqueue.Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, clientToken(), timeout)
+		if err != nil {
+			die("%s", err)
+		}
+		defer func() {
+			err = jq.Disconnect()
+			if err != nil {
+				warn("Disconnecting from the server failed: %s", err)
+			}
+		}()
+
+		jobs, _ := getJobs(jq, "", false, 0, false, false)
+		var incomplete, running []*jobqueue.Job
+		for _, job := range jobs {
+			switch job.State {
+			case jobqueue.JobStateComplete, jobqueue.JobStateBuried, jobqueue.JobStateDeleted:
+				continue
+			case jobqueue.JobStateReserved, jobqueue.JobStateRunning, jobqueue.JobStateLost:
+				running = append(running, job)
+			}
+			incomplete = append(incomplete, job)
+		}
+
+		var job *jobqueue.Job
+		switch {
+		case len(incomplete) == 1:
+			job = incomplete[0]
+		case len(running) == 1:
+			job = running[0]
+		case len(incomplete) == 0:
+			die("No matching command that might run was found")
+		default:
+			die("%d matching commands might run (%d are running now); pick out just one of them", len(incomplete), len(running))
+		}
+
+		if job.State != jobqueue.JobStateRunning && job.State != jobqueue.JobStateReserved && job.State != jobqueue.JobStateLost {
+			info("waiting for the command to start running...")
+		}
+
+		state, err := jq.Tail(job, os.Stdout, os.Stderr)
+		if err != nil {
+			die("failed to follow the output of the command: %s", err)
+		}
+		info("the command is no longer running (it is now %s)", state)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(tailCmd)
+
+	// flags specific to this sub-command
+	tailCmd.Flags().StringVarP(&cmdIDStatus, "identifier", "i", "", "identifier of the command you want to follow")
+	tailCmd.Flags().StringVarP(&cmdLine, "cmdline", "l", "", "a command line you want to follow")
+	tailCmd.Flags().StringVarP(&cmdCwd, "cwd", "c", "", "working dir that the command specified by -l was set to run in")
+	tailCmd.Flags().StringVar(&cmdMounts, "mounts", "", "mounts that the command specified by -l was set to use")
+
+	tailCmd.Flags().IntVar(&timeoutint, "timeout", 120, "how long (seconds) to wait to get a reply from 'wr manager'")
+}
@@ -0,0 +1,190 @@
+// Copyright © 2016-2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package internal
+
+// this file has functions for securing communication between the manager and
+// its clients
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	certValidity = 365 * 24 * time.Hour
+	tokenLength  = 32
+)
+
+// GenerateCerts creates a CA certificate which is used to sign a newly created
+// server certificate for the given domain. The CA certificate, server
+// certificate and server's private key are all saved in PEM format to the
+// given paths, overwriting any existing files. (The CA's private key is not
+// kept, so the CA can't be used to sign anything else.) Clients can verify the
+// server using the CA certificate, as long as they are told to expect the given
+// domain.
+func GenerateCerts(caFile, serverPemFile, serverKeyFile, domain string) error {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate CA key: %s", err)
+	}
+	notBefore := time.Now().Add(-1 * time.Hour)
+	caTemplate, err := certTemplate(notBefore)
+	if err != nil {
+		return err
+	}
+	caTemplate.Subject = pkix.Name{Organization: []string{"wr manager CA"}}
+	caTemplate.IsCA = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("failed to create CA certificate: %s", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return err
+	}
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate server key: %s", err)
+	}
+	serverTemplate, err := certTemplate(notBefore)
+	if err != nil {
+		return err
+	}
+	serverTemplate.Subject = pkix.Name{Organization: []string{"wr manager"}, CommonName: domain}
+	serverTemplate.DNSNames = []string{domain}
+	serverTemplate.KeyUsage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("failed to create server certificate: %s", err)
+	}
+	serverKeyDER, err := x509.MarshalECPrivateKey(serverKey)
+	if err != nil {
+		return err
+	}
+
+	err = writePEM(caFile, "CERTIFICATE", caDER, 0644)
+	if err != nil {
+		return err
+	}
+	err = writePEM(serverPemFile, "CERTIFICATE", serverDER, 0644)
+	if err != nil {
+		return err
+	}
+	return writePEM(serverKeyFile, "EC PRIVATE KEY", serverKeyDER, 0600)
+}
+
+// certTemplate returns a certificate template with a random serial number
+// that is valid from the given time for a year.
+func certTemplate(notBefore time.Time) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate a certificate serial number: %s", err)
+	}
+	return &x509.Certificate{
+		SerialNumber:          serial,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(certValidity),
+		BasicConstraintsValid: true,
+	}, nil
+}
+
+// writePEM encodes the given bytes as a PEM block of the given type and
+// writes it to the given path with the given permissions.
+func writePEM(path string, blockType string, b []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	err = pem.Encode(f, &pem.Block{Type: blockType, Bytes: b})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// CheckCerts checks that the given server certificate and key files exist,
+// belong together and that the certificate has not expired (or will not expire
+// in the next day). If this returns an error, you should probably
+// GenerateCerts() before starting a server.
+func CheckCerts(serverPemFile, serverKeyFile string) error {
+	cert, err := tls.LoadX509KeyPair(serverPemFile, serverKeyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if time.Now().Add(24 * time.Hour).After(leaf.NotAfter) {
+		return fmt.Errorf("certificate %s expires at %s", serverPemFile, leaf.NotAfter)
+	}
+	return nil
+}
+
+// GenerateToken creates a cryptographically secure random URL-safe base64
+// encoded string that can be used to authenticate clients of the manager.
+func GenerateToken() ([]byte, error) {
+	b := make([]byte, tokenLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, base64.RawURLEncoding.EncodedLen(tokenLength))
+	base64.RawURLEncoding.Encode(token, b)
+	return token, nil
+}
+
+// ReadOrCreateToken returns the token stored in the given file. If the file
+// doesn't exist, a new token is generated and stored in it, readable only by
+// the current user.
+func ReadOrCreateToken(tokenFile string) ([]byte, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err == nil {
+		token = []byte(strings.TrimSpace(string(token)))
+		if len(token) == 0 {
+			return nil, fmt.Errorf("token file %s is empty", tokenFile)
+		}
+		return token, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	token, err = GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+	return token, ioutil.WriteFile(tokenFile, token, 0600)
+}
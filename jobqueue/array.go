@@ -0,0 +1,345 @@
+// Copyright © 2016-2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the job array related code.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ArrayIndexPlaceholder is the placeholder that gets replaced with a Job's
+// ArrayIndex when expanding a job array.
+const ArrayIndexPlaceholder = "{{index}}"
+
+// MaxArraySize is the maximum number of Jobs a single job array may expand in
+// to.
+var MaxArraySize = 1000000
+
+// arrayParamNameRegex matches valid ArrayParam Names.
+var arrayParamNameRegex = regexp.MustCompile(`^\w+$`)
+
+// ArrayParam describes one of the parameters of a job array: the Name that is
+// used in "{{Name}}" placeholders, and the values it takes. Either supply
+// Values explicitly, or leave it empty to have the parameter take the integer
+// values from Start to End (inclusive) in increments of Step (defaults to 1).
+type ArrayParam struct {
+	Name   string
+	Values []string
+	Start  int
+	End    int
+	Step   int
+}
+
+// NewArrayParam parses a user-supplied parameter specification in to an
+// ArrayParam. The spec can be a range like "1-100" or "1-100:2" (start-end:step,
+// inclusive), "@path" to read values from a file with one value per line, or a
+// comma separated list of values.
+func NewArrayParam(name string, spec string) (*ArrayParam, error) {
+	ap := &ArrayParam{Name: name}
+	switch {
+	case strings.HasPrefix(spec, "@"):
+		path := strings.TrimPrefix(spec, "@")
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("array parameter %s list file could not be opened: %s", name, err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			val := strings.TrimSpace(scanner.Text())
+			if val != "" {
+				ap.Values = append(ap.Values, val)
+			}
+		}
+		err = scanner.Err()
+		errc := f.Close()
+		if err == nil {
+			err = errc
+		}
+		if err != nil {
+			return nil, fmt.Errorf("array parameter %s list file could not be read: %s", name, err)
+		}
+	case arrayRangeRegex.MatchString(spec):
+		matches := arrayRangeRegex.FindStringSubmatch(spec)
+		ap.Start, _ = strconv.Atoi(matches[1])
+		ap.End, _ = strconv.Atoi(matches[2])
+		if matches[3] != "" {
+			ap.Step, _ = strconv.Atoi(matches[3])
+		}
+	default:
+		for _, val := range strings.Split(spec, ",") {
+			if val != "" {
+				ap.Values = append(ap.Values, val)
+			}
+		}
+	}
+	return ap, ap.validate()
+}
+
+// ParseArrayParams parses user-supplied "name=spec" strings in to ArrayParams,
+// where spec is as described for NewArrayParam().
+func ParseArrayParams(specs []string) (ArrayParams, error) {
+	var aps ArrayParams
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("array parameter [%s] is not in the form name=spec", spec)
+		}
+		ap, err := NewArrayParam(parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+		aps = append(aps, ap)
+	}
+	return aps, nil
+}
+
+// arrayRangeRegex matches the start-end:step form of an ArrayParam spec.
+var arrayRangeRegex = regexp.MustCompile(`^(-?\d+)-(-?\d+)(?::(\d+))?$`)
+
+// validate checks the ArrayParam makes sense.
+func (ap *ArrayParam) validate() error {
+	if !arrayParamNameRegex.MatchString(ap.Name) || ap.Name == "index" {
+		return fmt.Errorf("array parameter name '%s' is invalid; it must consist of letters, numbers and underscores, and not be 'index'", ap.Name)
+	}
+	if ap.Step < 0 {
+		return fmt.Errorf("array parameter %s has a negative step", ap.Name)
+	}
+	if ap.len() == 0 {
+		return fmt.Errorf("array parameter %s has no values", ap.Name)
+	}
+	return nil
+}
+
+// len returns the number of values this ArrayParam takes.
+func (ap *ArrayParam) len() int {
+	if len(ap.Values) > 0 {
+		return len(ap.Values)
+	}
+	if ap.End < ap.Start {
+		return 0
+	}
+	step := ap.Step
+	if step == 0 {
+		step = 1
+	}
+	return (ap.End-ap.Start)/step + 1
+}
+
+// value returns the ith value of this ArrayParam.
+func (ap *ArrayParam) value(i int) string {
+	if len(ap.Values) > 0 {
+		return ap.Values[i]
+	}
+	step := ap.Step
+	if step == 0 {
+		step = 1
+	}
+	return strconv.Itoa(ap.Start + i*step)
+}
+
+// placeholder returns the string that will be replaced with our values.
+func (ap *ArrayParam) placeholder() string {
+	return "{{" + ap.Name + "}}"
+}
+
+// ArrayParams is a slice of *ArrayParam, for use in Job.ArrayParams.
+type ArrayParams []*ArrayParam
+
+// size returns the number of Jobs a job array with these params would expand
+// in to, or -1 if that would be more than MaxArraySize.
+func (aps ArrayParams) size() int {
+	size := 1
+	for _, ap := range aps {
+		size *= ap.len()
+		if size > MaxArraySize {
+			return -1
+		}
+	}
+	return size
+}
+
+// expand creates the Jobs described by the given template Job, which must have
+// ArrayParams. The first ArrayParam varies slowest.
+func (aps ArrayParams) expand(template *Job) ([]*Job, error) {
+	names := make(map[string]bool)
+	for _, ap := range aps {
+		if err := ap.validate(); err != nil {
+			return nil, err
+		}
+		if names[ap.Name] {
+			return nil, fmt.Errorf("array parameter %s was specified more than once", ap.Name)
+		}
+		names[ap.Name] = true
+		if !strings.Contains(template.Cmd, ap.placeholder()) && !(template.CwdMatters && strings.Contains(template.Cwd, ap.placeholder())) {
+			return nil, fmt.Errorf("array parameter %s is not used in the cmd [%s]", ap.Name, template.Cmd)
+		}
+	}
+
+	size := aps.size()
+	if size < 0 {
+		return nil, fmt.Errorf("job array for cmd [%s] would have more than %d jobs", template.Cmd, MaxArraySize)
+	}
+
+	jobs := make([]*Job, size)
+	indices := make([]int, len(aps))
+	for i := 0; i < size; i++ {
+		// work out which value of each param we're on, with the last param
+		// varying fastest
+		remainder := i
+		for p := len(aps) - 1; p >= 0; p-- {
+			l := aps[p].len()
+			indices[p] = remainder % l
+			remainder /= l
+		}
+
+		oldnew := []string{ArrayIndexPlaceholder, strconv.Itoa(i + 1)}
+		for p, ap := range aps {
+			oldnew = append(oldnew, ap.placeholder(), ap.value(indices[p]))
+		}
+		jobs[i] = template.arrayElement(strings.NewReplacer(oldnew...), i+1)
+	}
+	return jobs, nil
+}
+
+// arrayElement creates a copy of this template Job, using the replacer to fill
+// in placeholders.
+func (j *Job) arrayElement(r *strings.Replacer, index int) *Job {
+	job := &Job{
+		Cmd:          r.Replace(j.Cmd),
+		Cwd:          r.Replace(j.Cwd),
+		CwdMatters:   j.CwdMatters,
+		ChangeHome:   j.ChangeHome,
+		RepGroup:     j.RepGroup,
+		ReqGroup:     j.ReqGroup,
+		Override:     j.Override,
+		Priority:     j.Priority,
+		Retries:      j.Retries,
+		Behaviours:   j.Behaviours,
+		MountConfigs: j.MountConfigs,
+		EnvOverride:  j.EnvOverride,
+		StdCapture:   r.Replace(j.StdCapture),
+		StdCompress:  j.StdCompress,
+		KillGrace:    j.KillGrace,
+		TimeLimit:    j.TimeLimit,
+		ArrayIndex:   index,
+	}
+	job.SkipIfUpToDate = j.SkipIfUpToDate
+	job.TimeLimitMult = j.TimeLimitMult
+	job.RetryPolicy = j.RetryPolicy
+	if j.Requirements != nil {
+		req := *j.Requirements
+		job.Requirements = &req
+	}
+	for _, dg := range j.DepGroups {
+		job.DepGroups = append(job.DepGroups, r.Replace(dg))
+	}
+	for _, lg := range j.LimitGroups {
+		job.LimitGroups = append(job.LimitGroups, r.Replace(lg))
+	}
+	for _, path := range j.Inputs {
+		job.Inputs = append(job.Inputs, r.Replace(path))
+	}
+	for _, path := range j.Outputs {
+		job.Outputs = append(job.Outputs, r.Replace(path))
+	}
+	for _, dep := range j.Dependencies {
+		newDep := &Dependency{DepGroup: r.Replace(dep.DepGroup)}
+		if dep.Essence != nil {
+			newDep.Essence = &JobEssence{
+				JobKey:       dep.Essence.JobKey,
+				Cmd:          r.Replace(dep.Essence.Cmd),
+				Cwd:          r.Replace(dep.Essence.Cwd),
+				MountConfigs: dep.Essence.MountConfigs,
+			}
+		}
+		job.Dependencies = append(job.Dependencies, newDep)
+	}
+	return job
+}
+
+// expandJobArrays replaces any job array templates (Jobs with ArrayParams) in
+// the given slice with the Jobs they expand to.
+func expandJobArrays(jobs []*Job) ([]*Job, error) {
+	hasArrays := false
+	for _, job := range jobs {
+		if len(job.ArrayParams) > 0 {
+			hasArrays = true
+			break
+		}
+	}
+	if !hasArrays {
+		return jobs, nil
+	}
+
+	var expanded []*Job
+	for _, job := range jobs {
+		if len(job.ArrayParams) == 0 {
+			expanded = append(expanded, job)
+			continue
+		}
+		elements, err := job.ArrayParams.expand(job)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, elements...)
+	}
+	return expanded, nil
+}
+
+// arrayAddressRegex matches RepGroups suffixed with an array index or range of
+// indices, like "myrg[17]" or "myrg[1-10]".
+var arrayAddressRegex = regexp.MustCompile(`^(.+)\[(\d+)(?:-(\d+))?\]$`)
+
+// ParseArrayAddress parses an identifier like "myrg[17]" or "myrg[1-10]" in to
+// the RepGroup and the first and last (inclusive) array indices it refers to.
+// ok will be false if the identifier wasn't in that form.
+func ParseArrayAddress(identifier string) (repGroup string, first int, last int, ok bool) {
+	matches := arrayAddressRegex.FindStringSubmatch(identifier)
+	if matches == nil {
+		return "", 0, 0, false
+	}
+	first, _ = strconv.Atoi(matches[2])
+	last = first
+	if matches[3] != "" {
+		last, _ = strconv.Atoi(matches[3])
+	}
+	if last < first {
+		return "", 0, 0, false
+	}
+	return matches[1], first, last, true
+}
+
+// FilterArrayIndices returns the subset of the given Jobs that have an
+// ArrayIndex between first and last inclusive, such as you might get from
+// ParseArrayAddress().
+func FilterArrayIndices(jobs []*Job, first int, last int) []*Job {
+	var filtered []*Job
+	for _, job := range jobs {
+		if job.ArrayIndex >= first && job.ArrayIndex <= last {
+			filtered = append(filtered, job)
+		}
+	}
+	return filtered
+}
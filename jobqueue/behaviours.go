@@ -67,10 +67,8 @@ const (
 	CleanupAll BehaviourAction = 1 << iota
 
 	// Cleanup is a BehaviourAction that behaves exactly as CleanupAll in the
-	// case that no output files have been specified on the Job. If some have,
+	// case that no Outputs have been specified on the Job. If some have,
 	// everything except those files gets deleted. It takes no arguments.
-	// (NB: since output file specification has not yet been implemented, this
-	// is currently identical to CleanupAll.)
 	Cleanup
 
 	// Run is a BehaviourAction that runs a given command (supplied as a single
@@ -80,7 +78,10 @@ const (
 	// CopyToManager is a BehaviourAction that copies the given files (specified
 	// as a slice of string paths Arg to the Behaviour) from the Job's actual
 	// cwd to a configured location on the machine that the jobqueue server is
-	// running on. *** not yet implemented!
+	// running on (see ServerConfig.CopyDir). Paths must be relative to the
+	// actual cwd and may not refer to anything outside of it. The files are
+	// sent over the connection of the Client that Execute()d the Job, and are
+	// afterwards listed in the Job's CopiedFiles.
 	CopyToManager
 )
 
@@ -127,7 +128,7 @@ func (b *Behaviour) fillBVJM(bvjm *bvjMapping) {
 		bvj = BehaviourViaJSON{Run: arg}
 	case CopyToManager:
 		var arg []string
-		if files, wasStrSlice := b.Arg.([]string); wasStrSlice {
+		if files, wasStrSlice := b.argStrings(); wasStrSlice {
 			arg = files
 		} else {
 			arg = []string{"!invalid!"}
@@ -177,12 +178,8 @@ func (b *Behaviour) String() string {
 
 // cleanup with all == true wipes out the Job's unique dir as aggressively as
 // possible, along with all empty parent dirs up to Cwd. Without all, will keep
-// files designated as outputs (*** designation not yet implemented).
+// the Job's Outputs.
 func (b *Behaviour) cleanup(j *Job, all bool) error {
-	if !all {
-		// *** not yet implemented, we just wipe everything!
-	}
-
 	if j.ActualCwd == "" {
 		// must be a CwdMatters job, or somehow ActualCwd didn't get set; we do
 		// nothing in this case
@@ -194,11 +191,15 @@ func (b *Behaviour) cleanup(j *Job, all bool) error {
 	// dirs (that we don't want to delete).
 	workSpace := filepath.Dir(j.ActualCwd)
 
-	if len(j.MountConfigs) > 0 {
+	var keepDirs []string
+	if !all {
+		keepDirs = j.outputsWithin(j.ActualCwd)
+	}
+
+	if len(j.MountConfigs) > 0 || len(keepDirs) > 0 {
 		// if we have mounts, we don't want to delete the cache dirs or any
-		// mounted directories, so we'll have to go through and delete
-		// everything else manually
-		var keepDirs []string
+		// mounted directories, and we don't want to delete outputs, so we'll
+		// have to go through and delete everything else manually
 		var keepActualCwd bool
 		for _, mc := range j.MountConfigs {
 			if mc.Mount == "" {
@@ -277,17 +278,47 @@ func (b *Behaviour) run(j *Job) error {
 	return err
 }
 
+// argStrings returns our Arg as a []string, if it is one. Having been sent
+// between client and server, a []string Arg will have been decoded as an
+// []interface{}, so we also handle that.
+func (b *Behaviour) argStrings() ([]string, bool) {
+	switch arg := b.Arg.(type) {
+	case []string:
+		return arg, true
+	case []interface{}:
+		strs := make([]string, len(arg))
+		for i, val := range arg {
+			str, wasStr := val.(string)
+			if !wasStr {
+				return nil, false
+			}
+			strs[i] = str
+		}
+		return strs, true
+	}
+	return nil, false
+}
+
 // copyToManager copies the files specified in the Arg slice to the configured
-// location on the manager's machine.
+// location on the manager's machine, using the client that is executing the
+// Job. We try to copy every file even if some fail.
 func (b *Behaviour) copyToManager(j *Job) error {
-	_, wasStrSlice := b.Arg.([]string)
+	paths, wasStrSlice := b.argStrings()
 	if !wasStrSlice {
 		return fmt.Errorf("Arg %s is type %T, not []string", b.Arg, b.Arg)
 	}
+	if j.client == nil {
+		return fmt.Errorf("copy_to_manager behaviour can only be carried out while a client is executing the job")
+	}
 
-	// *** not yet implemented
-
-	return nil
+	var merr *multierror.Error
+	for _, path := range paths {
+		err := j.client.copyToManager(j, path)
+		if err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("failed to copy %s to the manager: %s", path, err))
+		}
+	}
+	return merr.ErrorOrNil()
 }
 
 // Behaviours are a slice of Behaviour.
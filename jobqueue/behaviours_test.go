@@ -68,6 +68,12 @@ func TestBehaviours(t *testing.T) {
 			So(b9.String(), ShouldEqual, `{"on_failure|success":[{"cleanup":true}]}`)
 			So(b10.String(), ShouldEqual, "{}")
 
+			// as they would be after being decoded from the server
+			b11 := &Behaviour{When: OnSuccess, Do: CopyToManager, Arg: []interface{}{"a.file", "b.file"}}
+			So(b11.String(), ShouldEqual, b7.String())
+			b12 := &Behaviour{When: OnSuccess, Do: CopyToManager, Arg: []interface{}{"a.file", 1}}
+			So(b12.String(), ShouldEqual, b8.String())
+
 			Convey("Behaviours can be nicely stringified", func() {
 				bs := Behaviours{b1, b4}
 				So(bs.String(), ShouldEqual, `{"on_success":[{"run":"touch ../../foo && true"}],"on_exit":[{"cleanup_all":true}]}`)
@@ -79,10 +85,12 @@ func TestBehaviours(t *testing.T) {
 
 		Convey("Individual Behaviour Trigger() correctly", func() {
 			err = b7.Trigger(OnSuccess, job1)
-			So(err, ShouldBeNil)
+			So(err, ShouldNotBeNil) // can't copy without a client executing the job
+			So(err.Error(), ShouldContainSubstring, "client")
 			err = b8.Trigger(OnSuccess, job1)
 			So(err, ShouldNotBeNil)
-			//*** CopyToManager not yet implemented, so no proper tests for it yet
+			// (CopyToManager is tested properly in jobqueue_test.go, since it
+			// needs a server)
 
 			err = b6.Trigger(OnSuccess, job1)
 			So(err, ShouldNotBeNil)
@@ -147,6 +155,32 @@ func TestBehaviours(t *testing.T) {
 			}
 		})
 
+		Convey("Cleanup keeps declared Outputs, unlike CleanupAll", func() {
+			os.MkdirAll(filepath.Join(actualCwd, "sub"), os.ModePerm)
+			os.OpenFile(filepath.Join(actualCwd, "sub", "c.file"), os.O_RDONLY|os.O_CREATE, 0666)
+			os.OpenFile(filepath.Join(actualCwd, "sub", "d.file"), os.O_RDONLY|os.O_CREATE, 0666)
+			os.MkdirAll(filepath.Join(cwd, "a", "b", "c", "def", "tmp"), os.ModePerm)
+			job1.Outputs = []string{"a.file", filepath.Join(actualCwd, "sub", "c.file"), "/elsewhere/e.file"}
+
+			err = b9.Trigger(OnSuccess, job1)
+			So(err, ShouldBeNil)
+			_, err = os.Stat(filepath.Join(actualCwd, "a.file"))
+			So(err, ShouldBeNil)
+			_, err = os.Stat(filepath.Join(actualCwd, "b.file"))
+			So(err, ShouldNotBeNil)
+			_, err = os.Stat(filepath.Join(actualCwd, "sub", "c.file"))
+			So(err, ShouldBeNil)
+			_, err = os.Stat(filepath.Join(actualCwd, "sub", "d.file"))
+			So(err, ShouldNotBeNil)
+			_, err = os.Stat(filepath.Join(cwd, "a", "b", "c", "def", "tmp"))
+			So(err, ShouldNotBeNil)
+
+			err = b1.Trigger(OnExit, job1)
+			So(err, ShouldBeNil)
+			_, err = os.Stat(adir)
+			So(err, ShouldNotBeNil)
+		})
+
 		Convey("Behaviours are triggered in order b2,b4, as specified", func() {
 			bs := Behaviours{b2, b4}
 			err = bs.Trigger(true, job1)
@@ -0,0 +1,332 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for capturing the complete STDOUT and STDERR of
+// a Job's Cmd to files, as done when its StdCapture is set, and for reading
+// them back.
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/VertebrateResequencing/muxfys"
+	"github.com/VertebrateResequencing/wr/internal"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+const (
+	captureOutSuffix = ".stdout"
+	captureErrSuffix = ".stderr"
+	captureGzSuffix  = ".gz"
+
+	// captureMaxRange is the most bytes of a captured file that will be
+	// returned at once by readCapture().
+	captureMaxRange = 1024 * 1024
+)
+
+// captureWriter is an io.Writer that never returns an error, so that a problem
+// writing out a captured stream does not stop us reading the cmd's output;
+// the first error is remembered instead.
+type captureWriter struct {
+	w   io.Writer
+	err error
+}
+
+// Write implements io.Writer.
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if cw.err == nil {
+		_, cw.err = cw.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// stdCapture writes the complete STDOUT and STDERR of a Job's Cmd to files in
+// its StdCapture location, which is a local directory or an S3 location that we
+// mount.
+type stdCapture struct {
+	stdout      *captureWriter
+	stderr      *captureWriter
+	outLocation string
+	errLocation string
+	paths       []string
+	files       []*os.File
+	gzips       []*gzip.Writer
+	fs          *muxfys.MuxFys
+	mnt         string
+	closed      bool
+}
+
+// newStdCapture creates the files that the output of the given Job's Cmd will
+// be written to, named after the Job's key. Relative local StdCapture
+// directories are treated as relative to the Job's Cwd.
+func newStdCapture(j *Job) (*stdCapture, error) {
+	location := strings.TrimSuffix(j.StdCapture, "/")
+	sc := &stdCapture{}
+	var dir string
+	if internal.InS3(location) {
+		mnt, err := ioutil.TempDir("", "wr_std_capture")
+		if err != nil {
+			return nil, err
+		}
+		sc.mnt = mnt
+		sc.fs, err = mountS3Dir(location, mnt, true)
+		if err != nil {
+			return nil, sc.failed(err)
+		}
+		dir = mnt
+	} else {
+		if !filepath.IsAbs(location) {
+			location = filepath.Join(j.Cwd, location)
+		}
+		err := os.MkdirAll(location, os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+		dir = location
+	}
+
+	ext := ""
+	if j.StdCompress {
+		ext = captureGzSuffix
+	}
+	base := j.key()
+	var err error
+	sc.stdout, err = sc.create(filepath.Join(dir, base+captureOutSuffix+ext))
+	if err != nil {
+		return nil, sc.failed(err)
+	}
+	sc.stderr, err = sc.create(filepath.Join(dir, base+captureErrSuffix+ext))
+	if err != nil {
+		return nil, sc.failed(err)
+	}
+	sc.outLocation = location + "/" + base + captureOutSuffix + ext
+	sc.errLocation = location + "/" + base + captureErrSuffix + ext
+	return sc, nil
+}
+
+// failed discards what newStdCapture() had set up before it hit the given
+// error, returning the error along with any problem discarding.
+func (sc *stdCapture) failed(err error) error {
+	if errd := sc.discard(); errd != nil {
+		return fmt.Errorf("%s (and cleaning up failed: %s)", err, errd)
+	}
+	return err
+}
+
+// create creates a file at the given path and returns a captureWriter that
+// writes to it, gzip compressing if the path ends in captureGzSuffix.
+func (sc *stdCapture) create(path string) (*captureWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	sc.paths = append(sc.paths, path)
+	sc.files = append(sc.files, f)
+	if strings.HasSuffix(path, captureGzSuffix) {
+		gz := gzip.NewWriter(f)
+		sc.gzips = append(sc.gzips, gz)
+		return &captureWriter{w: gz}, nil
+	}
+	return &captureWriter{w: f}, nil
+}
+
+// close finishes writing the captured files, uploading them if their location
+// is in S3. It returns any error that occurred while writing them.
+func (sc *stdCapture) close() error {
+	if sc.closed {
+		return nil
+	}
+	sc.closed = true
+	var merr *multierror.Error
+	for _, cw := range []*captureWriter{sc.stdout, sc.stderr} {
+		if cw.err != nil {
+			merr = multierror.Append(merr, cw.err)
+		}
+	}
+	for _, gz := range sc.gzips {
+		if err := gz.Close(); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	for _, f := range sc.files {
+		if err := f.Close(); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	if sc.fs != nil {
+		if err := sc.fs.Unmount(); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+		if err := os.RemoveAll(sc.mnt); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	return merr.ErrorOrNil()
+}
+
+// discard is used instead of close() when the Cmd did not get run: the files
+// are deleted instead of being kept or uploaded.
+func (sc *stdCapture) discard() error {
+	if sc.closed {
+		return nil
+	}
+	sc.closed = true
+	var merr *multierror.Error
+	for _, gz := range sc.gzips {
+		if err := gz.Close(); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	for _, f := range sc.files {
+		if err := f.Close(); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	for _, path := range sc.paths {
+		if err := os.Remove(path); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	if sc.fs != nil {
+		if err := sc.fs.Unmount(true); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	if sc.mnt != "" {
+		if err := os.RemoveAll(sc.mnt); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	return merr.ErrorOrNil()
+}
+
+// mountS3Dir mounts the given S3 location, specified like
+// s3://[profile@]bucket/path, at the given local directory.
+func mountS3Dir(location string, mnt string, write bool) (*muxfys.MuxFys, error) {
+	path := strings.TrimPrefix(location, internal.S3Prefix)
+	profile := "default"
+	pp := strings.Split(path, "@")
+	if len(pp) == 2 {
+		profile = pp[0]
+		path = pp[1]
+	}
+
+	accessorConfig, err := muxfys.S3ConfigFromEnvironment(profile, path)
+	if err != nil {
+		return nil, err
+	}
+	accessor, err := muxfys.NewS3Accessor(accessorConfig)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := muxfys.New(&muxfys.Config{Mount: mnt, Retries: 10})
+	if err != nil {
+		return nil, err
+	}
+	err = fs.Mount(&muxfys.RemoteConfig{Accessor: accessor, Write: write})
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// readCapture returns up to length bytes (at most captureMaxRange; the
+// maximum if length is 0 or less) of the uncompressed content of a file written
+// by a stdCapture, starting offset bytes in. The location is the local path or
+// S3 url of the file. Fewer bytes than requested are returned when the end of
+// the file is reached.
+func readCapture(location string, offset int64, length int) (data []byte, err error) {
+	if length <= 0 || length > captureMaxRange {
+		length = captureMaxRange
+	}
+
+	path := location
+	if internal.InS3(location) {
+		i := strings.LastIndex(location, "/")
+		var mnt string
+		mnt, err = ioutil.TempDir("", "wr_std_read")
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			errr := os.RemoveAll(mnt)
+			if errr != nil && err == nil {
+				err = errr
+			}
+		}()
+		var fs *muxfys.MuxFys
+		fs, err = mountS3Dir(location[:i], mnt, false)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			erru := fs.Unmount()
+			if erru != nil && err == nil {
+				err = erru
+			}
+		}()
+		path = filepath.Join(mnt, location[i+1:])
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		errc := f.Close()
+		if errc != nil && err == nil {
+			err = errc
+		}
+	}()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, captureGzSuffix) {
+		gz, errg := gzip.NewReader(f)
+		if errg != nil {
+			if errg == io.EOF {
+				// nothing has been written yet
+				return nil, nil
+			}
+			return nil, errg
+		}
+		r = gz
+		_, err = io.CopyN(ioutil.Discard, r, offset)
+	} else {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	data = make([]byte, length)
+	n, err := io.ReadFull(r, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return data[:n], err
+}
@@ -0,0 +1,385 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for running Cmds in their own cgroups (v2), so
+// that the kernel can enforce their resource limits and tell us exactly what
+// resources they used.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// cgroupRoot is where we expect the cgroup v2 unified hierarchy to be
+	// mounted.
+	cgroupRoot = "/sys/fs/cgroup"
+
+	// cgroupRunnerPrefix and cgroupJobPrefix are the prefixes of the names of
+	// the cgroups we create to hold ourselves and the Cmds we run. They are
+	// followed by our pid (and for Cmds, an underscore and the Job's key), so
+	// we can tell which runner a cgroup belongs to.
+	cgroupRunnerPrefix = "wr_runner_"
+	cgroupJobPrefix    = "wr_job_"
+
+	// cgroupCPUPeriod is the period (in microseconds) we use for cpu.max.
+	cgroupCPUPeriod = 100000
+
+	// cgroupRemoveAttempts is how many times we try to remove a cgroup (every
+	// killPollInterval) while waiting for its processes to exit.
+	cgroupRemoveAttempts = 20
+)
+
+var (
+	cgroupOnce      sync.Once
+	cgroupParentDir string
+	cgroupParentErr error
+)
+
+// cgroupParent returns the directory of the cgroup under which we can create
+// cgroups for the Cmds we run, with the memory and cpu controllers enabled for
+// them. This is only possible if cgroups v2 are in use and the cgroup we were
+// started in has been delegated to us (eg. by systemd), so that we have write
+// access to it.
+//
+// Because of the cgroup v2 rule that only cgroups without processes of their
+// own can distribute resources to their children, we first move ourselves in
+// to a child cgroup. The result is cached, so this is only attempted once per
+// process.
+func cgroupParent() (string, error) {
+	cgroupOnce.Do(func() {
+		cgroupParentDir, cgroupParentErr = setupCgroupParent()
+	})
+	return cgroupParentDir, cgroupParentErr
+}
+
+// setupCgroupParent does the work for cgroupParent().
+func setupCgroupParent() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroups v2 are not in use")
+	}
+
+	// find our own cgroup, which is the entry for hierarchy 0
+	b, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	var own string
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			own = filepath.Join(cgroupRoot, strings.TrimPrefix(line, "0::"))
+			break
+		}
+	}
+	if own == "" || own == cgroupRoot {
+		return "", fmt.Errorf("could not determine our own cgroup")
+	}
+
+	available, err := ioutil.ReadFile(filepath.Join(own, "cgroup.controllers"))
+	if err != nil {
+		return "", err
+	}
+	var controllers []string
+	for _, c := range strings.Fields(string(available)) {
+		switch c {
+		case "memory", "cpu", "io":
+			controllers = append(controllers, "+"+c)
+		}
+	}
+	if !strings.Contains(strings.Join(controllers, " "), "+memory") {
+		return "", fmt.Errorf("the memory controller is not available in cgroup %s", own)
+	}
+
+	removeStaleCgroups(own)
+
+	pid := strconv.Itoa(os.Getpid())
+	leaf := filepath.Join(own, cgroupRunnerPrefix+pid)
+	err = os.Mkdir(leaf, 0755)
+	if err != nil {
+		return "", err
+	}
+	err = writeCgroupFile(leaf, "cgroup.procs", pid)
+	if err != nil {
+		errr := os.Remove(leaf)
+		if errr != nil {
+			err = fmt.Errorf("%s (and removing the cgroup failed: %s)", err, errr)
+		}
+		return "", err
+	}
+
+	err = writeCgroupFile(own, "cgroup.subtree_control", strings.Join(controllers, " "))
+	if err != nil {
+		// probably there are other processes in our original cgroup; go back
+		// to how things were
+		errm := writeCgroupFile(own, "cgroup.procs", pid)
+		if errm == nil {
+			errm = os.Remove(leaf)
+		}
+		if errm != nil {
+			err = fmt.Errorf("%s (and restoring our original cgroup failed: %s)", err, errm)
+		}
+		return "", err
+	}
+
+	return own, nil
+}
+
+// removeStaleCgroups tidies up the cgroups in dir that were left behind by
+// previous runners that didn't get the chance to remove them. Only the cgroups
+// of runners that are no longer alive are touched, since other runners may be
+// sharing dir, and have created cgroups that they are about to use.
+func removeStaleCgroups(dir string) {
+	for _, prefix := range []string{cgroupRunnerPrefix, cgroupJobPrefix} {
+		dirs, err := filepath.Glob(filepath.Join(dir, prefix+"*"))
+		if err != nil {
+			continue
+		}
+		for _, cgdir := range dirs {
+			pid := cgroupOwner(filepath.Base(cgdir), prefix)
+			if pid == 0 || pid == os.Getpid() || processAlive(pid) {
+				continue
+			}
+
+			// (this only succeeds for empty cgroups)
+			_ = os.Remove(cgdir)
+		}
+	}
+}
+
+// cgroupOwner returns the pid of the runner that created the cgroup with the
+// given name, which has the given prefix. Returns 0 if the name doesn't
+// contain a pid.
+func cgroupOwner(name, prefix string) int {
+	owner := strings.TrimPrefix(name, prefix)
+	if i := strings.Index(owner, "_"); i >= 0 {
+		owner = owner[:i]
+	}
+	pid, err := strconv.Atoi(owner)
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// writeCgroupFile writes the given value to the named interface file of the
+// cgroup at dir.
+func writeCgroupFile(dir, file, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+}
+
+// readCgroupUint reads an interface file of the cgroup at dir that contains a
+// single number.
+func readCgroupUint(dir, file string) (uint64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(bytes.TrimSpace(b)), 10, 64)
+}
+
+// readCgroupKeyed reads an interface file of the cgroup at dir that has lines
+// of "key value" (flat keyed, like cpu.stat) or "name key=value key=value"
+// (nested keyed, like io.stat), summing the values of each key.
+func readCgroupKeyed(dir, file string) (values map[string]uint64, err error) {
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		errc := f.Close()
+		if errc != nil && err == nil {
+			err = errc
+		}
+	}()
+
+	values = make(map[string]uint64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && !strings.Contains(fields[1], "=") {
+			if v, errp := strconv.ParseUint(fields[1], 10, 64); errp == nil {
+				values[fields[0]] += v
+			}
+			continue
+		}
+		for _, field := range fields {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			if v, errp := strconv.ParseUint(kv[1], 10, 64); errp == nil {
+				values[kv[0]] += v
+			}
+		}
+	}
+	return values, s.Err()
+}
+
+// cgroupStats holds the resource usage of a cgroup.
+type cgroupStats struct {
+	peakMB    int
+	cpu       time.Duration
+	ioRead    uint64
+	ioWrite   uint64
+	oomKilled bool
+}
+
+// jobCgroup is a cgroup that we run a Cmd in.
+type jobCgroup struct {
+	dir string
+}
+
+// newJobCgroup creates a cgroup for running a Cmd in, with its memory.max set
+// to the given number of Megabytes and its cpu.max to the given number of
+// cores. It returns an error if cgroups can't be used on this host (see
+// cgroupParent()).
+func newJobCgroup(name string, ramMB int, cores int) (*jobCgroup, error) {
+	parent, err := cgroupParent()
+	if err != nil {
+		return nil, err
+	}
+
+	// (a cgroup may have been left behind by a previous attempt at running
+	// the same Cmd, if we failed to remove it)
+	dir := filepath.Join(parent, cgroupJobPrefix+strconv.Itoa(os.Getpid())+"_"+name)
+	_ = os.Remove(dir)
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	cg := &jobCgroup{dir: dir}
+
+	if ramMB > 0 {
+		err = writeCgroupFile(dir, "memory.max", strconv.FormatUint(uint64(ramMB)*1024*1024, 10))
+		if err != nil {
+			return nil, cg.failed(err)
+		}
+
+		// (these are best-effort: we'd rather the Cmd got killed than swapped,
+		// and that it gets killed in its entirety, but older kernels may not
+		// support these)
+		_ = writeCgroupFile(dir, "memory.swap.max", "0")
+		_ = writeCgroupFile(dir, "memory.oom.group", "1")
+	}
+
+	if cores > 0 {
+		if _, errs := os.Stat(filepath.Join(dir, "cpu.max")); errs == nil {
+			err = writeCgroupFile(dir, "cpu.max", fmt.Sprintf("%d %d", cores*cgroupCPUPeriod, cgroupCPUPeriod))
+			if err != nil {
+				return nil, cg.failed(err)
+			}
+		}
+	}
+
+	return cg, nil
+}
+
+// failed removes our cgroup and returns the given error, amended if the
+// removal fails.
+func (cg *jobCgroup) failed(err error) error {
+	errr := os.Remove(cg.dir)
+	if errr != nil {
+		err = fmt.Errorf("%s (and removing the cgroup failed: %s)", err, errr)
+	}
+	return err
+}
+
+// addProcess moves the given pid in to our cgroup. Its children will then
+// also be in our cgroup when they are created.
+func (cg *jobCgroup) addProcess(pid int) error {
+	return writeCgroupFile(cg.dir, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// joinPrefix returns shell code that moves the shell running it in to our
+// cgroup. Prefixing a Cmd with this means that everything the Cmd spawns is in
+// our cgroup from the start, instead of only what it spawns after we get the
+// chance to call addProcess().
+func (cg *jobCgroup) joinPrefix() string {
+	procs := strings.Replace(filepath.Join(cg.dir, "cgroup.procs"), "'", `'\''`, -1)
+	return "echo $$ > '" + procs + "' 2>/dev/null; "
+}
+
+// currentMemory returns the current memory usage of all the processes in our
+// cgroup, in MB.
+func (cg *jobCgroup) currentMemory() (int, error) {
+	b, err := readCgroupUint(cg.dir, "memory.current")
+	return int(b / 1024 / 1024), err
+}
+
+// stats returns the resource usage of our cgroup. The peakMB will be 0 if the
+// kernel is too old to record memory.peak, and the io values will be 0 if the
+// io controller isn't available.
+func (cg *jobCgroup) stats() (*cgroupStats, error) {
+	st := &cgroupStats{}
+
+	if peak, err := readCgroupUint(cg.dir, "memory.peak"); err == nil {
+		st.peakMB = int(peak / 1024 / 1024)
+	}
+
+	cpu, err := readCgroupKeyed(cg.dir, "cpu.stat")
+	if err != nil {
+		return nil, err
+	}
+	st.cpu = time.Duration(cpu["usage_usec"]) * time.Microsecond
+
+	if io, errio := readCgroupKeyed(cg.dir, "io.stat"); errio == nil {
+		st.ioRead = io["rbytes"]
+		st.ioWrite = io["wbytes"]
+	}
+
+	events, err := readCgroupKeyed(cg.dir, "memory.events")
+	if err != nil {
+		return nil, err
+	}
+	st.oomKilled = events["oom_kill"] > 0 || events["oom_group_kill"] > 0
+
+	return st, nil
+}
+
+// kill sends SIGKILL to every process in our cgroup. This does nothing on
+// kernels too old to support cgroup.kill.
+func (cg *jobCgroup) kill() error {
+	if _, err := os.Stat(filepath.Join(cg.dir, "cgroup.kill")); err != nil {
+		return nil
+	}
+	return writeCgroupFile(cg.dir, "cgroup.kill", "1")
+}
+
+// remove deletes our cgroup, which is only possible once all of its processes
+// have exited, so we wait a short while for that to happen if necessary.
+func (cg *jobCgroup) remove() error {
+	var err error
+	for i := 0; i < cgroupRemoveAttempts; i++ {
+		err = os.Remove(cg.dir)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		<-time.After(killPollInterval)
+	}
+	return err
+}
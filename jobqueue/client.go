@@ -22,11 +22,16 @@ package jobqueue
 
 import (
 	"bytes"
+	"crypto/md5" // #nosec - only used to detect transfer errors
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
@@ -36,7 +41,7 @@ import (
 	"github.com/VertebrateResequencing/wr/internal"
 	"github.com/go-mangos/mangos"
 	"github.com/go-mangos/mangos/protocol/req"
-	"github.com/go-mangos/mangos/transport/tcp"
+	"github.com/go-mangos/mangos/transport/tlstcp"
 	"github.com/satori/go.uuid"
 	"github.com/ugorji/go/codec"
 )
@@ -59,6 +64,8 @@ const (
 	FailReasonMount    = "mounting of remote file system(s) failed"
 	FailReasonUpload   = "failed to upload files to remote file system"
 	FailReasonKilled   = "killed by user request"
+	FailReasonOutputs  = "command did not create its declared outputs"
+	FailReasonCapture  = "failed to set up capture of STDOUT/STDERR"
 )
 
 // these global variables are primarily exported for testing purposes; you
@@ -66,7 +73,12 @@ const (
 // as fields of a config struct...)
 var (
 	ClientTouchInterval               = 15 * time.Second
+	ClientTailInterval                = 1 * time.Second
 	ClientReleaseDelay                = 30 * time.Second
+	ClientReconnectMax                = 5 * time.Second
+	ClientKillGrace                   = 10 * time.Second
+	ClientUseCgroups                  = true
+	ClientDiskInterval                = 1 * time.Minute
 	RAMIncreaseMin            float64 = 1000
 	RAMIncreaseMultLow                = 2.0
 	RAMIncreaseMultHigh               = 1.3
@@ -77,21 +89,34 @@ var (
 // to request it do something. (The properties are only exported so the
 // encoder doesn't ignore them.)
 type clientRequest struct {
+	Chunk          *fileChunk
 	ClientID       uuid.UUID
 	Env            []byte // compressed binc encoding of []string
 	FirstReserve   bool
 	GetEnv         bool
 	GetStd         bool
+	History        *HistoryQuery
+	Host           string
 	IgnoreComplete bool
 	Job            *Job
 	JobEndState    *JobEndState
 	Jobs           []*Job
 	Keys           []string
 	Limit          int
+	LimitGroup     *LimitGroup
 	Method         string
+	Modifier       *JobModifier
+	Offset         int64
+	Output         *outputChunk
+	PageSize       int
+	Replica        *replicaRequest
+	Schedule       *CronSchedule
 	SchedulerGroup string
 	State          JobState
+	Stderr         bool
+	Subscription   *Subscription
 	Timeout        time.Duration
+	Token          []byte
 	User           string
 }
 
@@ -104,6 +129,7 @@ type Client struct {
 	sock        mangos.Socket
 	sync.Mutex
 	teMutex    sync.Mutex // to protect Touch() from other methods during Execute()
+	token      []byte
 	user       string
 	ServerInfo *ServerInfo
 }
@@ -113,10 +139,25 @@ type envStr struct {
 	Environ []string
 }
 
-// Connect creates a connection to the jobqueue server. Timeout determines how
-// long to wait for a response from the server, not only while connecting, but
-// for all subsequent interactions with it using the returned Client.
-func Connect(addr string, timeout time.Duration) (*Client, error) {
+// Connect creates a connection to the jobqueue server.
+//
+// addr is the server's host:port, or a comma separated list of these, as when
+// you have standby servers (see Standby()). Your requests will go to whichever
+// of these is serving, and you also connect to any standbys the server knows
+// about, so that if one of them takes over, the returned Client carries on
+// working with the new server.
+//
+// caFile is the path to the PEM encoded certificate of the CA that signed the
+// server's certificate (as created by Serve() at its configured CAFile path),
+// and certDomain is the domain that certificate is valid for. If caFile doesn't
+// exist, the server's certificate must instead be trusted by your system.
+//
+// token is the secret token found in the server's configured TokenFile.
+//
+// Timeout determines how long to wait for a response from the server, not only
+// while connecting, but for all subsequent interactions with it using the
+// returned Client.
+func Connect(addr, caFile, certDomain string, token []byte, timeout time.Duration) (*Client, error) {
 	// a server is only allowed to be accessed by a particular user, so we get
 	// our username here. NB: *** this is not real security, since someone could
 	// just recompile with the following line altered to a hardcoded username
@@ -141,13 +182,43 @@ func Connect(addr string, timeout time.Duration) (*Client, error) {
 		return nil, err
 	}
 
-	sock.AddTransport(tcp.NewTransport())
-
-	err = sock.Dial("tcp://" + addr)
+	// if we lose contact with a server, we keep trying to reconnect, but not
+	// so slowly that we're late to notice a standby has taken over
+	err = sock.SetOption(mangos.OptionMaxReconnectTime, ClientReconnectMax)
 	if err != nil {
 		return nil, err
 	}
 
+	// all our communication is encrypted, and we make sure we're talking to
+	// the real server
+	tlsConfig := &tls.Config{ServerName: certDomain, MinVersion: tls.VersionTLS12}
+	caCert, err := ioutil.ReadFile(caFile)
+	if err == nil {
+		certPool := x509.NewCertPool()
+		certPool.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = certPool
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	sock.AddTransport(tlstcp.NewTransport())
+
+	dialed := make(map[string]bool)
+	dial := func(addr string) error {
+		addr = strings.TrimSpace(addr)
+		if addr == "" || dialed[addr] {
+			return nil
+		}
+		dialed[addr] = true
+		return sock.DialOptions("tls+tcp://"+addr, map[string]interface{}{mangos.OptionTLSConfig: tlsConfig})
+	}
+	for _, a := range strings.Split(addr, ",") {
+		err = dial(a)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// clients identify themselves (only for the purpose of calling methods that
 	// require the client has previously used Require()) with a UUID; v4 is used
 	// since speed doesn't matter: a typical client executable will only
@@ -157,7 +228,7 @@ func Connect(addr string, timeout time.Duration) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	c := &Client{sock: sock, ch: new(codec.BincHandle), user: user, clientid: u}
+	c := &Client{sock: sock, ch: new(codec.BincHandle), token: token, user: user, clientid: u}
 
 	// Dial succeeds even when there's no server up, so we test the connection
 	// works with a Ping()
@@ -168,13 +239,26 @@ func Connect(addr string, timeout time.Duration) (*Client, error) {
 			return c, errc
 		}
 		msg := ErrNoServer
-		if jqerr, ok := err.(Error); ok && jqerr.Err == ErrWrongUser {
-			msg = ErrWrongUser
+		if jqerr, ok := err.(Error); ok && (jqerr.Err == ErrWrongUser || jqerr.Err == ErrWrongToken) {
+			msg = jqerr.Err
 		}
 		return nil, Error{"Connect", "", msg}
 	}
 	c.ServerInfo = si
 
+	// also connect to the server's standbys, so that if one of them takes over
+	// from it, our requests will go there instead
+	for _, a := range si.Standbys {
+		err = dial(a)
+		if err != nil {
+			errc := sock.Close()
+			if errc != nil {
+				err = fmt.Errorf("%s (and closing the socket failed: %s)", err, errc)
+			}
+			return nil, err
+		}
+	}
+
 	return c, err
 }
 
@@ -241,6 +325,29 @@ func (c *Client) BackupDB(path string) error {
 	return os.Rename(tmpPath, path)
 }
 
+// replicate is used by a Standby() to get a replica of the server it is
+// standing by for.
+func (c *Client) replicate(rr *replicaRequest) (*replica, error) {
+	resp, err := c.request(&clientRequest{Method: "replicate", Replica: rr})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Replica, err
+}
+
+// CompactDB rewrites the server's database file to reclaim the space left
+// unused by things that were removed from it (eg. when pruning old jobs
+// according to the server's RetentionPolicy). The server can't make any other
+// use of its database while it does this. Returns the size in bytes of the
+// file before and after.
+func (c *Client) CompactDB() (before int64, after int64, err error) {
+	resp, err := c.request(&clientRequest{Method: "compact"})
+	if err != nil {
+		return before, after, err
+	}
+	return resp.DBSizes[0], resp.DBSizes[1], err
+}
+
 // Add adds new jobs to the job queue, but only if those jobs aren't already in
 // there.
 //
@@ -254,6 +361,10 @@ func (c *Client) BackupDB(path string) error {
 // replace the old one in the database. To have such jobs skipped as "existed"
 // instead, supply ignoreComplete as true.
 //
+// Jobs with ArrayParams are treated as templates: they are expanded by the
+// server in to the Jobs of a job array, and the returned counts relate to
+// those Jobs.
+//
 // The envVars argument is a slice of ("key=value") strings with the environment
 // variables you want to be set when the job's Cmd actually runs. Typically you
 // would pass in os.Environ().
@@ -288,7 +399,7 @@ func (c *Client) Reserve(timeout time.Duration) (*Job, error) {
 		fr = true
 		c.hasReserved = true
 	}
-	resp, err := c.request(&clientRequest{Method: "reserve", Timeout: timeout, FirstReserve: fr})
+	resp, err := c.request(&clientRequest{Method: "reserve", Timeout: timeout, FirstReserve: fr, Host: hostName()})
 	if err != nil {
 		return nil, err
 	}
@@ -312,7 +423,7 @@ func (c *Client) ReserveScheduled(timeout time.Duration, schedulerGroup string)
 		fr = true
 		c.hasReserved = true
 	}
-	resp, err := c.request(&clientRequest{Method: "reserve", Timeout: timeout, SchedulerGroup: schedulerGroup, FirstReserve: fr})
+	resp, err := c.request(&clientRequest{Method: "reserve", Timeout: timeout, SchedulerGroup: schedulerGroup, FirstReserve: fr, Host: hostName()})
 	if err != nil {
 		return nil, err
 	}
@@ -343,13 +454,30 @@ func (c *Client) ReserveScheduled(timeout time.Duration, schedulerGroup string)
 // Internally, Execute() calls Mount() and Started() and keeps track of peak RAM
 // used. It regularly calls Touch() on the Job so that the server knows we are
 // still alive and handling the Job successfully. It also intercepts SIGTERM,
-// SIGINT, SIGQUIT, SIGUSR1 and SIGUSR2, sending SIGKILL to the running Cmd and
-// returning Error.Err(FailReasonSignal); you should check for this and exit
-// your process. Finally it calls Unmount() and TriggerBehaviours().
+// SIGINT, SIGQUIT, SIGUSR1 and SIGUSR2, killing the running Cmd and all the
+// processes it spawned (with SIGTERM, and then SIGKILL after the Job's
+// KillGrace) and returning Error.Err(FailReasonSignal); you should check for
+// this and exit your process. Finally it calls Unmount() and
+// TriggerBehaviours().
+//
+// On linux hosts where cgroups v2 are in use and our cgroup has been delegated
+// to us, the Cmd is run in its own cgroup, limited to the Job's RAM and Cores.
+// The kernel then enforces the RAM limit (being OOM killed results in
+// Error.Err(FailReasonRAM)), and the Job's PeakRAM, CPUtime, IORead and IOWrite
+// are taken from the cgroup. Otherwise, memory usage is polled every second,
+// and the Cmd is killed if it uses more than the Job's RAM.
+//
+// Jobs are normally allowed to run for longer than their expected time, but if
+// the Job has a TimeLimit or TimeLimitMult, the Cmd is killed once that hard
+// limit is reached, returning Error.Err(FailReasonTime). The Job is then
+// Release()d so that it gets retried with more time.
 //
 // If Kill() is called while executing the Cmd, the next internal Touch() call
 // will result in the Cmd being killed and the job being Bury()ied.
 //
+// A Cmd that we killed for any of the above reasons is treated as having
+// failed even if it traps our SIGTERM and exits 0.
+//
 // If no error is returned, the Cmd will have run OK, exited with status 0, and
 // been Archive()d from the queue while being placed in the permanent store.
 // Otherwise, it will have been Release()d or Bury()ied as appropriate.
@@ -368,6 +496,7 @@ func (c *Client) Execute(job *Job, shell string) error {
 	if !uuid.Equal(c.clientid, job.ReservedBy) {
 		return Error{"Execute", job.key(), ErrMustReserve}
 	}
+	job.client = c
 
 	// we support arbitrary shell commands that may include semi-colons,
 	// quoted stuff and pipes, so it's best if we just pass it to bash
@@ -377,32 +506,72 @@ func (c *Client) Execute(job *Job, shell string) error {
 	}
 	cmd := exec.Command(shell, "-c", jc) // #nosec Our whole purpose is to allow users to run arbitrary commands via us...
 
+	// run the command in its own process group, so that we can kill it along
+	// with everything it spawns
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	killGrace := job.KillGrace
+	if killGrace <= 0 {
+		killGrace = ClientKillGrace
+	}
+
+	// we'll run the command from the desired directory, which must exist or
+	// it will fail
+	if fi, errf := os.Stat(job.Cwd); errf != nil || !fi.Mode().IsDir() {
+		errb := c.Bury(job, nil, FailReasonCwd)
+		extra := ""
+		if errb != nil {
+			extra = fmt.Sprintf(" (and burying the job failed: %s)", errb)
+		}
+		return fmt.Errorf("working directory [%s] does not exist%s", job.Cwd, extra)
+	}
+
+	// if desired, we'll write the complete STDERR/OUT of the cmd to files; if
+	// we don't end up running the cmd, they're discarded
+	var capture *stdCapture
+	if job.StdCapture != "" {
+		var errc error
+		capture, errc = newStdCapture(job)
+		if errc != nil {
+			buryErr := fmt.Errorf("could not set up capture of STDOUT/STDERR: %s", errc)
+			errb := c.Bury(job, nil, FailReasonCapture, buryErr)
+			if errb != nil {
+				buryErr = fmt.Errorf("%s (and burying the job failed: %s)", buryErr.Error(), errb)
+			}
+			return buryErr
+		}
+		defer func() {
+			// (any problem is of no consequence since the cmd didn't run)
+			_ = capture.discard()
+		}()
+	}
+
 	// we'll filter STDERR/OUT of the cmd to keep only the first and last line
 	// of any contiguous block of \r terminated lines (to mostly eliminate
 	// progress bars), and  we'll store only up to 4kb of their head and tail
+	// (we also keep the most recent unfiltered output for anyone who wants to
+	// tail it while the cmd runs)
+	streamer := &outputStreamer{}
 	errReader, err := cmd.StderrPipe()
 	if err != nil {
 		return fmt.Errorf("failed to create a pipe for STDERR from cmd [%s]: %s", jc, err)
 	}
+	errWriters := []io.Writer{streamer.writer(true)}
+	if capture != nil {
+		errWriters = append(errWriters, capture.stderr)
+	}
 	stderr := &prefixSuffixSaver{N: 4096}
-	stderrWait := stdFilter(errReader, stderr)
+	stderrWait := stdFilter(io.TeeReader(errReader, io.MultiWriter(errWriters...)), stderr)
 	outReader, err := cmd.StdoutPipe()
 	if err != nil {
 		return fmt.Errorf("failed to create a pipe for STDOUT from cmd [%s]: %s", jc, err)
 	}
+	outWriters := []io.Writer{streamer.writer(false)}
+	if capture != nil {
+		outWriters = append(outWriters, capture.stdout)
+	}
 	stdout := &prefixSuffixSaver{N: 4096}
-	stdoutWait := stdFilter(outReader, stdout)
+	stdoutWait := stdFilter(io.TeeReader(outReader, io.MultiWriter(outWriters...)), stdout)
 
-	// we'll run the command from the desired directory, which must exist or
-	// it will fail
-	if fi, errf := os.Stat(job.Cwd); errf != nil || !fi.Mode().IsDir() {
-		errb := c.Bury(job, nil, FailReasonCwd)
-		extra := ""
-		if errb != nil {
-			extra = fmt.Sprintf(" (and burying the job failed: %s)", errb)
-		}
-		return fmt.Errorf("working directory [%s] does not exist%s", job.Cwd, extra)
-	}
 	var actualCwd, tmpDir string
 	if job.CwdMatters {
 		cmd.Dir = job.Cwd
@@ -440,12 +609,18 @@ func (c *Client) Execute(job *Job, shell string) error {
 		}
 	}
 
+	// we don't need to run the cmd at all if its outputs are already up to
+	// date
+	if job.SkipIfUpToDate && job.outputsUpToDate() {
+		return c.skip(job, actualCwd)
+	}
+
 	var myerr error
 
 	// and we'll run it with the environment variables that were present when
 	// the command was first added to the queue (or if none, current env vars,
-	// and in either case, including any overrides) *** we need a way for users
-	// to update a job with new env vars
+	// and in either case, including any overrides, which users can change
+	// with Modify())
 	env, err := job.Env()
 	if err != nil {
 		errb := c.Bury(job, nil, FailReasonEnv)
@@ -486,6 +661,20 @@ func (c *Client) Execute(job *Job, shell string) error {
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
 	defer signal.Stop(sigs)
 
+	// where cgroups v2 have been delegated to us, run the command in its own
+	// cgroup so that the kernel enforces its memory and cpu limits and can
+	// tell us exactly what it used; otherwise we fall back on polling its
+	// memory usage ourselves
+	var cg *jobCgroup
+	if ClientUseCgroups {
+		cg, _ = newJobCgroup(job.key(), job.Requirements.RAM, job.Requirements.Cores)
+		if cg != nil {
+			// have the shell join the cgroup before it runs anything, so
+			// nothing the command spawns can escape it
+			cmd.Args[len(cmd.Args)-1] = cg.joinPrefix() + jc
+		}
+	}
+
 	// start running the command
 	endT := time.Now().Add(job.Requirements.Time)
 	err = cmd.Start()
@@ -500,19 +689,43 @@ func (c *Client) Execute(job *Job, shell string) error {
 		if erru != nil {
 			extra += fmt.Sprintf(" (and unmounting the job failed: %s)", erru)
 		}
+		if cg != nil {
+			_ = cg.remove()
+		}
 		return fmt.Errorf("could not start command [%s]: %s%s", jc, err, extra)
 	}
+	if cg != nil {
+		// (the shell should have already joined the cgroup itself, but make
+		// sure; if it can't, we fall back on polling)
+		if errc := cg.addProcess(cmd.Process.Pid); errc != nil {
+			_ = cg.remove()
+			cg = nil
+		}
+	}
+	killCmd := func() error {
+		errk := killProcessTree(cmd.Process.Pid, killGrace)
+		if cg != nil {
+			// catch anything that escaped the process tree
+			if errc := cg.kill(); errc != nil && errk == nil {
+				errk = errc
+			}
+		}
+		return errk
+	}
 
 	// update the server that we've started the job
-	err = c.Started(job, cmd.Process.Pid)
+	tail, err := c.started(job, cmd.Process.Pid)
 	if err != nil {
 		// if we can't access the server, may as well bail out now - kill the
 		// command (and don't bother trying to Release(); it will auto-Release)
-		errk := cmd.Process.Kill()
+		errk := killCmd()
 		extra := ""
 		if errk != nil {
 			extra = fmt.Sprintf(" (and killing the cmd failed: %s)", errk)
 		}
+		if cg != nil {
+			_ = cg.remove()
+		}
 		errt := job.TriggerBehaviours(false)
 		if errt != nil {
 			extra += fmt.Sprintf(" (and triggering behaviours failed: %s)", errt)
@@ -525,65 +738,141 @@ func (c *Client) Execute(job *Job, shell string) error {
 	}
 
 	// update peak mem used by command, touch job and check if we use too much
-	// resources, every 15s. Also check for signals
+	// resources, every 15s. Also check for signals, and send the server our
+	// recent output if anyone wants to tail it
+	streamer.setActive(tail)
 	peakmem := 0
 	ticker := time.NewTicker(ClientTouchInterval) //*** this should be less than the ServerItemTTR set when the server started, not a fixed value
 	memTicker := time.NewTicker(1 * time.Second)  // we need to check on memory usage frequently
+	tailTicker := time.NewTicker(ClientTailInterval)
 	ranoutMem := false
 	ranoutTime := false
+	hitTimeLimit := false
 	signalled := false
 	killCalled := false
 	var killErr error
 	var stateMutex sync.Mutex
+
+	// if we have unique working directories, periodically measure how much
+	// disk space the command is using in them; we do this in its own
+	// goroutine, since it could be slow
+	peakdisk := 0
+	measureDisk := func() {
+		disk := diskUsage(actualCwd, tmpDir)
+		stateMutex.Lock()
+		if disk > peakdisk {
+			peakdisk = disk
+		}
+		stateMutex.Unlock()
+	}
+	stopDiskChecking := make(chan bool)
+	diskCheckingDone := make(chan bool)
+	if tmpDir != "" {
+		go func() {
+			defer close(diskCheckingDone)
+			diskTicker := time.NewTicker(ClientDiskInterval)
+			defer diskTicker.Stop()
+			for {
+				select {
+				case <-diskTicker.C:
+					measureDisk()
+				case <-stopDiskChecking:
+					return
+				}
+			}
+		}()
+	} else {
+		close(diskCheckingDone)
+	}
+
+	// if the job has a hard time limit, we'll kill it when that's reached
+	var timeLimit <-chan time.Time
+	if limit := job.timeLimit(); limit > 0 {
+		limitTimer := time.NewTimer(limit)
+		defer limitTimer.Stop()
+		timeLimit = limitTimer.C
+	}
+
 	stopChecking := make(chan bool, 1)
+	checkingDone := make(chan bool)
 	go func() {
+		defer close(checkingDone)
 		for {
 			select {
 			case <-sigs:
-				killErr = cmd.Process.Kill()
+				killErr = killCmd()
 				stateMutex.Lock()
 				signalled = true
 				stateMutex.Unlock()
 				return
+			case <-timeLimit:
+				killErr = killCmd()
+				stateMutex.Lock()
+				hitTimeLimit = true
+				stateMutex.Unlock()
+				return
 			case <-ticker.C:
 				stateMutex.Lock()
 				if !ranoutTime && time.Now().After(endT) {
 					ranoutTime = true
-					// we allow things to go over time, but then if we end up
-					// getting signalled later, we now know it may be because we
-					// used too much time
+					// without a hard time limit we allow things to go over
+					// time, but then if we end up getting signalled later, we
+					// now know it may be because we used too much time
 				}
 				stateMutex.Unlock()
 
-				kc, errf := c.Touch(job)
+				kc, tailWanted, errf := c.touch(job)
 				if errf != nil {
 					// we may have lost contact with the manager; this is OK. We
 					// will keep trying to touch until it works
 					continue
 				}
+				streamer.setActive(tailWanted)
 				if kc {
-					killErr = cmd.Process.Kill()
+					killErr = killCmd()
 					stateMutex.Lock()
 					killCalled = true
 					stateMutex.Unlock()
 					return
 				}
 			case <-memTicker.C:
-				mem, errf := currentMemory(job.Pid)
+				if cg != nil {
+					// the kernel enforces the memory limit, and we get the
+					// true peak from the cgroup at the end, but we may as
+					// well keep track in case the kernel can't tell us
+					mem, errf := cg.currentMemory()
+					stateMutex.Lock()
+					if errf == nil && mem > peakmem {
+						peakmem = mem
+					}
+					stateMutex.Unlock()
+					continue
+				}
+
+				mem, errf := currentTreeMemory(job.Pid)
 				stateMutex.Lock()
 				if errf == nil && mem > peakmem {
 					peakmem = mem
 
 					if peakmem > job.Requirements.RAM {
 						// we don't allow things to use too much memory, or we
-						// could screw up the machine we're running on
-						killErr = cmd.Process.Kill()
+						// could screw up the machine we're running on (we
+						// don't hold the lock while killing, since that can
+						// take the whole kill grace period)
 						ranoutMem = true
 						stateMutex.Unlock()
+						killErr = killCmd()
 						return
 					}
 				}
 				stateMutex.Unlock()
+			case <-tailTicker.C:
+				if chunk := streamer.unsent(); chunk != nil {
+					tailWanted, errf := c.streamOutput(job, chunk)
+					if errf == nil {
+						streamer.setActive(tailWanted)
+					}
+				}
 			case <-stopChecking:
 				return
 			}
@@ -596,10 +885,57 @@ func (c *Client) Execute(job *Job, shell string) error {
 	err = cmd.Wait()
 	ticker.Stop()
 	memTicker.Stop()
+	tailTicker.Stop()
 	stopChecking <- true
+
+	// if we were in the middle of killing the command, wait until any of its
+	// descendants that outlived it have been dealt with as well
+	<-checkingDone
+
+	// take a final measurement of disk usage, since the command may have
+	// exited before we ever measured it
+	close(stopDiskChecking)
+	<-diskCheckingDone
+	if tmpDir != "" {
+		measureDisk()
+	}
+
+	// send any final output to tailers before we tell the server the job has
+	// ended, so they get it all (it doesn't matter if this fails)
+	if chunk := streamer.unsent(); chunk != nil {
+		_, _ = c.streamOutput(job, chunk)
+	}
 	stateMutex.Lock()
 	defer stateMutex.Unlock()
 
+	var captureErr error
+	if capture != nil {
+		captureErr = capture.close()
+	}
+
+	// get exact resource usage from the cgroup if we had one; if the kernel
+	// OOM killed the command, that's the same as us killing it for using too
+	// much memory
+	cputime := cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+	var ioRead, ioWrite uint64
+	if cg != nil {
+		if st, errs := cg.stats(); errs == nil {
+			if st.peakMB > 0 {
+				peakmem = st.peakMB
+			}
+			cputime = st.cpu
+			ioRead = st.ioRead
+			ioWrite = st.ioWrite
+			if st.oomKilled {
+				ranoutMem = true
+			}
+		}
+
+		// (if this fails, the next runner to start on this host will try
+		// again)
+		_ = cg.remove()
+	}
+
 	// we could get the max rss from ProcessState.SysUsage, but we'll stick with
 	// our better (?) pss-based Peakmem, unless the command exited so quickly
 	// we never ticked and calculated it
@@ -624,6 +960,22 @@ func (c *Client) Execute(job *Job, shell string) error {
 	}
 	peakmem += ourmem
 
+	// if we killed the command, it failed however it exited: it may have
+	// trapped our SIGTERM and exited 0 during its kill grace period
+	killReason := ""
+	switch {
+	case ranoutMem:
+		killReason = FailReasonRAM
+	case hitTimeLimit:
+		killReason = FailReasonTime
+	case signalled && ranoutTime:
+		killReason = FailReasonTime
+	case signalled:
+		killReason = FailReasonSignal
+	case killCalled:
+		killReason = FailReasonKilled
+	}
+
 	// get the exit code and figure out what to do with the Job
 	var exitcode int
 	dobury := false
@@ -653,21 +1005,10 @@ func (c *Client) Execute(job *Job, shell string) error {
 				myerr = fmt.Errorf("command [%s] exited with code %d (invalid exit code), which seems permanent, so it has been buried", job.Cmd, exitcode)
 			default:
 				dorelease = true
-				if ranoutMem {
-					failreason = FailReasonRAM
-					myerr = Error{"Execute", job.key(), FailReasonRAM}
-				} else if signalled {
-					if ranoutTime {
-						failreason = FailReasonTime
-						myerr = Error{"Execute", job.key(), FailReasonTime}
-					} else {
-						failreason = FailReasonSignal
-						myerr = Error{"Execute", job.key(), FailReasonSignal}
-					}
-				} else if killCalled {
-					dobury = true
-					failreason = FailReasonKilled
-					myerr = Error{"Execute", job.key(), FailReasonKilled}
+				if killReason != "" {
+					dobury = killReason == FailReasonKilled
+					failreason = killReason
+					myerr = Error{"Execute", job.key(), killReason}
 				} else {
 					failreason = FailReasonExit
 					myerr = fmt.Errorf("command [%s] exited with code %d%s", job.Cmd, exitcode, mayBeTemp)
@@ -681,10 +1022,22 @@ func (c *Client) Execute(job *Job, shell string) error {
 			myerr = fmt.Errorf("command [%s] failed to complete normally (%v)%s", job.Cmd, err, mayBeTemp)
 		}
 	} else {
-		// the command worked fine
+		// the command worked fine, but may not have done what it said it
+		// would
 		exitcode = cmd.ProcessState.Sys().(syscall.WaitStatus).ExitStatus()
-		doarchive = true
-		myerr = nil
+		if killReason != "" {
+			dorelease = true
+			dobury = killReason == FailReasonKilled
+			failreason = killReason
+			myerr = Error{"Execute", job.key(), killReason}
+		} else if missing := job.missingOutputs(); len(missing) > 0 {
+			dorelease = true
+			failreason = FailReasonOutputs
+			myerr = fmt.Errorf("command [%s] exited 0 but did not create its outputs %s%s", job.Cmd, strings.Join(missing, ", "), mayBeTemp)
+		} else {
+			doarchive = true
+			myerr = nil
+		}
 	}
 
 	finalStdErr := bytes.TrimSpace(stderr.Bytes())
@@ -699,7 +1052,7 @@ func (c *Client) Execute(job *Job, shell string) error {
 			case <-sigs:
 				return
 			case <-ticker2.C:
-				if !killCalled && !ranoutMem && !signalled {
+				if !killCalled && !ranoutMem && !hitTimeLimit && !signalled {
 					_, errf := c.Touch(job)
 					if errf != nil {
 						return
@@ -770,6 +1123,11 @@ func (c *Client) Execute(job *Job, shell string) error {
 		finalStdErr = append(finalStdErr, errsew.Error()...)
 	}
 
+	if captureErr != nil {
+		finalStdErr = append(finalStdErr, "\n\nSTDOUT/STDERR capture problems:\n"...)
+		finalStdErr = append(finalStdErr, captureErr.Error()...)
+	}
+
 	finalStdOut := bytes.TrimSpace(stdout.Bytes())
 	if errsow != nil {
 		finalStdOut = append(finalStdOut, "\n\nSTDOUT handling problems:\n"...)
@@ -787,11 +1145,18 @@ func (c *Client) Execute(job *Job, shell string) error {
 		Cwd:      actualCwd,
 		Exitcode: exitcode,
 		PeakRAM:  peakmem,
-		CPUtime:  cmd.ProcessState.SystemTime(),
+		PeakDisk: peakdisk,
+		CPUtime:  cputime,
+		IORead:   ioRead,
+		IOWrite:  ioWrite,
 		Stdout:   finalStdOut,
 		Stderr:   finalStdErr,
 		Exited:   true,
 	}
+	if capture != nil && captureErr == nil {
+		jes.CapturedStdOut = capture.outLocation
+		jes.CapturedStdErr = capture.errLocation
+	}
 	for retryNum := 0; retryNum < maxRetries; retryNum++ {
 		// update the database with our final state
 		if dobury {
@@ -821,6 +1186,31 @@ func (c *Client) Execute(job *Job, shell string) error {
 	return myerr
 }
 
+// skip is used by Execute() to mark a job as complete without running its Cmd,
+// when its Outputs are already up to date. Any directories created for it are
+// cleaned up, though its Outputs are kept.
+func (c *Client) skip(job *Job, actualCwd string) error {
+	var extra string
+	_, erru := job.Unmount()
+	if erru != nil {
+		extra = fmt.Sprintf(" (and unmounting the job failed: %s)", erru)
+	}
+	if actualCwd != "" {
+		errc := (&Behaviour{Do: Cleanup}).cleanup(job, false)
+		if errc != nil {
+			extra += fmt.Sprintf(" (and cleaning up the job failed: %s)", errc)
+		}
+	}
+	err := c.Archive(job, &JobEndState{Cwd: actualCwd, Exited: true, Skipped: true})
+	if err != nil {
+		return fmt.Errorf("command [%s] was skipped since its outputs are up to date, but archiving it failed: %s%s", job.Cmd, err, extra)
+	}
+	if extra != "" {
+		return fmt.Errorf("command [%s] was skipped since its outputs are up to date%s", job.Cmd, extra)
+	}
+	return nil
+}
+
 // Started updates a Job on the server with information that you've started
 // running the Job's Cmd. Started also figures out some host name, ip and
 // possibly id (in cloud situations) to associate with the job, so that if
@@ -829,21 +1219,28 @@ func (c *Client) Execute(job *Job, shell string) error {
 // about it (use one of the Get methods afterwards to get a new object with the
 // HostID set if necessary).
 func (c *Client) Started(job *Job, pid int) error {
+	_, err := c.started(job, pid)
+	return err
+}
+
+// started is like Started(), but also tells you if anyone wants to tail the
+// job's output.
+func (c *Client) started(job *Job, pid int) (bool, error) {
 	// host details
-	host, err := os.Hostname()
-	if err != nil {
-		host = "localhost"
-	}
-	job.Host = host
+	job.Host = hostName()
+	var err error
 	job.HostIP, err = CurrentIP("")
 	if err != nil {
-		return err
+		return false, err
 	}
 	job.Pid = pid
 	job.Attempts++             // not considered by server, which does this itself - just for benefit of this process
 	job.StartTime = time.Now() // ditto
-	_, err = c.request(&clientRequest{Method: "jstart", Job: job})
-	return err
+	resp, err := c.request(&clientRequest{Method: "jstart", Job: job})
+	if err != nil {
+		return false, err
+	}
+	return resp.Tail, err
 }
 
 // Touch adds to a job's ttr, allowing you more time to work on it. Note that
@@ -851,13 +1248,157 @@ func (c *Client) Started(job *Job, pid int) error {
 // is true, you stop doing what you're doing and bury the job, since this means
 // that Kill() has been called for this job.
 func (c *Client) Touch(job *Job) (bool, error) {
+	killCalled, _, err := c.touch(job)
+	return killCalled, err
+}
+
+// touch is like Touch(), but also tells you if anyone wants to tail the job's
+// output.
+func (c *Client) touch(job *Job) (bool, bool, error) {
 	c.teMutex.Lock()
 	defer c.teMutex.Unlock()
 	resp, err := c.request(&clientRequest{Method: "jtouch", Job: job})
+	if err != nil {
+		return false, false, err
+	}
+	return resp.KillCalled, resp.Tail, err
+}
+
+// streamOutput sends recent output of a job's Cmd to the server for the benefit
+// of anyone tailing it, returning true if anyone still wants it. Note that you
+// must have reserved the job before you can send its output.
+func (c *Client) streamOutput(job *Job, chunk *outputChunk) (bool, error) {
+	resp, err := c.request(&clientRequest{Method: "jstream", Job: job, Output: chunk})
 	if err != nil {
 		return false, err
 	}
-	return resp.KillCalled, err
+	return resp.Tail, err
+}
+
+// Tail writes the output of the given Job's Cmd to stdout and stderr as it is
+// produced, starting with the most recent output (up to 64KB of each) if it is
+// already running. If it hasn't started running yet, waits for it to start.
+// Returns once it is no longer running, giving you its state at that point.
+//
+// Output is checked for every ClientTailInterval, but since the runner of an
+// already-running Job only learns that you want its output when it next
+// touches the server, it can take a while for output to start arriving. Output
+// that is produced faster than can be sent to you is skipped over, and where
+// this happens a note is written saying how many bytes were missed.
+//
+// The server only follows the output of a limited number of Jobs at once; if
+// that many other Jobs are already being tailed, you get back an Error with
+// an Err of ErrTooManyTails.
+func (c *Client) Tail(job *Job, stdout io.Writer, stderr io.Writer) (JobState, error) {
+	key := job.key()
+	want := &outputChunk{StdoutOffset: -1, StderrOffset: -1}
+	seenRunning := false
+	for {
+		resp, err := c.request(&clientRequest{Method: "jtail", Keys: []string{key}, Output: want})
+		if err != nil {
+			return "", err
+		}
+		got := resp.Output
+		if got == nil {
+			return "", Error{"Tail", key, ErrBadRequest}
+		}
+
+		err = tailWrite(stdout, want.StdoutOffset, got.StdoutOffset, got.Stdout)
+		if err != nil {
+			return got.State, err
+		}
+		err = tailWrite(stderr, want.StderrOffset, got.StderrOffset, got.Stderr)
+		if err != nil {
+			return got.State, err
+		}
+		want.StdoutOffset = got.StdoutOffset + int64(len(got.Stdout))
+		want.StderrOffset = got.StderrOffset + int64(len(got.Stderr))
+
+		if tailFinished(got.State, seenRunning) {
+			return got.State, nil
+		}
+		if tailRunning(got.State) {
+			seenRunning = true
+		}
+		<-time.After(ClientTailInterval)
+	}
+}
+
+// copyToManager copies the given file, which must be a path relative to the
+// job's actual working directory, to the server's CopyDir, sending it in
+// chunks along with a checksum so the server can confirm it arrived intact.
+// Note that you must have reserved the job before you can copy its files.
+func (c *Client) copyToManager(job *Job, path string) (err error) {
+	relPath, err := copyRelPath(path)
+	if err != nil {
+		return err
+	}
+	dir := job.ActualCwd
+	if dir == "" {
+		dir = job.Cwd
+	}
+	f, err := os.Open(filepath.Join(dir, relPath))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		errc := f.Close()
+		if errc != nil {
+			if err == nil {
+				err = errc
+			} else {
+				err = fmt.Errorf("%s (and closing %s failed: %s)", err.Error(), path, errc)
+			}
+		}
+	}()
+
+	// don't bother sending anything if we know the server won't accept it
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	if c.ServerInfo != nil && c.ServerInfo.CopyMaxSize > 0 && info.Size() > c.ServerInfo.CopyMaxSize {
+		return Error{"copy", job.key(), ErrCopyTooBig}
+	}
+
+	h := md5.New() // #nosec
+	r := io.TeeReader(f, h)
+	buf := make([]byte, copyChunkSize)
+	var offset int64
+	for {
+		n, errr := io.ReadFull(r, buf)
+		final := errr == io.EOF || errr == io.ErrUnexpectedEOF
+		if errr != nil && !final {
+			return errr
+		}
+		chunk := &fileChunk{Path: relPath, Offset: offset, Data: buf[:n], Final: final}
+		if final {
+			chunk.MD5 = fmt.Sprintf("%x", h.Sum(nil))
+		}
+		_, err = c.request(&clientRequest{Method: "copy", Job: job, Chunk: chunk})
+		if err != nil {
+			return err
+		}
+		if final {
+			break
+		}
+		offset += int64(n)
+	}
+
+	// update our process with what the server would have done
+	job.Lock()
+	defer job.Unlock()
+	rel := copyDest(job.RepGroup, job.key(), relPath)
+	for _, copied := range job.CopiedFiles {
+		if copied == rel {
+			return nil
+		}
+	}
+	job.CopiedFiles = append(job.CopiedFiles, rel)
+	return nil
 }
 
 // JobEndState is used to describe the state of a job after it has (tried to)
@@ -866,15 +1407,23 @@ func (c *Client) Touch(job *Job) (bool, error) {
 // different to the Job's Cwd property; if not, supply empty string. Always set
 // exited to true, and populate all other fields, unless you never actually
 // tried to execute the Cmd, in which case you would just provide a nil
-// JobEndState to the methods that need one.
+// JobEndState to the methods that need one. (The exception is if you decided
+// not to execute the Cmd because its Outputs were up to date, in which case set
+// Skipped and Exited to true and Archive() the job.)
 type JobEndState struct {
-	Cwd      string
-	Exitcode int
-	PeakRAM  int
-	CPUtime  time.Duration
-	Stdout   []byte
-	Stderr   []byte
-	Exited   bool
+	Cwd            string
+	Exitcode       int
+	PeakRAM        int
+	PeakDisk       int
+	CPUtime        time.Duration
+	IORead         uint64
+	IOWrite        uint64
+	Stdout         []byte
+	Stderr         []byte
+	Exited         bool
+	Skipped        bool
+	CapturedStdOut string
+	CapturedStdErr string
 }
 
 // ended updates a Job for the benefit of the client only; this has no effect on
@@ -889,10 +1438,18 @@ func (c *Client) ended(job *Job, jes *JobEndState) error {
 	job.Exited = true
 	job.Exitcode = jes.Exitcode
 	job.PeakRAM = jes.PeakRAM
+	job.PeakDisk = jes.PeakDisk
 	job.CPUtime = jes.CPUtime
+	job.IORead = jes.IORead
+	job.IOWrite = jes.IOWrite
+	job.Skipped = jes.Skipped
 	if jes.Cwd != "" {
 		job.ActualCwd = jes.Cwd
 	}
+	if jes.CapturedStdOut != "" {
+		job.CapturedStdOut = jes.CapturedStdOut
+		job.CapturedStdErr = jes.CapturedStdErr
+	}
 	var err error
 	if len(jes.Stdout) > 0 {
 		job.StdOutC, err = compress(jes.Stdout)
@@ -934,7 +1491,8 @@ func (c *Client) Archive(job *Job, jes *JobEndState) error {
 // You can only Release() the same job as many times as its Retries value if it
 // has been run and failed; a subsequent call to Release() will instead result
 // in a Bury(). (If the job's Cmd was not run, you can Release() an unlimited
-// number of times.)
+// number of times.) If the job has a RetryPolicy, it determines how long the
+// job is delayed for, and a failreason it doesn't retry results in a Bury().
 func (c *Client) Release(job *Job, jes *JobEndState, failreason string) error {
 	err := c.ended(job, jes)
 	if err != nil {
@@ -948,11 +1506,16 @@ func (c *Client) Release(job *Job, jes *JobEndState, failreason string) error {
 		return err
 	}
 
-	// update our process with what the server would have done
-	if job.Exited && job.Exitcode != 0 {
+	// update our process with what the server would have done (which counts
+	// any run of the cmd against its Retries, even if it exited 0 but failed
+	// for some other reason, such as not creating its Outputs)
+	if !job.StartTime.IsZero() {
 		job.UntilBuried--
+	}
+	if job.Exited && job.Exitcode != 0 {
 		job.updateRecsAfterFailure()
 	}
+	job.applyRetryPolicy()
 	if job.UntilBuried <= 0 {
 		job.State = JobStateBuried
 	} else {
@@ -999,10 +1562,11 @@ func (c *Client) Kick(jes []*JobEssence) (int, error) {
 	return resp.Existed, err
 }
 
-// Delete removes previously Bury()'d jobs from the queue completely. For use
-// when jobs were created incorrectly/ by accident, or they can never be fixed.
-// It returns a count of jobs that it actually removed. Errors will only be
-// related to not being able to contact the server.
+// Delete removes incomplete, not currently running jobs from the queue
+// completely. For use when jobs were created incorrectly/ by accident, or they
+// can never be fixed. Jobs that other jobs depend upon can't be removed. It
+// returns a count of jobs that it actually removed. Errors will only be related
+// to not being able to contact the server.
 func (c *Client) Delete(jes []*JobEssence) (int, error) {
 	keys := c.jesToKeys(jes)
 	resp, err := c.request(&clientRequest{Method: "jdel", Keys: keys})
@@ -1031,6 +1595,90 @@ func (c *Client) Kill(jes []*JobEssence) (int, error) {
 	return resp.Existed, err
 }
 
+// AddSchedule asks the server to add the CronSchedule's Job to the queue
+// whenever its cron expression says it should. Make the CronSchedule with
+// NewCronSchedule(). The envVars argument is as for Add(), and the Job will be
+// executed with these environment variables every time it is added.
+//
+// You will get an error if a schedule with the same name already exists (cast
+// to Error and check for Err == ErrCronExists).
+func (c *Client) AddSchedule(sc *CronSchedule, envVars []string) error {
+	compressed, err := c.CompressEnv(envVars)
+	if err != nil {
+		return err
+	}
+	sc.Env = compressed
+	_, err = c.request(&clientRequest{Method: "cadd", Schedule: sc})
+	return err
+}
+
+// GetSchedules gets all the CronSchedules that have been added, including
+// when they will next fire and a history of their recent firings.
+func (c *Client) GetSchedules() ([]*CronSchedule, error) {
+	resp, err := c.request(&clientRequest{Method: "cget"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Schedules, err
+}
+
+// RemoveSchedules stops the named CronSchedules from firing in the future.
+// Jobs they already added to the queue are unaffected. It returns a count of
+// schedules that were removed.
+func (c *Client) RemoveSchedules(names []string) (int, error) {
+	resp, err := c.request(&clientRequest{Method: "cdel", Keys: names})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Existed, err
+}
+
+// AddSubscription asks the server to POST Notifications about the events the
+// Subscription is interested in to its URL. Make the Subscription with
+// NewSubscription().
+//
+// You will get an error if a subscription with the same name already exists
+// (cast to Error and check for Err == ErrNotifyExists).
+func (c *Client) AddSubscription(sub *Subscription) error {
+	_, err := c.request(&clientRequest{Method: "nadd", Subscription: sub})
+	return err
+}
+
+// GetSubscriptions gets all the Subscriptions that have been added, including
+// how many of their POSTs succeeded and failed.
+func (c *Client) GetSubscriptions() ([]*Subscription, error) {
+	resp, err := c.request(&clientRequest{Method: "nget"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Subs, err
+}
+
+// RemoveSubscriptions stops the named Subscriptions from being notified in the
+// future. It returns a count of subscriptions that were removed.
+func (c *Client) RemoveSubscriptions(names []string) (int, error) {
+	resp, err := c.request(&clientRequest{Method: "ndel", Keys: names})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Existed, err
+}
+
+// Modify changes the properties of incomplete, non-running jobs according to
+// the supplied JobModifier. For example, you could increase the memory
+// requirement of jobs that were buried because they ran out of memory, before
+// Kick()ing them. It returns a count of jobs that were actually modified.
+// Properties that make up a job's key (Cmd, Cwd, CwdMatters and MountConfigs)
+// can't be changed.
+func (c *Client) Modify(jes []*JobEssence, jm *JobModifier) (int, error) {
+	keys := c.jesToKeys(jes)
+	resp, err := c.request(&clientRequest{Method: "jmod", Keys: keys, Modifier: jm})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Existed, err
+}
+
 // GetByEssence gets a Job given a JobEssence to describe it. With the boolean
 // args set to true, this is the only way to get a Job that StdOut() and
 // StdErr() will work on, and one of 2 ways that Env() will work (the other
@@ -1058,8 +1706,21 @@ func (c *Client) GetByEssences(jes []*JobEssence) ([]*Job, error) {
 	return resp.Jobs, err
 }
 
-// jesToKeys deals with the jes arg that GetByEccences(), Kick() and Delete()
-// take.
+// GetCapturedStd gets up to length bytes of the complete STDOUT (or STDERR if
+// stderr is true) of the given Job's Cmd, starting offset bytes in. This only
+// works for Jobs that had StdCapture set and that have run; you will get back
+// fewer bytes than requested once you reach the end of the output. A length of
+// 0 or less gets you as much as the server is willing to return at once (1MB).
+func (c *Client) GetCapturedStd(job *Job, stderr bool, offset int64, length int) ([]byte, error) {
+	resp, err := c.request(&clientRequest{Method: "getstd", Keys: []string{job.key()}, Stderr: stderr, Offset: offset, Limit: length})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Std, err
+}
+
+// jesToKeys deals with the jes arg that GetByEccences(), Kick(), Delete(),
+// Kill() and Modify() take.
 func (c *Client) jesToKeys(jes []*JobEssence) []string {
 	var keys []string
 	for _, je := range jes {
@@ -1084,6 +1745,76 @@ func (c *Client) GetByRepGroup(repgroup string, limit int, state JobState, getSt
 	return resp.Jobs, err
 }
 
+// GetByRepGroupPage is like GetByRepGroup() with a limit of 0, but only gets
+// up to pageSize of the Jobs, after skipping the first offset of them. By
+// calling it with increasing offsets until you get fewer than pageSize Jobs,
+// you can get every Job in a huge RepGroup without you or the server having to
+// hold all of them in memory at once. Incomplete Jobs come first, followed by
+// complete ones, each ordered by key; Jobs that change state while you page
+// through them may be missed or repeated.
+func (c *Client) GetByRepGroupPage(repgroup string, state JobState, offset int, pageSize int, getStd bool, getEnv bool) ([]*Job, error) {
+	resp, err := c.request(&clientRequest{Method: "getbr", Job: &Job{RepGroup: repgroup}, State: state, Offset: int64(offset), PageSize: pageSize, GetStd: getStd, GetEnv: getEnv})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Jobs, err
+}
+
+// History searches the permanent history of jobs that have finished running
+// (successfully or not), returning a Job for each run that matches the given
+// query, most recently ended first. The returned Jobs are as they were when
+// that run ended, with the details (Exitcode, FailReason, Host, EndTime etc.)
+// of that particular run.
+func (c *Client) History(q *HistoryQuery) ([]*Job, error) {
+	resp, err := c.request(&clientRequest{Method: "gethist", History: q})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Jobs, err
+}
+
+// SetLimitGroup creates a LimitGroup, so that no more than its Max Jobs with
+// its Name in their LimitGroups will run at once, with at least its Delay
+// between the starts of each of them. If a LimitGroup with the same Name
+// already exists, its limits are changed instead; Jobs that are already
+// running are unaffected.
+func (c *Client) SetLimitGroup(lg *LimitGroup) error {
+	_, err := c.request(&clientRequest{Method: "lset", LimitGroup: lg})
+	return err
+}
+
+// GetLimitGroups gets all the LimitGroups that have been set, including how
+// many of their Jobs are currently running.
+func (c *Client) GetLimitGroups() ([]*LimitGroup, error) {
+	resp, err := c.request(&clientRequest{Method: "lget"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.LimitGroups, err
+}
+
+// RemoveLimitGroups removes the named LimitGroups, so that Jobs in those groups
+// are no longer limited. It returns a count of LimitGroups that were removed.
+func (c *Client) RemoveLimitGroups(names []string) (int, error) {
+	resp, err := c.request(&clientRequest{Method: "ldel", Keys: names})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Existed, err
+}
+
+// GetGraph gets the dependency graph of the Jobs (current and complete) with
+// the given RepGroup, including all the Jobs upstream of them, even if those
+// have a different RepGroup. Use the graph's DOT() method to get a version
+// suitable for rendering with Graphviz.
+func (c *Client) GetGraph(repgroup string) (*JobGraph, error) {
+	resp, err := c.request(&clientRequest{Method: "getgraph", Job: &Job{RepGroup: repgroup}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Graph, err
+}
+
 // GetIncomplete gets all Jobs that are currently in the jobqueue, ie. excluding
 // those that are complete and have been Archive()d. The args are as in
 // GetByRepGroup().
@@ -1095,6 +1826,16 @@ func (c *Client) GetIncomplete(limit int, state JobState, getStd bool, getEnv bo
 	return resp.Jobs, err
 }
 
+// GetIncompletePage is like GetIncomplete() with a limit of 0, but gets the
+// Jobs a page at a time, in key order, like GetByRepGroupPage().
+func (c *Client) GetIncompletePage(state JobState, offset int, pageSize int, getStd bool, getEnv bool) ([]*Job, error) {
+	resp, err := c.request(&clientRequest{Method: "getin", State: state, Offset: int64(offset), PageSize: pageSize, GetStd: getStd, GetEnv: getEnv})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Jobs, err
+}
+
 // request the server do something and get back its response. We can only cope
 // with one request at a time per client, or we'll get replies back in the
 // wrong order, hence we lock.
@@ -1105,6 +1846,7 @@ func (c *Client) request(cr *clientRequest) (*serverResponse, error) {
 	// encode and send the request
 	var encoded []byte
 	enc := codec.NewEncoderBytes(&encoded, c.ch)
+	cr.Token = c.token
 	cr.User = c.user
 	cr.ClientID = c.clientid
 	err := enc.Encode(cr)
@@ -0,0 +1,183 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for copying files from a Job's working
+// directory back to the manager, as done by CopyToManager Behaviours.
+
+import (
+	"crypto/md5" // #nosec - only used to detect transfer errors
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/VertebrateResequencing/wr/internal"
+)
+
+const (
+	// copyChunkSize is the maximum number of bytes of a file that are sent to
+	// the server in a single request.
+	copyChunkSize = 1024 * 1024
+
+	// copyDefaultMaxSize is the default maximum size of a file that can be
+	// copied to the server.
+	copyDefaultMaxSize int64 = 100 * 1024 * 1024
+
+	// copyTmpSuffix is appended to the path of files as they are being
+	// received, before they are known to be complete.
+	copyTmpSuffix = ".wr_copying"
+)
+
+// fileChunk is sent by clients to the server to copy part of a file. Chunks
+// must be sent in order, and the Final one must include the MD5 checksum of
+// the whole file, which the server will check before making the file
+// available.
+type fileChunk struct {
+	Path   string // relative to the Job's actual working directory
+	Offset int64
+	Data   []byte
+	Final  bool
+	MD5    string
+}
+
+// copyRelPath cleans the given path and confirms it is a relative path that
+// doesn't refer to anything outside of the directory it is relative to.
+func copyRelPath(path string) (string, error) {
+	clean := filepath.Clean(path)
+	if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not a relative path within the working directory", path)
+	}
+	return clean, nil
+}
+
+// copyDest returns the path, relative to the server's CopyDir, that the given
+// relative path of a file from the given Job should be stored at. Each
+// RepGroup gets its own directory, with a sub-directory per Job.
+func copyDest(rg string, key string, relPath string) string {
+	rg = strings.Replace(rg, string(filepath.Separator), "_", -1)
+	if rg == "" || rg == "." || rg == ".." {
+		rg = "_" + rg
+	}
+	return filepath.Join(rg, key, relPath)
+}
+
+// receiveChunk is used by the server to store a fileChunk sent by the client
+// running the given Job. Chunks are appended to a temporary file, and once the
+// final chunk has arrived and the checksum matches, the file is moved to its
+// final location in our CopyDir and recorded in the Job's CopiedFiles. It
+// returns one of our Err* constants and a more detailed error message if
+// something went wrong.
+func (s *Server) receiveChunk(job *Job, chunk *fileChunk) (string, string) {
+	relPath, err := copyRelPath(chunk.Path)
+	if err != nil {
+		return ErrBadRequest, err.Error()
+	}
+	if chunk.Offset < 0 {
+		return ErrBadRequest, fmt.Sprintf("bad offset %d for %s", chunk.Offset, chunk.Path)
+	}
+	if chunk.Offset+int64(len(chunk.Data)) > s.ServerInfo.CopyMaxSize {
+		return ErrCopyTooBig, fmt.Sprintf("%s is bigger than %d bytes", chunk.Path, s.ServerInfo.CopyMaxSize)
+	}
+
+	job.RLock()
+	rel := copyDest(job.RepGroup, job.key(), relPath)
+	job.RUnlock()
+	dest := filepath.Join(s.ServerInfo.CopyDir, rel)
+	tmp := dest + copyTmpSuffix
+	err = os.MkdirAll(filepath.Dir(dest), os.ModePerm)
+	if err != nil {
+		return ErrCopyFailed, err.Error()
+	}
+
+	// append this chunk to what we've received so far, starting afresh on
+	// the first chunk
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if chunk.Offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(tmp, flags, 0666)
+	if err != nil {
+		return ErrCopyFailed, err.Error()
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() != chunk.Offset {
+		err = fmt.Errorf("chunk of %s at offset %d arrived when we had %d bytes", chunk.Path, chunk.Offset, info.Size())
+	}
+	if err == nil {
+		_, err = f.Write(chunk.Data)
+	}
+	errc := f.Close()
+	if err == nil {
+		err = errc
+	}
+	if err != nil {
+		s.removeCopyTmp(tmp)
+		return ErrCopyFailed, err.Error()
+	}
+	if !chunk.Final {
+		return "", ""
+	}
+
+	// confirm we got the file intact before making it available
+	sum, err := s.fileMD5(tmp)
+	if err == nil && sum != chunk.MD5 {
+		err = fmt.Errorf("checksum of %s was %s, not %s", chunk.Path, sum, chunk.MD5)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		s.removeCopyTmp(tmp)
+		return ErrCopyFailed, err.Error()
+	}
+
+	job.Lock()
+	defer job.Unlock()
+	for _, path := range job.CopiedFiles {
+		if path == rel {
+			return "", ""
+		}
+	}
+	job.CopiedFiles = append(job.CopiedFiles, rel)
+	return "", ""
+}
+
+// removeCopyTmp removes a partially received file, logging any failure.
+func (s *Server) removeCopyTmp(path string) {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		s.Warn("failed to remove partially copied file", "path", path, "err", err)
+	}
+}
+
+// fileMD5 returns the hex encoded MD5 checksum of the given file's contents.
+func (s *Server) fileMD5(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer internal.LogClose(s.Logger, f, "copied file", "path", path)
+	h := md5.New() // #nosec
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
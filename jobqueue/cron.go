@@ -0,0 +1,611 @@
+// Copyright © 2016-2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for recurring jobs: CronSchedules that the server
+// uses to add Jobs to the queue at the times described by cron expressions.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VertebrateResequencing/wr/internal"
+	"github.com/VertebrateResequencing/wr/queue"
+	"github.com/ugorji/go/codec"
+)
+
+// CronOverlap* constants are the policies a CronSchedule can have for what to
+// do when it fires while the Job it added last time is still incomplete.
+// CronOverlapSkip does nothing, CronOverlapQueue adds the Job again once the
+// previous one has completed (or been removed), and CronOverlapKill kills (or
+// removes, if not running) the previous one and adds the Job again as soon as
+// possible.
+const (
+	CronOverlapSkip  CronOverlap = "skip"
+	CronOverlapQueue CronOverlap = "queue"
+	CronOverlapKill  CronOverlap = "kill"
+)
+
+// CronFiring* constants are the possible outcomes of a CronSchedule firing,
+// found in CronFiring.Outcome. CronFiringAdded means the schedule's Job was
+// added to the queue, CronFiringSkipped that nothing was done because the
+// previous Job was still incomplete, CronFiringQueued that the Job will be
+// added once the previous one is complete, CronFiringKilled that the previous
+// Job was killed and the Job will be added once it is dead, CronFiringReplaced
+// that the previous (not running) Job was removed and the Job added in its
+// place, and CronFiringFailed that something went wrong (see CronFiring.Err).
+const (
+	CronFiringAdded    = "added"
+	CronFiringSkipped  = "skipped"
+	CronFiringQueued   = "queued"
+	CronFiringKilled   = "killed previous"
+	CronFiringReplaced = "replaced previous"
+	CronFiringFailed   = "failed"
+)
+
+// these global variables are primarily exported for testing purposes; you
+// probably shouldn't change them
+var (
+	// ServerCronTicker is how often the server checks if any CronSchedules
+	// are due to fire.
+	ServerCronTicker = 10 * time.Second
+
+	// CronHistoryLimit is the maximum number of CronFirings a CronSchedule
+	// remembers; older ones are forgotten.
+	CronHistoryLimit = 100
+)
+
+// CronOverlap describes what a CronSchedule should do if it fires while the
+// Job it previously added is still incomplete.
+type CronOverlap string
+
+// CronFiring records what happened when a CronSchedule fired.
+type CronFiring struct {
+	Time    time.Time
+	Outcome string // one of the CronFiring* constants
+	JobKey  string // the key of the Job that was added, or would have been
+	Err     string // only set if Outcome is CronFiringFailed
+}
+
+// CronSchedule describes a Job that the server should add to the queue
+// repeatedly, at the times described by a cron expression.
+type CronSchedule struct {
+	// Name uniquely identifies the schedule.
+	Name string
+
+	// Spec is a standard 5 field cron expression (minute, hour, day of month,
+	// month, day of week), where each field can be *, a number, a range like
+	// 1-5, a comma separated list of these, and a step like */15 or 0-30/10.
+	// Month and day of week fields also accept 3 letter names like jan or
+	// mon. Instead of 5 fields you can use one of @yearly, @monthly,
+	// @weekly, @daily or @hourly. Times are interpreted in the server's local
+	// time zone.
+	Spec string
+
+	// Overlap is the policy for what to do when the schedule fires but the
+	// Job it added last time is still incomplete. Defaults to
+	// CronOverlapSkip.
+	Overlap CronOverlap
+
+	// Job is the Job to add to the queue each time the schedule fires. It
+	// can't be a job array template.
+	Job *Job
+
+	// Env is the compressed environment Job's Cmd will run with, as set by
+	// Client.AddSchedule().
+	Env []byte
+
+	// The remaining properties are set by the server. Created is when the
+	// schedule was added, Next is when it will next fire, Pending is the
+	// number of firings waiting to add Job because of the Overlap policy,
+	// and History is the most recent firings (up to CronHistoryLimit of
+	// them), oldest first.
+	Created time.Time
+	Next    time.Time
+	Pending int
+	History []*CronFiring
+
+	cron *cronSpec
+}
+
+// NewCronSchedule creates a CronSchedule, checking that spec is a valid cron
+// expression (see CronSchedule.Spec) and that job is suitable for scheduling.
+// Pass the result to Client.AddSchedule() to have it take effect.
+func NewCronSchedule(name string, spec string, overlap CronOverlap, job *Job) (*CronSchedule, error) {
+	sc := &CronSchedule{Name: name, Spec: spec, Overlap: overlap, Job: job}
+	if sc.Overlap == "" {
+		sc.Overlap = CronOverlapSkip
+	}
+	return sc, sc.validate()
+}
+
+// validate checks our properties make sense, and parses our Spec.
+func (sc *CronSchedule) validate() error {
+	if sc.Name == "" {
+		return fmt.Errorf("cron schedules must have a name")
+	}
+	switch sc.Overlap {
+	case CronOverlapSkip, CronOverlapQueue, CronOverlapKill:
+	default:
+		return fmt.Errorf("cron schedule overlap policy must be one of %s, %s or %s", CronOverlapSkip, CronOverlapQueue, CronOverlapKill)
+	}
+	if sc.Job == nil || sc.Job.Cmd == "" {
+		return fmt.Errorf("cron schedule %s has no cmd", sc.Name)
+	}
+	if len(sc.Job.ArrayParams) > 0 {
+		return fmt.Errorf("cron schedule %s can't be for a job array", sc.Name)
+	}
+	cs, err := parseCronSpec(sc.Spec)
+	if err != nil {
+		return err
+	}
+	if cs.next(time.Now()).IsZero() {
+		return fmt.Errorf("cron expression [%s] never fires", sc.Spec)
+	}
+	sc.cron = cs
+	return nil
+}
+
+// recordFiring adds a CronFiring to our History, forgetting the oldest if we
+// have more than CronHistoryLimit.
+func (sc *CronSchedule) recordFiring(cf *CronFiring) {
+	sc.History = append(sc.History, cf)
+	if len(sc.History) > CronHistoryLimit {
+		sc.History = sc.History[len(sc.History)-CronHistoryLimit:]
+	}
+}
+
+// LastFiring returns the most recent CronFiring, or nil if the schedule has
+// never fired.
+func (sc *CronSchedule) LastFiring() *CronFiring {
+	if len(sc.History) == 0 {
+		return nil
+	}
+	return sc.History[len(sc.History)-1]
+}
+
+// copy returns a copy of this CronSchedule that won't be affected by future
+// firings.
+func (sc *CronSchedule) copy() *CronSchedule {
+	c := *sc
+	c.History = make([]*CronFiring, len(sc.History))
+	for i, cf := range sc.History {
+		cfc := *cf
+		c.History[i] = &cfc
+	}
+	return &c
+}
+
+// cronSpec is a parsed cron expression, with the allowed values of each field
+// stored as bits.
+type cronSpec struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+// cronField describes the allowed values of one of the fields of a cron
+// expression.
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+// cronFields are the fields of a cron expression, in order. Day of week allows
+// 7 as well as 0 to mean Sunday.
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}},
+	{name: "day of week", min: 0, max: 7, names: map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}},
+}
+
+// cronDescriptors are the shorthands we understand in place of a 5 field
+// cron expression.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCronSpec parses a cron expression, as described for CronSchedule.Spec.
+func parseCronSpec(spec string) (*cronSpec, error) {
+	expr := spec
+	if expanded, exists := cronDescriptors[strings.ToLower(strings.TrimSpace(spec))]; exists {
+		expr = expanded
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression [%s] must have 5 fields: minute hour day-of-month month day-of-week", spec)
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		b, err := cronFields[i].parse(field)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression [%s] is invalid: %s", spec, err)
+		}
+		bits[i] = b
+	}
+
+	cs := &cronSpec{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	if cs.dow&(1<<7) != 0 {
+		cs.dow |= 1
+		cs.dow &^= 1 << 7
+	}
+	return cs, nil
+}
+
+// parse parses one field of a cron expression, returning the allowed values as
+// bits.
+func (cf cronField) parse(field string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		hasStep := false
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("%s has a bad step [%s]", cf.name, part)
+			}
+			part = part[:i]
+			hasStep = true
+		}
+
+		var start, end int
+		var err error
+		switch {
+		case part == "*":
+			start, end = cf.min, cf.max
+		case strings.Contains(part, "-"):
+			ends := strings.SplitN(part, "-", 2)
+			start, err = cf.value(ends[0])
+			if err != nil {
+				return 0, err
+			}
+			end, err = cf.value(ends[1])
+			if err != nil {
+				return 0, err
+			}
+			if end < start {
+				return 0, fmt.Errorf("%s has a backwards range [%s]", cf.name, part)
+			}
+		default:
+			start, err = cf.value(part)
+			if err != nil {
+				return 0, err
+			}
+			end = start
+			if hasStep {
+				end = cf.max
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value converts a single number or name in a field of a cron expression in
+// to a number, checking it is in range.
+func (cf cronField) value(str string) (int, error) {
+	if v, exists := cf.names[strings.ToLower(str)]; exists {
+		return v, nil
+	}
+	v, err := strconv.Atoi(str)
+	if err != nil || v < cf.min || v > cf.max {
+		return 0, fmt.Errorf("%s value [%s] is not a number between %d and %d", cf.name, str, cf.min, cf.max)
+	}
+	return v, nil
+}
+
+// next returns the first time after the given time that matches this
+// cronSpec, to a resolution of minutes. Returns the zero time if there is no
+// such time in the next 5 years (eg. for the 30th of February).
+func (cs *cronSpec) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if cs.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !cs.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if cs.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if cs.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches tells you if the day of the given time matches our day of month
+// and day of week fields. As is standard for cron, if both fields are
+// restricted (don't start with *), the day only has to match one of them.
+func (cs *cronSpec) dayMatches(t time.Time) bool {
+	domMatch := cs.dom&(1<<uint(t.Day())) != 0
+	dowMatch := cs.dow&(1<<uint(t.Weekday())) != 0
+	if cs.domStar || cs.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// loadCronSchedules gets our CronSchedules from the database and works out
+// when they will next fire. Any firings missed while the server was down are
+// not made up for.
+func (s *Server) loadCronSchedules() error {
+	schedules, err := s.db.retrieveCronSchedules()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	s.cronmutex.Lock()
+	defer s.cronmutex.Unlock()
+	for _, sc := range schedules {
+		err = sc.validate()
+		if err != nil {
+			s.Warn("stored cron schedule is invalid", "name", sc.Name, "err", err)
+			continue
+		}
+		sc.Next = sc.cron.next(now)
+		s.cronSchedules[sc.Name] = sc
+	}
+	return nil
+}
+
+// cronLoop checks our CronSchedules every ServerCronTicker, firing any that
+// are due, until stopCron is closed.
+func (s *Server) cronLoop() {
+	defer internal.LogPanic(s.Logger, "jobqueue cron", true)
+	ticker := time.NewTicker(ServerCronTicker)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.checkCronSchedules(now)
+		case <-s.stopCron:
+			return
+		}
+	}
+}
+
+// addCronSchedule validates and stores a new CronSchedule, which will first
+// fire at the next time matching its Spec. It is an error to add a schedule
+// with the same name as an existing one.
+func (s *Server) addCronSchedule(sc *CronSchedule) (srerr string, qerr error) {
+	err := sc.validate()
+	if err != nil {
+		return ErrBadRequest, err
+	}
+
+	s.cronmutex.Lock()
+	defer s.cronmutex.Unlock()
+	if _, exists := s.cronSchedules[sc.Name]; exists {
+		return ErrCronExists, fmt.Errorf("cron schedule %s already exists", sc.Name)
+	}
+	sc.Created = time.Now()
+	sc.Next = sc.cron.next(sc.Created)
+	sc.Pending = 0
+	sc.History = nil
+	err = s.db.storeCronSchedule(sc)
+	if err != nil {
+		return ErrDBError, err
+	}
+	s.cronSchedules[sc.Name] = sc
+	s.Debug("added cron schedule", "name", sc.Name, "spec", sc.Spec, "next", sc.Next)
+	return "", nil
+}
+
+// removeCronSchedule removes the named CronSchedule, returning false if it
+// didn't exist. Jobs it already added are not affected.
+func (s *Server) removeCronSchedule(name string) bool {
+	s.cronmutex.Lock()
+	defer s.cronmutex.Unlock()
+	if _, exists := s.cronSchedules[name]; !exists {
+		return false
+	}
+	delete(s.cronSchedules, name)
+	s.db.deleteCronSchedule(name)
+	s.Debug("removed cron schedule", "name", name)
+	return true
+}
+
+// getCronSchedules returns copies of all our CronSchedules, sorted by name.
+func (s *Server) getCronSchedules() []*CronSchedule {
+	s.cronmutex.Lock()
+	defer s.cronmutex.Unlock()
+	schedules := make([]*CronSchedule, 0, len(s.cronSchedules))
+	for _, sc := range s.cronSchedules {
+		schedules = append(schedules, sc.copy())
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Name < schedules[j].Name
+	})
+	return schedules
+}
+
+// checkCronSchedules fires any CronSchedules that were due at or before the
+// given time, and adds the Jobs of any with pending firings whose previous Job
+// is no longer in the way.
+func (s *Server) checkCronSchedules(now time.Time) {
+	s.ssmutex.RLock()
+	up := s.up
+	s.ssmutex.RUnlock()
+	if !up {
+		return
+	}
+
+	s.cronmutex.Lock()
+	defer s.cronmutex.Unlock()
+	for _, sc := range s.cronSchedules {
+		changed := false
+		if sc.Pending > 0 {
+			changed = s.addPendingCronJob(sc, now)
+		}
+		if !now.Before(sc.Next) {
+			s.fireCronSchedule(sc, now)
+			sc.Next = sc.cron.next(now)
+			changed = true
+		}
+		if changed {
+			err := s.db.storeCronSchedule(sc)
+			if err != nil {
+				s.Warn("failed to store cron schedule", "name", sc.Name, "err", err)
+			}
+		}
+	}
+}
+
+// fireCronSchedule adds the schedule's Job to the queue, or applies the
+// schedule's Overlap policy if the Job it previously added is still
+// incomplete. You must hold the cronmutex lock.
+func (s *Server) fireCronSchedule(sc *CronSchedule, now time.Time) {
+	key := sc.Job.key()
+	cf := &CronFiring{Time: now, JobKey: key}
+	defer sc.recordFiring(cf)
+
+	item, err := s.q.Get(key)
+	if err != nil || item == nil || item.Stats().State == queue.ItemStateRemoved {
+		s.addCronJob(sc, cf, CronFiringAdded)
+		return
+	}
+
+	switch sc.Overlap {
+	case CronOverlapQueue:
+		sc.Pending++
+		cf.Outcome = CronFiringQueued
+	case CronOverlapKill:
+		if item.Stats().State == queue.ItemStateRun {
+			_, err = s.killJob(key)
+			if err != nil {
+				cf.Outcome = CronFiringFailed
+				cf.Err = err.Error()
+				return
+			}
+			sc.Pending = 1
+			cf.Outcome = CronFiringKilled
+			return
+		}
+		if !s.deleteJob(key) {
+			cf.Outcome = CronFiringFailed
+			cf.Err = "the previous job could not be removed (does something depend on it?)"
+			return
+		}
+		s.addCronJob(sc, cf, CronFiringReplaced)
+	default:
+		cf.Outcome = CronFiringSkipped
+	}
+}
+
+// addPendingCronJob adds the schedule's Job to the queue if a previous firing
+// was queued or killed the previous Job and that Job is no longer in the way,
+// recording this as a new CronFiring. Returns true if anything was done. You
+// must hold the cronmutex lock.
+func (s *Server) addPendingCronJob(sc *CronSchedule, now time.Time) bool {
+	key := sc.Job.key()
+	item, err := s.q.Get(key)
+	if err == nil && item != nil {
+		state := item.Stats().State
+		if state != queue.ItemStateRemoved {
+			// a killed job ends up buried; remove it so we can add it again
+			if sc.Overlap != CronOverlapKill || state != queue.ItemStateBury || !s.deleteJob(key) {
+				return false
+			}
+		}
+	}
+
+	sc.Pending--
+	if sc.Overlap == CronOverlapKill {
+		sc.Pending = 0
+	}
+	cf := &CronFiring{Time: now, JobKey: key}
+	s.addCronJob(sc, cf, CronFiringAdded)
+	sc.recordFiring(cf)
+	return true
+}
+
+// addCronJob adds a copy of the schedule's Job to the queue, setting the
+// firing's Outcome to the given outcome, or to CronFiringFailed if there was
+// a problem.
+func (s *Server) addCronJob(sc *CronSchedule, cf *CronFiring, outcome string) {
+	job, err := s.copyCronJob(sc)
+	if err == nil {
+		var envkey string
+		envkey, err = s.db.storeEnv(sc.Env)
+		if err == nil {
+			_, _, _, _, err = s.createJobs([]*Job{job}, envkey, false)
+		}
+	}
+	if err != nil {
+		cf.Outcome = CronFiringFailed
+		cf.Err = err.Error()
+		s.Warn("cron schedule failed to add its job", "name", sc.Name, "err", err)
+		return
+	}
+	cf.Outcome = outcome
+}
+
+// copyCronJob makes a fresh copy of the schedule's Job, since createJobs()
+// alters the Jobs it is given.
+func (s *Server) copyCronJob(sc *CronSchedule) (*Job, error) {
+	var encoded []byte
+	enc := codec.NewEncoderBytes(&encoded, s.ch)
+	err := enc.Encode(sc.Job)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{}
+	dec := codec.NewDecoderBytes(encoded, s.ch)
+	err = dec.Decode(job)
+	return job, err
+}
@@ -47,8 +47,10 @@ import (
 const (
 	dbDelimiter               = "_::_"
 	jobStatWindowPercent      = float32(5)
+	jobStatCoresScale         = 100
 	dbFilePermission          = 0600
 	minimumTimeBetweenBackups = 30 * time.Second
+	compactTxSize             = 10000
 )
 
 var (
@@ -62,6 +64,15 @@ var (
 	bucketStdE         = []byte("stde")
 	bucketJobMBs       = []byte("jobMBs")
 	bucketJobSecs      = []byte("jobSecs")
+	bucketJobDisk      = []byte("jobDisk")
+	bucketJobCores     = []byte("jobCores")
+	bucketCron         = []byte("cron")
+	bucketLimits       = []byte("limits")
+	bucketNotify       = []byte("notify")
+	bucketHistory      = []byte("history")
+	bucketHTH          = []byte("hostToHistory")
+	bucketXTH          = []byte("exitcodeToHistory")
+	bucketFTH          = []byte("failreasonToHistory")
 	wipeDevDBOnInit    = true
 	forceBackups       = false
 )
@@ -69,8 +80,9 @@ var (
 // Rec* variables are only exported for testing purposes (*** though they should
 // probably be user configurable somewhere...).
 var (
-	RecMBRound  = 100  // when we recommend amount of memory to reserve for a job, we round up to the nearest RecMBRound MBs
-	RecSecRound = 1800 // when we recommend time to reserve for a job, we round up to the nearest RecSecRound seconds
+	RecMBRound   = 100  // when we recommend amount of memory to reserve for a job, we round up to the nearest RecMBRound MBs
+	RecSecRound  = 1800 // when we recommend time to reserve for a job, we round up to the nearest RecSecRound seconds
+	RecDiskRound = 1024 // when we recommend disk space to reserve for a job, we round up to the nearest RecDiskRound MBs (before converting to GB)
 )
 
 // sobsd ('slice of byte slice doublets') implements sort interface so we can
@@ -95,6 +107,8 @@ type sobsdStorer func(bucket []byte, encodes sobsd) (err error)
 
 type db struct {
 	backingUp          bool
+	backupDuration     time.Duration
+	backupFailed       bool
 	backupFinal        bool
 	backupStopWait     chan bool
 	backupLast         time.Time
@@ -102,9 +116,13 @@ type db struct {
 	backupNotification chan bool
 	backupPath         string
 	backupQueued       bool
+	backupSucceeded    time.Time
 	backupWait         time.Duration
 	backupsEnabled     bool
 	bolt               *bolt.DB
+	boltMutex          sync.RWMutex // stops compact() swapping bolt during use
+	changes            *changeLog
+	storeMutex         sync.RWMutex // stops prune() running during stores
 	ch                 codec.Handle
 	closed             bool
 	envcache           *lru.ARCCache
@@ -123,9 +141,9 @@ type db struct {
 // which will cause that s3 path to be mounted in the same directory as dbFile
 // and backups will be written there.
 //
-// In development we delete any existing db and force a fresh start. Backups
-// are also not carried out, so dbBkFile is ignored.
-func initDB(dbFile string, dbBkFile string, deployment string, logger log15.Logger) (*db, string, error) {
+// In development we delete any existing db and force a fresh start, unless keep
+// is true. Backups are also not carried out, so dbBkFile is ignored.
+func initDB(dbFile string, dbBkFile string, deployment string, keep bool, logger log15.Logger) (*db, string, error) {
 	l := logger.New()
 
 	var backupsEnabled bool
@@ -178,7 +196,7 @@ func initDB(dbFile string, dbBkFile string, deployment string, logger log15.Logg
 		}
 	}
 
-	if wipeDevDBOnInit && deployment == internal.Development {
+	if wipeDevDBOnInit && deployment == internal.Development && !keep {
 		errr := os.Remove(dbFile)
 		if errr != nil && !os.IsNotExist(errr) {
 			l.Warn("Failed to remove database file", "path", dbFile, "err", errr)
@@ -273,6 +291,41 @@ func initDB(dbFile string, dbBkFile string, deployment string, logger log15.Logg
 		if errf != nil {
 			return fmt.Errorf("create bucket %s: %s", bucketJobSecs, errf)
 		}
+		_, errf = tx.CreateBucketIfNotExists(bucketJobDisk)
+		if errf != nil {
+			return fmt.Errorf("create bucket %s: %s", bucketJobDisk, errf)
+		}
+		_, errf = tx.CreateBucketIfNotExists(bucketJobCores)
+		if errf != nil {
+			return fmt.Errorf("create bucket %s: %s", bucketJobCores, errf)
+		}
+		_, errf = tx.CreateBucketIfNotExists(bucketCron)
+		if errf != nil {
+			return fmt.Errorf("create bucket %s: %s", bucketCron, errf)
+		}
+		_, errf = tx.CreateBucketIfNotExists(bucketLimits)
+		if errf != nil {
+			return fmt.Errorf("create bucket %s: %s", bucketLimits, errf)
+		}
+		_, errf = tx.CreateBucketIfNotExists(bucketNotify)
+		if errf != nil {
+			return fmt.Errorf("create bucket %s: %s", bucketNotify, errf)
+		}
+		newHistory := tx.Bucket(bucketHistory) == nil
+		for _, bucket := range [][]byte{bucketHistory, bucketHTH, bucketXTH, bucketFTH} {
+			_, errf = tx.CreateBucketIfNotExists(bucket)
+			if errf != nil {
+				return fmt.Errorf("create bucket %s: %s", bucket, errf)
+			}
+		}
+		if newHistory {
+			// this database predates our keeping a history, so start it off
+			// with the jobs that have already completed
+			errf = historyFromComplete(tx, new(codec.BincHandle))
+			if errf != nil {
+				return fmt.Errorf("populate bucket %s: %s", bucketHistory, errf)
+			}
+		}
 		return nil
 	})
 	if err != nil {
@@ -287,6 +340,7 @@ func initDB(dbFile string, dbBkFile string, deployment string, logger log15.Logg
 
 	dbstruct := &db{
 		bolt:               boltdb,
+		changes:            newChangeLog(),
 		envcache:           envcache,
 		ch:                 new(codec.BincHandle),
 		backupsEnabled:     backupsEnabled,
@@ -325,6 +379,9 @@ func initDB(dbFile string, dbBkFile string, deployment string, logger log15.Logg
 //
 // Finally, it triggers a background database backup.
 func (db *db) storeNewJobs(jobs []*Job, ignoreAdded bool) (jobsToQueue []*Job, jobsToUpdate []*Job, alreadyAdded int, err error) {
+	db.storeMutex.RLock()
+	defer db.storeMutex.RUnlock()
+
 	// turn the jobs in to sobsd and sort by their keys, likewise for the
 	// lookups
 	var encodedJobs sobsd
@@ -495,7 +552,7 @@ func (db *db) generateLookupKey(prefix string, jobKey []byte) []byte {
 // bucket.
 func (db *db) checkIfLive(key string) (bool, error) {
 	var isLive bool
-	err := db.bolt.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		newJobBucket := tx.Bucket(bucketJobsLive)
 		if newJobBucket.Get([]byte(key)) != nil {
 			isLive = true
@@ -509,7 +566,7 @@ func (db *db) checkIfLive(key string) (bool, error) {
 // complete bucket or the live bucket.
 func (db *db) checkIfAdded(key string) (bool, error) {
 	var isInDB bool
-	err := db.bolt.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		newJobBucket := tx.Bucket(bucketJobsLive)
 		completeJobBucket := tx.Bucket(bucketJobsComplete)
 		if newJobBucket.Get([]byte(key)) != nil || completeJobBucket.Get([]byte(key)) != nil {
@@ -528,19 +585,23 @@ func (db *db) checkIfAdded(key string) (bool, error) {
 //
 // The key you supply must be the key of the job you supply, or bad things will
 // happen - no checking is done! A backgroundBackup() is triggered afterwards.
+//
+// The job is also added to the history, as with updateJobAfterExit.
 func (db *db) archiveJob(key string, job *Job) error {
 	var encoded []byte
 	enc := codec.NewEncoderBytes(&encoded, db.ch)
 	job.RLock()
 	err := enc.Encode(job)
+	hr := newHistoryRecord(key, job)
+	js := newJobStats(job)
 	job.RUnlock()
 	if err != nil {
 		return err
 	}
 
-	err = db.bolt.Batch(func(tx *bolt.Tx) error {
-		bo := tx.Bucket(bucketStdO)
-		be := tx.Bucket(bucketStdE)
+	err = db.batch(func(tx *bolt.Tx) error {
+		bo := db.changes.bucket(tx, bucketStdO)
+		be := db.changes.bucket(tx, bucketStdE)
 		key := []byte(key)
 		errf := bo.Delete(key)
 		if errf != nil {
@@ -551,26 +612,26 @@ func (db *db) archiveJob(key string, job *Job) error {
 			return errf
 		}
 
-		b := tx.Bucket(bucketJobsLive)
+		b := db.changes.bucket(tx, bucketJobsLive)
 		errf = b.Delete(key)
 		if errf != nil {
 			return errf
 		}
 
-		b = tx.Bucket(bucketJobsComplete)
+		b = db.changes.bucket(tx, bucketJobsComplete)
 		errf = b.Put(key, encoded)
 		if errf != nil {
 			return errf
 		}
 
-		b = tx.Bucket(bucketJobMBs)
-		errf = b.Put([]byte(fmt.Sprintf("%s%s%20d", job.ReqGroup, dbDelimiter, job.PeakRAM)), []byte(strconv.Itoa(job.PeakRAM)))
-		if errf != nil {
-			return errf
+		if hr != nil {
+			errf = hr.put(tx, db.changes, encoded)
+			if errf != nil {
+				return errf
+			}
 		}
-		b = tx.Bucket(bucketJobSecs)
-		secs := int(math.Ceil(job.EndTime.Sub(job.StartTime).Seconds()))
-		return b.Put([]byte(fmt.Sprintf("%s%s%20d", job.ReqGroup, dbDelimiter, secs)), []byte(strconv.Itoa(secs)))
+
+		return js.put(tx, db.changes)
 	})
 
 	db.backgroundBackup()
@@ -579,11 +640,164 @@ func (db *db) archiveJob(key string, job *Job) error {
 }
 
 // deleteLiveJob remove a job from the live bucket, for use when jobs were
-// added in error.
+// added in error. Its std and its current RepGroup, DepGroup and Dependency
+// lookups are also removed, unless it had been completed before. (Lookups for
+// any RepGroups it had previously are removed by prune().)
 func (db *db) deleteLiveJob(key string) {
-	db.remove(bucketJobsLive, key)
+	db.wg.Add(1)
+	go func() {
+		defer internal.LogPanic(db.Logger, "deleteLiveJob", true)
+		defer db.wg.Done()
+		err := db.batch(func(tx *bolt.Tx) error {
+			bkey := []byte(key)
+			b := db.changes.bucket(tx, bucketJobsLive)
+			encoded := b.Get(bkey)
+			if encoded == nil {
+				return nil
+			}
+			job := &Job{}
+			errf := codec.NewDecoderBytes(encoded, db.ch).Decode(job)
+			if errf != nil {
+				return errf
+			}
+			errf = b.Delete(bkey)
+			if errf != nil {
+				return errf
+			}
+			for _, bucket := range [][]byte{bucketStdO, bucketStdE} {
+				errf = db.changes.bucket(tx, bucket).Delete(bkey)
+				if errf != nil {
+					return errf
+				}
+			}
+			if tx.Bucket(bucketJobsComplete).Get(bkey) != nil {
+				return nil
+			}
+			return deleteJobLookups(tx, db.changes, bkey, job)
+		})
+		if err != nil {
+			db.Error("Database operation deleteLiveJob failed", "err", err)
+		}
+	}()
 	db.backgroundBackup()
-	//*** we're not removing the lookup entries from the bucket*TK buckets...
+}
+
+// deleteJobLookups removes the lookups for the given job's current RepGroup,
+// DepGroups and Dependencies, noting the changes in the given changeLog.
+func deleteJobLookups(tx *bolt.Tx, cl *changeLog, key []byte, job *Job) error {
+	lookups := map[string][]string{
+		string(bucketRTK):  {job.RepGroup},
+		string(bucketDTK):  job.DepGroups,
+		string(bucketRDTK): job.Dependencies.DepGroups(),
+	}
+	for bucket, groups := range lookups {
+		b := cl.bucket(tx, []byte(bucket))
+		for _, group := range groups {
+			err := b.Delete(append([]byte(group+dbDelimiter), key...))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// putJobLookups is the inverse of deleteJobLookups(), storing lookups for the
+// given job's current RepGroup, DepGroups and Dependencies.
+func putJobLookups(tx *bolt.Tx, cl *changeLog, key []byte, job *Job) error {
+	lookups := map[string][]string{
+		string(bucketRTK):  {job.RepGroup},
+		string(bucketDTK):  job.DepGroups,
+		string(bucketRDTK): job.Dependencies.DepGroups(),
+	}
+	for bucket, groups := range lookups {
+		b := cl.bucket(tx, []byte(bucket))
+		for _, group := range groups {
+			if group == "" && bucket != string(bucketRTK) {
+				continue
+			}
+			err := b.Put(append([]byte(group+dbDelimiter), key...), nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// modifyLiveJobs replaces the given jobs in the live bucket with their current
+// state, for use after they have been modified with a JobModifier. Lookups for
+// the jobs' current RepGroup, DepGroups and Dependencies are added. Lookups for
+// the DepGroups and Dependencies they had before modification, which you
+// supply keyed on job key, are removed (lookups for old RepGroups are kept, so
+// you can still find jobs by any RepGroup they ever had).
+func (db *db) modifyLiveJobs(jobs []*Job, oldDepGroups map[string][]string, oldDepDepGroups map[string][]string) error {
+	err := db.update(func(tx *bolt.Tx) error {
+		bjobs := db.changes.bucket(tx, bucketJobsLive)
+		brtk := db.changes.bucket(tx, bucketRTK)
+		bdtk := db.changes.bucket(tx, bucketDTK)
+		brdtk := db.changes.bucket(tx, bucketRDTK)
+		for _, job := range jobs {
+			keyStr := job.key()
+			key := []byte(keyStr)
+
+			for _, depGroup := range oldDepGroups[keyStr] {
+				if depGroup != "" {
+					errd := bdtk.Delete(db.generateLookupKey(depGroup, key))
+					if errd != nil {
+						return errd
+					}
+				}
+			}
+			for _, depGroup := range oldDepDepGroups[keyStr] {
+				errd := brdtk.Delete(db.generateLookupKey(depGroup, key))
+				if errd != nil {
+					return errd
+				}
+			}
+
+			var encoded []byte
+			enc := codec.NewEncoderBytes(&encoded, db.ch)
+			job.RLock()
+			errf := enc.Encode(job)
+			repGroup := job.RepGroup
+			depGroups := job.DepGroups
+			depDepGroups := job.Dependencies.DepGroups()
+			job.RUnlock()
+			if errf != nil {
+				return errf
+			}
+
+			errf = brtk.Put(db.generateLookupKey(repGroup, key), nil)
+			if errf != nil {
+				return errf
+			}
+			for _, depGroup := range depGroups {
+				if depGroup != "" {
+					errf = bdtk.Put(db.generateLookupKey(depGroup, key), nil)
+					if errf != nil {
+						return errf
+					}
+				}
+			}
+			for _, depGroup := range depDepGroups {
+				errf = brdtk.Put(db.generateLookupKey(depGroup, key), nil)
+				if errf != nil {
+					return errf
+				}
+			}
+
+			errf = bjobs.Put(key, encoded)
+			if errf != nil {
+				return errf
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		db.backgroundBackup()
+	}
+	return err
 }
 
 // recoverIncompleteJobs returns all jobs in the live bucket, for use when
@@ -593,7 +807,7 @@ func (db *db) deleteLiveJob(key string) {
 // back the Jobs exactly as they were when you put them in with storeNewJobs().
 func (db *db) recoverIncompleteJobs() ([]*Job, error) {
 	var jobs []*Job
-	err := db.bolt.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucketJobsLive)
 		return b.ForEach(func(_, encoded []byte) error {
 			if encoded != nil {
@@ -615,7 +829,7 @@ func (db *db) recoverIncompleteJobs() ([]*Job, error) {
 // jobs bucket (ie. those that have gone through the queue and been Remove()d).
 func (db *db) retrieveCompleteJobsByKeys(keys []string) ([]*Job, error) {
 	var jobs []*Job
-	err := db.bolt.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucketJobsComplete)
 		for _, key := range keys {
 			encoded := b.Get([]byte(key))
@@ -638,16 +852,30 @@ func (db *db) retrieveCompleteJobsByKeys(keys []string) ([]*Job, error) {
 // Archive()d), but not those that are also currently live (ie. are being
 // re-run).
 func (db *db) retrieveCompleteJobsByRepGroup(repgroup string) ([]*Job, error) {
+	return db.retrieveCompleteJobsByRepGroupPage(repgroup, 0, 0)
+}
+
+// retrieveCompleteJobsByRepGroupPage is like retrieveCompleteJobsByRepGroup(),
+// but skips the first offset matching jobs and returns at most count of them
+// (all of them if count is 0), in key order. Skipped jobs are not decoded.
+func (db *db) retrieveCompleteJobsByRepGroupPage(repgroup string, offset int, count int) ([]*Job, error) {
 	var jobs []*Job
-	err := db.bolt.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		newJobBucket := tx.Bucket(bucketJobsLive)
 		completeJobBucket := tx.Bucket(bucketJobsComplete)
 		lookupBucket := tx.Bucket(bucketRTK).Cursor()
 		prefix := []byte(repgroup + dbDelimiter)
 		for k, _ := lookupBucket.Seek(prefix); bytes.HasPrefix(k, prefix); k, _ = lookupBucket.Next() {
+			if count > 0 && len(jobs) == count {
+				break
+			}
 			key := bytes.TrimPrefix(k, prefix)
 			encoded := completeJobBucket.Get(key)
 			if len(encoded) > 0 && newJobBucket.Get(key) == nil {
+				if offset > 0 {
+					offset--
+					continue
+				}
 				dec := codec.NewDecoderBytes(encoded, db.ch)
 				job := &Job{}
 				err := dec.Decode(job)
@@ -662,6 +890,180 @@ func (db *db) retrieveCompleteJobsByRepGroup(repgroup string) ([]*Job, error) {
 	return jobs, err
 }
 
+// historyRecord holds what we need to store a run of a job in the history.
+type historyRecord struct {
+	key        []byte
+	host       string
+	exitcode   int
+	failReason string
+}
+
+// newHistoryRecord returns a historyRecord for the most recent run of the
+// given job, which has the given key. You must hold the job's read lock. If the
+// job didn't actually run and exit, returns nil.
+func newHistoryRecord(jobKey string, job *Job) *historyRecord {
+	if !job.Exited || job.EndTime.IsZero() {
+		return nil
+	}
+	return &historyRecord{
+		key:        historyKey(job.EndTime.UnixNano(), jobKey),
+		host:       job.Host,
+		exitcode:   job.Exitcode,
+		failReason: job.FailReason,
+	}
+}
+
+// historyKey generates the key for a run of a job in the history bucket: its
+// end time followed by the job's key, so that the history is ordered by end
+// time. The lookup buckets for the history have keys of the looked-up value
+// followed by a history key, so are ordered by end time for each value.
+func historyKey(endTime int64, jobKey string) []byte {
+	return []byte(fmt.Sprintf("%020d%s%s", endTime, dbDelimiter, jobKey))
+}
+
+// lookup generates a key for one of the history lookup buckets, for looking up
+// this record by the given value.
+func (hr *historyRecord) lookup(value string) []byte {
+	key := append([]byte(value), []byte(dbDelimiter)...)
+	return append(key, hr.key...)
+}
+
+// put stores the given encoded job in the history bucket under this record's
+// key, along with lookups on its host, exit code and fail reason, noting the
+// changes in the given changeLog.
+func (hr *historyRecord) put(tx *bolt.Tx, cl *changeLog, encoded []byte) error {
+	err := cl.bucket(tx, bucketHistory).Put(hr.key, encoded)
+	if err != nil {
+		return err
+	}
+	err = cl.bucket(tx, bucketHTH).Put(hr.lookup(hr.host), nil)
+	if err != nil {
+		return err
+	}
+	err = cl.bucket(tx, bucketXTH).Put(hr.lookup(strconv.Itoa(hr.exitcode)), nil)
+	if err != nil || hr.failReason == "" {
+		return err
+	}
+	return cl.bucket(tx, bucketFTH).Put(hr.lookup(hr.failReason), nil)
+}
+
+// delete removes this record's run and its lookups from the history, noting
+// the changes in the given changeLog.
+func (hr *historyRecord) delete(tx *bolt.Tx, cl *changeLog) error {
+	err := cl.bucket(tx, bucketHistory).Delete(hr.key)
+	if err != nil {
+		return err
+	}
+	err = cl.bucket(tx, bucketHTH).Delete(hr.lookup(hr.host))
+	if err != nil {
+		return err
+	}
+	err = cl.bucket(tx, bucketXTH).Delete(hr.lookup(strconv.Itoa(hr.exitcode)))
+	if err != nil || hr.failReason == "" {
+		return err
+	}
+	return cl.bucket(tx, bucketFTH).Delete(hr.lookup(hr.failReason))
+}
+
+// historyFromComplete adds every job in the complete bucket to the history,
+// for use when upgrading a database made before we kept a history.
+func historyFromComplete(tx *bolt.Tx, ch codec.Handle) error {
+	return tx.Bucket(bucketJobsComplete).ForEach(func(key, encoded []byte) error {
+		dec := codec.NewDecoderBytes(encoded, ch)
+		job := &Job{}
+		err := dec.Decode(job)
+		if err != nil {
+			return err
+		}
+		hr := newHistoryRecord(string(key), job)
+		if hr == nil {
+			return nil
+		}
+		return hr.put(tx, nil, encoded)
+	})
+}
+
+// retrieveHistory gets the runs of jobs in the history that match the given
+// (validated) query, most recently ended first. If the query is on host, exit
+// code or fail reason, we only look at the runs with that value using our
+// lookups; otherwise we walk back through all runs in the query's time range.
+func (db *db) retrieveHistory(q *HistoryQuery) ([]*Job, error) {
+	var jobs []*Job
+	err := db.view(func(tx *bolt.Tx) error {
+		historyBucket := tx.Bucket(bucketHistory)
+		var cursor *bolt.Cursor
+		var prefix []byte
+		switch {
+		case q.Host != "":
+			cursor = tx.Bucket(bucketHTH).Cursor()
+			prefix = []byte(q.Host + dbDelimiter)
+		case q.Exitcode != nil:
+			cursor = tx.Bucket(bucketXTH).Cursor()
+			prefix = []byte(strconv.Itoa(*q.Exitcode) + dbDelimiter)
+		case q.FailReason != "":
+			cursor = tx.Bucket(bucketFTH).Cursor()
+			prefix = []byte(q.FailReason + dbDelimiter)
+		default:
+			cursor = historyBucket.Cursor()
+		}
+
+		// seek to just after the end of the time range, then walk backwards
+		to := int64(math.MaxInt64)
+		if !q.To.IsZero() {
+			to = q.To.UnixNano() + 1
+		}
+		var from int64
+		if !q.From.IsZero() {
+			from = q.From.UnixNano()
+		}
+		k, _ := cursor.Seek(append(prefix, fmt.Sprintf("%020d", to)...))
+		if k == nil {
+			k, _ = cursor.Last()
+		} else {
+			k, _ = cursor.Prev()
+		}
+
+		var skipped int
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Prev() {
+			hk := k[len(prefix):]
+			if len(hk) < 20 {
+				continue
+			}
+			ended, err := strconv.ParseInt(string(hk[:20]), 10, 64)
+			if err != nil {
+				return err
+			}
+			if ended < from {
+				break
+			}
+
+			encoded := historyBucket.Get(hk)
+			if encoded == nil {
+				continue
+			}
+			dec := codec.NewDecoderBytes(encoded, db.ch)
+			job := &Job{}
+			err = dec.Decode(job)
+			if err != nil {
+				return err
+			}
+			if !q.matches(job) {
+				continue
+			}
+			if skipped < q.Offset {
+				skipped++
+				continue
+			}
+			jobs = append(jobs, job)
+			if q.Limit > 0 && len(jobs) == q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return jobs, err
+}
+
 // retrieveDependentJobs gets previously stored jobs that had a dependency on
 // one for the input depGroups. If the job is found in the live bucket, then it
 // is returned in the jobsToUpdate return value. If it is found in the complete
@@ -675,7 +1077,7 @@ func (db *db) retrieveDependentJobs(depGroups map[string]bool, newJobKeys map[st
 	}
 	sort.Sort(prefixes)
 
-	err = db.bolt.View(func(tx *bolt.Tx) error {
+	err = db.view(func(tx *bolt.Tx) error {
 		newJobBucket := tx.Bucket(bucketJobsLive)
 		completeJobBucket := tx.Bucket(bucketJobsComplete)
 		lookupBucket := tx.Bucket(bucketRDTK).Cursor()
@@ -748,7 +1150,7 @@ func (db *db) retrieveDependentJobs(depGroups map[string]bool, newJobKeys map[st
 // Archive()d - even if they've been added and archived in the past).
 func (db *db) retrieveIncompleteJobKeysByDepGroup(depgroup string) ([]string, error) {
 	var jobKeys []string
-	err := db.bolt.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		newJobBucket := tx.Bucket(bucketJobsLive)
 		lookupBucket := tx.Bucket(bucketDTK).Cursor()
 		prefix := []byte(depgroup + dbDelimiter)
@@ -763,9 +1165,32 @@ func (db *db) retrieveIncompleteJobKeysByDepGroup(depgroup string) ([]string, er
 	return jobKeys, err
 }
 
+// retrieveJobKeysByDepGroup gets the keys of jobs with the given DepGroup from
+// both the live and complete buckets, for when you want to know about every
+// job a DepGroup-based Dependency has ever referred to.
+func (db *db) retrieveJobKeysByDepGroup(depgroup string) ([]string, error) {
+	var jobKeys []string
+	err := db.view(func(tx *bolt.Tx) error {
+		newJobBucket := tx.Bucket(bucketJobsLive)
+		completeJobBucket := tx.Bucket(bucketJobsComplete)
+		lookupBucket := tx.Bucket(bucketDTK).Cursor()
+		prefix := []byte(depgroup + dbDelimiter)
+		for k, _ := lookupBucket.Seek(prefix); bytes.HasPrefix(k, prefix); k, _ = lookupBucket.Next() {
+			key := bytes.TrimPrefix(k, prefix)
+			if newJobBucket.Get(key) != nil || completeJobBucket.Get(key) != nil {
+				jobKeys = append(jobKeys, string(key))
+			}
+		}
+		return nil
+	})
+	return jobKeys, err
+}
+
 // storeEnv stores a clientRequest.Env in db unless cached, which means it must
 // already be there. Returns a key by which the stored Env can be retrieved.
 func (db *db) storeEnv(env []byte) (string, error) {
+	db.storeMutex.RLock()
+	defer db.storeMutex.RUnlock()
 	envkey := byteKey(env)
 	if !db.envcache.Contains(envkey) {
 		err := db.store(bucketEnvs, envkey, env)
@@ -790,9 +1215,141 @@ func (db *db) retrieveEnv(envkey string) []byte {
 	return envc
 }
 
-// updateJobAfterExit stores the Job's peak RAM usage and wall time against the
-// Job's ReqGroup, allowing recommendedReqGroup*(ReqGroup) to work. It also
-// updates the stdout/err associated with a job.
+// storeCronSchedule stores a CronSchedule (including its firing history) keyed
+// on its Name, replacing any previously stored version. A backgroundBackup()
+// is triggered afterwards.
+func (db *db) storeCronSchedule(sc *CronSchedule) error {
+	var encoded []byte
+	enc := codec.NewEncoderBytes(&encoded, db.ch)
+	err := enc.Encode(sc)
+	if err != nil {
+		return err
+	}
+	err = db.store(bucketCron, sc.Name, encoded)
+	if err != nil {
+		return err
+	}
+	db.backgroundBackup()
+	return nil
+}
+
+// retrieveCronSchedules returns all the CronSchedules stored with
+// storeCronSchedule().
+func (db *db) retrieveCronSchedules() ([]*CronSchedule, error) {
+	var schedules []*CronSchedule
+	err := db.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketCron)
+		return b.ForEach(func(_, encoded []byte) error {
+			dec := codec.NewDecoderBytes(encoded, db.ch)
+			sc := &CronSchedule{}
+			errf := dec.Decode(sc)
+			if errf != nil {
+				return errf
+			}
+			schedules = append(schedules, sc)
+			return nil
+		})
+	})
+	return schedules, err
+}
+
+// deleteCronSchedule removes the named CronSchedule from the db.
+func (db *db) deleteCronSchedule(name string) {
+	db.remove(bucketCron, name)
+	db.backgroundBackup()
+}
+
+// storeLimitGroup stores a LimitGroup in the db, keyed on its Name, replacing
+// any previously stored version. A backgroundBackup() is triggered afterwards.
+func (db *db) storeLimitGroup(lg *LimitGroup) error {
+	var encoded []byte
+	enc := codec.NewEncoderBytes(&encoded, db.ch)
+	err := enc.Encode(lg)
+	if err != nil {
+		return err
+	}
+	err = db.store(bucketLimits, lg.Name, encoded)
+	if err != nil {
+		return err
+	}
+	db.backgroundBackup()
+	return nil
+}
+
+// retrieveLimitGroups returns all the LimitGroups stored with
+// storeLimitGroup().
+func (db *db) retrieveLimitGroups() ([]*LimitGroup, error) {
+	var limits []*LimitGroup
+	err := db.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketLimits)
+		return b.ForEach(func(_, encoded []byte) error {
+			dec := codec.NewDecoderBytes(encoded, db.ch)
+			lg := &LimitGroup{}
+			errf := dec.Decode(lg)
+			if errf != nil {
+				return errf
+			}
+			limits = append(limits, lg)
+			return nil
+		})
+	})
+	return limits, err
+}
+
+// deleteLimitGroup removes the named LimitGroup from the db.
+func (db *db) deleteLimitGroup(name string) {
+	db.remove(bucketLimits, name)
+	db.backgroundBackup()
+}
+
+// storeSubscription stores a Subscription in the db, keyed on its Name,
+// replacing any previously stored version. A backgroundBackup() is triggered
+// afterwards.
+func (db *db) storeSubscription(sub *Subscription) error {
+	var encoded []byte
+	enc := codec.NewEncoderBytes(&encoded, db.ch)
+	err := enc.Encode(sub)
+	if err != nil {
+		return err
+	}
+	err = db.store(bucketNotify, sub.Name, encoded)
+	if err != nil {
+		return err
+	}
+	db.backgroundBackup()
+	return nil
+}
+
+// retrieveSubscriptions returns all the Subscriptions stored with
+// storeSubscription().
+func (db *db) retrieveSubscriptions() ([]*Subscription, error) {
+	var subs []*Subscription
+	err := db.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketNotify)
+		return b.ForEach(func(_, encoded []byte) error {
+			dec := codec.NewDecoderBytes(encoded, db.ch)
+			sub := &Subscription{}
+			errf := dec.Decode(sub)
+			if errf != nil {
+				return errf
+			}
+			subs = append(subs, sub)
+			return nil
+		})
+	})
+	return subs, err
+}
+
+// deleteSubscription removes the named Subscription from the db.
+func (db *db) deleteSubscription(name string) {
+	db.remove(bucketNotify, name)
+	db.backgroundBackup()
+}
+
+// updateJobAfterExit stores the Job's peak RAM and disk usage, wall time and
+// CPU utilisation against the Job's ReqGroup (see newJobStats()), allowing
+// recommendedReqGroup*(ReqGroup) to work. It also updates the stdout/err
+// associated with a job.
 //
 // We don't want to store these in the job, since that would waste a lot of the
 // queue's memory; we store in db instead, and only retrieve when a client needs
@@ -804,6 +1361,9 @@ func (db *db) retrieveEnv(envkey string) []byte {
 // may be nil even on cmd failure. Since it is not critical to the running of
 // jobs and workflows that this works 100% of the time, we ignore errors and
 // write to bolt in a goroutine, giving us a significant speed boost.
+//
+// If the Job exited, a record of this run of it is also added to the history
+// that retrieveHistory() searches.
 func (db *db) updateJobAfterExit(job *Job, stdo []byte, stde []byte, forceStorage bool) {
 	db.RLock()
 	defer db.RUnlock()
@@ -811,11 +1371,18 @@ func (db *db) updateJobAfterExit(job *Job, stdo []byte, stde []byte, forceStorag
 		return
 	}
 	jobkey := job.key()
+	var encoded []byte
+	enc := codec.NewEncoderBytes(&encoded, db.ch)
 	job.RLock()
-	secs := int(math.Ceil(job.EndTime.Sub(job.StartTime).Seconds()))
-	jrg := job.ReqGroup
-	jpm := job.PeakRAM
+	js := newJobStats(job)
 	jec := job.Exitcode
+	hr := newHistoryRecord(jobkey, job)
+	if hr != nil {
+		if err := enc.Encode(job); err != nil {
+			db.Warn("Failed to encode job for the history", "err", err)
+			hr = nil
+		}
+	}
 	job.RUnlock()
 	db.wg.Add(1)
 	go func() {
@@ -825,9 +1392,9 @@ func (db *db) updateJobAfterExit(job *Job, stdo []byte, stde []byte, forceStorag
 		db.Lock()
 		db.updatingAfterJobExit++
 		db.Unlock()
-		err := db.bolt.Batch(func(tx *bolt.Tx) error {
-			bo := tx.Bucket(bucketStdO)
-			be := tx.Bucket(bucketStdE)
+		err := db.batch(func(tx *bolt.Tx) error {
+			bo := db.changes.bucket(tx, bucketStdO)
+			be := db.changes.bucket(tx, bucketStdE)
 			key := []byte(jobkey)
 			errf := bo.Delete(key)
 			if errf != nil {
@@ -850,13 +1417,14 @@ func (db *db) updateJobAfterExit(job *Job, stdo []byte, stde []byte, forceStorag
 				return errf
 			}
 
-			b := tx.Bucket(bucketJobMBs)
-			errf = b.Put([]byte(fmt.Sprintf("%s%s%20d", jrg, dbDelimiter, jpm)), []byte(strconv.Itoa(jpm)))
-			if errf != nil {
-				return errf
+			if hr != nil {
+				errf = hr.put(tx, db.changes, encoded)
+				if errf != nil {
+					return errf
+				}
 			}
-			b = tx.Bucket(bucketJobSecs)
-			return b.Put([]byte(fmt.Sprintf("%s%s%20d", jrg, dbDelimiter, secs)), []byte(strconv.Itoa(secs)))
+
+			return js.put(tx, db.changes)
 		})
 		if err != nil {
 			db.Error("Database operation updateJobAfterExit failed", "err", err)
@@ -867,6 +1435,60 @@ func (db *db) updateJobAfterExit(job *Job, stdo []byte, stde []byte, forceStorag
 	}()
 }
 
+// jobStats are the resource usage values of a run of a job that we learn from.
+type jobStats struct {
+	reqGroup string
+	mbs      int
+	secs     int
+	diskMBs  int // -1 if not measured
+	cores    int // CPU utilisation in jobStatCoresScale units; -1 if unknown
+}
+
+// newJobStats gets the stats of the given Job's last run, which you should
+// have RLock()ed. Disk usage is only known for jobs that ran with CwdMatters
+// false, and CPU utilisation only for jobs that used CPU time.
+func newJobStats(job *Job) *jobStats {
+	wall := job.EndTime.Sub(job.StartTime)
+	js := &jobStats{
+		reqGroup: job.ReqGroup,
+		mbs:      job.PeakRAM,
+		secs:     int(math.Ceil(wall.Seconds())),
+		diskMBs:  -1,
+		cores:    -1,
+	}
+	if job.Exited && !job.Skipped && !job.CwdMatters {
+		js.diskMBs = job.PeakDisk
+	}
+	if job.CPUtime > 0 && wall > 0 {
+		js.cores = int(math.Ceil(job.CPUtime.Seconds() / wall.Seconds() * jobStatCoresScale))
+	}
+	return js
+}
+
+// put stores our stats in their buckets, noting the changes in the given
+// changeLog.
+func (js *jobStats) put(tx *bolt.Tx, cl *changeLog) error {
+	stats := []struct {
+		bucket []byte
+		value  int
+	}{
+		{bucketJobMBs, js.mbs},
+		{bucketJobSecs, js.secs},
+		{bucketJobDisk, js.diskMBs},
+		{bucketJobCores, js.cores},
+	}
+	for _, stat := range stats {
+		if stat.value < 0 {
+			continue
+		}
+		err := cl.bucket(tx, stat.bucket).Put([]byte(fmt.Sprintf("%s%s%20d", js.reqGroup, dbDelimiter, stat.value)), []byte(strconv.Itoa(stat.value)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // retrieveJobStd gets the values that were stored using updateJobStd() for the
 // given job.
 func (db *db) retrieveJobStd(jobkey string) (stdo []byte, stde []byte) {
@@ -883,7 +1505,7 @@ func (db *db) retrieveJobStd(jobkey string) (stdo []byte, stde []byte) {
 		<-time.After(10 * time.Millisecond)
 	}
 
-	err := db.bolt.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		bo := tx.Bucket(bucketStdO)
 		be := tx.Bucket(bucketStdE)
 		key := []byte(jobkey)
@@ -927,13 +1549,34 @@ func (db *db) recommendedReqGroupTime(reqGroup string) (int, error) {
 	return db.recommendedReqGroupStat(bucketJobSecs, reqGroup, RecSecRound)
 }
 
+// recommendedReqGroupDisk returns the 95th percentile peak disk usage of all
+// jobs that previously ran with the given reqGroup (and had their disk usage
+// measured, which requires CwdMatters to be false), in the same way as
+// recommendedReqGroupMemory(), except that the true value is rounded up to the
+// nearest RecDiskRound MB and then returned in GB. Returns 0 if there are no
+// prior values, or if the jobs used no disk space.
+func (db *db) recommendedReqGroupDisk(reqGroup string) (int, error) {
+	mbs, err := db.recommendedReqGroupStat(bucketJobDisk, reqGroup, RecDiskRound)
+	return int(math.Ceil(float64(mbs) / 1024)), err
+}
+
+// recommendedReqGroupCores returns the 95th percentile CPU utilisation (CPU
+// time divided by wall time) of all jobs that previously ran with the given
+// reqGroup, in the same way as recommendedReqGroupMemory(), except that the
+// true value is rounded up to the nearest whole core. Returns 0 if there are
+// no prior values.
+func (db *db) recommendedReqGroupCores(reqGroup string) (int, error) {
+	scaled, err := db.recommendedReqGroupStat(bucketJobCores, reqGroup, jobStatCoresScale)
+	return scaled / jobStatCoresScale, err
+}
+
 // recommendedReqGroupStat is the implementation for the other recommend*()
 // methods.
 func (db *db) recommendedReqGroupStat(statBucket []byte, reqGroup string, roundAmount int) (int, error) {
 	prefix := []byte(reqGroup)
 	max := 0
 	var recommendation int
-	err := db.bolt.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		c := tx.Bucket(statBucket).Cursor()
 
 		// we seek over the bucket, and to avoid having to do it twice (first to
@@ -983,8 +1626,8 @@ func (db *db) recommendedReqGroupStat(statBucket []byte, reqGroup string, roundA
 
 // store does a basic set of a key/val in a given bucket
 func (db *db) store(bucket []byte, key string, val []byte) error {
-	err := db.bolt.Batch(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucket)
+	err := db.batch(func(tx *bolt.Tx) error {
+		b := db.changes.bucket(tx, bucket)
 		err := b.Put([]byte(key), val)
 		return err
 	})
@@ -995,7 +1638,7 @@ func (db *db) store(bucket []byte, key string, val []byte) error {
 // possible here.
 func (db *db) retrieve(bucket []byte, key string) []byte {
 	var val []byte
-	err := db.bolt.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucket)
 		v := b.Get([]byte(key))
 		if v != nil {
@@ -1018,8 +1661,8 @@ func (db *db) remove(bucket []byte, key string) {
 	db.wg.Add(1)
 	go func() {
 		defer db.wg.Done()
-		err := db.bolt.Batch(func(tx *bolt.Tx) error {
-			b := tx.Bucket(bucket)
+		err := db.batch(func(tx *bolt.Tx) error {
+			b := db.changes.bucket(tx, bucket)
 			return b.Delete([]byte(key))
 		})
 		if err != nil {
@@ -1072,8 +1715,8 @@ func (db *db) storeBatched(bucket []byte, data sobsd, storer sobsdStorer) error
 // storeLookups is a sobsdStorer for storing Job.[somevalue]->Job.Key() lookups
 // in the db.
 func (db *db) storeLookups(bucket []byte, lookups sobsd) error {
-	err := db.bolt.Batch(func(tx *bolt.Tx) error {
-		lookup := tx.Bucket(bucket)
+	err := db.batch(func(tx *bolt.Tx) error {
+		lookup := db.changes.bucket(tx, bucket)
 		for _, doublet := range lookups {
 			err := lookup.Put(doublet[0], nil)
 			if err != nil {
@@ -1087,8 +1730,8 @@ func (db *db) storeLookups(bucket []byte, lookups sobsd) error {
 
 // storeEncodedJobs is a sobsdStorer for storing Jobs in the db.
 func (db *db) storeEncodedJobs(bucket []byte, encodes sobsd) error {
-	err := db.bolt.Batch(func(tx *bolt.Tx) error {
-		bjobs := tx.Bucket(bucket)
+	err := db.batch(func(tx *bolt.Tx) error {
+		bjobs := db.changes.bucket(tx, bucket)
 		for _, doublet := range encodes {
 			err := bjobs.Put(doublet[0], doublet[1])
 			if err != nil {
@@ -1100,6 +1743,356 @@ func (db *db) storeEncodedJobs(bucket []byte, encodes sobsd) error {
 	return err
 }
 
+// view, update and batch are like the bolt.DB methods of the same name, but
+// stop compact() from swapping out our bolt.DB while they are in use.
+func (db *db) view(fn func(*bolt.Tx) error) error {
+	db.boltMutex.RLock()
+	defer db.boltMutex.RUnlock()
+	return db.bolt.View(fn)
+}
+
+func (db *db) update(fn func(*bolt.Tx) error) error {
+	db.boltMutex.RLock()
+	defer db.boltMutex.RUnlock()
+	return db.bolt.Update(fn)
+}
+
+func (db *db) batch(fn func(*bolt.Tx) error) error {
+	db.boltMutex.RLock()
+	defer db.boltMutex.RUnlock()
+	return db.bolt.Batch(fn)
+}
+
+// pruneStats says how many things prune() removed from the database.
+type pruneStats struct {
+	Jobs    int // complete jobs
+	Runs    int // runs in the history
+	Lookups int // RepGroup, DepGroup and Dependency lookups
+	Std     int // stored STDOUT and STDERR
+	Envs    int // environment variables
+}
+
+// total returns the total number of things pruned.
+func (ps *pruneStats) total() int {
+	return ps.Jobs + ps.Runs + ps.Lookups + ps.Std + ps.Envs
+}
+
+// prune removes complete jobs that ended longer ago than the given
+// RetentionPolicy allows (unless they are currently being re-run), along with
+// their lookups. Unless the policy says to KeepStats, runs in the history that
+// ended too long ago are also removed. Finally, things that are no longer
+// needed by any live or complete job are removed: lookups (eg. for RepGroups
+// the pruned jobs used to have, or left behind by deleteLiveJob()), stored
+// STDOUT/ERR and environment variables.
+//
+// Stats on peak memory and disk, run time and CPU utilisation (used by
+// recommendedReqGroup*()) are never removed. A backgroundBackup() is triggered afterwards if anything was
+// removed.
+func (db *db) prune(rp *RetentionPolicy, now time.Time) (*pruneStats, error) {
+	db.storeMutex.Lock()
+	defer db.storeMutex.Unlock()
+	ps := &pruneStats{}
+	err := db.update(func(tx *bolt.Tx) error {
+		live := tx.Bucket(bucketJobsLive)
+		complete := db.changes.bucket(tx, bucketJobsComplete)
+
+		// find the complete jobs to remove, and the envs that are still in use
+		envKeys := make(map[string]bool)
+		var keys [][]byte
+		var jobs []*Job
+		err := complete.ForEach(func(k, encoded []byte) error {
+			job := &Job{}
+			errf := codec.NewDecoderBytes(encoded, db.ch).Decode(job)
+			if errf != nil {
+				return errf
+			}
+			if live.Get(k) == nil && rp.expired(job.RepGroup, job.EndTime, now) {
+				keys = append(keys, append([]byte{}, k...))
+				jobs = append(jobs, job)
+				return nil
+			}
+			envKeys[job.EnvKey] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = live.ForEach(func(_, encoded []byte) error {
+			job := &Job{}
+			errf := codec.NewDecoderBytes(encoded, db.ch).Decode(job)
+			if errf != nil {
+				return errf
+			}
+			envKeys[job.EnvKey] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for i, key := range keys {
+			err = complete.Delete(key)
+			if err != nil {
+				return err
+			}
+			err = deleteJobLookups(tx, db.changes, key, jobs[i])
+			if err != nil {
+				return err
+			}
+		}
+		ps.Jobs = len(keys)
+
+		if !rp.KeepStats {
+			ps.Runs, err = db.pruneHistory(tx, rp, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		// remove lookups of jobs that no longer exist
+		for _, bucket := range [][]byte{bucketRTK, bucketDTK, bucketRDTK} {
+			removed, errd := deleteKeysWhere(db.changes.bucket(tx, bucket), func(k []byte) bool {
+				i := bytes.LastIndex(k, []byte(dbDelimiter))
+				if i == -1 {
+					return false
+				}
+				key := k[i+len(dbDelimiter):]
+				return live.Get(key) == nil && complete.Get(key) == nil
+			})
+			if errd != nil {
+				return errd
+			}
+			ps.Lookups += removed
+		}
+
+		// remove std of jobs that aren't live (complete jobs never have any)
+		for _, bucket := range [][]byte{bucketStdO, bucketStdE} {
+			removed, errd := deleteKeysWhere(db.changes.bucket(tx, bucket), func(k []byte) bool {
+				return live.Get(k) == nil
+			})
+			if errd != nil {
+				return errd
+			}
+			ps.Std += removed
+		}
+
+		// remove envs that no job uses, unless they're cached, since then
+		// storeEnv() would assume they're still stored
+		ps.Envs, err = deleteKeysWhere(db.changes.bucket(tx, bucketEnvs), func(k []byte) bool {
+			return !envKeys[string(k)] && !db.envcache.Contains(string(k))
+		})
+		return err
+	})
+	if err == nil && ps.total() > 0 {
+		db.backgroundBackup()
+	}
+	return ps, err
+}
+
+// pruneHistory is used by prune() to remove runs in the history that ended
+// longer ago than the given RetentionPolicy allows. Returns the number of runs
+// removed.
+func (db *db) pruneHistory(tx *bolt.Tx, rp *RetentionPolicy, now time.Time) (int, error) {
+	minAge := rp.minAge()
+	if minAge == 0 {
+		return 0, nil
+	}
+	keepFrom := now.Add(-minAge).UnixNano()
+
+	// the history is ordered by end time, so we only need to look at the runs
+	// that ended longer ago than the shortest max age
+	var hrs []*historyRecord
+	c := tx.Bucket(bucketHistory).Cursor()
+	for k, encoded := c.First(); k != nil; k, encoded = c.Next() {
+		if len(k) < 20 {
+			continue
+		}
+		ended, err := strconv.ParseInt(string(k[:20]), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if ended >= keepFrom {
+			break
+		}
+		job := &Job{}
+		err = codec.NewDecoderBytes(encoded, db.ch).Decode(job)
+		if err != nil {
+			return 0, err
+		}
+		if rp.expired(job.RepGroup, job.EndTime, now) {
+			hrs = append(hrs, &historyRecord{
+				key:        append([]byte{}, k...),
+				host:       job.Host,
+				exitcode:   job.Exitcode,
+				failReason: job.FailReason,
+			})
+		}
+	}
+
+	for _, hr := range hrs {
+		err := hr.delete(tx, db.changes)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(hrs), nil
+}
+
+// deleteKeysWhere deletes the keys in the given bucket for which the given
+// function returns true. Returns the number of keys deleted.
+func deleteKeysWhere(b *loggedBucket, del func(k []byte) bool) (int, error) {
+	var keys [][]byte
+	err := b.ForEach(func(k, _ []byte) error {
+		if del(k) {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		err = b.Delete(key)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// compact rewrites our database file to reclaim the space left unused by
+// things that were deleted from it (bolt never shrinks its file by itself),
+// then carries on using the new file. All other database operations wait until
+// this completes. Returns the size of the file before and after.
+func (db *db) compact() (before int64, after int64, err error) {
+	db.RLock()
+	closed := db.closed
+	db.RUnlock()
+	if closed {
+		return 0, 0, fmt.Errorf("database is closed")
+	}
+
+	db.boltMutex.Lock()
+	defer db.boltMutex.Unlock()
+	path := db.bolt.Path()
+	tmpPath := path + ".compact"
+	before, after, err = compactBolt(db.bolt, tmpPath)
+	if err != nil {
+		return before, after, err
+	}
+
+	err = db.bolt.Close()
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	boltdb, erro := bolt.Open(path, dbFilePermission, &bolt.Options{Timeout: 10 * time.Second})
+	if erro != nil {
+		// we can't carry on without a database
+		db.Crit("Could not reopen database after compacting", "err", erro)
+		return before, after, erro
+	}
+	db.bolt = boltdb
+	db.changes.reset()
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return before, before, err
+	}
+
+	db.backgroundBackup()
+	return before, after, err
+}
+
+// compactBolt copies everything in src in to a new bolt database at dstPath,
+// which will take up no more space than needed. Returns the size of src's file
+// and the new file.
+func compactBolt(src *bolt.DB, dstPath string) (before int64, after int64, err error) {
+	info, err := os.Stat(src.Path())
+	if err != nil {
+		return before, after, err
+	}
+	before = info.Size()
+
+	dst, err := bolt.Open(dstPath, dbFilePermission, nil)
+	if err != nil {
+		return before, after, err
+	}
+	err = src.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return copyBucket(dst, name, b)
+		})
+	})
+	errc := dst.Close()
+	if err == nil {
+		err = errc
+	}
+	if err != nil {
+		_ = os.Remove(dstPath)
+		return before, after, err
+	}
+
+	info, err = os.Stat(dstPath)
+	if err != nil {
+		return before, after, err
+	}
+	return before, info.Size(), err
+}
+
+// copyBucket copies the keys and values of b to a bucket with the given name
+// in dst, in transactions of no more than compactTxSize keys, so that we don't
+// need huge amounts of memory to copy huge buckets.
+func copyBucket(dst *bolt.DB, name []byte, b *bolt.Bucket) error {
+	c := b.Cursor()
+	k, v := c.First()
+	for {
+		err := dst.Update(func(tx *bolt.Tx) error {
+			nb, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+			// we add keys in order, so can fill every page completely
+			nb.FillPercent = 1.0
+			for n := 0; k != nil && n < compactTxSize; k, v = c.Next() {
+				err = nb.Put(k, v)
+				if err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil || k == nil {
+			return err
+		}
+	}
+}
+
+// CompactDBFile compacts the database file at the given path, as created by
+// Serve() at its configured DBFile path, to reclaim the space left unused by
+// things that were deleted from it. The database must not be in use by a
+// running server (use Client.CompactDB() instead in that case). Returns the
+// size of the file before and after.
+func CompactDBFile(path string) (before int64, after int64, err error) {
+	_, err = os.Stat(path)
+	if err != nil {
+		return before, after, err
+	}
+	boltdb, err := bolt.Open(path, dbFilePermission, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return before, after, fmt.Errorf("could not open %s (is the manager using it?): %s", path, err)
+	}
+	tmpPath := path + ".compact"
+	before, after, err = compactBolt(boltdb, tmpPath)
+	errc := boltdb.Close()
+	if err == nil {
+		err = errc
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return before, after, err
+	}
+	return before, after, os.Rename(tmpPath, path)
+}
+
 // close shuts down the db, should be used prior to exiting. Ensures any
 // ongoing backgroundBackup() completes first (but does not wait for backup() to
 // complete).
@@ -1124,7 +2117,9 @@ func (db *db) close() error {
 			db.Lock()
 		}
 
+		db.boltMutex.Lock()
 		err := db.bolt.Close()
+		db.boltMutex.Unlock()
 		if db.backupMount != nil {
 			erru := db.backupMount.Unmount()
 			if erru != nil {
@@ -1186,7 +2181,7 @@ func (db *db) backgroundBackup() {
 		// create the new backup file with temp name
 		start := time.Now()
 		tmpBackupPath := db.backupPath + ".tmp"
-		err := db.bolt.View(func(tx *bolt.Tx) error {
+		err := db.view(func(tx *bolt.Tx) error {
 			return tx.CopyFile(tmpBackupPath, dbFilePermission)
 		})
 
@@ -1214,6 +2209,11 @@ func (db *db) backgroundBackup() {
 		db.backingUp = false
 		db.backupLast = time.Now()
 		duration := time.Since(start)
+		db.backupDuration = duration
+		db.backupFailed = err != nil
+		if err == nil {
+			db.backupSucceeded = db.backupLast
+		}
 		if duration > minimumTimeBetweenBackups {
 			db.backupWait = duration
 		}
@@ -1238,6 +2238,38 @@ func (db *db) backgroundBackup() {
 	}(db.backupLast, db.backupWait, db.backupFinal)
 }
 
+// dbStats holds some details about the database and its backups.
+type dbStats struct {
+	Size           int64         // bytes of data in the database
+	BackupsEnabled bool          // false if no automatic backups are being made
+	BackupLast     time.Time     // when the last successful backup completed
+	BackupDuration time.Duration // how long the last backup attempt took
+	BackupFailed   bool          // true if the last backup attempt failed
+}
+
+// stats returns details about the database and the state of its automatic
+// backups. Returns an error if the database can't be read from.
+func (db *db) stats() (*dbStats, error) {
+	db.RLock()
+	if db.closed {
+		db.RUnlock()
+		return nil, fmt.Errorf("database closed")
+	}
+	ds := &dbStats{
+		BackupsEnabled: db.backupsEnabled,
+		BackupLast:     db.backupSucceeded,
+		BackupDuration: db.backupDuration,
+		BackupFailed:   db.backupFailed,
+	}
+	db.RUnlock()
+
+	err := db.view(func(tx *bolt.Tx) error {
+		ds.Size = tx.Size()
+		return nil
+	})
+	return ds, err
+}
+
 // backup backs up the database to the given writer. Can be called at the same
 // time as an active backgroundBackup() or even another backup(). You will get
 // a consistent view of the database at the time you call this. NB: this can be
@@ -1250,8 +2282,175 @@ func (db *db) backup(w io.Writer) error {
 	}
 	db.RUnlock()
 
-	return db.bolt.View(func(tx *bolt.Tx) error {
+	return db.view(func(tx *bolt.Tx) error {
 		_, txErr := tx.WriteTo(w)
 		return txErr
 	})
 }
+
+// replicate is like backup(), but for keeping a standby's copy of the database
+// up to date. Given the epoch and id of the last transaction the standby has,
+// it returns a replica with our current epoch and transaction id, and the
+// current values of the keys that changed since then. If we no longer know
+// everything that changed (or the standby has a different epoch), the replica
+// has the whole database instead.
+func (db *db) replicate(epoch int64, since int) (*replica, error) {
+	db.RLock()
+	if db.closed {
+		db.RUnlock()
+		return nil, fmt.Errorf("database closed")
+	}
+	db.RUnlock()
+
+	rep := &replica{}
+	err := db.view(func(tx *bolt.Tx) error {
+		rep.TxID = tx.ID()
+		var changed map[string]map[string]bool
+		var known bool
+		rep.Epoch, changed, known = db.changes.changedSince(epoch, since)
+		if !known {
+			var b bytes.Buffer
+			_, txErr := tx.WriteTo(&b)
+			rep.DB = b.Bytes()
+			return txErr
+		}
+		if rep.TxID == since {
+			return nil
+		}
+
+		for bucket, keys := range changed {
+			c := tx.Bucket([]byte(bucket)).Cursor()
+			for key := range keys {
+				change := &dbChange{Bucket: []byte(bucket), Key: []byte(key)}
+				k, v := c.Seek(change.Key)
+				if bytes.Equal(k, change.Key) {
+					change.Value = append([]byte{}, v...)
+				} else {
+					change.Deleted = true
+				}
+				rep.Changes = append(rep.Changes, change)
+			}
+		}
+		return nil
+	})
+	return rep, err
+}
+
+// dbChange is the current state of a key that was changed in the database, for
+// sending to a standby.
+type dbChange struct {
+	Bucket  []byte
+	Key     []byte
+	Value   []byte
+	Deleted bool
+}
+
+// changeLog remembers which keys were changed by our recent write transactions,
+// so that standbys can be sent just the changes since they last asked, instead
+// of the whole database.
+type changeLog struct {
+	epoch   int64 // changes when transaction ids start over
+	from    int   // we know every change made after this transaction id
+	entries []*changeLogEntry
+	mutex   sync.Mutex
+}
+
+// changeLogEntry is a key that was changed by a particular transaction.
+type changeLogEntry struct {
+	txid   int
+	bucket []byte
+	key    []byte
+}
+
+// newChangeLog creates a changeLog at the start of a new epoch.
+func newChangeLog() *changeLog {
+	cl := &changeLog{}
+	cl.reset()
+	return cl
+}
+
+// reset forgets all changes and starts a new epoch, for when the database has
+// been replaced and transaction ids start over. There must not be any write
+// transactions in progress.
+func (cl *changeLog) reset() {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	cl.epoch = time.Now().UnixNano()
+	cl.from = 0
+	cl.entries = nil
+}
+
+// note records that the given key in the given bucket was changed by the write
+// transaction with the given id. We only remember the last StandbyMaxChanges
+// changes.
+func (cl *changeLog) note(txid int, bucket []byte, key []byte) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	cl.entries = append(cl.entries, &changeLogEntry{txid: txid, bucket: bucket, key: append([]byte{}, key...)})
+	for len(cl.entries) > StandbyMaxChanges {
+		if cl.entries[0].txid > cl.from {
+			cl.from = cl.entries[0].txid
+		}
+		cl.entries[0] = nil
+		cl.entries = cl.entries[1:]
+	}
+}
+
+// changedSince returns our current epoch, and the keys (grouped by bucket) that
+// were changed after the given transaction id of the given epoch. The bool is
+// false if we don't know all the keys that were changed since then.
+//
+// Keys changed by transactions still in progress (or that were rolled back) may
+// be included, so you should look up their values in a transaction of your
+// own.
+func (cl *changeLog) changedSince(epoch int64, txid int) (int64, map[string]map[string]bool, bool) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	if epoch != cl.epoch || txid < cl.from {
+		return cl.epoch, nil, false
+	}
+	changed := make(map[string]map[string]bool)
+	i := sort.Search(len(cl.entries), func(i int) bool { return cl.entries[i].txid > txid })
+	for _, entry := range cl.entries[i:] {
+		bucket := string(entry.bucket)
+		if changed[bucket] == nil {
+			changed[bucket] = make(map[string]bool)
+		}
+		changed[bucket][string(entry.key)] = true
+	}
+	return cl.epoch, changed, true
+}
+
+// bucket returns the named bucket of the given write transaction, with Put()
+// and Delete() noting their changes in this changeLog. (The changeLog can be
+// nil, in which case nothing is noted.)
+func (cl *changeLog) bucket(tx *bolt.Tx, name []byte) *loggedBucket {
+	return &loggedBucket{Bucket: tx.Bucket(name), name: name, txid: tx.ID(), cl: cl}
+}
+
+// loggedBucket is a bolt.Bucket that notes the keys changed in it in a
+// changeLog.
+type loggedBucket struct {
+	*bolt.Bucket
+	name []byte
+	txid int
+	cl   *changeLog
+}
+
+// Put is like bolt.Bucket.Put(), noting the change.
+func (b *loggedBucket) Put(key []byte, value []byte) error {
+	err := b.Bucket.Put(key, value)
+	if err == nil && b.cl != nil {
+		b.cl.note(b.txid, b.name, key)
+	}
+	return err
+}
+
+// Delete is like bolt.Bucket.Delete(), noting the change.
+func (b *loggedBucket) Delete(key []byte) error {
+	err := b.Bucket.Delete(key)
+	if err == nil && b.cl != nil {
+		b.cl.note(b.txid, b.name, key)
+	}
+	return err
+}
@@ -53,6 +53,10 @@ Server
         RunnerCmd:       selfExe + " runner -s '%s' --deployment %s --server '%s' -r %d -m %d",
         DBFile:          "/home/username/.wr_production/boltdb",
         DBFileBackup:    "/home/username/.wr_production/boltdb.backup",
+        TokenFile:       "/home/username/.wr_production/client.token",
+        CAFile:          "/home/username/.wr_production/ca.pem",
+        CertFile:        "/home/username/.wr_production/cert.pem",
+        KeyFile:         "/home/username/.wr_production/key.pem",
         Deployment:      "production",
         CIDR:            "",
     })
@@ -84,7 +88,8 @@ An example client, one for adding commands to the job queue:
         Dependencies: deps,
     })
 
-    jq, err := jobqueue.Connect("localhost:12345", 30 * time.Second)
+    token, err := ioutil.ReadFile("/home/username/.wr_production/client.token")
+    jq, err := jobqueue.Connect("localhost:12345", "/home/username/.wr_production/ca.pem", "localhost", token, 30 * time.Second)
     inserts, dups, err := jq.Add(jobs, os.Environ())
 */
 package jobqueue
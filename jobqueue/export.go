@@ -0,0 +1,588 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for exporting the contents of a database to a
+// portable archive, and for importing such archives in to another database.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	exportFormat = "wr-db-export"
+
+	// ExportVersion is the version of the archive format written by ExportDB().
+	// ImportDB() can read archives of this version or earlier.
+	ExportVersion = 1
+
+	exportTypeLive     = "live"
+	exportTypeComplete = "complete"
+	exportTypeRun      = "run"
+	exportTypeEnv      = "env"
+	exportTypeStd      = "std"
+	exportTypeStat     = "stat"
+	exportStatMemory   = "memory"
+	exportStatTime     = "time"
+	exportStatDisk     = "disk"
+	exportStatCores    = "cores"
+)
+
+// exportStatBuckets are the resource-learning buckets, keyed on the names we
+// give them in archives.
+var exportStatBuckets = map[string][]byte{
+	exportStatMemory: bucketJobMBs,
+	exportStatTime:   bucketJobSecs,
+	exportStatDisk:   bucketJobDisk,
+	exportStatCores:  bucketJobCores,
+}
+
+// ExportOptions let you choose what ExportDB() exports. The zero value exports
+// everything.
+type ExportOptions struct {
+	// RepGroup is a glob (as understood by path.Match(), eg. "mygroup*");
+	// only jobs with a matching RepGroup are exported, along with only their
+	// std and envs, and only the resource-learning stats of their ReqGroups.
+	RepGroup string
+
+	// From and To, if not zero, restrict the exported complete jobs and runs
+	// from the history to those that ended within this time range. Live jobs
+	// are always exported.
+	From time.Time
+	To   time.Time
+
+	// NoStd and NoEnv skip the export of jobs' std output and environment
+	// variables respectively.
+	NoStd bool
+	NoEnv bool
+}
+
+// wantsJob tells you if the given job should be exported.
+func (opts *ExportOptions) wantsJob(job *Job, ended bool) bool {
+	if opts.RepGroup != "" {
+		if matched, _ := path.Match(opts.RepGroup, job.RepGroup); !matched {
+			return false
+		}
+	}
+	if ended {
+		if !opts.From.IsZero() && job.EndTime.Before(opts.From) {
+			return false
+		}
+		if !opts.To.IsZero() && job.EndTime.After(opts.To) {
+			return false
+		}
+	}
+	return true
+}
+
+// ExportCounts tells you how many of each kind of thing ExportDB() exported,
+// or how many ImportDB() added to the database.
+type ExportCounts struct {
+	Live     int
+	Complete int
+	Runs     int
+	Envs     int
+	Std      int
+	Stats    int
+}
+
+// exportHeader is the first line of an archive.
+type exportHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+}
+
+// exportRecord is every subsequent line of an archive, holding one of the
+// things we export, according to its Type.
+type exportRecord struct {
+	Type     string   `json:"type"`
+	Key      string   `json:"key,omitempty"`
+	Job      *Job     `json:"job,omitempty"`
+	Env      []string `json:"env,omitempty"`
+	StdOut   string   `json:"stdout,omitempty"`
+	StdErr   string   `json:"stderr,omitempty"`
+	Stat     string   `json:"stat,omitempty"`
+	ReqGroup string   `json:"req_grp,omitempty"`
+	Value    int      `json:"value,omitempty"`
+}
+
+// ExportDB writes the contents of the database file at the given path, as
+// created by Serve() at its configured DBFile path, to the given writer as a
+// gzip compressed archive of JSON lines. The archive holds live jobs, complete
+// jobs, the history of job runs, envs, std output and resource-learning stats,
+// which you can merge in to another database with ImportDB(). The database
+// must not be in use by a running server (but you can export from a copy made
+// with Client.BackupDB() instead).
+func ExportDB(dbPath string, w io.Writer, opts *ExportOptions) (counts *ExportCounts, err error) {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	_, err = os.Stat(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	boltdb, err := bolt.Open(dbPath, dbFilePermission, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s (is the manager using it?): %s", dbPath, err)
+	}
+	defer func() {
+		errc := boltdb.Close()
+		if err == nil {
+			err = errc
+		}
+	}()
+
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	err = enc.Encode(&exportHeader{Format: exportFormat, Version: ExportVersion, Created: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+
+	counts = &ExportCounts{}
+	ch := new(codec.BincHandle)
+	err = boltdb.View(func(tx *bolt.Tx) error {
+		envKeys := make(map[string]bool)
+		reqGroups := make(map[string]bool)
+		write := func(rec *exportRecord, count *int) error {
+			if rec.Job != nil {
+				envKeys[rec.Job.EnvKey] = true
+				reqGroups[rec.Job.ReqGroup] = true
+			}
+			*count++
+			return enc.Encode(rec)
+		}
+		decode := func(encoded []byte) (*Job, error) {
+			job := &Job{}
+			errd := codec.NewDecoderBytes(encoded, ch).Decode(job)
+			return job, errd
+		}
+
+		// live jobs and their std
+		bo := tx.Bucket(bucketStdO)
+		be := tx.Bucket(bucketStdE)
+		errf := tx.Bucket(bucketJobsLive).ForEach(func(key, encoded []byte) error {
+			job, errd := decode(encoded)
+			if errd != nil {
+				return errd
+			}
+			if !opts.wantsJob(job, false) {
+				return nil
+			}
+			errd = write(&exportRecord{Type: exportTypeLive, Key: string(key), Job: job}, &counts.Live)
+			if errd != nil || opts.NoStd {
+				return errd
+			}
+			rec := &exportRecord{Type: exportTypeStd, Key: string(key)}
+			for _, std := range []struct {
+				b   *bolt.Bucket
+				dst *string
+			}{{bo, &rec.StdOut}, {be, &rec.StdErr}} {
+				compressed := std.b.Get(key)
+				if compressed == nil {
+					continue
+				}
+				decompressed, errc := decompress(compressed)
+				if errc != nil {
+					return errc
+				}
+				*std.dst = string(decompressed)
+			}
+			if rec.StdOut == "" && rec.StdErr == "" {
+				return nil
+			}
+			counts.Std++
+			return enc.Encode(rec)
+		})
+		if errf != nil {
+			return errf
+		}
+
+		// complete jobs and the history of runs
+		for _, kind := range []struct {
+			bucket []byte
+			typ    string
+			count  *int
+		}{{bucketJobsComplete, exportTypeComplete, &counts.Complete}, {bucketHistory, exportTypeRun, &counts.Runs}} {
+			typ, count := kind.typ, kind.count
+			errf = tx.Bucket(kind.bucket).ForEach(func(key, encoded []byte) error {
+				job, errd := decode(encoded)
+				if errd != nil {
+					return errd
+				}
+				if !opts.wantsJob(job, true) {
+					return nil
+				}
+				rec := &exportRecord{Type: typ, Key: string(key), Job: job}
+				if typ == exportTypeRun {
+					// history keys are the end time followed by the job key
+					rec.Key = string(key[bytes.Index(key, []byte(dbDelimiter))+len(dbDelimiter):])
+				}
+				return write(rec, count)
+			})
+			if errf != nil {
+				return errf
+			}
+		}
+
+		// the envs of the jobs we exported
+		if !opts.NoEnv {
+			b := tx.Bucket(bucketEnvs)
+			for _, key := range sortedKeys(envKeys) {
+				compressed := b.Get([]byte(key))
+				if compressed == nil {
+					continue
+				}
+				decompressed, errd := decompress(compressed)
+				if errd != nil {
+					return errd
+				}
+				es := &envStr{}
+				errd = codec.NewDecoderBytes(decompressed, ch).Decode(es)
+				if errd != nil {
+					return errd
+				}
+				counts.Envs++
+				errd = enc.Encode(&exportRecord{Type: exportTypeEnv, Key: key, Env: es.Environ})
+				if errd != nil {
+					return errd
+				}
+			}
+		}
+
+		// resource-learning stats, which are keyed on ReqGroup and value
+		for _, stat := range []string{exportStatMemory, exportStatTime, exportStatDisk, exportStatCores} {
+			b := tx.Bucket(exportStatBuckets[stat])
+			if b == nil {
+				// databases from older versions don't have all the stats
+				continue
+			}
+			errf = b.ForEach(func(key, val []byte) error {
+				i := bytes.LastIndex(key, []byte(dbDelimiter))
+				if i == -1 {
+					return nil
+				}
+				reqGroup := string(key[:i])
+				if opts.RepGroup != "" && !reqGroups[reqGroup] {
+					return nil
+				}
+				value, errc := strconv.Atoi(string(val))
+				if errc != nil {
+					return errc
+				}
+				counts.Stats++
+				return enc.Encode(&exportRecord{Type: exportTypeStat, Stat: stat, ReqGroup: reqGroup, Value: value})
+			})
+			if errf != nil {
+				return errf
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = gz.Close()
+	return counts, err
+}
+
+// sortedKeys returns the non-empty keys of the given map in sorted order.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ImportDB merges an archive created by ExportDB(), read from the given reader,
+// in to the database file at the given path (which is created if necessary).
+// The database must not be in use by a running server; the server will pick up
+// the imported live jobs when next started.
+//
+// Nothing already in the database is lost: live jobs are only added if they
+// are not already live or complete, complete jobs only replace existing ones
+// that ended earlier, and std output is only added for the live jobs that were
+// added. The necessary lookups are created for everything that is added.
+// Returns counts of what was added.
+func ImportDB(dbPath string, r io.Reader) (counts *ExportCounts, err error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(gz)
+	header := &exportHeader{}
+	err = dec.Decode(header)
+	if err != nil {
+		return nil, fmt.Errorf("could not read archive header: %s", err)
+	}
+	if header.Format != exportFormat {
+		return nil, fmt.Errorf("not a wr database export archive")
+	}
+	if header.Version < 1 || header.Version > ExportVersion {
+		return nil, fmt.Errorf("unsupported archive version %d (this wr supports up to version %d)", header.Version, ExportVersion)
+	}
+
+	boltdb, err := bolt.Open(dbPath, dbFilePermission, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s (is the manager using it?): %s", dbPath, err)
+	}
+	defer func() {
+		errc := boltdb.Close()
+		if err == nil {
+			err = errc
+		}
+	}()
+
+	err = boltdb.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketJobsLive, bucketJobsComplete, bucketRTK, bucketDTK, bucketRDTK, bucketEnvs, bucketStdO, bucketStdE, bucketJobMBs, bucketJobSecs, bucketJobDisk, bucketJobCores, bucketHistory, bucketHTH, bucketXTH, bucketFTH} {
+			_, errf := tx.CreateBucketIfNotExists(bucket)
+			if errf != nil {
+				return fmt.Errorf("create bucket %s: %s", bucket, errf)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	im := &importer{
+		ch:        new(codec.BincHandle),
+		counts:    &ExportCounts{},
+		addedLive: make(map[string]bool),
+	}
+	var recs []*exportRecord
+	for {
+		rec := &exportRecord{}
+		err = dec.Decode(rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return im.counts, err
+		}
+		recs = append(recs, rec)
+		if len(recs) == compactTxSize {
+			err = boltdb.Update(func(tx *bolt.Tx) error { return im.add(tx, recs) })
+			if err != nil {
+				return im.counts, err
+			}
+			recs = nil
+		}
+	}
+	err = boltdb.Update(func(tx *bolt.Tx) error { return im.add(tx, recs) })
+	return im.counts, err
+}
+
+// importer merges the records of an archive in to a database for ImportDB().
+type importer struct {
+	ch        codec.Handle
+	counts    *ExportCounts
+	addedLive map[string]bool
+}
+
+// add adds the given records to the database in the given transaction.
+func (im *importer) add(tx *bolt.Tx, recs []*exportRecord) error {
+	for _, rec := range recs {
+		var err error
+		switch rec.Type {
+		case exportTypeLive:
+			err = im.addLive(tx, rec)
+		case exportTypeComplete:
+			err = im.addComplete(tx, rec)
+		case exportTypeRun:
+			err = im.addRun(tx, rec)
+		case exportTypeEnv:
+			err = im.addEnv(tx, rec)
+		case exportTypeStd:
+			err = im.addStd(tx, rec)
+		case exportTypeStat:
+			err = im.addStat(tx, rec)
+		default:
+			err = fmt.Errorf("unknown record type '%s' in archive", rec.Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode binc encodes the given job.
+func (im *importer) encode(job *Job) ([]byte, error) {
+	var encoded []byte
+	err := codec.NewEncoderBytes(&encoded, im.ch).Encode(job)
+	return encoded, err
+}
+
+// jobKey returns the key of a job record, which should be in the record, but
+// we can calculate it if not.
+func jobKey(rec *exportRecord) []byte {
+	if rec.Key != "" {
+		return []byte(rec.Key)
+	}
+	return []byte(rec.Job.key())
+}
+
+// addLive adds a live job, unless it is already live or complete.
+func (im *importer) addLive(tx *bolt.Tx, rec *exportRecord) error {
+	if rec.Job == nil {
+		return fmt.Errorf("live job record has no job")
+	}
+	key := jobKey(rec)
+	b := tx.Bucket(bucketJobsLive)
+	if b.Get(key) != nil || tx.Bucket(bucketJobsComplete).Get(key) != nil {
+		return nil
+	}
+	encoded, err := im.encode(rec.Job)
+	if err != nil {
+		return err
+	}
+	err = b.Put(key, encoded)
+	if err != nil {
+		return err
+	}
+	im.addedLive[string(key)] = true
+	im.counts.Live++
+	return putJobLookups(tx, nil, key, rec.Job)
+}
+
+// addComplete adds a complete job, unless the same job completed later.
+func (im *importer) addComplete(tx *bolt.Tx, rec *exportRecord) error {
+	if rec.Job == nil {
+		return fmt.Errorf("complete job record has no job")
+	}
+	key := jobKey(rec)
+	b := tx.Bucket(bucketJobsComplete)
+	if existing := b.Get(key); existing != nil {
+		job := &Job{}
+		err := codec.NewDecoderBytes(existing, im.ch).Decode(job)
+		if err != nil {
+			return err
+		}
+		if !rec.Job.EndTime.After(job.EndTime) {
+			return nil
+		}
+	}
+	encoded, err := im.encode(rec.Job)
+	if err != nil {
+		return err
+	}
+	err = b.Put(key, encoded)
+	if err != nil {
+		return err
+	}
+	im.counts.Complete++
+	return putJobLookups(tx, nil, key, rec.Job)
+}
+
+// addRun adds a run to the history, unless it is already there.
+func (im *importer) addRun(tx *bolt.Tx, rec *exportRecord) error {
+	if rec.Job == nil {
+		return fmt.Errorf("run record has no job")
+	}
+	hr := newHistoryRecord(string(jobKey(rec)), rec.Job)
+	if hr == nil || tx.Bucket(bucketHistory).Get(hr.key) != nil {
+		return nil
+	}
+	encoded, err := im.encode(rec.Job)
+	if err != nil {
+		return err
+	}
+	im.counts.Runs++
+	return hr.put(tx, nil, encoded)
+}
+
+// addEnv adds an env, unless it is already there.
+func (im *importer) addEnv(tx *bolt.Tx, rec *exportRecord) error {
+	b := tx.Bucket(bucketEnvs)
+	key := []byte(rec.Key)
+	if b.Get(key) != nil {
+		return nil
+	}
+	var encoded []byte
+	err := codec.NewEncoderBytes(&encoded, im.ch).Encode(&envStr{rec.Env})
+	if err != nil {
+		return err
+	}
+	compressed, err := compress(encoded)
+	if err != nil {
+		return err
+	}
+	im.counts.Envs++
+	return b.Put(key, compressed)
+}
+
+// addStd adds the std output of a live job that we added.
+func (im *importer) addStd(tx *bolt.Tx, rec *exportRecord) error {
+	if !im.addedLive[rec.Key] {
+		return nil
+	}
+	key := []byte(rec.Key)
+	for _, std := range []struct {
+		bucket []byte
+		val    string
+	}{{bucketStdO, rec.StdOut}, {bucketStdE, rec.StdErr}} {
+		if std.val == "" {
+			continue
+		}
+		compressed, err := compress([]byte(std.val))
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(std.bucket).Put(key, compressed)
+		if err != nil {
+			return err
+		}
+	}
+	im.counts.Std++
+	return nil
+}
+
+// addStat adds a resource-learning stat.
+func (im *importer) addStat(tx *bolt.Tx, rec *exportRecord) error {
+	bucket, known := exportStatBuckets[rec.Stat]
+	if !known {
+		return fmt.Errorf("unknown stat '%s' in archive", rec.Stat)
+	}
+	b := tx.Bucket(bucket)
+	key := []byte(fmt.Sprintf("%s%s%20d", rec.ReqGroup, dbDelimiter, rec.Value))
+	if b.Get(key) != nil {
+		return nil
+	}
+	im.counts.Stats++
+	return b.Put(key, []byte(strconv.Itoa(rec.Value)))
+}
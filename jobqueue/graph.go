@@ -0,0 +1,230 @@
+// Copyright © 2016-2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for describing the dependency graph of jobs.
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// JobGraphEssence is the Via of a JobGraphEdge that was made because of
+	// a Dependency specified with an Essence, as opposed to a DepGroup.
+	JobGraphEssence = "essence"
+
+	// jobGraphMaxLabel is the maximum length of Cmd shown in the label of a
+	// node in DOT output.
+	jobGraphMaxLabel = 50
+)
+
+// jobGraphStateColours are the fill colours of nodes in DOT output, matching
+// the colours used for the different states in the web interface.
+var jobGraphStateColours = map[JobState]string{
+	JobStateDelayed:   "#f0ad4e",
+	JobStateDependent: "#f0ad4e",
+	JobStateReady:     "#5bc0de",
+	JobStateRunning:   "#337ab7",
+	JobStateReserved:  "#337ab7",
+	JobStateLost:      "#d9534f",
+	JobStateBuried:    "#d9534f",
+	JobStateComplete:  "#5cb85c",
+}
+
+// JobGraph describes the dependency graph (a DAG) of the jobs in a RepGroup,
+// along with all the jobs upstream of them, even if those are in other
+// RepGroups. You get these from Client.GetGraph() or the REST API.
+type JobGraph struct {
+	RepGroup string          `json:"rep_grp"`
+	Nodes    []*JobGraphNode `json:"nodes"`
+	Edges    []*JobGraphEdge `json:"edges"`
+}
+
+// JobGraphNode describes a Job in a JobGraph. Key is the same value you'd get
+// from the Job's ToEssence().JobKey.
+type JobGraphNode struct {
+	Key       string   `json:"key"`
+	Cmd       string   `json:"cmd"`
+	RepGroup  string   `json:"rep_grp"`
+	DepGroups []string `json:"dep_grps"`
+	State     JobState `json:"state"`
+}
+
+// JobGraphEdge describes a dependency in a JobGraph: the job with the key To
+// depends on the job with the key From. Via is the DepGroup that made the
+// dependency, or JobGraphEssence if the dependency was specified by the Cmd
+// (and Cwd) of the From job. Resolved is true if the From job is complete.
+type JobGraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Via      string `json:"via"`
+	Resolved bool   `json:"resolved"`
+}
+
+// Unresolved returns the keys of the nodes that the node with the given key is
+// still waiting on: those at the end of its unresolved edges, and recursively
+// those they are waiting on in turn.
+func (g *JobGraph) Unresolved(key string) []string {
+	var keys []string
+	seen := map[string]bool{key: true}
+	todo := []string{key}
+	for len(todo) > 0 {
+		var next []string
+		for _, to := range todo {
+			for _, edge := range g.Edges {
+				if edge.To != to || edge.Resolved || seen[edge.From] {
+					continue
+				}
+				seen[edge.From] = true
+				keys = append(keys, edge.From)
+				next = append(next, edge.From)
+			}
+		}
+		todo = next
+	}
+	return keys
+}
+
+// DOT returns a description of the graph in the DOT language, suitable for
+// rendering with Graphviz. Nodes are coloured by state, nodes from other
+// RepGroups have a dashed outline, and resolved edges are dashed.
+func (g *JobGraph) DOT() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "digraph %s {\n", strconv.Quote(g.RepGroup))
+	buf.WriteString("\tnode [shape=box, style=filled];\n")
+	for _, node := range g.Nodes {
+		cmd := node.Cmd
+		if len(cmd) > jobGraphMaxLabel {
+			cmd = cmd[0:jobGraphMaxLabel-3] + "..."
+		}
+		colour, known := jobGraphStateColours[node.State]
+		if !known {
+			colour = "#ffffff"
+		}
+		style := "filled"
+		if node.RepGroup != g.RepGroup {
+			style = "filled,dashed"
+		}
+		fmt.Fprintf(&buf, "\t%s [label=%s, fillcolor=%s, style=%s];\n", strconv.Quote(node.Key), strconv.Quote(cmd+"\n"+string(node.State)), strconv.Quote(colour), strconv.Quote(style))
+	}
+	for _, edge := range g.Edges {
+		style := "solid"
+		if edge.Resolved {
+			style = "dashed"
+		}
+		fmt.Fprintf(&buf, "\t%s -> %s [label=%s, style=%s];\n", strconv.Quote(edge.From), strconv.Quote(edge.To), strconv.Quote(edge.Via), style)
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// getJobGraph builds the JobGraph for the jobs (current and complete) in the
+// given RepGroup, following their Dependencies upstream until we reach jobs
+// with no dependencies.
+func (s *Server) getJobGraph(repgroup string) (graph *JobGraph, srerr string, qerr string) {
+	jobs, srerr, qerr := s.getJobsByRepGroup(repgroup, 0, "", false, false)
+	if srerr != "" {
+		return nil, srerr, qerr
+	}
+
+	graph = &JobGraph{RepGroup: repgroup, Nodes: []*JobGraphNode{}, Edges: []*JobGraphEdge{}}
+	nodes := make(map[string]*JobGraphNode)
+	edges := make(map[string]bool)
+	depGroupKeys := make(map[string][]string)
+	for len(jobs) > 0 {
+		var upstream []string
+		for _, job := range jobs {
+			key := job.key()
+			if _, done := nodes[key]; done {
+				continue
+			}
+			node := &JobGraphNode{
+				Key:       key,
+				Cmd:       job.Cmd,
+				RepGroup:  job.RepGroup,
+				DepGroups: job.DepGroups,
+				State:     job.State,
+			}
+			nodes[key] = node
+			graph.Nodes = append(graph.Nodes, node)
+
+			for _, dep := range job.Dependencies {
+				var keys []string
+				var via string
+				if dep.DepGroup != "" {
+					via = dep.DepGroup
+					var cached bool
+					keys, cached = depGroupKeys[via]
+					if !cached {
+						var err error
+						keys, err = s.db.retrieveJobKeysByDepGroup(via)
+						if err != nil {
+							return nil, ErrDBError, err.Error()
+						}
+						depGroupKeys[via] = keys
+					}
+				} else if dep.Essence != nil {
+					via = JobGraphEssence
+					keys = []string{dep.Essence.Key()}
+				}
+
+				for _, from := range keys {
+					if from == key {
+						continue
+					}
+					edgeKey := from + dbDelimiter + key + dbDelimiter + via
+					if edges[edgeKey] {
+						continue
+					}
+					edges[edgeKey] = true
+					graph.Edges = append(graph.Edges, &JobGraphEdge{From: from, To: key, Via: via})
+					if _, done := nodes[from]; !done {
+						upstream = append(upstream, from)
+					}
+				}
+			}
+		}
+
+		jobs = nil
+		if len(upstream) > 0 {
+			jobs, srerr, qerr = s.getJobsByKeys(upstream, false, false)
+			if srerr != "" {
+				return nil, srerr, qerr
+			}
+		}
+	}
+
+	// drop edges to jobs that don't exist (an Essence dependency on a job that
+	// was never added is ignored by the queue), and mark the rest as resolved
+	// if the job we depend on is complete
+	kept := []*JobGraphEdge{}
+	for _, edge := range graph.Edges {
+		from, exists := nodes[edge.From]
+		if !exists {
+			continue
+		}
+		edge.Resolved = from.State == JobStateComplete
+		kept = append(kept, edge)
+	}
+	graph.Edges = kept
+
+	return graph, srerr, qerr
+}
@@ -0,0 +1,136 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for searching the permanent history of the jobs
+// that have finished running.
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// HistoryQuery describes the runs of jobs you want to find in the history of
+// jobs that have finished running, for use with Client.History(). Every run of
+// a job that exits (successfully or not) is recorded in the history, so a job
+// that failed twice and then completed will have 3 runs in the history.
+//
+// All the criteria you set must be met for a run to be returned; unset
+// criteria match every run.
+type HistoryQuery struct {
+	// From and To restrict the runs to those that ended at or after From, and
+	// at or before To.
+	From time.Time
+	To   time.Time
+
+	// Cmd restricts the runs to those of jobs with a Cmd that contains this
+	// substring, or if CmdRegex is true, to those with a Cmd that matches this
+	// regular expression.
+	Cmd      string
+	CmdRegex bool
+
+	// RepGroup restricts the runs to those of jobs with this RepGroup.
+	RepGroup string
+
+	// Host restricts the runs to those that happened on this host.
+	Host string
+
+	// Exitcode, if not nil, restricts the runs to those that exited with this
+	// exit code.
+	Exitcode *int
+
+	// FailReason restricts the runs to those that failed for this reason (one
+	// of the FailReason* constants).
+	FailReason string
+
+	// Failed restricts the runs to those that failed, ie. that had a non-zero
+	// exit code or a FailReason.
+	Failed bool
+
+	// Offset skips this many of the matching runs, and Limit (if greater than
+	// 0) returns no more than this many of them, for paging through very many
+	// results. Runs are ordered most recently ended first.
+	Offset int
+	Limit  int
+
+	re *regexp.Regexp
+}
+
+// validate checks the query makes sense and compiles its Cmd if CmdRegex is
+// true.
+func (q *HistoryQuery) validate() error {
+	if q.Offset < 0 || q.Limit < 0 {
+		return fmt.Errorf("offset and limit can't be negative")
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return fmt.Errorf("the end of the time range is before its start")
+	}
+	if q.CmdRegex {
+		re, err := regexp.Compile(q.Cmd)
+		if err != nil {
+			return err
+		}
+		q.re = re
+	}
+	return nil
+}
+
+// matches tells you if the given job (as stored in the history) meets the
+// query's criteria, other than its time range, which is dealt with by
+// db.retrieveHistory().
+func (q *HistoryQuery) matches(job *Job) bool {
+	if q.RepGroup != "" && job.RepGroup != q.RepGroup {
+		return false
+	}
+	if q.Host != "" && job.Host != q.Host {
+		return false
+	}
+	if q.Exitcode != nil && job.Exitcode != *q.Exitcode {
+		return false
+	}
+	if q.FailReason != "" && job.FailReason != q.FailReason {
+		return false
+	}
+	if q.Failed && job.Exitcode == 0 && job.FailReason == "" {
+		return false
+	}
+	if q.Cmd != "" {
+		if q.re != nil {
+			return q.re.MatchString(job.Cmd)
+		}
+		return strings.Contains(job.Cmd, q.Cmd)
+	}
+	return true
+}
+
+// getJobHistory gets the runs of jobs that match the given query from the
+// permanent store, most recent first.
+func (s *Server) getJobHistory(q *HistoryQuery) (jobs []*Job, srerr string, qerr string) {
+	err := q.validate()
+	if err != nil {
+		return nil, ErrBadRequest, err.Error()
+	}
+	jobs, err = s.db.retrieveHistory(q)
+	if err != nil {
+		return nil, ErrDBError, err.Error()
+	}
+	return jobs, "", ""
+}
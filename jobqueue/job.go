@@ -34,6 +34,7 @@ import (
 	"github.com/VertebrateResequencing/muxfys"
 	"github.com/VertebrateResequencing/wr/jobqueue/scheduler"
 	"github.com/VertebrateResequencing/wr/queue"
+	"github.com/VertebrateResequencing/wr/rp"
 	"github.com/hashicorp/go-multierror"
 	"github.com/satori/go.uuid"
 	"github.com/ugorji/go/codec"
@@ -128,7 +129,8 @@ type Job struct {
 	// Override determines if your own supplied Requirements get used, or if the
 	// systems' calculated values get used. 0 means prefer the system values. 1
 	// means prefer your values if they are higher. 2 means always use your
-	// values.
+	// values. The system learns RAM, Time and Cores from past runs, and Disk
+	// from past runs of Jobs with CwdMatters false.
 	Override uint8
 
 	// Priority is a number between 0 and 255 inclusive - higher numbered jobs
@@ -142,6 +144,13 @@ type Job struct {
 	// can refer to in their Dependencies.
 	DepGroups []string
 
+	// LimitGroups are the names of the limit groups this job belongs to. If a
+	// limit has been set for a group (see Client.SetLimitGroup()), no more than
+	// its Max jobs in the group will run at once, and they will start no closer
+	// together than its Delay; jobs over the limit remain ready until there is
+	// capacity. Groups that have no limit set do not restrict anything.
+	LimitGroups []string
+
 	// Dependencies describe the jobs that must be complete before this job
 	// starts.
 	Dependencies Dependencies
@@ -166,6 +175,68 @@ type Job struct {
 	// ActualCwd.
 	MountConfigs MountConfigs
 
+	// Inputs and Outputs are the paths of the files that Cmd reads and writes.
+	// Relative paths are relative to Cwd if CwdMatters, otherwise to the
+	// actual working directory, so can refer to files in remote file systems
+	// mounted there by MountConfigs. The Cleanup Behaviour won't delete
+	// Outputs, and if Cmd exits 0 but any Outputs don't exist afterwards, the
+	// Job fails with FailReasonOutputs.
+	Inputs  []string
+	Outputs []string
+
+	// SkipIfUpToDate, make-style, has Execute() mark the Job as complete
+	// without running Cmd if all its Outputs already exist and none of them
+	// are older than any of its Inputs (which must also all exist). It has no
+	// effect if no Outputs have been declared.
+	SkipIfUpToDate bool
+
+	// StdCapture, if set to a local directory (which, if relative, is
+	// relative to Cwd), or an S3 location specified like
+	// s3://[profile@]bucket/path, has Execute() write the complete STDOUT and
+	// STDERR of Cmd to files there, named after the Job's key, in addition to
+	// keeping the truncated versions you get from StdOut() and StdErr(). For
+	// the manager to be able to return ranges of these files (see
+	// Client.GetCapturedStd()), a local directory must be on a file system
+	// that the manager can also access. StdCompress has the files gzip
+	// compressed.
+	StdCapture  string
+	StdCompress bool
+
+	// KillGrace is how long Execute() waits after sending SIGTERM to Cmd and
+	// all the processes it spawned, when it has to kill them (because the Job
+	// was killed, used too much memory, or the runner was signalled), before
+	// sending them SIGKILL. If 0, ClientKillGrace is used.
+	KillGrace time.Duration
+
+	// TimeLimit, if greater than 0, is a hard limit on how long Cmd can run
+	// for: Execute() kills it (as described for KillGrace) once it has run
+	// for this long, and the Job fails with FailReasonTime, which increases
+	// its Requirements.Time for the next attempt. Alternatively, or in
+	// addition, TimeLimitMult, if greater than 0, sets the limit to this
+	// multiple of Requirements.Time (so the limit increases along with it).
+	// If both are set, the larger limit applies. (An absolute TimeLimit does
+	// not increase, so if it is too short the Job will be buried once it runs
+	// out of Retries.) Without a limit, Cmd is allowed to run for as long as
+	// it likes (unless the job scheduler kills it).
+	TimeLimit     time.Duration
+	TimeLimitMult float64
+
+	// RetryPolicy, if set, changes which failures are retried (within the
+	// limit of Retries), how long to wait before retrying, and where.
+	RetryPolicy *RetryPolicy
+
+	// ArrayParams, if set, turns this Job in to a template for a job array: when
+	// added to the queue, it is expanded in to one Job for every combination
+	// (the cartesian product) of the parameter values, with "{{name}}"
+	// placeholders in Cmd, Cwd, DepGroups, LimitGroups, Dependencies, Inputs
+	// and Outputs replaced with the corresponding value, and "{{index}}"
+	// replaced with the ArrayIndex. Every parameter must be used in Cmd (or Cwd
+	// if CwdMatters), so that the expanded Jobs are unique. The expanded Jobs
+	// all share this Job's RepGroup, and each get this Job's LimitGroups (so
+	// unless those use placeholders, their limits apply to the elements
+	// together).
+	ArrayParams ArrayParams
+
 	// The remaining properties are used to record information about what
 	// happened when Cmd was executed, or otherwise provide its current state.
 	// It is meaningless to set these yourself.
@@ -175,6 +246,9 @@ type Job struct {
 	ActualCwd string
 	// peak RAM (MB) used.
 	PeakRAM int
+	// peak disk space (MB) used in ActualCwd and TMPDIR; only measured if
+	// CwdMatters is false.
+	PeakDisk int
 	// true if the Cmd was run and exited.
 	Exited bool
 	// if the job ran and exited, its exit code is recorded here, but check
@@ -187,6 +261,9 @@ type Job struct {
 	FailReason string
 	// pid of the running or ran process.
 	Pid int
+	// true if Cmd was not run because its Outputs were up to date (see
+	// SkipIfUpToDate).
+	Skipped bool
 	// host the process is running or did run on.
 	Host string
 	// host id the process is running or did run on (cloud specific).
@@ -199,6 +276,10 @@ type Job struct {
 	EndTime time.Time
 	// CPU time used.
 	CPUtime time.Duration
+	// bytes read from and written to block devices by the cmd; only known if
+	// it ran in its own cgroup (see Client.Execute()).
+	IORead  uint64
+	IOWrite uint64
 	// to read, call job.StdErr() instead; if the job ran, its (truncated)
 	// STDERR will be here.
 	StdErrC []byte
@@ -216,6 +297,8 @@ type Job struct {
 	State JobState
 	// number of times the job had ever entered 'running' state.
 	Attempts uint32
+	// the outcomes of the most recent times Cmd was run, oldest first.
+	AttemptHistory []*JobAttempt
 	// remaining number of Release()s allowed before being buried instead.
 	UntilBuried uint8
 	// we note which client reserved this job, for validating if that client has
@@ -228,6 +311,16 @@ type Job struct {
 	// when retrieving jobs with a limit, this tells you how many jobs were
 	// excluded.
 	Similar int
+	// if this job was created by expanding a job array (see ArrayParams), this
+	// is its 1-based position within the array.
+	ArrayIndex int
+	// if StdCapture was set, the locations of the files holding the complete
+	// STDOUT and STDERR of the Cmd.
+	CapturedStdOut string
+	CapturedStdErr string
+	// files copied to the manager by CopyToManager Behaviours, as paths
+	// relative to the server's CopyDir (see ServerInfo).
+	CopiedFiles []string
 
 	// we add this internally to match up runners we spawn via the scheduler to
 	// the Jobs they're allowed to ReserveFiltered().
@@ -241,9 +334,21 @@ type Job struct {
 	// later; this is purely client side
 	mountedFS []*muxfys.MuxFys
 
+	// we store the Client that is Execute()ing this job so that CopyToManager
+	// Behaviours can send files back to the server; this is purely client side
+	client *Client
+
 	// killCalled is set for running jobs if Kill() is called on them
 	killCalled bool
 
+	// the server uses this to avoid having jobs reserved on hosts they failed
+	// on, if their RetryPolicy prefers them to retry on a different host.
+	avoidHostsUntil time.Time
+
+	// the server uses this to track the limit group tokens it granted this job
+	// when it was reserved, keyed on limit group name.
+	limitReceipts map[string]rp.Receipt
+
 	sync.RWMutex
 }
 
@@ -379,6 +484,70 @@ func (j *Job) TriggerBehaviours(success bool) error {
 	return j.Behaviours.Trigger(success, j)
 }
 
+// FilePath returns the absolute path of one of the Job's Inputs or Outputs.
+// Relative paths are treated as relative to the actual working directory (or
+// Cwd if the Job hasn't run yet or CwdMatters).
+func (j *Job) FilePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	dir := j.ActualCwd
+	if dir == "" {
+		dir = j.Cwd
+	}
+	return filepath.Join(dir, path)
+}
+
+// missingOutputs returns those of the Job's Outputs that do not exist.
+func (j *Job) missingOutputs() []string {
+	var missing []string
+	for _, path := range j.Outputs {
+		if _, err := os.Stat(j.FilePath(path)); err != nil {
+			missing = append(missing, path)
+		}
+	}
+	return missing
+}
+
+// outputsUpToDate returns true if the Job has Outputs that all exist and are
+// at least as new as all of its Inputs (which must all exist).
+func (j *Job) outputsUpToDate() bool {
+	if len(j.Outputs) == 0 {
+		return false
+	}
+	var newestInput time.Time
+	for _, path := range j.Inputs {
+		info, err := os.Stat(j.FilePath(path))
+		if err != nil {
+			return false
+		}
+		if info.ModTime().After(newestInput) {
+			newestInput = info.ModTime()
+		}
+	}
+	for _, path := range j.Outputs {
+		info, err := os.Stat(j.FilePath(path))
+		if err != nil || info.ModTime().Before(newestInput) {
+			return false
+		}
+	}
+	return true
+}
+
+// outputsWithin returns those of the Job's Outputs that are inside the given
+// directory, as paths relative to it.
+func (j *Job) outputsWithin(dir string) []string {
+	var within []string
+	for _, path := range j.Outputs {
+		rel, err := filepath.Rel(dir, j.FilePath(path))
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		within = append(within, rel)
+	}
+	return within
+}
+
 // Mount uses the Job's MountConfigs to mount the remote file systems at the
 // desired mount points. If a mount point is unspecified, mounts in the sub
 // folder Cwd/mnt if CwdMatters (and unspecified CacheBase becomes Cwd),
@@ -575,11 +744,22 @@ func (j *Job) updateAfterExit(jes *JobEndState) {
 	j.Exited = true
 	j.Exitcode = jes.Exitcode
 	j.PeakRAM = jes.PeakRAM
+	j.PeakDisk = jes.PeakDisk
 	j.CPUtime = jes.CPUtime
+	j.IORead = jes.IORead
+	j.IOWrite = jes.IOWrite
 	j.EndTime = time.Now()
 	if jes.Cwd != "" {
 		j.ActualCwd = jes.Cwd
 	}
+	j.Skipped = jes.Skipped
+	if jes.CapturedStdOut != "" {
+		j.CapturedStdOut = jes.CapturedStdOut
+		j.CapturedStdErr = jes.CapturedStdErr
+	}
+	if jes.Skipped && j.StartTime.IsZero() {
+		j.StartTime = j.EndTime
+	}
 	j.Unlock()
 }
 
@@ -608,6 +788,18 @@ func (j *Job) updateRecsAfterFailure() {
 	}
 }
 
+// timeLimit returns the hard limit on how long the Job's Cmd can run for, as
+// determined by TimeLimit and TimeLimitMult, or 0 if there is no limit.
+func (j *Job) timeLimit() time.Duration {
+	limit := j.TimeLimit
+	if j.TimeLimitMult > 0 && j.Requirements != nil {
+		if mult := time.Duration(float64(j.Requirements.Time) * j.TimeLimitMult); mult > limit {
+			limit = mult
+		}
+	}
+	return limit
+}
+
 // key calculates a unique key to describe the job.
 func (j *Job) key() string {
 	if j.CwdMatters {
@@ -616,6 +808,12 @@ func (j *Job) key() string {
 	return byteKey([]byte(fmt.Sprintf("%s.%s", j.Cmd, j.MountConfigs.Key())))
 }
 
+// ToEssence converts a Job to its matching JobEssence, for when you have a Job
+// (eg. from GetByRepGroup()) and want to Kick(), Delete() or Kill() it.
+func (j *Job) ToEssence() *JobEssence {
+	return &JobEssence{JobKey: j.key()}
+}
+
 // getScheduledRunner provides a thread-safe way of getting the scheduledRunner
 // property of a Job.
 func (j *Job) getScheduledRunner() bool {
@@ -690,3 +888,139 @@ func (j *JobEssence) Stringify() string {
 	}
 	return out
 }
+
+// JobModifier describes changes you want to make to existing incomplete, non-
+// running Jobs, for use with Client.Modify(). Properties left nil (or, for
+// those with a corresponding *Set bool, with that bool false) are not changed.
+// RepGroup is not changed if left as an empty string. Properties that
+// contribute to a Job's key (Cmd, Cwd, CwdMatters and MountConfigs) can not be
+// modified; remove the Job and add it again instead.
+type JobModifier struct {
+	// RAM is the number of Megabytes the Job's Cmd will need.
+	RAM *int
+
+	// Time is how long the Job's Cmd will take to run.
+	Time *time.Duration
+
+	// Cores is the number of CPU cores the Job's Cmd will use.
+	Cores *int
+
+	// Disk is the number of Gigabytes of disk space the Job's Cmd will use.
+	Disk *int
+
+	// Other values are set in the Job's Requirements.Other if OtherSet is
+	// true, replacing any existing values for the same keys; keys with empty
+	// values are removed.
+	Other    map[string]string
+	OtherSet bool
+
+	Override *uint8
+	Priority *uint8
+	Retries  *uint8
+	RepGroup string
+
+	// DepGroups replaces the Job's DepGroups if DepGroupsSet is true.
+	DepGroups    []string
+	DepGroupsSet bool
+
+	// Dependencies replaces the Job's Dependencies if DependenciesSet is true.
+	Dependencies    Dependencies
+	DependenciesSet bool
+
+	// LimitGroups replaces the Job's LimitGroups if LimitGroupsSet is true.
+	LimitGroups    []string
+	LimitGroupsSet bool
+
+	// EnvOverride replaces the Job's EnvOverride if EnvOverrideSet is true.
+	// Use SetEnvOverride() to set this.
+	EnvOverride    []byte
+	EnvOverrideSet bool
+}
+
+// SetEnvOverride sets the environment variables (as key=value strings) that
+// will replace any existing overrides of the Job's environment.
+func (jm *JobModifier) SetEnvOverride(env []string) error {
+	var err error
+	if len(env) > 0 {
+		jm.EnvOverride, err = compressEnv(env)
+	} else {
+		jm.EnvOverride = nil
+	}
+	jm.EnvOverrideSet = err == nil
+	return err
+}
+
+// modify applies our changes to the given Job, which you should have Lock()ed.
+// Returns true if the Job's Requirements changed.
+func (jm *JobModifier) modify(j *Job) bool {
+	reqsChanged := false
+	if jm.RAM != nil || jm.Time != nil || jm.Cores != nil || jm.Disk != nil || jm.OtherSet {
+		// copy reqs since clients may have been given a reference to the old
+		// ones
+		req := &scheduler.Requirements{}
+		*req = *j.Requirements
+		if jm.RAM != nil {
+			req.RAM = *jm.RAM
+		}
+		if jm.Time != nil {
+			req.Time = *jm.Time
+		}
+		if jm.Cores != nil {
+			req.Cores = *jm.Cores
+		}
+		if jm.Disk != nil {
+			req.Disk = *jm.Disk
+		}
+		if jm.OtherSet {
+			other := make(map[string]string)
+			for key, val := range j.Requirements.Other {
+				other[key] = val
+			}
+			for key, val := range jm.Other {
+				if val == "" {
+					delete(other, key)
+				} else {
+					other[key] = val
+				}
+			}
+			req.Other = other
+		}
+		j.Requirements = req
+		reqsChanged = true
+	}
+	if jm.Override != nil {
+		j.Override = *jm.Override
+	}
+	if jm.Priority != nil {
+		j.Priority = *jm.Priority
+	}
+	if jm.Retries != nil {
+		// keep the number of attempts already used up, but don't let a non-
+		// buried job become buried by lowering Retries
+		used := int(j.Retries) + 1 - int(j.UntilBuried)
+		ub := int(*jm.Retries) + 1 - used
+		if ub < 1 && j.UntilBuried > 0 {
+			ub = 1
+		} else if ub < 0 {
+			ub = 0
+		}
+		j.Retries = *jm.Retries
+		j.UntilBuried = uint8(ub)
+	}
+	if jm.RepGroup != "" {
+		j.RepGroup = jm.RepGroup
+	}
+	if jm.DepGroupsSet {
+		j.DepGroups = jm.DepGroups
+	}
+	if jm.DependenciesSet {
+		j.Dependencies = jm.Dependencies
+	}
+	if jm.LimitGroupsSet {
+		j.LimitGroups = jm.LimitGroups
+	}
+	if jm.EnvOverrideSet {
+		j.EnvOverride = jm.EnvOverride
+	}
+	return reqsChanged
+}
@@ -19,12 +19,16 @@
 package jobqueue
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -95,10 +99,19 @@ func TestJobqueue(t *testing.T) {
 		SchedulerConfig: &jqs.ConfigLocal{Shell: config.RunnerExecShell},
 		DBFile:          config.ManagerDbFile,
 		DBFileBackup:    managerDBBkFile,
+		TokenFile:       config.ManagerTokenFile,
+		CAFile:          config.ManagerCAFile,
+		CertFile:        config.ManagerCertFile,
+		KeyFile:         config.ManagerKeyFile,
+		CertDomain:      config.ManagerCertDomain,
 		Deployment:      config.Deployment,
 		Logger:          testLogger,
 	}
 	addr := "localhost:" + config.ManagerPort
+	token, err := internal.ReadOrCreateToken(config.ManagerTokenFile)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	ServerInterruptTime = 10 * time.Millisecond
 	ServerReserveTicker = 10 * time.Millisecond
@@ -173,7 +186,7 @@ func TestJobqueue(t *testing.T) {
 		}
 		// parent; wait a while for our child to bring up the server
 		defer syscall.Kill(child.Pid, syscall.SIGTERM)
-		jq, err := Connect(addr, 10*time.Second)
+		jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, 10*time.Second)
 		So(err, ShouldBeNil)
 		defer jq.Disconnect()
 
@@ -233,7 +246,7 @@ func TestJobqueue(t *testing.T) {
 				So(<-j1worked, ShouldBeTrue)
 				So(<-j2worked, ShouldBeTrue)
 
-				jq2, err := Connect(addr, clientConnectTime)
+				jq2, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 				defer jq2.Disconnect()
 				job, err = jq2.GetByEssence(&JobEssence{Cmd: cmd}, false, false)
@@ -273,7 +286,7 @@ func TestJobqueue(t *testing.T) {
 	var server *Server
 	var errs error
 	Convey("Without the jobserver being up, clients can't connect and time out", t, func() {
-		_, err := Connect(addr, clientConnectTime)
+		_, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 		So(err, ShouldNotBeNil)
 		jqerr, ok := err.(Error)
 		So(ok, ShouldBeTrue)
@@ -286,8 +299,22 @@ func TestJobqueue(t *testing.T) {
 
 		server.rc = `echo %s %s %s %d %d` // ReserveScheduled() only works if we have an rc
 
+		Convey("You can't connect without the correct token and CA certificate", func() {
+			_, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, []byte("wrong"), clientConnectTime)
+			So(err, ShouldNotBeNil)
+			jqerr, ok := err.(Error)
+			So(ok, ShouldBeTrue)
+			So(jqerr.Err, ShouldEqual, ErrWrongToken)
+
+			_, err = Connect(addr, config.ManagerCAFile, "wrong.domain", token, clientConnectTime)
+			So(err, ShouldNotBeNil)
+			jqerr, ok = err.(Error)
+			So(ok, ShouldBeTrue)
+			So(jqerr.Err, ShouldEqual, ErrNoServer)
+		})
+
 		Convey("You can connect to the server and add jobs to the queue", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -380,6 +407,42 @@ func TestJobqueue(t *testing.T) {
 				So(rtime, ShouldEqual, 10800)
 			})
 
+			Convey("You can store their (fake) disk and cpu stats and get recommendations for those as well", func() {
+				rdisk, err := server.db.recommendedReqGroupDisk("fake_group")
+				So(err, ShouldBeNil)
+				So(rdisk, ShouldEqual, 0)
+				rcores, err := server.db.recommendedReqGroupCores("fake_group")
+				So(err, ShouldBeNil)
+				So(rcores, ShouldEqual, 0)
+
+				for index, job := range jobs {
+					job.Exited = true
+					job.PeakDisk = (index + 1) * 1000
+					job.StartTime = time.Now()
+					job.EndTime = job.StartTime.Add(10 * time.Second)
+					job.CPUtime = time.Duration(index+1) * 5 * time.Second
+					server.db.updateJobAfterExit(job, []byte{}, []byte{}, false)
+				}
+				<-time.After(100 * time.Millisecond)
+				rdisk, err = server.db.recommendedReqGroupDisk("fake_group")
+				So(err, ShouldBeNil)
+				So(rdisk, ShouldEqual, 5)
+				rcores, err = server.db.recommendedReqGroupCores("fake_group")
+				So(err, ShouldBeNil)
+				So(rcores, ShouldEqual, 3)
+
+				job := &Job{Cmd: "test cmd cwd_matters", Cwd: "/fake/cwd", CwdMatters: true, ReqGroup: "fake_group", Requirements: &jqs.Requirements{RAM: 1024, Time: 4 * time.Hour, Cores: 1}, Retries: uint8(3), RepGroup: "manually_added"}
+				job.Exited = true
+				job.PeakDisk = 100000
+				job.StartTime = time.Now()
+				job.EndTime = job.StartTime.Add(10 * time.Second)
+				server.db.updateJobAfterExit(job, []byte{}, []byte{}, false)
+				<-time.After(100 * time.Millisecond)
+				rdisk, err = server.db.recommendedReqGroupDisk("fake_group")
+				So(err, ShouldBeNil)
+				So(rdisk, ShouldEqual, 5)
+			})
+
 			Convey("You can reserve jobs from the queue in the correct order", func() {
 				for i := 9; i >= 0; i-- {
 					jid := i
@@ -429,7 +492,7 @@ func TestJobqueue(t *testing.T) {
 									ticks++
 									if ticks == 2 {
 										jobs = append(jobs, &Job{Cmd: "new", Cwd: "/fake/cwd", ReqGroup: "add_group", Requirements: &jqs.Requirements{RAM: 1024, Time: 5 * time.Hour, Cores: 1}, Retries: uint8(3), RepGroup: "manually_added"})
-										gojq, _ := Connect(addr, clientConnectTime)
+										gojq, _ := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 										defer gojq.Disconnect()
 										gojq.Add(jobs, envVars, true)
 									}
@@ -587,7 +650,7 @@ func TestJobqueue(t *testing.T) {
 				syscall.Kill(os.Getpid(), syscall.SIGTERM)
 				<-time.After(ClientTouchInterval)
 				<-time.After(ClientTouchInterval)
-				_, err := Connect(addr, clientConnectTime)
+				_, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldNotBeNil)
 				jqerr, ok := err.(Error)
 				So(ok, ShouldBeTrue)
@@ -596,14 +659,14 @@ func TestJobqueue(t *testing.T) {
 				server, _, errs = Serve(serverConfig)
 				So(errs, ShouldBeNil)
 
-				jq, err = Connect(addr, clientConnectTime)
+				jq, err = Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 				jq.Disconnect()
 
 				syscall.Kill(os.Getpid(), syscall.SIGINT)
 				<-time.After(ClientTouchInterval)
 				<-time.After(ClientTouchInterval)
-				_, err = Connect(addr, clientConnectTime)
+				_, err = Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldNotBeNil)
 				jqerr, ok = err.(Error)
 				So(ok, ShouldBeTrue)
@@ -638,10 +701,10 @@ func TestJobqueue(t *testing.T) {
 		So(errs, ShouldBeNil)
 
 		Convey("You can connect, and add some real jobs", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
-			jq2, err := Connect(addr, clientConnectTime)
+			jq2, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq2.Disconnect()
 
@@ -662,6 +725,205 @@ func TestJobqueue(t *testing.T) {
 				jq.Disconnect()
 			})
 
+			Convey("The history of jobs that finished running can be searched", func() {
+				start := time.Now()
+				job, err := jq.Reserve(50 * time.Millisecond)
+				So(err, ShouldBeNil)
+				So(job.Cmd, ShouldEqual, "sleep 0.1 && true")
+				err = jq.Execute(job, config.RunnerExecShell)
+				So(err, ShouldBeNil)
+				job, err = jq.Reserve(50 * time.Millisecond)
+				So(err, ShouldBeNil)
+				So(job.Cmd, ShouldEqual, "sleep 0.1 && false")
+				err = jq.Execute(job, config.RunnerExecShell)
+				So(err, ShouldNotBeNil)
+
+				// failed runs are stored in the background
+				var runs []*Job
+				for i := 0; i < 100; i++ {
+					runs, err = jq.History(&HistoryQuery{})
+					if err != nil || len(runs) == 2 {
+						break
+					}
+					<-time.After(10 * time.Millisecond)
+				}
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 2)
+				So(runs[0].Cmd, ShouldEqual, "sleep 0.1 && false")
+				So(runs[0].Exitcode, ShouldEqual, 1)
+				So(runs[1].Cmd, ShouldEqual, "sleep 0.1 && true")
+				So(runs[1].Exitcode, ShouldEqual, 0)
+				So(runs[1].EndTime, ShouldHappenBefore, runs[0].EndTime)
+
+				runs, err = jq.History(&HistoryQuery{Failed: true})
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 1)
+				So(runs[0].Cmd, ShouldEqual, "sleep 0.1 && false")
+
+				zero := 0
+				runs, err = jq.History(&HistoryQuery{Exitcode: &zero})
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 1)
+				So(runs[0].Cmd, ShouldEqual, "sleep 0.1 && true")
+
+				host, _ := os.Hostname()
+				runs, err = jq.History(&HistoryQuery{Host: host, RepGroup: "manually_added"})
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 2)
+				runs, err = jq.History(&HistoryQuery{Host: "not" + host})
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 0)
+
+				runs, err = jq.History(&HistoryQuery{Cmd: "&& true"})
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 1)
+				runs, err = jq.History(&HistoryQuery{Cmd: `^sleep [\d.]+ && (true|false)$`, CmdRegex: true})
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 2)
+				_, err = jq.History(&HistoryQuery{Cmd: "(", CmdRegex: true})
+				So(err, ShouldNotBeNil)
+				jqerr, ok := err.(Error)
+				So(ok, ShouldBeTrue)
+				So(jqerr.Err, ShouldEqual, ErrBadRequest)
+
+				runs, err = jq.History(&HistoryQuery{From: start, To: time.Now()})
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 2)
+				runs, err = jq.History(&HistoryQuery{From: time.Now()})
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 0)
+				runs, err = jq.History(&HistoryQuery{To: start})
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 0)
+
+				runs, err = jq.History(&HistoryQuery{Offset: 1, Limit: 1})
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 1)
+				So(runs[0].Cmd, ShouldEqual, "sleep 0.1 && true")
+			})
+
+			Convey("Old complete jobs can be pruned according to a RetentionPolicy, and the database compacted", func() {
+				_, err := NewRetentionPolicy("foo", "", false)
+				So(err, ShouldNotBeNil)
+				_, err = NewRetentionPolicy("", "tmp*", false)
+				So(err, ShouldNotBeNil)
+				rp, err := NewRetentionPolicy("720h", "tmp*=1h, keep*=0", false)
+				So(err, ShouldBeNil)
+				So(rp.maxAge("tmp_foo"), ShouldEqual, 1*time.Hour)
+				So(rp.maxAge("keep_foo"), ShouldEqual, 0)
+				So(rp.maxAge("foo"), ShouldEqual, 720*time.Hour)
+				So(rp.minAge(), ShouldEqual, 1*time.Hour)
+
+				job, err := jq.Reserve(50 * time.Millisecond)
+				So(err, ShouldBeNil)
+				So(job.Cmd, ShouldEqual, "sleep 0.1 && true")
+				err = jq.Execute(job, config.RunnerExecShell)
+				So(err, ShouldBeNil)
+
+				ps, err := server.db.prune(rp, time.Now())
+				So(err, ShouldBeNil)
+				So(ps.Jobs, ShouldEqual, 0)
+				So(ps.Runs, ShouldEqual, 0)
+				job, err = jq.GetByEssence(&JobEssence{Cmd: "sleep 0.1 && true"}, false, false)
+				So(err, ShouldBeNil)
+				So(job, ShouldNotBeNil)
+
+				rp.KeepStats = true
+				ps, err = server.db.prune(rp, time.Now().Add(721*time.Hour))
+				So(err, ShouldBeNil)
+				So(ps.Jobs, ShouldEqual, 1)
+				So(ps.Runs, ShouldEqual, 0)
+				job, err = jq.GetByEssence(&JobEssence{Cmd: "sleep 0.1 && true"}, false, false)
+				So(err, ShouldBeNil)
+				So(job, ShouldBeNil)
+				jobs, err := jq.GetByRepGroup("manually_added", 0, "", false, false)
+				So(err, ShouldBeNil)
+				So(len(jobs), ShouldEqual, 1)
+				So(jobs[0].Cmd, ShouldEqual, "sleep 0.1 && false")
+				runs, err := jq.History(&HistoryQuery{})
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 1)
+
+				rp.KeepStats = false
+				ps, err = server.db.prune(rp, time.Now().Add(721*time.Hour))
+				So(err, ShouldBeNil)
+				So(ps.Jobs, ShouldEqual, 0)
+				So(ps.Runs, ShouldEqual, 1)
+				runs, err = jq.History(&HistoryQuery{})
+				So(err, ShouldBeNil)
+				So(len(runs), ShouldEqual, 0)
+
+				before, after, err := jq.CompactDB()
+				So(err, ShouldBeNil)
+				So(before, ShouldBeGreaterThan, 0)
+				So(after, ShouldBeGreaterThan, 0)
+				So(after, ShouldBeLessThanOrEqualTo, before)
+
+				job, err = jq.GetByEssence(&JobEssence{Cmd: "sleep 0.1 && false"}, false, false)
+				So(err, ShouldBeNil)
+				So(job, ShouldNotBeNil)
+				inserts, _, err := jq.Add([]*Job{{Cmd: "sleep 0.1 && true", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "manually_added"}}, envVars, true)
+				So(err, ShouldBeNil)
+				So(inserts, ShouldEqual, 1)
+			})
+
+			Convey("The database can be exported to an archive, which can be imported in to another database", func() {
+				job, err := jq.Reserve(50 * time.Millisecond)
+				So(err, ShouldBeNil)
+				So(job.Cmd, ShouldEqual, "sleep 0.1 && true")
+				err = jq.Execute(job, config.RunnerExecShell)
+				So(err, ShouldBeNil)
+
+				tmpDir, err := ioutil.TempDir("", "wr_jobqueue_test_export_")
+				So(err, ShouldBeNil)
+				defer os.RemoveAll(tmpDir)
+				backup := filepath.Join(tmpDir, "backup")
+				err = jq.BackupDB(backup)
+				So(err, ShouldBeNil)
+
+				_, err = ExportDB(config.ManagerDbFile, ioutil.Discard, nil)
+				So(err, ShouldNotBeNil)
+
+				var archive bytes.Buffer
+				counts, err := ExportDB(backup, &archive, nil)
+				So(err, ShouldBeNil)
+				So(counts.Live, ShouldEqual, 1)
+				So(counts.Complete, ShouldEqual, 1)
+				So(counts.Runs, ShouldEqual, 1)
+				So(counts.Envs, ShouldEqual, 1)
+				So(counts.Stats, ShouldEqual, 2)
+
+				var filtered bytes.Buffer
+				fcounts, err := ExportDB(backup, &filtered, &ExportOptions{RepGroup: "manually*", From: time.Now(), NoEnv: true})
+				So(err, ShouldBeNil)
+				So(fcounts.Live, ShouldEqual, 1)
+				So(fcounts.Complete, ShouldEqual, 0)
+				So(fcounts.Runs, ShouldEqual, 0)
+				So(fcounts.Envs, ShouldEqual, 0)
+				So(fcounts.Stats, ShouldEqual, 2)
+				fcounts, err = ExportDB(backup, &filtered, &ExportOptions{RepGroup: "foo"})
+				So(err, ShouldBeNil)
+				So(fcounts.Live, ShouldEqual, 0)
+				So(fcounts.Stats, ShouldEqual, 0)
+
+				_, err = ImportDB(filepath.Join(tmpDir, "imported"), strings.NewReader("not an archive"))
+				So(err, ShouldNotBeNil)
+
+				imported := filepath.Join(tmpDir, "imported")
+				icounts, err := ImportDB(imported, bytes.NewReader(archive.Bytes()))
+				So(err, ShouldBeNil)
+				So(icounts, ShouldResemble, counts)
+
+				icounts, err = ImportDB(imported, bytes.NewReader(archive.Bytes()))
+				So(err, ShouldBeNil)
+				So(icounts, ShouldResemble, &ExportCounts{})
+
+				var reexport bytes.Buffer
+				rcounts, err := ExportDB(imported, &reexport, nil)
+				So(err, ShouldBeNil)
+				So(rcounts, ShouldResemble, counts)
+			})
+
 			Convey("Once reserved you can execute jobs, and other clients see the correct state on gets", func() {
 				// job that succeeds, no std out
 				job, err := jq.Reserve(50 * time.Millisecond)
@@ -769,6 +1031,33 @@ func TestJobqueue(t *testing.T) {
 						So(jobs[0].Cmd, ShouldEqual, "sleep 0.1 && false")
 						//*** should probably have a better test, where there are incomplete jobs in each of the sub queues
 					})
+
+					Convey("They can also be retrieved a page at a time", func() {
+						page1, err := jq.GetByRepGroupPage("manually_added", "", 0, 1, false, false)
+						So(err, ShouldBeNil)
+						So(len(page1), ShouldEqual, 1)
+						So(page1[0].Cmd, ShouldEqual, "sleep 0.1 && false")
+						page2, err := jq.GetByRepGroupPage("manually_added", "", 1, 1, false, false)
+						So(err, ShouldBeNil)
+						So(len(page2), ShouldEqual, 1)
+						So(page2[0].State, ShouldEqual, JobStateComplete)
+						page3, err := jq.GetByRepGroupPage("manually_added", "", 2, 1, false, false)
+						So(err, ShouldBeNil)
+						So(len(page3), ShouldEqual, 0)
+
+						page1, err = jq.GetByRepGroupPage("manually_added", JobStateComplete, 0, 5, false, false)
+						So(err, ShouldBeNil)
+						So(len(page1), ShouldEqual, 1)
+						So(page1[0].Cmd, ShouldEqual, page2[0].Cmd)
+
+						page1, err = jq.GetIncompletePage("", 0, 5, false, false)
+						So(err, ShouldBeNil)
+						So(len(page1), ShouldEqual, 1)
+						So(page1[0].Cmd, ShouldEqual, "sleep 0.1 && false")
+						page2, err = jq.GetIncompletePage("", 1, 5, false, false)
+						So(err, ShouldBeNil)
+						So(len(page2), ShouldEqual, 0)
+					})
 				})
 
 				Convey("A temp failed job is reservable after a delay", func() {
@@ -861,16 +1150,406 @@ func TestJobqueue(t *testing.T) {
 				})
 			})
 
-			Convey("Jobs can be deleted, but only once buried, and you can only bury once reserved", func() {
+			Convey("Jobs can be deleted when ready, using their essence", func() {
+				var jes []*JobEssence
 				for _, added := range jobs {
-					job, err := jq.GetByEssence(&JobEssence{Cmd: added.Cmd}, false, false)
+					jes = append(jes, added.ToEssence())
+				}
+				deleted, err := jq.Delete(jes)
+				So(err, ShouldBeNil)
+				So(deleted, ShouldEqual, 2)
+
+				job, err := jq.Reserve(5 * time.Millisecond)
+				So(err, ShouldBeNil)
+				So(job, ShouldBeNil)
+
+				jobs, err := jq.GetByRepGroup("manually_added", 0, "", false, false)
+				So(err, ShouldBeNil)
+				So(len(jobs), ShouldEqual, 0)
+			})
+
+			Convey("Jobs can be modified, but not while running", func() {
+				ram := 2048
+				pri := uint8(5)
+				retries := uint8(0)
+				override := uint8(2)
+				jm := &JobModifier{RAM: &ram, Override: &override, Priority: &pri, Retries: &retries, RepGroup: "modified", DepGroups: []string{"mdg"}, DepGroupsSet: true}
+				err := jm.SetEnvOverride([]string{"wr_mod_test=a"})
+				So(err, ShouldBeNil)
+				modified, err := jq.Modify([]*JobEssence{jobs[1].ToEssence()}, jm)
+				So(err, ShouldBeNil)
+				So(modified, ShouldEqual, 1)
+
+				job, err := jq.GetByEssence(&JobEssence{Cmd: jobs[1].Cmd}, false, true)
+				So(err, ShouldBeNil)
+				So(job, ShouldNotBeNil)
+				So(job.State, ShouldEqual, JobStateReady)
+				So(job.Requirements.RAM, ShouldEqual, 2048)
+				So(job.Requirements.Time, ShouldEqual, standardReqs.Time)
+				So(job.Priority, ShouldEqual, 5)
+				So(job.Retries, ShouldEqual, 0)
+				So(job.UntilBuried, ShouldEqual, 1)
+				So(job.RepGroup, ShouldEqual, "modified")
+				So(job.DepGroups, ShouldResemble, []string{"mdg"})
+				env, err := job.Env()
+				So(err, ShouldBeNil)
+				So(env, ShouldContain, "wr_mod_test=a")
+
+				rgJobs, err := jq.GetByRepGroup("modified", 0, "", false, false)
+				So(err, ShouldBeNil)
+				So(len(rgJobs), ShouldEqual, 1)
+				rgJobs, err = jq.GetByRepGroup("manually_added", 0, "", false, false)
+				So(err, ShouldBeNil)
+				So(len(rgJobs), ShouldEqual, 1)
+
+				// priority change means it is now reserved first
+				job, err = jq.Reserve(5 * time.Millisecond)
+				So(err, ShouldBeNil)
+				So(job.Cmd, ShouldEqual, jobs[1].Cmd)
+
+				modified, err = jq.Modify([]*JobEssence{jobs[1].ToEssence()}, &JobModifier{Priority: &retries})
+				So(err, ShouldBeNil)
+				So(modified, ShouldEqual, 0)
+
+				Convey("But not their key properties", func() {
+					jvj := &JobViaJSON{Cmd: "foo"}
+					_, err := jvj.Modifier()
+					So(err, ShouldNotBeNil)
+
+					jvj = &JobViaJSON{Memory: "1G", Priority: &ram}
+					_, err = jvj.Modifier()
+					So(err, ShouldNotBeNil)
+
+					p := 3
+					jvj = &JobViaJSON{Memory: "1G", Priority: &p, DepGrps: []string{}}
+					jm, err := jvj.Modifier()
+					So(err, ShouldBeNil)
+					So(*jm.RAM, ShouldEqual, 1024)
+					So(*jm.Priority, ShouldEqual, 3)
+					So(jm.DepGroupsSet, ShouldBeTrue)
+					So(jm.Time, ShouldBeNil)
+				})
+			})
+
+			Convey("Job arrays can be added, expanding in to individually addressable jobs", func() {
+				chrs, err := NewArrayParam("chr", "1-3")
+				So(err, ShouldBeNil)
+				samples, err := NewArrayParam("sample", "a,b")
+				So(err, ShouldBeNil)
+				template := &Job{Cmd: "echo {{chr}} {{sample}} {{index}}", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, Retries: uint8(3), RepGroup: "array", DepGroups: []string{"array.{{sample}}"}, LimitGroups: []string{"array_limit"}, ArrayParams: ArrayParams{chrs, samples}}
+				inserts, already, err := jq.Add([]*Job{template}, envVars, true)
+				So(err, ShouldBeNil)
+				So(inserts, ShouldEqual, 6)
+				So(already, ShouldEqual, 0)
+
+				arrayJobs, err := jq.GetByRepGroup("array", 0, "", false, false)
+				So(err, ShouldBeNil)
+				So(len(arrayJobs), ShouldEqual, 6)
+				cmds := make(map[int]string)
+				for _, job := range arrayJobs {
+					cmds[job.ArrayIndex] = job.Cmd
+					So(len(job.ArrayParams), ShouldEqual, 0)
+				}
+				So(cmds[1], ShouldEqual, "echo 1 a 1")
+				So(cmds[2], ShouldEqual, "echo 1 b 2")
+				So(cmds[6], ShouldEqual, "echo 3 b 6")
+
+				job, err := jq.GetByEssence(&JobEssence{Cmd: "echo 2 a 3"}, false, false)
+				So(err, ShouldBeNil)
+				So(job, ShouldNotBeNil)
+				So(job.ArrayIndex, ShouldEqual, 3)
+				So(job.DepGroups, ShouldResemble, []string{"array.a"})
+				So(job.LimitGroups, ShouldResemble, []string{"array_limit"})
+
+				repGroup, first, last, ok := ParseArrayAddress("array[2-4]")
+				So(ok, ShouldBeTrue)
+				So(repGroup, ShouldEqual, "array")
+				So(len(FilterArrayIndices(arrayJobs, first, last)), ShouldEqual, 3)
+				_, first, last, ok = ParseArrayAddress("array[5]")
+				So(ok, ShouldBeTrue)
+				So(first, ShouldEqual, 5)
+				So(last, ShouldEqual, 5)
+				_, _, _, ok = ParseArrayAddress("array")
+				So(ok, ShouldBeFalse)
+				_, _, _, ok = ParseArrayAddress("array[4-2]")
+				So(ok, ShouldBeFalse)
+
+				Convey("But not if the template doesn't use all its params", func() {
+					unused, err := NewArrayParam("unused", "1-2")
+					So(err, ShouldBeNil)
+					template = &Job{Cmd: "echo {{chr}}", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "badarray", ArrayParams: ArrayParams{chrs, unused}}
+					_, _, err = jq.Add([]*Job{template}, envVars, true)
+					So(err, ShouldNotBeNil)
+
+					_, err = NewArrayParam("index", "1-2")
+					So(err, ShouldNotBeNil)
+					_, err = NewArrayParam("bad", "")
+					So(err, ShouldNotBeNil)
+				})
+			})
+
+			Convey("Cron schedules can be added, and add their job to the queue when they fire", func() {
+				cs, err := parseCronSpec("*/15 9-17 * * mon-fri")
+				So(err, ShouldBeNil)
+				friday := time.Date(2018, 3, 2, 17, 50, 0, 0, time.Local)
+				So(cs.next(friday).Equal(time.Date(2018, 3, 5, 9, 0, 0, 0, time.Local)), ShouldBeTrue)
+				So(cs.next(friday.Add(-10*time.Minute)).Equal(time.Date(2018, 3, 2, 17, 45, 0, 0, time.Local)), ShouldBeTrue)
+				cs, err = parseCronSpec("@daily")
+				So(err, ShouldBeNil)
+				So(cs.next(friday).Equal(time.Date(2018, 3, 3, 0, 0, 0, 0, time.Local)), ShouldBeTrue)
+				cs, err = parseCronSpec("0 0 1 * 1")
+				So(err, ShouldBeNil)
+				So(cs.next(friday).Equal(time.Date(2018, 3, 5, 0, 0, 0, 0, time.Local)), ShouldBeTrue)
+				_, err = parseCronSpec("61 * * * *")
+				So(err, ShouldNotBeNil)
+				_, err = parseCronSpec("* * * *")
+				So(err, ShouldNotBeNil)
+				_, err = NewCronSchedule("never", "0 0 30 2 *", CronOverlapSkip, &Job{Cmd: "true"})
+				So(err, ShouldNotBeNil)
+				_, err = NewCronSchedule("badoverlap", "@daily", CronOverlap("foo"), &Job{Cmd: "true"})
+				So(err, ShouldNotBeNil)
+
+				sc, err := NewCronSchedule("nightly", "0 2 * * *", "", &Job{Cmd: "echo cron", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "cron"})
+				So(err, ShouldBeNil)
+				So(sc.Overlap, ShouldEqual, CronOverlapSkip)
+				err = jq.AddSchedule(sc, envVars)
+				So(err, ShouldBeNil)
+				err = jq.AddSchedule(sc, envVars)
+				So(err, ShouldNotBeNil)
+				jqerr, ok := err.(Error)
+				So(ok, ShouldBeTrue)
+				So(jqerr.Err, ShouldEqual, ErrCronExists)
+
+				schedules, err := jq.GetSchedules()
+				So(err, ShouldBeNil)
+				So(len(schedules), ShouldEqual, 1)
+				So(schedules[0].Name, ShouldEqual, "nightly")
+				So(schedules[0].Next.After(time.Now()), ShouldBeTrue)
+				So(schedules[0].Next.Hour(), ShouldEqual, 2)
+				So(schedules[0].LastFiring(), ShouldBeNil)
+
+				fire := schedules[0].Next
+				server.checkCronSchedules(fire)
+				job, err := jq.GetByEssence(&JobEssence{Cmd: "echo cron"}, false, false)
+				So(err, ShouldBeNil)
+				So(job, ShouldNotBeNil)
+				So(job.State, ShouldEqual, JobStateReady)
+				So(job.RepGroup, ShouldEqual, "cron")
+
+				server.checkCronSchedules(fire.Add(24 * time.Hour))
+				schedules, err = jq.GetSchedules()
+				So(err, ShouldBeNil)
+				So(len(schedules[0].History), ShouldEqual, 2)
+				So(schedules[0].History[0].Outcome, ShouldEqual, CronFiringAdded)
+				So(schedules[0].History[0].JobKey, ShouldEqual, job.ToEssence().JobKey)
+				So(schedules[0].History[1].Outcome, ShouldEqual, CronFiringSkipped)
+				So(schedules[0].Next.Equal(fire.Add(48*time.Hour)), ShouldBeTrue)
+
+				removed, err := jq.RemoveSchedules([]string{"nightly", "foo"})
+				So(err, ShouldBeNil)
+				So(removed, ShouldEqual, 1)
+				schedules, err = jq.GetSchedules()
+				So(err, ShouldBeNil)
+				So(len(schedules), ShouldEqual, 0)
+
+				Convey("Overlapping firings can be queued or replace the previous job", func() {
+					sc, err = NewCronSchedule("queued", "30 3 * * *", CronOverlapQueue, &Job{Cmd: "echo queued", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "cron"})
+					So(err, ShouldBeNil)
+					err = jq.AddSchedule(sc, envVars)
+					So(err, ShouldBeNil)
+					sc, err = NewCronSchedule("replaced", "30 3 * * *", CronOverlapKill, &Job{Cmd: "echo replaced", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "cron"})
+					So(err, ShouldBeNil)
+					err = jq.AddSchedule(sc, envVars)
+					So(err, ShouldBeNil)
+
+					schedules, err = jq.GetSchedules()
+					So(err, ShouldBeNil)
+					So(len(schedules), ShouldEqual, 2)
+					fire = schedules[0].Next
+					server.checkCronSchedules(fire)
+					server.checkCronSchedules(fire.Add(24 * time.Hour))
+
+					schedules, err = jq.GetSchedules()
+					So(err, ShouldBeNil)
+					So(schedules[0].Name, ShouldEqual, "queued")
+					So(schedules[0].Pending, ShouldEqual, 1)
+					So(schedules[0].LastFiring().Outcome, ShouldEqual, CronFiringQueued)
+					So(schedules[1].Name, ShouldEqual, "replaced")
+					So(schedules[1].Pending, ShouldEqual, 0)
+					So(schedules[1].LastFiring().Outcome, ShouldEqual, CronFiringReplaced)
+
+					deleted, err := jq.Delete([]*JobEssence{{Cmd: "echo queued"}})
+					So(err, ShouldBeNil)
+					So(deleted, ShouldEqual, 1)
+					server.checkCronSchedules(fire.Add(24*time.Hour + time.Minute))
+
+					schedules, err = jq.GetSchedules()
+					So(err, ShouldBeNil)
+					So(schedules[0].Pending, ShouldEqual, 0)
+					So(schedules[0].LastFiring().Outcome, ShouldEqual, CronFiringAdded)
+					job, err = jq.GetByEssence(&JobEssence{Cmd: "echo queued"}, false, false)
 					So(err, ShouldBeNil)
 					So(job, ShouldNotBeNil)
 					So(job.State, ShouldEqual, JobStateReady)
+				})
+			})
 
-					deleted, err := jq.Delete([]*JobEssence{{Cmd: added.Cmd}})
+			Convey("Subscriptions can be added, and are POSTed notifications of events", func() {
+				_, err := NewSubscription("bad", "ftp://foo", nil, "", nil, 0)
+				So(err, ShouldNotBeNil)
+				_, err = NewSubscription("bad", "http://localhost", []NotifyEvent{"foo"}, "", nil, 0)
+				So(err, ShouldNotBeNil)
+				_, err = NewSubscription("bad", "http://localhost", nil, "", []JobState{JobStateLost}, 0)
+				So(err, ShouldNotBeNil)
+
+				batches := make(chan *NotificationBatch, 10)
+				ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					batch := &NotificationBatch{}
+					errd := json.NewDecoder(r.Body).Decode(batch)
+					if errd != nil {
+						http.Error(w, errd.Error(), http.StatusBadRequest)
+						return
+					}
+					batches <- batch
+				}))
+				defer ts.Close()
+
+				sub, err := NewSubscription("hook", ts.URL, nil, "notify*", nil, 0)
+				So(err, ShouldBeNil)
+				So(sub.Events, ShouldResemble, NotifyEvents)
+				So(sub.States, ShouldResemble, []JobState{JobStateBuried})
+				err = jq.AddSubscription(sub)
+				So(err, ShouldBeNil)
+				err = jq.AddSubscription(sub)
+				So(err, ShouldNotBeNil)
+				jqerr, ok := err.(Error)
+				So(ok, ShouldBeTrue)
+				So(jqerr.Err, ShouldEqual, ErrNotifyExists)
+
+				notifyReqs := &jqs.Requirements{RAM: 400, Time: 1 * time.Minute, Cores: 1}
+				var notifyJobs []*Job
+				for i := 0; i < 2; i++ {
+					notifyJobs = append(notifyJobs, &Job{Cmd: fmt.Sprintf("echo notify %d", i), Cwd: "/tmp", ReqGroup: "fake_group", Requirements: notifyReqs, RepGroup: "notify_rg"})
+				}
+				inserts, _, err := jq.Add(notifyJobs, envVars, true)
+				So(err, ShouldBeNil)
+				So(inserts, ShouldEqual, 2)
+
+				nextNotification := func() *Notification {
+					select {
+					case batch := <-batches:
+						if batch.Subscription != "hook" || len(batch.Notifications) != 1 {
+							return nil
+						}
+						return batch.Notifications[0]
+					case <-time.After(5 * time.Second):
+						return nil
+					}
+				}
+
+				job, err := jq.ReserveScheduled(10*time.Millisecond, notifyReqs.Stringify())
+				So(err, ShouldBeNil)
+				So(job, ShouldNotBeNil)
+				err = jq.Bury(job, nil, "test bury")
+				So(err, ShouldBeNil)
+				n := nextNotification()
+				So(n, ShouldNotBeNil)
+				So(n.Event, ShouldEqual, NotifyEventJob)
+				So(n.State, ShouldEqual, JobStateBuried)
+				So(n.RepGroup, ShouldEqual, "notify_rg")
+				So(n.Cmd, ShouldEqual, job.Cmd)
+				So(n.FailReason, ShouldEqual, "test bury")
+
+				other := "echo notify 0"
+				if job.Cmd == other {
+					other = "echo notify 1"
+				}
+				deleted, err := jq.Delete([]*JobEssence{{Cmd: other}})
+				So(err, ShouldBeNil)
+				So(deleted, ShouldEqual, 1)
+				n = nextNotification()
+				So(n, ShouldNotBeNil)
+				So(n.Event, ShouldEqual, NotifyEventRepGroup)
+				So(n.RepGroup, ShouldEqual, "notify_rg")
+				So(n.Buried, ShouldEqual, 1)
+
+				subs, err := jq.GetSubscriptions()
+				So(err, ShouldBeNil)
+				So(len(subs), ShouldEqual, 1)
+				So(subs[0].Name, ShouldEqual, "hook")
+				So(subs[0].URL, ShouldEqual, ts.URL)
+
+				removed, err := jq.RemoveSubscriptions([]string{"hook", "foo"})
+				So(err, ShouldBeNil)
+				So(removed, ShouldEqual, 1)
+				subs, err = jq.GetSubscriptions()
+				So(err, ShouldBeNil)
+				So(len(subs), ShouldEqual, 0)
+			})
+
+			Convey("Limit groups stop too many of their jobs from running at once", func() {
+				err := jq.SetLimitGroup(&LimitGroup{Name: "l1", Max: 1})
+				So(err, ShouldBeNil)
+				err = jq.SetLimitGroup(&LimitGroup{Name: "l2", Max: -1})
+				So(err, ShouldNotBeNil)
+
+				limitReqs := &jqs.Requirements{RAM: 300, Time: 1 * time.Minute, Cores: 1}
+				var limitJobs []*Job
+				for i := 0; i < 3; i++ {
+					limitJobs = append(limitJobs, &Job{Cmd: fmt.Sprintf("echo limited %d", i), Cwd: "/tmp", ReqGroup: "fake_group", Requirements: limitReqs, RepGroup: "limited", LimitGroups: []string{"l1", "unlimited"}})
+				}
+				inserts, _, err := jq.Add(limitJobs, envVars, true)
+				So(err, ShouldBeNil)
+				So(inserts, ShouldEqual, 3)
+
+				job, err := jq.ReserveScheduled(10*time.Millisecond, limitReqs.Stringify())
+				So(err, ShouldBeNil)
+				So(job, ShouldNotBeNil)
+				So(job.LimitGroups, ShouldResemble, []string{"l1", "unlimited"})
+				job2, err := jq.ReserveScheduled(10*time.Millisecond, limitReqs.Stringify())
+				So(err, ShouldBeNil)
+				So(job2, ShouldBeNil)
+
+				limits, err := jq.GetLimitGroups()
+				So(err, ShouldBeNil)
+				So(len(limits), ShouldEqual, 1)
+				So(limits[0].Name, ShouldEqual, "l1")
+				So(limits[0].Max, ShouldEqual, 1)
+				So(limits[0].Running, ShouldEqual, 1)
+
+				err = jq.Release(job, nil, "")
+				So(err, ShouldBeNil)
+				job2, err = jq.ReserveScheduled(10*time.Millisecond, limitReqs.Stringify())
+				So(err, ShouldBeNil)
+				So(job2, ShouldNotBeNil)
+
+				err = jq.SetLimitGroup(&LimitGroup{Name: "l1", Max: 2})
+				So(err, ShouldBeNil)
+				job3, err := jq.ReserveScheduled(10*time.Millisecond, limitReqs.Stringify())
+				So(err, ShouldBeNil)
+				So(job3, ShouldNotBeNil)
+				So(job3.Cmd, ShouldNotEqual, job2.Cmd)
+				job4, err := jq.ReserveScheduled(10*time.Millisecond, limitReqs.Stringify())
+				So(err, ShouldBeNil)
+				So(job4, ShouldBeNil)
+
+				removed, err := jq.RemoveLimitGroups([]string{"l1", "foo"})
+				So(err, ShouldBeNil)
+				So(removed, ShouldEqual, 1)
+				limits, err = jq.GetLimitGroups()
+				So(err, ShouldBeNil)
+				So(len(limits), ShouldEqual, 0)
+				job4, err = jq.ReserveScheduled(10*time.Millisecond, limitReqs.Stringify())
+				So(err, ShouldBeNil)
+				So(job4, ShouldNotBeNil)
+			})
+
+			Convey("Jobs can be deleted, but not while running, and you can only bury once reserved", func() {
+				for _, added := range jobs {
+					job, err := jq.GetByEssence(&JobEssence{Cmd: added.Cmd}, false, false)
 					So(err, ShouldBeNil)
-					So(deleted, ShouldEqual, 0)
+					So(job, ShouldNotBeNil)
+					So(job.State, ShouldEqual, JobStateReady)
 
 					err = jq.Bury(job, nil, "test bury")
 					So(err, ShouldNotBeNil)
@@ -883,7 +1562,7 @@ func TestJobqueue(t *testing.T) {
 					So(job.Cmd, ShouldEqual, added.Cmd)
 					So(job.State, ShouldEqual, JobStateReserved)
 
-					deleted, err = jq.Delete([]*JobEssence{{Cmd: added.Cmd}})
+					deleted, err := jq.Delete([]*JobEssence{{Cmd: added.Cmd}})
 					So(err, ShouldBeNil)
 					So(deleted, ShouldEqual, 0)
 
@@ -1005,6 +1684,282 @@ func TestJobqueue(t *testing.T) {
 					jq.Delete([]*JobEssence{{Cmd: cmd}})
 				})
 
+				Convey("If a job's child processes use too much memory, all of them are killed", func() {
+					jobs = nil
+					tmpdir, err := ioutil.TempDir("", "wr_jobqueue_test_tree_")
+					So(err, ShouldBeNil)
+					defer os.RemoveAll(tmpdir)
+					pidFile := filepath.Join(tmpdir, "pid")
+					cmd := "perl -e '$SIG{TERM} = q[IGNORE]; sleep(60)' & echo $! > " + pidFile + "; perl -e '@a; for (1..3) { push(@a, q[a] x 50000000); sleep(1) }'"
+					jobs = append(jobs, &Job{Cmd: cmd, Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, Retries: uint8(3), RepGroup: "tree_out_of_mem", KillGrace: 1 * time.Second})
+					inserts, _, err := jq.Add(jobs, envVars, true)
+					So(err, ShouldBeNil)
+					So(inserts, ShouldEqual, 1)
+
+					job, err := jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job.Cmd, ShouldEqual, cmd)
+					So(job.KillGrace, ShouldEqual, 1*time.Second)
+
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldNotBeNil)
+					jqerr, ok := err.(Error)
+					So(ok, ShouldBeTrue)
+					So(jqerr.Err, ShouldEqual, FailReasonRAM)
+					So(job.FailReason, ShouldEqual, FailReasonRAM)
+					So(job.PeakRAM, ShouldBeGreaterThan, standardReqs.RAM)
+
+					b, err := ioutil.ReadFile(pidFile)
+					So(err, ShouldBeNil)
+					pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+					So(err, ShouldBeNil)
+					So(processAlive(pid), ShouldBeFalse)
+					jq.Delete([]*JobEssence{{Cmd: cmd}})
+				})
+
+				Convey("If a job exceeds its hard time limit it is killed and retried with more time", func() {
+					jobs = nil
+					cmd := "sleep 30"
+					jobs = append(jobs, &Job{Cmd: cmd, Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, Retries: uint8(3), RepGroup: "time_limit", KillGrace: 1 * time.Second, TimeLimit: 1 * time.Second, TimeLimitMult: 0.05})
+					inserts, _, err := jq.Add(jobs, envVars, true)
+					So(err, ShouldBeNil)
+					So(inserts, ShouldEqual, 1)
+
+					job, err := jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job.Cmd, ShouldEqual, cmd)
+					So(job.TimeLimit, ShouldEqual, 1*time.Second)
+					So(job.TimeLimitMult, ShouldEqual, 0.05)
+					So(job.timeLimit(), ShouldEqual, 1*time.Second)
+
+					t := time.Now()
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(time.Since(t), ShouldBeLessThan, 10*time.Second)
+					So(err, ShouldNotBeNil)
+					jqerr, ok := err.(Error)
+					So(ok, ShouldBeTrue)
+					So(jqerr.Err, ShouldEqual, FailReasonTime)
+					So(job.State, ShouldEqual, JobStateDelayed)
+					So(job.Exited, ShouldBeTrue)
+					So(job.FailReason, ShouldEqual, FailReasonTime)
+					So(job.Requirements.Time, ShouldEqual, standardReqs.Time+1*time.Hour)
+					So(job.timeLimit(), ShouldEqual, time.Duration(float64(standardReqs.Time+1*time.Hour)*0.05))
+					jq.Delete([]*JobEssence{{Cmd: cmd}})
+				})
+
+				Convey("Jobs that trap being killed and exit 0 are not archived", func() {
+					cmd := "trap 'exit 0' TERM; sleep 100"
+					reserveTrapper := func(timeLimit time.Duration) *Job {
+						jobs = nil
+						jobs = append(jobs, &Job{Cmd: cmd, Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, Retries: uint8(3), RepGroup: "trapper", KillGrace: 1 * time.Second, TimeLimit: timeLimit})
+						inserts, _, errf := jq.Add(jobs, envVars, true)
+						So(errf, ShouldBeNil)
+						So(inserts, ShouldEqual, 1)
+						job, errf := jq.Reserve(50 * time.Millisecond)
+						So(errf, ShouldBeNil)
+						So(job.Cmd, ShouldEqual, cmd)
+						return job
+					}
+
+					job := reserveTrapper(1 * time.Second)
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldNotBeNil)
+					jqerr, ok := err.(Error)
+					So(ok, ShouldBeTrue)
+					So(jqerr.Err, ShouldEqual, FailReasonTime)
+					So(job.State, ShouldEqual, JobStateDelayed)
+					So(job.Exitcode, ShouldEqual, 0)
+					So(job.FailReason, ShouldEqual, FailReasonTime)
+					jq.Delete([]*JobEssence{{Cmd: cmd}})
+
+					job = reserveTrapper(0)
+					executed := make(chan error, 1)
+					go func() {
+						executed <- jq.Execute(job, config.RunnerExecShell)
+					}()
+					<-time.After(500 * time.Millisecond)
+					killCount, errk := jq.Kill([]*JobEssence{{Cmd: cmd}})
+					So(errk, ShouldBeNil)
+					So(killCount, ShouldEqual, 1)
+					err = <-executed
+					So(err, ShouldNotBeNil)
+					jqerr, ok = err.(Error)
+					So(ok, ShouldBeTrue)
+					So(jqerr.Err, ShouldEqual, FailReasonKilled)
+					So(job.State, ShouldEqual, JobStateBuried)
+					So(job.FailReason, ShouldEqual, FailReasonKilled)
+					jq.Delete([]*JobEssence{{Cmd: cmd}})
+
+					cmd = "trap 'exit 0' TERM; perl -e '@a; for (1..3) { push(@a, q[a] x 50000000); sleep(1) }'; sleep 100"
+					job = reserveTrapper(0)
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldNotBeNil)
+					jqerr, ok = err.(Error)
+					So(ok, ShouldBeTrue)
+					So(jqerr.Err, ShouldEqual, FailReasonRAM)
+					So(job.State, ShouldEqual, JobStateDelayed)
+					So(job.FailReason, ShouldEqual, FailReasonRAM)
+					jq.Delete([]*JobEssence{{Cmd: cmd}})
+				})
+
+				Convey("Retry policies control which failures are retried, when and where", func() {
+					rp := &RetryPolicy{Backoff: 1 * time.Second, MaxBackoff: 5 * time.Second}
+					So(rp.delay(1), ShouldEqual, 1*time.Second)
+					So(rp.delay(2), ShouldEqual, 2*time.Second)
+					So(rp.delay(3), ShouldEqual, 4*time.Second)
+					So(rp.delay(4), ShouldEqual, 5*time.Second)
+					rp.Jitter = 0.5
+					So(rp.delay(1), ShouldBeBetweenOrEqual, 500*time.Millisecond, 1*time.Second)
+
+					rpj := &RetryPolicyViaJSON{FailReasons: []string{"signal", FailReasonLost}, Backoff: "1m"}
+					rp, err := rpj.RetryPolicy()
+					So(err, ShouldBeNil)
+					So(rp.FailReasons, ShouldResemble, []string{FailReasonSignal, FailReasonLost})
+					So(rp.Backoff, ShouldEqual, 1*time.Minute)
+					So(rp.retryable(false, -1, FailReasonSignal), ShouldBeTrue)
+					So(rp.retryable(true, 1, FailReasonExit), ShouldBeFalse)
+					rpj = rp.ViaJSON()
+					So(rpj.FailReasons, ShouldResemble, []string{"signal", "lost"})
+					So(rpj.Backoff, ShouldEqual, "1m0s")
+					So(rpj.MaxBackoff, ShouldEqual, "")
+					rpj = &RetryPolicyViaJSON{FailReasons: []string{"foo"}}
+					_, err = rpj.RetryPolicy()
+					So(err, ShouldNotBeNil)
+
+					jobs = nil
+					policy := &RetryPolicy{ExitCodes: []int{4}, Backoff: 100 * time.Millisecond, OtherHost: true}
+					jobs = append(jobs, &Job{Cmd: "exit 3", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, Retries: uint8(3), RepGroup: "retry_policy", RetryPolicy: policy})
+					jobs = append(jobs, &Job{Cmd: "exit 4", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, Retries: uint8(3), RepGroup: "retry_policy", RetryPolicy: policy})
+					inserts, _, err := jq.Add(jobs, envVars, true)
+					So(err, ShouldBeNil)
+					So(inserts, ShouldEqual, 2)
+
+					job, err := jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job.Cmd, ShouldEqual, "exit 3")
+					So(job.RetryPolicy, ShouldNotBeNil)
+					So(job.RetryPolicy.ExitCodes, ShouldResemble, []int{4})
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldNotBeNil)
+					So(job.State, ShouldEqual, JobStateBuried)
+
+					job, err = jq.GetByEssence(&JobEssence{Cmd: "exit 3"}, false, false)
+					So(err, ShouldBeNil)
+					So(job.State, ShouldEqual, JobStateBuried)
+					So(job.UntilBuried, ShouldEqual, 0)
+					So(len(job.AttemptHistory), ShouldEqual, 1)
+					So(job.AttemptHistory[0].Exitcode, ShouldEqual, 3)
+					So(job.AttemptHistory[0].FailReason, ShouldEqual, FailReasonExit)
+					So(job.AttemptHistory[0].Host, ShouldEqual, hostName())
+
+					job, err = jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job.Cmd, ShouldEqual, "exit 4")
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldNotBeNil)
+					So(job.State, ShouldEqual, JobStateDelayed)
+
+					job, err = jq.GetByEssence(&JobEssence{Cmd: "exit 4"}, false, false)
+					So(err, ShouldBeNil)
+					So(job.State, ShouldEqual, JobStateDelayed)
+					So(job.UntilBuried, ShouldEqual, 3)
+					So(len(job.AttemptHistory), ShouldEqual, 1)
+					So(job.AttemptHistory[0].Exitcode, ShouldEqual, 4)
+
+					// it prefers another host for a while...
+					avoider := &Job{RetryPolicy: policy, AttemptHistory: job.AttemptHistory, avoidHostsUntil: time.Now().Add(ServerRetryHostGrace)}
+					So(avoider.avoidsHost(hostName()), ShouldBeTrue)
+					So(avoider.avoidsHost("otherhost"), ShouldBeFalse)
+					avoider.avoidHostsUntil = time.Now()
+					So(avoider.avoidsHost(hostName()), ShouldBeFalse)
+
+					// ...but this is the only host, so it gets it anyway
+					<-time.After(200 * time.Millisecond)
+					job, err = jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job, ShouldNotBeNil)
+					So(job.Cmd, ShouldEqual, "exit 4")
+					err = jq.Release(job, nil, "")
+					So(err, ShouldBeNil)
+
+					jq.Delete([]*JobEssence{{Cmd: "exit 3"}, {Cmd: "exit 4"}})
+				})
+
+				if _, errc := cgroupParent(); errc == nil {
+					Convey("Jobs run in their own cgroup, which limits their memory and gives their CPU time", func() {
+						jobs = nil
+						memCmd := "perl -e '@a; for (1..3) { push(@a, q[a] x 50000000); sleep(1) }'"
+						cpuCmd := "perl -e '$i = 0; $i++ while $i < 50000000' && grep -q " + cgroupJobPrefix + " /proc/self/cgroup"
+						jobs = append(jobs, &Job{Cmd: memCmd, Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, Retries: uint8(3), RepGroup: "cgroup_mem"})
+						jobs = append(jobs, &Job{Cmd: cpuCmd, Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, Retries: uint8(0), RepGroup: "cgroup_cpu"})
+						inserts, _, err := jq.Add(jobs, envVars, true)
+						So(err, ShouldBeNil)
+						So(inserts, ShouldEqual, 2)
+
+						job, err := jq.Reserve(50 * time.Millisecond)
+						So(err, ShouldBeNil)
+						So(job.Cmd, ShouldEqual, memCmd)
+						err = jq.Execute(job, config.RunnerExecShell)
+						So(err, ShouldNotBeNil)
+						jqerr, ok := err.(Error)
+						So(ok, ShouldBeTrue)
+						So(jqerr.Err, ShouldEqual, FailReasonRAM)
+						So(job.FailReason, ShouldEqual, FailReasonRAM)
+
+						job, err = jq.Reserve(50 * time.Millisecond)
+						So(err, ShouldBeNil)
+						So(job.Cmd, ShouldEqual, cpuCmd)
+						err = jq.Execute(job, config.RunnerExecShell)
+						So(err, ShouldBeNil)
+						So(job.State, ShouldEqual, JobStateComplete)
+						So(job.CPUtime, ShouldBeGreaterThan, 0)
+
+						dirs, err := filepath.Glob(filepath.Join(cgroupParentDir, cgroupJobPrefix+"*"))
+						So(err, ShouldBeNil)
+						So(len(dirs), ShouldEqual, 0)
+						jq.Delete([]*JobEssence{{Cmd: memCmd}, {Cmd: cpuCmd}})
+					})
+
+					Convey("Jobs are in their cgroup before they run anything", func() {
+						jobs = nil
+						forkCmd := "(grep -q " + cgroupJobPrefix + " /proc/self/cgroup) && grep -q " + cgroupJobPrefix + " /proc/self/cgroup"
+						jobs = append(jobs, &Job{Cmd: forkCmd, Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, Retries: uint8(0), RepGroup: "cgroup_fork"})
+						inserts, _, err := jq.Add(jobs, envVars, true)
+						So(err, ShouldBeNil)
+						So(inserts, ShouldEqual, 1)
+
+						job, err := jq.Reserve(50 * time.Millisecond)
+						So(err, ShouldBeNil)
+						So(job.Cmd, ShouldEqual, forkCmd)
+						err = jq.Execute(job, config.RunnerExecShell)
+						So(err, ShouldBeNil)
+						So(job.State, ShouldEqual, JobStateComplete)
+						jq.Delete([]*JobEssence{{Cmd: forkCmd}})
+					})
+
+					Convey("Only the cgroups of runners that have died are tidied up", func() {
+						dead := exec.Command("true")
+						err := dead.Run()
+						So(err, ShouldBeNil)
+						deadPid := strconv.Itoa(dead.Process.Pid)
+						livePid := strconv.Itoa(os.Getppid())
+
+						deadDir := filepath.Join(cgroupParentDir, cgroupJobPrefix+deadPid+"_key")
+						liveDir := filepath.Join(cgroupParentDir, cgroupJobPrefix+livePid+"_key")
+						So(os.Mkdir(deadDir, 0755), ShouldBeNil)
+						So(os.Mkdir(liveDir, 0755), ShouldBeNil)
+						defer os.Remove(liveDir)
+
+						removeStaleCgroups(cgroupParentDir)
+						_, err = os.Stat(deadDir)
+						So(os.IsNotExist(err), ShouldBeTrue)
+						_, err = os.Stat(liveDir)
+						So(err, ShouldBeNil)
+					})
+				} else {
+					SkipConvey("Skipping cgroup tests since cgroups v2 have not been delegated to us", func() {})
+				}
+
 				RecMBRound = 100 // revert back to normal
 
 				Convey("The stdout/err of jobs is only kept for failed jobs, and cwd&TMPDIR&HOME get set appropriately", func() {
@@ -1331,6 +2286,306 @@ func TestJobqueue(t *testing.T) {
 					So(entries[0].Name(), ShouldEqual, "jobqueue_cwd")
 				})
 
+				Convey("Job CopyToManager behaviours copy files to the manager", func() {
+					jobs = nil
+					cwd, err := ioutil.TempDir("", "wr_jobqueue_test_runner_dir_")
+					So(err, ShouldBeNil)
+					defer os.RemoveAll(cwd)
+					So(jq.ServerInfo.CopyDir, ShouldNotBeBlank)
+					defer os.RemoveAll(filepath.Join(jq.ServerInfo.CopyDir, "copy_rg"))
+					b1 := &Behaviour{When: OnSuccess, Do: CopyToManager, Arg: []string{"out.txt", "sub/deep.txt"}}
+					b2 := &Behaviour{When: OnSuccess, Do: CleanupAll}
+					bigCmd := "mkdir sub && echo deep > sub/deep.txt && perl -e 'print q[a] x 2500000' > out.txt"
+					jobs = append(jobs, &Job{Cmd: bigCmd, Cwd: cwd, ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "copy_rg", Behaviours: Behaviours{b1, b2}})
+					b3 := &Behaviour{When: OnExit, Do: CopyToManager, Arg: []string{"../escape.txt"}}
+					jobs = append(jobs, &Job{Cmd: "touch ../escape.txt", Cwd: cwd, ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "copy_rg", Behaviours: Behaviours{b3}})
+					inserts, _, err := jq.Add(jobs, envVars, true)
+					So(err, ShouldBeNil)
+					So(inserts, ShouldEqual, 2)
+
+					job, err := jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job.Cmd, ShouldEqual, bigCmd)
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldBeNil)
+					So(job.State, ShouldEqual, JobStateComplete)
+					So(len(job.CopiedFiles), ShouldEqual, 2)
+
+					key := job.key()
+					So(job.CopiedFiles[0], ShouldEqual, filepath.Join("copy_rg", key, "out.txt"))
+					So(job.CopiedFiles[1], ShouldEqual, filepath.Join("copy_rg", key, "sub", "deep.txt"))
+					info, err := os.Stat(filepath.Join(jq.ServerInfo.CopyDir, job.CopiedFiles[0]))
+					So(err, ShouldBeNil)
+					So(info.Size(), ShouldEqual, 2500000)
+					content, err := ioutil.ReadFile(filepath.Join(jq.ServerInfo.CopyDir, job.CopiedFiles[1]))
+					So(err, ShouldBeNil)
+					So(string(content), ShouldEqual, "deep\n")
+					_, err = os.Stat(filepath.Join(jq.ServerInfo.CopyDir, job.CopiedFiles[0]+copyTmpSuffix))
+					So(err, ShouldNotBeNil)
+
+					// the original files were still cleaned up afterwards
+					_, err = os.Stat(filepath.Join(job.ActualCwd, "out.txt"))
+					So(err, ShouldNotBeNil)
+
+					job2, err := jq2.GetByEssence(&JobEssence{Cmd: bigCmd}, false, false)
+					So(err, ShouldBeNil)
+					So(job2, ShouldNotBeNil)
+					So(job2.CopiedFiles, ShouldResemble, job.CopiedFiles)
+
+					Convey("But not files outside of the actual working directory", func() {
+						job, err = jq.Reserve(50 * time.Millisecond)
+						So(err, ShouldBeNil)
+						So(job.Cmd, ShouldEqual, "touch ../escape.txt")
+						err = jq.Execute(job, config.RunnerExecShell)
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldContainSubstring, "not a relative path within the working directory")
+						So(len(job.CopiedFiles), ShouldEqual, 0)
+					})
+				})
+
+				Convey("Jobs with declared Outputs fail if they don't create them, and can be skipped if up to date", func() {
+					jobs = nil
+					cwd, err := ioutil.TempDir("", "wr_jobqueue_test_runner_dir_")
+					So(err, ShouldBeNil)
+					defer os.RemoveAll(cwd)
+					in := filepath.Join(cwd, "in.txt")
+					out := filepath.Join(cwd, "out.txt")
+					err = ioutil.WriteFile(in, []byte("in\n"), 0600)
+					So(err, ShouldBeNil)
+					failCmd := "echo made > made.txt"
+					jobs = append(jobs, &Job{Cmd: failCmd, Cwd: cwd, CwdMatters: true, ReqGroup: "fake_group", Requirements: standardReqs, Retries: uint8(0), RepGroup: "outputs", Outputs: []string{"made.txt", "not_made.txt"}})
+					skipCmd := "cp in.txt out.txt && echo ran >> ran.txt"
+					jobs = append(jobs, &Job{Cmd: skipCmd, Cwd: cwd, CwdMatters: true, ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "outputs", Inputs: []string{"in.txt"}, Outputs: []string{out}, SkipIfUpToDate: true})
+					inserts, _, err := jq.Add(jobs, envVars, true)
+					So(err, ShouldBeNil)
+					So(inserts, ShouldEqual, 2)
+
+					job, err := jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job.Cmd, ShouldEqual, failCmd)
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "not_made.txt")
+					So(job.State, ShouldEqual, JobStateBuried)
+					So(job.Exitcode, ShouldEqual, 0)
+					So(job.FailReason, ShouldEqual, FailReasonOutputs)
+
+					job, err = jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job.Cmd, ShouldEqual, skipCmd)
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldBeNil)
+					So(job.State, ShouldEqual, JobStateComplete)
+					So(job.Skipped, ShouldBeFalse)
+					_, err = os.Stat(out)
+					So(err, ShouldBeNil)
+
+					// now that out.txt is newer than in.txt, running again
+					// gets skipped
+					inserts, _, err = jq.Add(jobs[1:], envVars, false)
+					So(err, ShouldBeNil)
+					So(inserts, ShouldEqual, 1)
+					job, err = jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job.Cmd, ShouldEqual, skipCmd)
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldBeNil)
+					So(job.State, ShouldEqual, JobStateComplete)
+					So(job.Skipped, ShouldBeTrue)
+					ran, err := ioutil.ReadFile(filepath.Join(cwd, "ran.txt"))
+					So(err, ShouldBeNil)
+					So(string(ran), ShouldEqual, "ran\n")
+
+					job2, err := jq2.GetByEssence(&JobEssence{Cmd: skipCmd, Cwd: cwd}, false, false)
+					So(err, ShouldBeNil)
+					So(job2, ShouldNotBeNil)
+					So(job2.State, ShouldEqual, JobStateComplete)
+					So(job2.Skipped, ShouldBeTrue)
+					So(job2.Outputs, ShouldResemble, []string{out})
+
+					// but not if an input is newer than an output
+					future := time.Now().Add(1 * time.Hour)
+					err = os.Chtimes(in, future, future)
+					So(err, ShouldBeNil)
+					inserts, _, err = jq.Add(jobs[1:], envVars, false)
+					So(err, ShouldBeNil)
+					So(inserts, ShouldEqual, 1)
+					job, err = jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldBeNil)
+					So(job.Skipped, ShouldBeFalse)
+					ran, err = ioutil.ReadFile(filepath.Join(cwd, "ran.txt"))
+					So(err, ShouldBeNil)
+					So(string(ran), ShouldEqual, "ran\nran\n")
+				})
+
+				Convey("Jobs can have their complete STDOUT and STDERR captured, and ranges of it retrieved", func() {
+					jobs = nil
+					dir, err := ioutil.TempDir("", "wr_jobqueue_test_std_capture_")
+					So(err, ShouldBeNil)
+					defer os.RemoveAll(dir)
+					cmd := "perl -e 'print qq[$_\\n] for 1..5000; print STDERR qq[err\\n]'"
+					jobs = append(jobs, &Job{Cmd: cmd, Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "std_capture", StdCapture: dir})
+					gzCmd := "perl -e 'print STDERR qq[gzerr\\n]; exit 1'"
+					jobs = append(jobs, &Job{Cmd: gzCmd, Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, Retries: uint8(0), RepGroup: "std_capture", StdCapture: dir, StdCompress: true})
+					plainCmd := "echo plain"
+					jobs = append(jobs, &Job{Cmd: plainCmd, Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "std_capture"})
+					inserts, _, err := jq.Add(jobs, envVars, true)
+					So(err, ShouldBeNil)
+					So(inserts, ShouldEqual, 3)
+
+					var expected []string
+					for i := 1; i <= 5000; i++ {
+						expected = append(expected, strconv.Itoa(i))
+					}
+					expectedOut := strings.Join(expected, "\n") + "\n"
+
+					job, err := jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job.Cmd, ShouldEqual, cmd)
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldBeNil)
+					So(job.State, ShouldEqual, JobStateComplete)
+					So(job.CapturedStdOut, ShouldEqual, filepath.Join(dir, job.key()+".stdout"))
+					So(job.CapturedStdErr, ShouldEqual, filepath.Join(dir, job.key()+".stderr"))
+					content, err := ioutil.ReadFile(job.CapturedStdOut)
+					So(err, ShouldBeNil)
+					So(string(content), ShouldEqual, expectedOut)
+
+					job2, err := jq2.GetByEssence(&JobEssence{Cmd: cmd}, false, false)
+					So(err, ShouldBeNil)
+					So(job2.CapturedStdOut, ShouldEqual, job.CapturedStdOut)
+					data, err := jq2.GetCapturedStd(job2, false, 0, 10)
+					So(err, ShouldBeNil)
+					So(string(data), ShouldEqual, "1\n2\n3\n4\n5\n")
+					data, err = jq2.GetCapturedStd(job2, false, int64(len(expectedOut)-5), 10)
+					So(err, ShouldBeNil)
+					So(string(data), ShouldEqual, "5000\n")
+					data, err = jq2.GetCapturedStd(job2, false, int64(len(expectedOut)+10), 10)
+					So(err, ShouldBeNil)
+					So(data, ShouldBeEmpty)
+					data, err = jq2.GetCapturedStd(job2, false, 0, 0)
+					So(err, ShouldBeNil)
+					So(string(data), ShouldEqual, expectedOut)
+					data, err = jq2.GetCapturedStd(job2, true, 0, 0)
+					So(err, ShouldBeNil)
+					So(string(data), ShouldEqual, "err\n")
+
+					job, err = jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job.Cmd, ShouldEqual, gzCmd)
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldNotBeNil)
+					So(job.State, ShouldEqual, JobStateBuried)
+					So(job.CapturedStdErr, ShouldEqual, filepath.Join(dir, job.key()+".stderr.gz"))
+					data, err = jq2.GetCapturedStd(job, true, 2, 0)
+					So(err, ShouldBeNil)
+					So(string(data), ShouldEqual, "err\n")
+					data, err = jq2.GetCapturedStd(job, false, 0, 0)
+					So(err, ShouldBeNil)
+					So(data, ShouldBeEmpty)
+
+					job, err = jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job.Cmd, ShouldEqual, plainCmd)
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldBeNil)
+					So(job.CapturedStdOut, ShouldBeEmpty)
+					_, err = jq2.GetCapturedStd(job, false, 0, 0)
+					So(err, ShouldNotBeNil)
+					jqerr, ok := err.(Error)
+					So(ok, ShouldBeTrue)
+					So(jqerr.Err, ShouldEqual, ErrNoCapturedStd)
+				})
+
+				Convey("The output of running jobs can be followed with Tail()", func() {
+					origTail := ClientTailInterval
+					ClientTailInterval = 50 * time.Millisecond
+					defer func() {
+						ClientTailInterval = origTail
+					}()
+					jobs = nil
+					cmd := "perl -e '$|=1; print qq[out1\\n]; print STDERR qq[err1\\n]; sleep(1); print qq[out2\\n]'"
+					jobs = append(jobs, &Job{Cmd: cmd, Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "tail"})
+					inserts, _, err := jq.Add(jobs, envVars, true)
+					So(err, ShouldBeNil)
+					So(inserts, ShouldEqual, 1)
+
+					job, err := jq.Reserve(50 * time.Millisecond)
+					So(err, ShouldBeNil)
+					So(job.Cmd, ShouldEqual, cmd)
+
+					job2, err := jq2.GetByEssence(&JobEssence{Cmd: cmd}, false, false)
+					So(err, ShouldBeNil)
+					So(job2, ShouldNotBeNil)
+
+					tailed := make(chan bool)
+					var stdout, stderr bytes.Buffer
+					var state JobState
+					var tailErr error
+					go func() {
+						state, tailErr = jq2.Tail(job2, &stdout, &stderr)
+						tailed <- true
+					}()
+
+					err = jq.Execute(job, config.RunnerExecShell)
+					So(err, ShouldBeNil)
+					So(job.State, ShouldEqual, JobStateComplete)
+
+					finished := false
+					select {
+					case <-tailed:
+						finished = true
+					case <-time.After(5 * time.Second):
+					}
+					So(finished, ShouldBeTrue)
+					So(tailErr, ShouldBeNil)
+					So(state, ShouldEqual, JobStateComplete)
+					So(stdout.String(), ShouldEqual, "out1\nout2\n")
+					So(stderr.String(), ShouldEqual, "err1\n")
+				})
+
+				Convey("Only a limited number of jobs can be tailed at once", func() {
+					origMax := ServerMaxTails
+					ServerMaxTails = 1
+					defer func() {
+						ServerMaxTails = origMax
+					}()
+					jobs = nil
+					jobs = append(jobs, &Job{Cmd: "echo tail1", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "tail"})
+					jobs = append(jobs, &Job{Cmd: "echo tail2", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "tail"})
+					inserts, _, err := jq.Add(jobs, envVars, true)
+					So(err, ShouldBeNil)
+					So(inserts, ShouldEqual, 2)
+
+					want := &outputChunk{StdoutOffset: -1, StderrOffset: -1}
+					resp, err := jq2.request(&clientRequest{Method: "jtail", Keys: []string{jobs[0].key()}, Output: want})
+					So(err, ShouldBeNil)
+					So(resp.Output, ShouldNotBeNil)
+					So(resp.Output.State, ShouldEqual, JobStateReady)
+
+					_, err = jq2.request(&clientRequest{Method: "jtail", Keys: []string{jobs[1].key()}, Output: want})
+					So(err, ShouldNotBeNil)
+					jqerr, ok := err.(Error)
+					So(ok, ShouldBeTrue)
+					So(jqerr.Err, ShouldEqual, ErrTooManyTails)
+
+					resp, err = jq2.request(&clientRequest{Method: "jtail", Keys: []string{jobs[0].key()}, Output: want})
+					So(err, ShouldBeNil)
+					So(resp.Output, ShouldNotBeNil)
+
+					server.tailMutex.Lock()
+					server.tails[jobs[0].key()].lastWanted = time.Now().Add(-tailExpiry - time.Second)
+					server.tailMutex.Unlock()
+
+					resp, err = jq2.request(&clientRequest{Method: "jtail", Keys: []string{jobs[1].key()}, Output: want})
+					So(err, ShouldBeNil)
+					So(resp.Output, ShouldNotBeNil)
+					So(server.tailWanted(jobs[0].key()), ShouldBeFalse)
+					So(server.tailWanted(jobs[1].key()), ShouldBeTrue)
+				})
+
 				Convey("Jobs that take longer than the ttr can execute successfully, even if clienttouchinterval is > ttr", func() {
 					jobs = nil
 					cmd := "perl -e 'for (1..3) { sleep(1) }'"
@@ -1399,7 +2654,7 @@ func TestJobqueue(t *testing.T) {
 		})
 
 		Convey("After connecting and adding some jobs under one RepGroup", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -1495,7 +2750,7 @@ func TestJobqueue(t *testing.T) {
 		})
 
 		Convey("After connecting and adding some jobs under some RepGroups", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -1550,6 +2805,52 @@ func TestJobqueue(t *testing.T) {
 					So(len(gottenJobs), ShouldEqual, 1)
 					So(gottenJobs[0].State, ShouldEqual, JobStateReady)
 
+					Convey("You can get the dependency graph of their RepGroups", func() {
+						keyOf := func(cmd string) string {
+							return NewEssenceDependency(cmd, "").Essence.Key()
+						}
+
+						graph, err := jq.GetGraph("dep7")
+						So(err, ShouldBeNil)
+						So(graph.RepGroup, ShouldEqual, "dep7")
+						So(len(graph.Nodes), ShouldEqual, 7)
+						So(len(graph.Edges), ShouldEqual, 8)
+						states := make(map[string]JobState)
+						for _, node := range graph.Nodes {
+							states[node.Cmd] = node.State
+						}
+						So(states["echo deptest1"], ShouldEqual, JobStateComplete)
+						So(states["echo deptest4"], ShouldEqual, JobStateReady)
+						So(states["echo deptest7"], ShouldEqual, JobStateDependent)
+
+						resolved := 0
+						for _, edge := range graph.Edges {
+							if edge.Resolved {
+								resolved++
+								So(edge.From, ShouldEqual, keyOf("echo deptest1"))
+							}
+							if edge.To == keyOf("echo deptest7") {
+								So(edge.Via, ShouldBeIn, []string{"dep5", "dep6"})
+							}
+						}
+						So(resolved, ShouldEqual, 2)
+
+						unresolved := graph.Unresolved(keyOf("echo deptest7"))
+						So(len(unresolved), ShouldEqual, 5)
+						So(unresolved, ShouldNotContain, keyOf("echo deptest1"))
+						So(unresolved, ShouldContain, keyOf("echo deptest4"))
+						So(len(graph.Unresolved(keyOf("echo deptest4"))), ShouldEqual, 0)
+
+						dot := graph.DOT()
+						So(dot, ShouldStartWith, "digraph \"dep7\" {\n")
+						So(dot, ShouldContainSubstring, fmt.Sprintf("\"%s\" -> \"%s\" [label=\"dep1+2+3\", style=solid];", keyOf("echo deptest2"), keyOf("echo deptest5")))
+
+						graph, err = jq.GetGraph("dep1")
+						So(err, ShouldBeNil)
+						So(len(graph.Nodes), ShouldEqual, 1)
+						So(len(graph.Edges), ShouldEqual, 0)
+					})
+
 					Convey("They are then only reservable according to the dependency chain", func() {
 						j2, err := jq.Reserve(50 * time.Millisecond)
 						So(err, ShouldBeNil)
@@ -1729,7 +3030,7 @@ func TestJobqueue(t *testing.T) {
 		})
 
 		Convey("After connecting you can add some jobs with DepGroups", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -2134,7 +3435,7 @@ func TestJobqueue(t *testing.T) {
 		So(err, ShouldNotBeNil)
 
 		Convey("You can connect, and add 2 jobs, which creates a db backup", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -2187,7 +3488,7 @@ func TestJobqueue(t *testing.T) {
 				server.Stop(true)
 				server, _, errs = Serve(serverConfig)
 				So(errs, ShouldBeNil)
-				jq, err = Connect(addr, clientConnectTime)
+				jq, err = Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 
 				jobsByRepGroup, err := jq.GetByRepGroup("manually_added", 0, "", false, false)
@@ -2202,7 +3503,7 @@ func TestJobqueue(t *testing.T) {
 				}()
 				server, _, errs = Serve(serverConfig)
 				So(errs, ShouldBeNil)
-				jq, err = Connect(addr, clientConnectTime)
+				jq, err = Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 
 				jobsByRepGroup, err = jq.GetByRepGroup("manually_added", 0, "", false, false)
@@ -2222,7 +3523,7 @@ func TestJobqueue(t *testing.T) {
 				}()
 				server, _, errs = Serve(serverConfig)
 				So(errs, ShouldBeNil)
-				jq, err = Connect(addr, clientConnectTime)
+				jq, err = Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 
 				jobsByRepGroup, err = jq.GetByRepGroup("manually_added", 0, "", false, false)
@@ -2246,7 +3547,7 @@ func TestJobqueue(t *testing.T) {
 				So(err, ShouldBeNil)
 				So(info2.Size(), ShouldEqual, 32768)
 
-				jq, err = Connect(addr, clientConnectTime)
+				jq, err = Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 
 				jobsByRepGroup, err = jq.GetByRepGroup("manually_added", 0, "", false, false)
@@ -2270,7 +3571,7 @@ func TestJobqueue(t *testing.T) {
 				So(err, ShouldBeNil)
 				So(info2.Size(), ShouldEqual, 32768)
 
-				jq, err = Connect(addr, clientConnectTime)
+				jq, err = Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 
 				jobsByRepGroup, err = jq.GetByRepGroup("manually_added", 0, "", false, false)
@@ -2293,7 +3594,7 @@ func TestJobqueue(t *testing.T) {
 				server, _, errs = Serve(serverConfig)
 				wipeDevDBOnInit = true
 				So(errs, ShouldBeNil)
-				jq, err = Connect(addr, clientConnectTime)
+				jq, err = Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 
 				job, err = jq.Reserve(50 * time.Millisecond)
@@ -2309,7 +3610,7 @@ func TestJobqueue(t *testing.T) {
 		})
 
 		Convey("You can connect, add a job, then immediately shutdown, and the db backup still completes", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -2331,7 +3632,7 @@ func TestJobqueue(t *testing.T) {
 		})
 
 		Convey("You can connect and add a non-instant job", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -2374,7 +3675,7 @@ func TestJobqueue(t *testing.T) {
 				server, _, errs = Serve(serverConfig)
 				wipeDevDBOnInit = true
 				So(errs, ShouldBeNil)
-				jq, err = Connect(addr, clientConnectTime)
+				jq, err = Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 
 				job, err = jq.GetByEssence(&JobEssence{Cmd: job1Cmd}, false, false)
@@ -2419,7 +3720,7 @@ func TestJobqueue(t *testing.T) {
 				server, _, errs = Serve(serverConfig)
 				wipeDevDBOnInit = true
 				So(errs, ShouldBeNil)
-				jq, err = Connect(addr, clientConnectTime)
+				jq, err = Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 
 				job, err = jq.GetByEssence(&JobEssence{Cmd: job1Cmd}, false, false)
@@ -2437,6 +3738,153 @@ func TestJobqueue(t *testing.T) {
 		server.Stop(true)
 	}
 
+	Convey("Once a jobqueue server is up with a standby", t, func() {
+		ServerItemTTR = 10 * time.Second
+		StandbyReplicateInterval = 50 * time.Millisecond
+		StandbyFailoverTimeout = 500 * time.Millisecond
+		defer func() {
+			StandbyReplicateInterval = 5 * time.Second
+			StandbyFailoverTimeout = 30 * time.Second
+		}()
+
+		server, _, errs = Serve(serverConfig)
+		So(errs, ShouldBeNil)
+
+		jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
+		So(err, ShouldBeNil)
+		defer jq.Disconnect()
+		var jobs []*Job
+		jobs = append(jobs, &Job{Cmd: "echo 1", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "standby"})
+		jobs = append(jobs, &Job{Cmd: "echo 2", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "standby"})
+		inserts, _, err := jq.Add(jobs, envVars, true)
+		So(err, ShouldBeNil)
+		So(inserts, ShouldEqual, 2)
+
+		port, err := strconv.Atoi(config.ManagerPort)
+		So(err, ShouldBeNil)
+		standbyConfig := serverConfig
+		standbyConfig.Port = strconv.Itoa(port + 2)
+		standbyConfig.WebPort = strconv.Itoa(port + 3)
+		standbyConfig.DBFile = config.ManagerDbFile + ".standby"
+		standbyConfig.DBFileBackup = managerDBBkFile + ".standby"
+		defer os.Remove(standbyConfig.DBFile)
+
+		_, _, err = Standby("localhost:"+strconv.Itoa(port+4), standbyConfig)
+		So(err, ShouldNotBeNil)
+
+		tookOver := make(chan *Server, 1)
+		standbyErr := make(chan error, 1)
+		go func() {
+			s, _, errs := Standby(addr, standbyConfig)
+			standbyErr <- errs
+			tookOver <- s
+		}()
+
+		var si *ServerInfo
+		for i := 0; i < 100; i++ {
+			si, err = jq.Ping(clientConnectTime)
+			if err != nil || len(si.Standbys) == 1 {
+				break
+			}
+			<-time.After(10 * time.Millisecond)
+		}
+		So(err, ShouldBeNil)
+		So(len(si.Standbys), ShouldEqual, 1)
+		So(si.Standbys[0], ShouldEndWith, ":"+standbyConfig.Port)
+
+		Convey("If the server dies, the standby takes over and its running jobs carry on", func() {
+			// standbys are sent the whole database when too far behind...
+			rep, err := server.db.replicate(0, 0)
+			So(err, ShouldBeNil)
+			So(rep.DB, ShouldNotBeNil)
+			So(rep.Changes, ShouldBeNil)
+
+			rep2, err := server.db.replicate(rep.Epoch, rep.TxID)
+			So(err, ShouldBeNil)
+			So(rep2.DB, ShouldBeNil)
+			So(len(rep2.Changes), ShouldEqual, 0)
+
+			StandbyMaxChanges = 1
+			inserts, _, err = jq.Add([]*Job{{Cmd: "echo 3", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "standby"}}, envVars, true)
+			StandbyMaxChanges = 100000
+			So(err, ShouldBeNil)
+			So(inserts, ShouldEqual, 1)
+			rep2, err = server.db.replicate(rep.Epoch, rep.TxID)
+			So(err, ShouldBeNil)
+			So(rep2.DB, ShouldNotBeNil)
+			<-time.After(200 * time.Millisecond)
+
+			// ...but otherwise just the changes
+			inserts, _, err = jq.Add([]*Job{{Cmd: "echo 4", Cwd: "/tmp", ReqGroup: "fake_group", Requirements: standardReqs, RepGroup: "standby"}}, envVars, true)
+			So(err, ShouldBeNil)
+			So(inserts, ShouldEqual, 1)
+			rep3, err := server.db.replicate(rep2.Epoch, rep2.TxID)
+			So(err, ShouldBeNil)
+			So(rep3.DB, ShouldBeNil)
+			So(rep3.TxID, ShouldBeGreaterThan, rep2.TxID)
+			changedLive := false
+			for _, change := range rep3.Changes {
+				if string(change.Bucket) == string(bucketJobsLive) && !change.Deleted {
+					changedLive = true
+				}
+			}
+			So(changedLive, ShouldBeTrue)
+
+			jq2, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
+			So(err, ShouldBeNil)
+			defer jq2.Disconnect()
+			job, err := jq2.Reserve(50 * time.Millisecond)
+			So(err, ShouldBeNil)
+			So(job, ShouldNotBeNil)
+			So(job.Cmd, ShouldEqual, "echo 1")
+			<-time.After(200 * time.Millisecond)
+
+			server.Stop(true)
+			server = nil
+			var standby *Server
+			select {
+			case standby = <-tookOver:
+			case <-time.After(10 * time.Second):
+			}
+			So(standby, ShouldNotBeNil)
+			So(<-standbyErr, ShouldBeNil)
+			server = standby
+
+			for i := 0; i < 100; i++ {
+				_, err = jq2.Touch(job)
+				if err == nil {
+					break
+				}
+				<-time.After(100 * time.Millisecond)
+			}
+			So(err, ShouldBeNil)
+
+			running, err := jq2.GetByRepGroup("standby", 0, JobStateRunning, false, false)
+			So(err, ShouldBeNil)
+			So(len(running), ShouldEqual, 1)
+			So(running[0].Cmd, ShouldEqual, "echo 1")
+
+			all, err := jq2.GetByRepGroup("standby", 0, "", false, false)
+			So(err, ShouldBeNil)
+			So(len(all), ShouldEqual, 4)
+
+			job2, err := jq2.Reserve(50 * time.Millisecond)
+			So(err, ShouldBeNil)
+			So(job2, ShouldNotBeNil)
+			So(job2.Cmd, ShouldEqual, "echo 2")
+		})
+
+		Reset(func() {
+			if server != nil {
+				server.Stop(true)
+			}
+		})
+	})
+
+	if server != nil {
+		server.Stop(true)
+	}
+
 	// start these tests anew because these tests have the server spawn runners
 	Convey("Once a new jobqueue server is up", t, func() {
 		ServerItemTTR = 10 * time.Second
@@ -2468,7 +3916,7 @@ func TestJobqueue(t *testing.T) {
 		runtime.GOMAXPROCS(maxCPU)
 
 		Convey("You can connect, and add a job that you can kill while it's running", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -2546,7 +3994,7 @@ func TestJobqueue(t *testing.T) {
 		})
 
 		Convey("You can connect, and add some real jobs", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -2621,7 +4069,7 @@ func TestJobqueue(t *testing.T) {
 		})
 
 		Convey("You can connect, and add a job that buries with no retries", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -2742,7 +4190,7 @@ func TestJobqueue(t *testing.T) {
 
 		if maxCPU > 2 {
 			Convey("You can connect and add jobs in alternating scheduler groups and they don't pend", func() {
-				jq, err := Connect(addr, clientConnectTime)
+				jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 				defer jq.Disconnect()
 
@@ -2814,7 +4262,7 @@ func TestJobqueue(t *testing.T) {
 		}
 
 		Convey("You can connect, and add 2 real jobs with the same reqs sequentially that run simultaneously", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -2944,7 +4392,7 @@ func TestJobqueue(t *testing.T) {
 			}
 
 			clientConnectTime = 20 * time.Second // it takes a long time with -race to add 10000 jobs...
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -3128,7 +4576,7 @@ func TestJobqueue(t *testing.T) {
 		So(errs, ShouldBeNil)
 
 		Convey("You can connect, and add a job", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -3237,6 +4685,10 @@ func TestJobqueueWithOpenStack(t *testing.T) {
 		var errs error
 		config := internal.ConfigLoad("development", true, testLogger)
 		addr := "localhost:" + config.ManagerPort
+		token, err := internal.ReadOrCreateToken(config.ManagerTokenFile)
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		runnertmpdir, err := ioutil.TempDir("", "wr_jobqueue_test_runner_dir_")
 		if err != nil {
@@ -3269,9 +4721,15 @@ func TestJobqueueWithOpenStack(t *testing.T) {
 				StateUpdateFrequency: 1 * time.Second,
 				Shell:                "bash",
 				MaxInstances:         -1,
+				ConfigFiles:          config.ManagerTokenFile + "," + config.ManagerCAFile,
 			},
 			DBFile:       config.ManagerDbFile,
 			DBFileBackup: config.ManagerDbBkFile,
+			TokenFile:    config.ManagerTokenFile,
+			CAFile:       config.ManagerCAFile,
+			CertFile:     config.ManagerCertFile,
+			KeyFile:      config.ManagerKeyFile,
+			CertDomain:   config.ManagerCertDomain,
 			Deployment:   config.Deployment,
 			RunnerCmd:    runnerCmd + " --runnermode --schedgrp '%s' --rdeployment %s --rserver '%s' --rtimeout %d --maxmins %d --tmpdir " + runnertmpdir,
 			Logger:       testLogger,
@@ -3282,7 +4740,7 @@ func TestJobqueueWithOpenStack(t *testing.T) {
 			So(errs, ShouldBeNil)
 			defer server.Stop(true)
 
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -3521,6 +4979,10 @@ func TestJobqueueWithMounts(t *testing.T) {
 
 	config := internal.ConfigLoad("development", true, testLogger)
 	addr := "localhost:" + config.ManagerPort
+	token, err := internal.ReadOrCreateToken(config.ManagerTokenFile)
+	if err != nil {
+		log.Fatal(err)
+	}
 	serverConfig := ServerConfig{
 		Port:            config.ManagerPort,
 		WebPort:         config.ManagerWeb,
@@ -3528,6 +4990,11 @@ func TestJobqueueWithMounts(t *testing.T) {
 		SchedulerConfig: &jqs.ConfigLocal{Shell: config.RunnerExecShell},
 		DBFile:          config.ManagerDbFile,
 		DBFileBackup:    config.ManagerDbBkFile,
+		TokenFile:       config.ManagerTokenFile,
+		CAFile:          config.ManagerCAFile,
+		CertFile:        config.ManagerCertFile,
+		KeyFile:         config.ManagerKeyFile,
+		CertDomain:      config.ManagerCertDomain,
 		Deployment:      config.Deployment,
 		Logger:          testLogger,
 	}
@@ -3585,7 +5052,7 @@ func TestJobqueueWithMounts(t *testing.T) {
 		So(err, ShouldNotBeNil)
 
 		Convey("You can connect and add a job, which creates a db backup", func() {
-			jq, err := Connect(addr, clientConnectTime)
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 			So(err, ShouldBeNil)
 			defer jq.Disconnect()
 
@@ -3618,7 +5085,7 @@ func TestJobqueueWithMounts(t *testing.T) {
 				server, _, errs = Serve(s3ServerConfig)
 				So(errs, ShouldBeNil)
 				defer server.Stop(true)
-				jq, err = Connect(addr, clientConnectTime)
+				jq, err = Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 
 				jobsByRepGroup, err = jq.GetByRepGroup("manually_added", 0, "", false, false)
@@ -3643,7 +5110,7 @@ func TestJobqueueWithMounts(t *testing.T) {
 				So(err, ShouldBeNil)
 				So(info2.Size(), ShouldEqual, 28672)
 
-				jq, err = Connect(addr, clientConnectTime)
+				jq, err = Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 
 				jobsByRepGroup, err = jq.GetByRepGroup("manually_added", 0, "", false, false)
@@ -3669,7 +5136,7 @@ func TestJobqueueWithMounts(t *testing.T) {
 
 		standardReqs := &jqs.Requirements{RAM: 10, Time: 10 * time.Second, Cores: 1, Disk: 0, Other: make(map[string]string)}
 
-		jq, err := Connect(addr, clientConnectTime)
+		jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 		So(err, ShouldBeNil)
 		defer jq.Disconnect()
 
@@ -3805,10 +5272,19 @@ func TestJobqueueSpeed(t *testing.T) {
 		SchedulerConfig: &jqs.ConfigLocal{Shell: config.RunnerExecShell},
 		DBFile:          config.ManagerDbFile,
 		DBFileBackup:    config.ManagerDbBkFile,
+		TokenFile:       config.ManagerTokenFile,
+		CAFile:          config.ManagerCAFile,
+		CertFile:        config.ManagerCertFile,
+		KeyFile:         config.ManagerKeyFile,
+		CertDomain:      config.ManagerCertDomain,
 		Deployment:      config.Deployment,
 		Logger:          testLogger,
 	}
 	addr := "localhost:" + config.ManagerPort
+	token, err := internal.ReadOrCreateToken(config.ManagerTokenFile)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// some manual speed tests (don't like the way the benchmarking feature
 	// works)
@@ -3822,7 +5298,7 @@ func TestJobqueueSpeed(t *testing.T) {
 		}
 
 		clientConnectTime := 10 * time.Second
-		jq, err := Connect(addr, clientConnectTime)
+		jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -3850,7 +5326,7 @@ func TestJobqueueSpeed(t *testing.T) {
 		for i := 1; i <= o; i++ {
 			go func(i int) {
 				start := time.After(time.Until(beginat))
-				gjq, err := Connect(addr, clientConnectTime)
+				gjq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				if err != nil {
 					log.Fatal(err)
 				}
@@ -4087,7 +5563,12 @@ func runner() {
 	//  runner client it would be used to end the below for loop before hitting
 	//  this limit)
 
-	jq, err := Connect(addr, timeout)
+	token, err := ioutil.ReadFile(config.ManagerTokenFile)
+	if err != nil {
+		log.Fatalf("token err: %s\n", err)
+	}
+
+	jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, timeout)
 
 	if err != nil {
 		log.Fatalf("connect err: %s\n", err)
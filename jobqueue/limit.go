@@ -0,0 +1,248 @@
+// Copyright © 2016-2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for limiting how many jobs in a named group can
+// run at once, using resource protectors from the rp package.
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/VertebrateResequencing/wr/queue"
+	"github.com/VertebrateResequencing/wr/rp"
+)
+
+// LimitGroup describes a limit on the jobs that have Name in their
+// LimitGroups: no more than Max of them will run at once, and no two of them
+// will start running within Delay of each other. A Max of 0 stops all jobs in
+// the group from starting. The limits are enforced when jobs are reserved, so
+// jobs over the limit remain in the ready state. The limit group tokens of a
+// running job that loses contact with the server are given back after
+// ServerItemTTR.
+type LimitGroup struct {
+	Name  string
+	Max   int
+	Delay time.Duration
+
+	// Running is the number of jobs in the group that are currently running,
+	// filled in for you by Client.GetLimitGroups().
+	Running int
+}
+
+// validate checks that the LimitGroup makes sense.
+func (lg *LimitGroup) validate() error {
+	if lg.Name == "" {
+		return fmt.Errorf("a limit group needs a name")
+	}
+	if lg.Max < 0 {
+		return fmt.Errorf("limit group %s can't have a negative max (%d)", lg.Name, lg.Max)
+	}
+	if lg.Delay < 0 {
+		return fmt.Errorf("limit group %s can't have a negative delay (%s)", lg.Name, lg.Delay)
+	}
+	return nil
+}
+
+// loadLimitGroups creates resource protectors for the LimitGroups stored in
+// the database, for when the server starts up.
+func (s *Server) loadLimitGroups() error {
+	limits, err := s.db.retrieveLimitGroups()
+	if err != nil {
+		return err
+	}
+	s.lgmutex.Lock()
+	defer s.lgmutex.Unlock()
+	for _, lg := range limits {
+		err = lg.validate()
+		if err != nil {
+			s.Warn("stored limit group is invalid", "name", lg.Name, "err", err)
+			continue
+		}
+		s.limitGroups[lg.Name] = lg
+		s.limitProtectors[lg.Name] = s.newLimitProtector(lg)
+	}
+	return nil
+}
+
+// newLimitProtector creates the resource protector for the given LimitGroup.
+// Whenever one of its tokens is released, the jobs that acquireLimits() held
+// back because the group was full become reservable again.
+func (s *Server) newLimitProtector(lg *LimitGroup) *rp.Protector {
+	p := rp.New(lg.Name, lg.Delay, lg.Max, ServerItemTTR)
+	q := s.q
+	name := lg.Name
+	p.SetReleaseCallback(func() {
+		q.ReleaseHeld(name)
+	})
+	return p
+}
+
+// setLimitGroup creates the given LimitGroup, or changes the limits of an
+// existing one with the same name. Jobs that are already running are not
+// affected. Returns a srerr and error on failure.
+func (s *Server) setLimitGroup(lg *LimitGroup) (srerr string, qerr error) {
+	err := lg.validate()
+	if err != nil {
+		return ErrBadRequest, err
+	}
+
+	limit := &LimitGroup{Name: lg.Name, Max: lg.Max, Delay: lg.Delay}
+	err = s.db.storeLimitGroup(limit)
+	if err != nil {
+		return ErrDBError, err
+	}
+
+	s.lgmutex.Lock()
+	s.limitGroups[limit.Name] = limit
+	if p, exists := s.limitProtectors[limit.Name]; exists {
+		p.SetLimits(limit.Delay, limit.Max)
+	} else {
+		s.limitProtectors[limit.Name] = s.newLimitProtector(limit)
+	}
+	s.lgmutex.Unlock()
+	s.Debug("set limit group", "name", limit.Name, "max", limit.Max, "delay", limit.Delay)
+
+	// jobs held back by the old limit may now be able to run
+	s.q.ReleaseHeld(limit.Name)
+	s.q.TriggerReadyAddedCallback()
+	return "", nil
+}
+
+// removeLimitGroup removes the named LimitGroup, so that jobs in that group
+// are no longer limited, returning false if it didn't exist.
+func (s *Server) removeLimitGroup(name string) bool {
+	s.lgmutex.Lock()
+	p, exists := s.limitProtectors[name]
+	if !exists {
+		s.lgmutex.Unlock()
+		return false
+	}
+	p.Shutdown()
+	delete(s.limitProtectors, name)
+	delete(s.limitGroups, name)
+	s.lgmutex.Unlock()
+	s.db.deleteLimitGroup(name)
+	s.Debug("removed limit group", "name", name)
+	s.q.ReleaseHeld(name)
+	s.q.TriggerReadyAddedCallback()
+	return true
+}
+
+// getLimitGroups returns copies of all our LimitGroups, sorted by name, with
+// their Running filled in.
+func (s *Server) getLimitGroups() []*LimitGroup {
+	s.lgmutex.RLock()
+	defer s.lgmutex.RUnlock()
+	limits := make([]*LimitGroup, 0, len(s.limitGroups))
+	for name, lg := range s.limitGroups {
+		running, _ := s.limitProtectors[name].Usage()
+		limits = append(limits, &LimitGroup{Name: lg.Name, Max: lg.Max, Delay: lg.Delay, Running: running})
+	}
+	sort.Slice(limits, func(i, j int) bool {
+		return limits[i].Name < limits[j].Name
+	})
+	return limits
+}
+
+// acquireLimits is used as the accept function when reserving items from our
+// queue: it tries to get a token from the protector of every limit group of
+// the item's job, returning true (and remembering the tokens on the job) if it
+// got all of them. Otherwise it gives back the tokens it got and returns false,
+// so that the job remains ready. If that was because a group is full, the name
+// of the group is also returned, so that the queue holds the job back until one
+// of that group's tokens is released, instead of checking it on every reserve.
+func (s *Server) acquireLimits(item *queue.Item) (bool, string) {
+	job := item.Data.(*Job)
+	job.RLock()
+	groups := job.LimitGroups
+	job.RUnlock()
+	if len(groups) == 0 {
+		return true, ""
+	}
+
+	s.lgmutex.RLock()
+	defer s.lgmutex.RUnlock()
+	receipts := make(map[string]rp.Receipt)
+	for _, group := range groups {
+		p, limited := s.limitProtectors[group]
+		if !limited {
+			continue
+		}
+		if _, done := receipts[group]; done {
+			continue
+		}
+		receipt, granted := p.TryRequest(1)
+		if !granted {
+			for rgroup, r := range receipts {
+				s.limitProtectors[rgroup].Release(r)
+			}
+			if used, max := p.Usage(); used >= max {
+				return false, group
+			}
+			return false, ""
+		}
+		receipts[group] = receipt
+	}
+
+	job.Lock()
+	job.limitReceipts = receipts
+	job.Unlock()
+	return true, ""
+}
+
+// touchLimits stops the limit group tokens of a running job from being
+// automatically released, for when the job is touched.
+func (s *Server) touchLimits(job *Job) {
+	job.RLock()
+	receipts := job.limitReceipts
+	job.RUnlock()
+	if len(receipts) == 0 {
+		return
+	}
+
+	s.lgmutex.RLock()
+	defer s.lgmutex.RUnlock()
+	for group, receipt := range receipts {
+		if p, exists := s.limitProtectors[group]; exists {
+			p.Touch(receipt)
+		}
+	}
+}
+
+// releaseLimits gives back the limit group tokens of a job, for when it stops
+// running.
+func (s *Server) releaseLimits(job *Job) {
+	job.Lock()
+	receipts := job.limitReceipts
+	job.limitReceipts = nil
+	job.Unlock()
+	if len(receipts) == 0 {
+		return
+	}
+
+	s.lgmutex.RLock()
+	defer s.lgmutex.RUnlock()
+	for group, receipt := range receipts {
+		if p, exists := s.limitProtectors[group]; exists {
+			p.Release(receipt)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for the manager's Prometheus metrics and health
+// check endpoints.
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VertebrateResequencing/wr/queue"
+)
+
+const (
+	metricsEndpoint = "/metrics"
+	healthzEndpoint = "/healthz"
+	metricsPrefix   = "wr_"
+)
+
+// metricsLabelEscaper escapes label values as required by the Prometheus text
+// exposition format.
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsWriter writes metrics in the Prometheus text exposition format,
+// remembering the first write error so that callers don't have to check every
+// write.
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+// family writes the HELP and TYPE lines that must precede the samples of a
+// metric.
+func (m *metricsWriter) family(name, kind, help string) {
+	m.printf("# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, kind)
+}
+
+// sample writes a single sample of a metric. labels are pairs of label names
+// and values.
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	var ls string
+	if len(labels) > 1 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], metricsLabelEscaper.Replace(labels[i+1])))
+		}
+		ls = "{" + strings.Join(pairs, ",") + "}"
+	}
+	m.printf("%s%s%s %s\n", metricsPrefix, name, ls, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (m *metricsWriter) printf(format string, a ...interface{}) {
+	if m.err != nil {
+		return
+	}
+	_, m.err = fmt.Fprintf(m.w, format, a...)
+}
+
+// metrics exports details about the jobs in the queue, the server's handling
+// of client requests, the job scheduler and the database, for scraping by
+// Prometheus.
+func metrics(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "metrics can only be got", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m := &metricsWriter{w: w}
+		s.writeJobMetrics(m)
+		s.writeRequestMetrics(m)
+		s.writeSchedulerMetrics(m)
+		s.writeDBMetrics(m)
+		if m.err != nil {
+			s.Warn("metrics failed to be written", "err", m.err)
+		}
+	}
+}
+
+// writeJobMetrics writes the counts of jobs in the queue per state and
+// RepGroup. These are counted straight from the queue's items, since making
+// client copies of every incomplete Job on every scrape would be too costly.
+func (s *Server) writeJobMetrics(m *metricsWriter) {
+	s.rpl.RLock()
+	rgKeys := make(map[string][]string, len(s.rpl.lookup))
+	for rg, keys := range s.rpl.lookup {
+		for key := range keys {
+			rgKeys[rg] = append(rgKeys[rg], key)
+		}
+	}
+	s.rpl.RUnlock()
+
+	counts := make(map[JobState]map[string]int)
+	for rg, keys := range rgKeys {
+		for _, key := range keys {
+			item, err := s.q.Get(key)
+			if err != nil || item == nil {
+				continue
+			}
+			state := itemJobState(item)
+			if _, exists := counts[state]; !exists {
+				counts[state] = make(map[string]int)
+			}
+			counts[state][rg]++
+		}
+	}
+
+	m.family("jobs", "gauge", "Number of incomplete jobs in the queue.")
+	states := make([]string, 0, len(counts))
+	for state := range counts {
+		states = append(states, string(state))
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		rgCounts := counts[JobState(state)]
+		rgs := make([]string, 0, len(rgCounts))
+		for rg := range rgCounts {
+			rgs = append(rgs, rg)
+		}
+		sort.Strings(rgs)
+		for _, rg := range rgs {
+			m.sample("jobs", float64(rgCounts[rg]), "state", state, "rep_group", rg)
+		}
+	}
+
+	m.family("running_jobs_etc_seconds", "gauge", "Seconds until the slowest of the currently running jobs is expected to complete.")
+	m.sample("running_jobs_etc_seconds", s.GetServerStats().ETC.Seconds())
+}
+
+// itemJobState returns the JobState that a client would see for the Job in the
+// given item, without the cost of converting the item to a Job.
+func itemJobState(item *queue.Item) JobState {
+	state := itemsStateToJobState[item.State()]
+	if state == "" {
+		return JobStateUnknown
+	}
+	if state == JobStateReserved {
+		sjob := item.Data.(*Job)
+		sjob.RLock()
+		defer sjob.RUnlock()
+		if sjob.Lost {
+			return JobStateLost
+		}
+		if !sjob.StartTime.IsZero() {
+			return JobStateRunning
+		}
+	}
+	return state
+}
+
+// writeRequestMetrics writes how many of each kind of client request we've
+// handled and how long they took.
+func (s *Server) writeRequestMetrics(m *metricsWriter) {
+	s.tmutex.Lock()
+	methods := make([]string, 0, len(s.timings))
+	avgs := make(map[string]*timingAvg, len(s.timings))
+	for method, avg := range s.timings {
+		methods = append(methods, method)
+		avgs[method] = avg
+	}
+	s.tmutex.Unlock()
+	sort.Strings(methods)
+
+	m.family("request_duration_seconds", "summary", "Time taken to handle client requests, by method (excluding time reserve requests spent waiting for a job to become ready).")
+	for _, method := range methods {
+		count, sum := avgs[method].totals()
+		m.sample("request_duration_seconds_sum", sum, "method", method)
+		m.sample("request_duration_seconds_count", float64(count), "method", method)
+	}
+}
+
+// writeSchedulerMetrics writes how many runners we've asked the job scheduler
+// for, and details of any cloud servers.
+func (s *Server) writeSchedulerMetrics(m *metricsWriter) {
+	s.sgcmutex.Lock()
+	groups := make([]string, 0, len(s.sgroupcounts))
+	runners := make(map[string]int, len(s.sgroupcounts))
+	for group, count := range s.sgroupcounts {
+		groups = append(groups, group)
+		runners[group] = count
+	}
+	failing := len(s.sgerrs)
+	s.sgcmutex.Unlock()
+	sort.Strings(groups)
+
+	m.family("scheduler_runners", "gauge", "Number of runners the job scheduler has been asked to have pending or running, by scheduler group.")
+	for _, group := range groups {
+		m.sample("scheduler_runners", float64(runners[group]), "scheduler_group", group)
+	}
+	m.family("scheduler_failing_groups", "gauge", "Number of scheduler groups that the job scheduler is currently failing to schedule runners for.")
+	m.sample("scheduler_failing_groups", float64(failing))
+
+	if servers := s.scheduler.ServerCount(); servers >= 0 {
+		m.family("cloud_servers", "gauge", "Number of cloud servers spawned to run jobs on.")
+		m.sample("cloud_servers", float64(servers))
+	}
+	s.bsmutex.RLock()
+	bad := len(s.badServers)
+	s.bsmutex.RUnlock()
+	m.family("cloud_bad_servers", "gauge", "Number of cloud servers that are currently not working correctly.")
+	m.sample("cloud_bad_servers", float64(bad))
+}
+
+// writeDBMetrics writes the size of the database and the state of its backups.
+func (s *Server) writeDBMetrics(m *metricsWriter) {
+	ds, err := s.db.stats()
+	if err != nil {
+		s.Warn("metrics could not get database stats", "err", err)
+		return
+	}
+
+	m.family("db_size_bytes", "gauge", "Size of the database.")
+	m.sample("db_size_bytes", float64(ds.Size))
+	if !ds.BackupsEnabled {
+		return
+	}
+
+	if !ds.BackupLast.IsZero() {
+		m.family("db_backup_age_seconds", "gauge", "Seconds since the last successful database backup completed.")
+		m.sample("db_backup_age_seconds", time.Since(ds.BackupLast).Seconds())
+	}
+	m.family("db_backup_duration_seconds", "gauge", "Time taken by the last database backup attempt.")
+	m.sample("db_backup_duration_seconds", ds.BackupDuration.Seconds())
+	failed := 0.0
+	if ds.BackupFailed {
+		failed = 1
+	}
+	m.family("db_backup_failed", "gauge", "1 if the last database backup attempt failed, otherwise 0.")
+	m.sample("db_backup_failed", failed)
+}
+
+// healthz responds with 200 OK if the server, its database and its job
+// scheduler seem to be working, or 503 Service Unavailable listing what the
+// problems are.
+func healthz(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var problems []string
+
+		s.ssmutex.RLock()
+		if !s.up && !s.drain {
+			problems = append(problems, "server is not running")
+		}
+		s.ssmutex.RUnlock()
+
+		ds, err := s.db.stats()
+		if err != nil {
+			problems = append(problems, "database can not be read")
+		} else if ds.BackupsEnabled && ds.BackupFailed {
+			problems = append(problems, "database backups are failing")
+		}
+
+		s.sgcmutex.Lock()
+		failing := len(s.sgerrs)
+		s.sgcmutex.Unlock()
+		if failing > 0 {
+			problems = append(problems, fmt.Sprintf("job scheduler is failing to schedule runners for %d scheduler groups", failing))
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		if len(problems) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, err = fmt.Fprintln(w, strings.Join(problems, "\n"))
+		} else {
+			_, err = fmt.Fprintln(w, "ok")
+		}
+		if err != nil {
+			s.Warn("healthz response failed to be written", "err", err)
+		}
+	}
+}
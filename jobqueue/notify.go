@@ -0,0 +1,600 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for webhook notifications: Subscriptions that the
+// server POSTs JSON Notifications to when interesting things happen.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/VertebrateResequencing/wr/cloud"
+	"github.com/VertebrateResequencing/wr/internal"
+	"github.com/VertebrateResequencing/wr/queue"
+	"github.com/jpillora/backoff"
+)
+
+// NotifyEvent* constants are the kinds of event a Subscription can be notified
+// about. NotifyEventJob is a job changing to one of the Subscription's States,
+// NotifyEventRepGroup is a RepGroup finishing (none of its jobs are left in the
+// queue, other than buried ones), NotifyEventServer is a cloud server going
+// bad, and NotifyEventScheduler is the job scheduler reporting a problem.
+const (
+	NotifyEventJob       NotifyEvent = "job"
+	NotifyEventRepGroup  NotifyEvent = "repgroup"
+	NotifyEventServer    NotifyEvent = "server"
+	NotifyEventScheduler NotifyEvent = "scheduler"
+)
+
+// NotifyEvents are all the NotifyEvent* constants, which is what a
+// Subscription is notified about if it doesn't specify any Events.
+var NotifyEvents = []NotifyEvent{NotifyEventJob, NotifyEventRepGroup, NotifyEventServer, NotifyEventScheduler}
+
+// these global variables are primarily exported for testing purposes; you
+// probably shouldn't change them
+var (
+	// NotifyRetries is how many times the server will retry POSTing
+	// Notifications to a Subscription's URL before giving up on them.
+	NotifyRetries = 5
+
+	// NotifyRetryMin is how long the server waits before the first retry;
+	// subsequent retries back off exponentially up to NotifyRetryMax.
+	NotifyRetryMin = 1 * time.Second
+
+	// NotifyRetryMax is the longest the server will wait between retries.
+	NotifyRetryMax = 5 * time.Minute
+
+	// NotifyTimeout is how long the server waits for a Subscription's URL to
+	// respond to a POST.
+	NotifyTimeout = 30 * time.Second
+)
+
+// notifyJobStates are the JobStates that a Subscription can ask to be notified
+// about jobs changing to.
+var notifyJobStates = map[JobState]bool{
+	JobStateDelayed:   true,
+	JobStateReady:     true,
+	JobStateRunning:   true,
+	JobStateBuried:    true,
+	JobStateDependent: true,
+	JobStateComplete:  true,
+	JobStateDeleted:   true,
+}
+
+// NotifyEvent is a kind of event that a Subscription can be notified about.
+type NotifyEvent string
+
+// Subscription describes a webhook that the server POSTs Notifications to.
+type Subscription struct {
+	// Name uniquely identifies the subscription.
+	Name string
+
+	// URL is the http or https URL that NotificationBatches will be POSTed
+	// to as JSON.
+	URL string
+
+	// Events are the kinds of event to be notified about. Defaults to all of
+	// NotifyEvents.
+	Events []NotifyEvent
+
+	// RepGroup is a glob (as understood by path.Match(), eg. "mygroup*") that
+	// the RepGroup of a job must match for job and repgroup events to be
+	// notified about. Defaults to matching everything.
+	RepGroup string
+
+	// States are the states that a job must change to for a job event to be
+	// notified about. Defaults to JobStateBuried.
+	States []JobState
+
+	// Debounce is how long to collect Notifications for before sending them
+	// all in a single POST, starting from the first one. 0 means send each
+	// event as it happens.
+	Debounce time.Duration
+
+	// The remaining properties are set by the server. Created is when the
+	// subscription was added, Sent is how many POSTs succeeded, Failed is how
+	// many were given up on after NotifyRetries retries, and LastErr is the
+	// reason the most recent failure failed.
+	Created time.Time
+	Sent    int
+	Failed  int
+	LastErr string
+
+	pending []*Notification
+	timer   *time.Timer
+}
+
+// NewSubscription creates a Subscription, checking that its properties are
+// valid. Pass the result to Client.AddSubscription() to have it take effect.
+func NewSubscription(name string, webhook string, events []NotifyEvent, repGroup string, states []JobState, debounce time.Duration) (*Subscription, error) {
+	sub := &Subscription{Name: name, URL: webhook, Events: events, RepGroup: repGroup, States: states, Debounce: debounce}
+	return sub, sub.validate()
+}
+
+// validate checks our properties make sense, filling in defaults.
+func (sub *Subscription) validate() error {
+	if sub.Name == "" {
+		return fmt.Errorf("subscriptions must have a name")
+	}
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("subscription %s must have an http or https URL, not [%s]", sub.Name, sub.URL)
+	}
+	if len(sub.Events) == 0 {
+		sub.Events = NotifyEvents
+	}
+	for _, event := range sub.Events {
+		switch event {
+		case NotifyEventJob, NotifyEventRepGroup, NotifyEventServer, NotifyEventScheduler:
+		default:
+			return fmt.Errorf("subscription %s has an unknown event [%s]", sub.Name, event)
+		}
+	}
+	if _, err = path.Match(sub.RepGroup, ""); err != nil {
+		return fmt.Errorf("subscription %s has a bad RepGroup glob [%s]: %s", sub.Name, sub.RepGroup, err)
+	}
+	if len(sub.States) == 0 {
+		sub.States = []JobState{JobStateBuried}
+	}
+	for _, state := range sub.States {
+		if !notifyJobStates[state] {
+			return fmt.Errorf("subscription %s has a state that can't be notified about [%s]", sub.Name, state)
+		}
+	}
+	if sub.Debounce < 0 {
+		return fmt.Errorf("subscription %s can't have a negative debounce", sub.Name)
+	}
+	return nil
+}
+
+// wants tells you if this subscription should be notified about the given
+// Notification.
+func (sub *Subscription) wants(n *Notification) bool {
+	wanted := false
+	for _, event := range sub.Events {
+		if event == n.Event {
+			wanted = true
+			break
+		}
+	}
+	if !wanted {
+		return false
+	}
+
+	switch n.Event {
+	case NotifyEventJob, NotifyEventRepGroup:
+		if sub.RepGroup != "" {
+			if matched, _ := path.Match(sub.RepGroup, n.RepGroup); !matched {
+				return false
+			}
+		}
+	}
+	if n.Event == NotifyEventJob {
+		for _, state := range sub.States {
+			if state == n.State {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// copy returns a copy of this Subscription without its pending Notifications.
+func (sub *Subscription) copy() *Subscription {
+	c := *sub
+	c.pending = nil
+	c.timer = nil
+	return &c
+}
+
+// Notification describes an event. Event and Time are always set; the other
+// properties are set depending on the Event:
+//
+// NotifyEventJob: JobKey, Cmd, RepGroup, FromState, State, Exitcode and
+// FailReason of the job that changed state.
+//
+// NotifyEventRepGroup: RepGroup, and Buried, the number of its jobs left
+// buried.
+//
+// NotifyEventServer: ServerID, ServerName, ServerIP and Msg (the problem with
+// the server).
+//
+// NotifyEventScheduler: Msg.
+type Notification struct {
+	Event      NotifyEvent
+	Time       time.Time
+	JobKey     string   `json:",omitempty"`
+	Cmd        string   `json:",omitempty"`
+	RepGroup   string   `json:",omitempty"`
+	FromState  JobState `json:",omitempty"`
+	State      JobState `json:",omitempty"`
+	Exitcode   int      `json:",omitempty"`
+	FailReason string   `json:",omitempty"`
+	Buried     int      `json:",omitempty"`
+	ServerID   string   `json:",omitempty"`
+	ServerName string   `json:",omitempty"`
+	ServerIP   string   `json:",omitempty"`
+	Msg        string   `json:",omitempty"`
+}
+
+// NotificationBatch is the JSON body of the POSTs made to Subscription URLs.
+// Manager is the host:port of the manager's web interface.
+type NotificationBatch struct {
+	Subscription  string
+	Manager       string
+	Notifications []*Notification
+}
+
+// loadSubscriptions gets our Subscriptions from the database.
+func (s *Server) loadSubscriptions() error {
+	subs, err := s.db.retrieveSubscriptions()
+	if err != nil {
+		return err
+	}
+	s.notifymutex.Lock()
+	defer s.notifymutex.Unlock()
+	for _, sub := range subs {
+		err = sub.validate()
+		if err != nil {
+			s.Warn("stored subscription is invalid", "name", sub.Name, "err", err)
+			continue
+		}
+		s.subscriptions[sub.Name] = sub
+	}
+	return nil
+}
+
+// addSubscription validates and stores a new Subscription. It is an error to
+// add a subscription with the same name as an existing one.
+func (s *Server) addSubscription(sub *Subscription) (srerr string, qerr error) {
+	err := sub.validate()
+	if err != nil {
+		return ErrBadRequest, err
+	}
+
+	s.notifymutex.Lock()
+	defer s.notifymutex.Unlock()
+	if _, exists := s.subscriptions[sub.Name]; exists {
+		return ErrNotifyExists, fmt.Errorf("subscription %s already exists", sub.Name)
+	}
+	sub.Created = time.Now()
+	sub.Sent = 0
+	sub.Failed = 0
+	sub.LastErr = ""
+	err = s.db.storeSubscription(sub)
+	if err != nil {
+		return ErrDBError, err
+	}
+	s.subscriptions[sub.Name] = sub
+	s.Debug("added subscription", "name", sub.Name, "url", sub.URL)
+	return "", nil
+}
+
+// removeSubscription removes the named Subscription, returning false if it
+// didn't exist. Any of its Notifications that haven't been sent yet are
+// discarded.
+func (s *Server) removeSubscription(name string) bool {
+	s.notifymutex.Lock()
+	defer s.notifymutex.Unlock()
+	sub, exists := s.subscriptions[name]
+	if !exists {
+		return false
+	}
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	delete(s.subscriptions, name)
+	s.db.deleteSubscription(name)
+	s.Debug("removed subscription", "name", name)
+	return true
+}
+
+// getSubscriptions returns copies of all our Subscriptions, sorted by name.
+func (s *Server) getSubscriptions() []*Subscription {
+	s.notifymutex.Lock()
+	defer s.notifymutex.Unlock()
+	subs := make([]*Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subs = append(subs, sub.copy())
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Name < subs[j].Name
+	})
+	return subs
+}
+
+// notifyJobs creates Notifications for jobs that changed state, and for any of
+// their RepGroups that have now finished.
+func (s *Server) notifyJobs(from, to JobState, data []interface{}) {
+	if !s.hasSubscriptions() {
+		return
+	}
+
+	now := time.Now()
+	var ns []*Notification
+	groups := make(map[string]bool)
+	for _, inter := range data {
+		job := inter.(*Job)
+		job.RLock()
+		ns = append(ns, &Notification{
+			Event:      NotifyEventJob,
+			Time:       now,
+			JobKey:     job.key(),
+			Cmd:        job.Cmd,
+			RepGroup:   job.RepGroup,
+			FromState:  from,
+			State:      to,
+			Exitcode:   job.Exitcode,
+			FailReason: job.FailReason,
+		})
+		groups[job.RepGroup] = true
+		job.RUnlock()
+	}
+
+	if to == JobStateComplete || to == JobStateDeleted || to == JobStateBuried {
+		for group := range groups {
+			if finished, buried := s.repGroupFinished(group); finished {
+				ns = append(ns, &Notification{Event: NotifyEventRepGroup, Time: now, RepGroup: group, Buried: buried})
+			}
+		}
+	}
+
+	s.notify(ns...)
+}
+
+// repGroupFinished checks if none of the jobs in the given RepGroup are left in
+// the queue, other than buried ones, returning true (along with the number of
+// buried jobs) only the first time this becomes the case since a job was last
+// added to the RepGroup.
+func (s *Server) repGroupFinished(group string) (bool, int) {
+	buried := 0
+	s.rpl.RLock()
+	for key := range s.rpl.lookup[group] {
+		item, err := s.q.Get(key)
+		if err != nil || item == nil {
+			continue
+		}
+		switch item.Stats().State {
+		case queue.ItemStateBury:
+			buried++
+		case queue.ItemStateRemoved:
+		default:
+			s.rpl.RUnlock()
+			return false, 0
+		}
+	}
+	s.rpl.RUnlock()
+
+	s.notifymutex.Lock()
+	defer s.notifymutex.Unlock()
+	if s.finishedRGs[group] {
+		return false, 0
+	}
+	s.finishedRGs[group] = true
+	return true, buried
+}
+
+// repGroupStarted notes that the given RepGroup has had jobs added to it, so
+// that we'll notify when it finishes again.
+func (s *Server) repGroupStarted(group string) {
+	s.notifymutex.Lock()
+	defer s.notifymutex.Unlock()
+	delete(s.finishedRGs, group)
+}
+
+// notifyBadServer creates a Notification about a cloud server going bad.
+func (s *Server) notifyBadServer(server *cloud.Server) {
+	s.notify(&Notification{
+		Event:      NotifyEventServer,
+		Time:       time.Now(),
+		ServerID:   server.ID,
+		ServerName: server.Name,
+		ServerIP:   server.IP,
+		Msg:        server.PermanentProblem(),
+	})
+}
+
+// hasSubscriptions tells you if anyone is subscribed to notifications, so you
+// can avoid the work of creating them if not.
+func (s *Server) hasSubscriptions() bool {
+	s.notifymutex.Lock()
+	defer s.notifymutex.Unlock()
+	return len(s.subscriptions) > 0
+}
+
+// notify queues the given Notifications to be sent to every Subscription that
+// wants them, sending them straight away if the Subscription has no Debounce.
+func (s *Server) notify(ns ...*Notification) {
+	select {
+	case <-s.stopNotify:
+		return
+	default:
+	}
+
+	s.notifymutex.Lock()
+	defer s.notifymutex.Unlock()
+	for _, sub := range s.subscriptions {
+		queued := false
+		for _, n := range ns {
+			if sub.wants(n) {
+				sub.pending = append(sub.pending, n)
+				queued = true
+			}
+		}
+		if !queued {
+			continue
+		}
+
+		if sub.Debounce <= 0 {
+			s.flushNotifications(sub)
+		} else if sub.timer == nil {
+			name := sub.Name
+			sub.timer = time.AfterFunc(sub.Debounce, func() {
+				s.notifymutex.Lock()
+				defer s.notifymutex.Unlock()
+				if current, exists := s.subscriptions[name]; exists && current == sub {
+					s.flushNotifications(sub)
+				}
+			})
+		}
+	}
+}
+
+// flushNotifications sends a Subscription's pending Notifications in the
+// background. You must hold the notifymutex lock.
+func (s *Server) flushNotifications(sub *Subscription) {
+	sub.timer = nil
+	if len(sub.pending) == 0 {
+		return
+	}
+	select {
+	case <-s.stopNotify:
+		return
+	default:
+	}
+	batch := &NotificationBatch{
+		Subscription:  sub.Name,
+		Manager:       s.ServerInfo.Host + ":" + s.ServerInfo.WebPort,
+		Notifications: sub.pending,
+	}
+	sub.pending = nil
+
+	s.wg.Add(1)
+	go func() {
+		defer internal.LogPanic(s.Logger, "jobqueue notify", false)
+		defer s.wg.Done()
+		err := s.sendNotifications(sub.URL, batch)
+		if err != nil {
+			s.Warn("failed to send notifications", "subscription", sub.Name, "err", err)
+		}
+
+		select {
+		case <-s.stopNotify:
+			return
+		default:
+		}
+		s.notifymutex.Lock()
+		defer s.notifymutex.Unlock()
+		if current, exists := s.subscriptions[sub.Name]; !exists || current != sub {
+			return
+		}
+		if err != nil {
+			sub.Failed++
+			sub.LastErr = err.Error()
+		} else {
+			sub.Sent++
+		}
+		errs := s.db.storeSubscription(sub)
+		if errs != nil {
+			s.Warn("failed to store subscription", "name", sub.Name, "err", errs)
+		}
+	}()
+}
+
+// sendNotifications POSTs the batch as JSON to the given webhook URL, retrying
+// with exponential backoff up to NotifyRetries times if it fails. Gives up
+// early if the server is shutting down.
+func (s *Server) sendNotifications(webhook string, batch *NotificationBatch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopNotify:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	client := &http.Client{Timeout: NotifyTimeout}
+	b := &backoff.Backoff{
+		Min:    NotifyRetryMin,
+		Max:    NotifyRetryMax,
+		Factor: 2,
+		Jitter: true,
+	}
+	for attempt := 0; ; attempt++ {
+		err = postNotifications(ctx, client, webhook, body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= NotifyRetries {
+			return err
+		}
+		select {
+		case <-time.After(b.Duration()):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// postNotifications does a single POST of JSON body to the webhook URL,
+// returning an error if it didn't get a 2xx response.
+func postNotifications(ctx context.Context, client *http.Client, webhook string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	errc := resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST to %s returned %s", webhook, resp.Status)
+	}
+	if err == nil {
+		err = errc
+	}
+	return err
+}
+
+// stopNotifications stops sending Notifications, discarding any that haven't
+// been sent yet.
+func (s *Server) stopNotifications() {
+	close(s.stopNotify)
+	s.notifymutex.Lock()
+	defer s.notifymutex.Unlock()
+	for _, sub := range s.subscriptions {
+		if sub.timer != nil {
+			sub.timer.Stop()
+			sub.timer = nil
+		}
+		sub.pending = nil
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for dealing with the process trees of the Cmds
+// that Execute() runs.
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// killPollInterval is how often killProcessTree() checks to see if the
+// processes it sent SIGTERM to have exited yet.
+const killPollInterval = 100 * time.Millisecond
+
+// procStat holds the parts of /proc/[pid]/stat we're interested in.
+type procStat struct {
+	pid    int
+	ppid   int
+	pgrp   int
+	zombie bool
+}
+
+// readProcStat parses /proc/[pid]/stat.
+func readProcStat(pid int) (*procStat, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	// the 2nd field is the command name in parentheses, which could itself
+	// contain spaces and parentheses, so we parse from after the last ')'
+	i := bytes.LastIndexByte(b, ')')
+	if i == -1 {
+		return nil, fmt.Errorf("could not parse /proc/%d/stat", pid)
+	}
+	fields := bytes.Fields(b[i+1:])
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("could not parse /proc/%d/stat", pid)
+	}
+	ps := &procStat{pid: pid, zombie: string(fields[0]) == "Z"}
+	ps.ppid, err = strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return nil, err
+	}
+	ps.pgrp, err = strconv.Atoi(string(fields[2]))
+	return ps, err
+}
+
+// processTree returns the pid of every live process that descends from the
+// given pid, or that is in the process group led by pid (which catches
+// descendants that were orphaned when their parent exited), with pid itself
+// first. Processes that exit while we're looking are silently skipped.
+func processTree(pid int) []int {
+	tree := []int{pid}
+
+	d, err := os.Open("/proc")
+	if err != nil {
+		return tree
+	}
+	names, err := d.Readdirnames(-1)
+	errc := d.Close()
+	if err != nil || errc != nil {
+		return tree
+	}
+
+	children := make(map[int][]int)
+	inGroup := make(map[int]bool)
+	for _, name := range names {
+		p, erra := strconv.Atoi(name)
+		if erra != nil || p == pid {
+			continue
+		}
+		ps, errs := readProcStat(p)
+		if errs != nil || ps.zombie {
+			continue
+		}
+		children[ps.ppid] = append(children[ps.ppid], p)
+		if ps.pgrp == pid {
+			inGroup[p] = true
+		}
+	}
+
+	seen := map[int]bool{pid: true}
+	for i := 0; i < len(tree); i++ {
+		for _, child := range children[tree[i]] {
+			if !seen[child] {
+				seen[child] = true
+				tree = append(tree, child)
+			}
+		}
+	}
+	for p := range inGroup {
+		if !seen[p] {
+			tree = append(tree, p)
+		}
+	}
+	return tree
+}
+
+// processAlive tells you if the given pid exists and isn't a zombie.
+func processAlive(pid int) bool {
+	ps, err := readProcStat(pid)
+	return err == nil && !ps.zombie
+}
+
+// signalProcessTree sends the given signal to the process group led by pid and
+// to every process in the tree, since descendants may have put themselves in a
+// different process group. The returned error is for the pid itself; failures
+// to signal the other processes (which may have already exited) are ignored.
+func signalProcessTree(pid int, tree []int, sig syscall.Signal) error {
+	var err error
+	_ = syscall.Kill(-pid, sig)
+	for _, p := range tree {
+		errk := syscall.Kill(p, sig)
+		if p == pid {
+			err = errk
+		}
+	}
+	if err == syscall.ESRCH {
+		// it already exited
+		err = nil
+	}
+	return err
+}
+
+// killProcessTree kills the process with the given pid, which should have
+// been started as the leader of its own process group, along with all its
+// descendants. They are first sent SIGTERM so that they can clean up, and then
+// if any are still running after the grace period, they are sent SIGKILL.
+func killProcessTree(pid int, grace time.Duration) error {
+	tree := processTree(pid)
+	err := signalProcessTree(pid, tree, syscall.SIGTERM)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) {
+		alive := false
+		for _, p := range tree {
+			if processAlive(p) {
+				alive = true
+				break
+			}
+		}
+		if !alive {
+			return nil
+		}
+		<-time.After(killPollInterval)
+	}
+
+	// new processes may have been spawned in the meantime, so look again
+	seen := make(map[int]bool)
+	for _, p := range tree {
+		seen[p] = true
+	}
+	for _, p := range processTree(pid) {
+		if !seen[p] {
+			tree = append(tree, p)
+		}
+	}
+	return signalProcessTree(pid, tree, syscall.SIGKILL)
+}
+
+// currentTreeMemory gets the current memory usage of the process with the
+// given pid summed with that of all its descendants (see processTree()).
+func currentTreeMemory(pid int) (int, error) {
+	kb, err := pssKB(pid)
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range processTree(pid)[1:] {
+		if ckb, errp := pssKB(p); errp == nil {
+			kb += ckb
+		}
+	}
+
+	// convert kB to MB
+	return int(kb / 1024), nil
+}
@@ -20,10 +20,15 @@ package jobqueue
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"testing"
 	"time"
@@ -54,13 +59,21 @@ func TestREST(t *testing.T) {
 		DBFile:          config.ManagerDbFile,
 		DBFileBackup:    config.ManagerDbFile + "_bk",
 		Deployment:      config.Deployment,
+		TokenFile:       config.ManagerTokenFile,
+		CAFile:          config.ManagerCAFile,
+		CertFile:        config.ManagerCertFile,
+		KeyFile:         config.ManagerKeyFile,
+		CertDomain:      config.ManagerCertDomain,
 		Logger:          testLogger,
 	}
 	addr := "localhost:" + config.ManagerPort
-	baseURL := "http://localhost:" + config.ManagerWeb
+	baseURL := "https://localhost:" + config.ManagerWeb
 	jobsEndPoint := baseURL + "/rest/v1/jobs"
 	warningsEndPoint := baseURL + "/rest/v1/warnings/"
 	serversEndPoint := baseURL + "/rest/v1/servers/"
+	metricsEndPoint := baseURL + "/metrics"
+	healthzEndPoint := baseURL + "/healthz"
+	historyEndPoint := baseURL + "/rest/v1/history/"
 
 	ServerInterruptTime = 10 * time.Millisecond
 	ServerReserveTicker = 10 * time.Millisecond
@@ -69,14 +82,81 @@ func TestREST(t *testing.T) {
 	ClientTouchInterval = 50 * time.Millisecond
 	clientConnectTime := 1500 * time.Millisecond
 
+	token, errt := internal.ReadOrCreateToken(config.ManagerTokenFile)
+	if errt != nil {
+		log.Fatal(errt)
+	}
+
+	// our requests must trust the manager's CA and supply our token
+	var client *http.Client
+	authedRequest := func(method, url string, body io.Reader) (*http.Response, error) {
+		req, err := http.NewRequest(method, url, body)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Authorization", "Bearer "+string(token))
+		return client.Do(req)
+	}
+	restGet := func(url string) (*http.Response, error) {
+		return authedRequest(http.MethodGet, url, nil)
+	}
+	restPost := func(url string, body io.Reader) (*http.Response, error) {
+		return authedRequest(http.MethodPost, url, body)
+	}
+
 	var server *Server
 	var err error
 	Convey("Once the jobqueue server is up", t, func() {
 		server, _, err = Serve(serverConfig)
 		So(err, ShouldBeNil)
 
+		caCert, err := ioutil.ReadFile(config.ManagerCAFile)
+		So(err, ShouldBeNil)
+		certPool := x509.NewCertPool()
+		certPool.AppendCertsFromPEM(caCert)
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: certPool, ServerName: config.ManagerCertDomain}}}
+
+		Convey("Queries without the correct token are rejected", func() {
+			response, err := client.Get(jobsEndPoint)
+			So(err, ShouldBeNil)
+			So(response.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			response, err = client.Get(jobsEndPoint + "/?token=wrong")
+			So(err, ShouldBeNil)
+			So(response.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			response, err = client.Get(jobsEndPoint + "/?token=" + string(token))
+			So(err, ShouldBeNil)
+			So(response.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			Convey("But the web interface swaps a token in its URL for a cookie", func() {
+				jar, err := cookiejar.New(nil)
+				So(err, ShouldBeNil)
+				browser := &http.Client{Transport: client.Transport, Jar: jar}
+
+				response, err := browser.Get(baseURL + "/?token=wrong")
+				So(err, ShouldBeNil)
+				So(response.StatusCode, ShouldEqual, http.StatusOK)
+				So(response.Request.URL.RawQuery, ShouldBeEmpty)
+				response, err = browser.Get(jobsEndPoint)
+				So(err, ShouldBeNil)
+				So(response.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+				response, err = browser.Get(baseURL + "/?token=" + string(token))
+				So(err, ShouldBeNil)
+				So(response.StatusCode, ShouldEqual, http.StatusOK)
+				So(response.Request.URL.RawQuery, ShouldBeEmpty)
+				response, err = browser.Get(jobsEndPoint)
+				So(err, ShouldBeNil)
+				So(response.StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+
 		Convey("Initial GET queries return nothing", func() {
-			response, err := http.Get(jobsEndPoint)
+			response, err := restGet(jobsEndPoint)
 			So(err, ShouldBeNil)
 			responseData, err := ioutil.ReadAll(response.Body)
 			So(err, ShouldBeNil)
@@ -97,7 +177,7 @@ func TestREST(t *testing.T) {
 			jsonValue, err := json.Marshal(inputJobs)
 			So(err, ShouldBeNil)
 
-			response, err := http.Post(jobsEndPoint+"/", "application/json", bytes.NewBuffer(jsonValue))
+			response, err := restPost(jobsEndPoint+"/", bytes.NewBuffer(jsonValue))
 			So(err, ShouldBeNil)
 			responseData, err := ioutil.ReadAll(response.Body)
 			So(err, ShouldBeNil)
@@ -124,8 +204,44 @@ func TestREST(t *testing.T) {
 			So(jstati[2].ExpectedTime, ShouldEqual, 120)
 			So(jstati[2].Cores, ShouldEqual, 2)
 
+			Convey("You can GET metrics about them, and the health of the server", func() {
+				response, err := client.Get(metricsEndPoint)
+				So(err, ShouldBeNil)
+				So(response.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+				response, err = restGet(metricsEndPoint)
+				So(err, ShouldBeNil)
+				So(response.StatusCode, ShouldEqual, http.StatusOK)
+				responseData, err := ioutil.ReadAll(response.Body)
+				So(err, ShouldBeNil)
+				metrics := string(responseData)
+				So(metrics, ShouldContainSubstring, "# TYPE wr_jobs gauge\n")
+				So(metrics, ShouldContainSubstring, `wr_jobs{state="ready",rep_group="rp1"} 2`+"\n")
+				So(metrics, ShouldContainSubstring, `wr_jobs{state="ready",rep_group="rp2"} 1`+"\n")
+				So(metrics, ShouldContainSubstring, "# TYPE wr_request_duration_seconds summary\n")
+				So(metrics, ShouldContainSubstring, "wr_db_size_bytes ")
+				So(metrics, ShouldNotContainSubstring, "wr_cloud_servers ")
+
+				response, err = client.Get(healthzEndPoint)
+				So(err, ShouldBeNil)
+				So(response.StatusCode, ShouldEqual, http.StatusOK)
+				responseData, err = ioutil.ReadAll(response.Body)
+				So(err, ShouldBeNil)
+				So(string(responseData), ShouldEqual, "ok\n")
+
+				server.sgcmutex.Lock()
+				server.sgerrs["fake_group"] = "fake error"
+				server.sgcmutex.Unlock()
+				response, err = client.Get(healthzEndPoint)
+				So(err, ShouldBeNil)
+				So(response.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+				responseData, err = ioutil.ReadAll(response.Body)
+				So(err, ShouldBeNil)
+				So(string(responseData), ShouldContainSubstring, "job scheduler is failing")
+			})
+
 			Convey("You can GET the current status of all jobs", func() {
-				response, err := http.Get(jobsEndPoint)
+				response, err := restGet(jobsEndPoint)
 				So(err, ShouldBeNil)
 				responseData, err := ioutil.ReadAll(response.Body)
 				So(err, ShouldBeNil)
@@ -137,7 +253,7 @@ func TestREST(t *testing.T) {
 			})
 
 			Convey("You can GET the status of particular jobs using their ids", func() {
-				response, err := http.Get(jobsEndPoint + "/de6d167c58701e55f5b9f9e1e91d7807")
+				response, err := restGet(jobsEndPoint + "/de6d167c58701e55f5b9f9e1e91d7807")
 				So(err, ShouldBeNil)
 				responseData, err := ioutil.ReadAll(response.Body)
 				So(err, ShouldBeNil)
@@ -148,7 +264,7 @@ func TestREST(t *testing.T) {
 				So(len(jstati), ShouldEqual, 1)
 				So(jstati[0].Key, ShouldEqual, "de6d167c58701e55f5b9f9e1e91d7807")
 
-				response, err = http.Get(jobsEndPoint + "/de6d167c58701e55f5b9f9e1e91d7807,db1e7d99becace3306c1c2470331c78e")
+				response, err = restGet(jobsEndPoint + "/de6d167c58701e55f5b9f9e1e91d7807,db1e7d99becace3306c1c2470331c78e")
 				So(err, ShouldBeNil)
 				responseData, err = ioutil.ReadAll(response.Body)
 				So(err, ShouldBeNil)
@@ -162,7 +278,7 @@ func TestREST(t *testing.T) {
 			})
 
 			Convey("You can GET the status of jobs by RepGroup", func() {
-				response, err := http.Get(jobsEndPoint + "/rp1")
+				response, err := restGet(jobsEndPoint + "/rp1")
 				So(err, ShouldBeNil)
 				responseData, err := ioutil.ReadAll(response.Body)
 				So(err, ShouldBeNil)
@@ -178,7 +294,7 @@ func TestREST(t *testing.T) {
 				So(keys, ShouldResemble, map[string]bool{"de6d167c58701e55f5b9f9e1e91d7807": true, "db1e7d99becace3306c1c2470331c78e": true})
 
 				Convey("And you can modify the results by changing limit", func() {
-					response, err := http.Get(jobsEndPoint + "/rp1?limit=1")
+					response, err := restGet(jobsEndPoint + "/rp1?limit=1")
 					So(err, ShouldBeNil)
 					responseData, err := ioutil.ReadAll(response.Body)
 					So(err, ShouldBeNil)
@@ -192,7 +308,7 @@ func TestREST(t *testing.T) {
 			})
 
 			Convey("Once one of the jobs has changed state", func() {
-				jq, err := Connect(addr, clientConnectTime)
+				jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
 				So(err, ShouldBeNil)
 				defer jq.Disconnect()
 
@@ -214,7 +330,7 @@ func TestREST(t *testing.T) {
 				So(job.Exitcode, ShouldEqual, 1)
 
 				Convey("You can GET all jobs by state, and get their stdout/err", func() {
-					response, err := http.Get(jobsEndPoint + "/?state=ready")
+					response, err := restGet(jobsEndPoint + "/?state=ready")
 					So(err, ShouldBeNil)
 					responseData, err := ioutil.ReadAll(response.Body)
 					So(err, ShouldBeNil)
@@ -229,7 +345,7 @@ func TestREST(t *testing.T) {
 					}
 					So(keys, ShouldResemble, map[string]bool{"de6d167c58701e55f5b9f9e1e91d7807": true, "f5c0d6240167a6e0b803e23f74e3a085": true})
 
-					response, err = http.Get(jobsEndPoint + "/?state=buried&std=true")
+					response, err = restGet(jobsEndPoint + "/?state=buried&std=true")
 					So(err, ShouldBeNil)
 					responseData, err = ioutil.ReadAll(response.Body)
 					So(err, ShouldBeNil)
@@ -244,7 +360,7 @@ func TestREST(t *testing.T) {
 					So(jstati2[0].State, ShouldEqual, "buried")
 					So(jstati2[0].StdOut, ShouldEqual, "3")
 
-					response, err = http.Get(jobsEndPoint + "/?state=buried&std=false")
+					response, err = restGet(jobsEndPoint + "/?state=buried&std=false")
 					So(err, ShouldBeNil)
 					responseData, err = ioutil.ReadAll(response.Body)
 					So(err, ShouldBeNil)
@@ -261,7 +377,7 @@ func TestREST(t *testing.T) {
 				})
 
 				Convey("You can GET all jobs by state and RepGroup", func() {
-					response, err := http.Get(jobsEndPoint + "/rp1?state=ready")
+					response, err := restGet(jobsEndPoint + "/rp1?state=ready")
 					So(err, ShouldBeNil)
 					responseData, err := ioutil.ReadAll(response.Body)
 					So(err, ShouldBeNil)
@@ -272,6 +388,40 @@ func TestREST(t *testing.T) {
 					So(len(jstati), ShouldEqual, 1)
 					So(jstati[0].Key, ShouldEqual, "de6d167c58701e55f5b9f9e1e91d7807")
 				})
+
+				Convey("You can GET its failed run from the history", func() {
+					// failed runs are stored in the background
+					var jstati []jstatus
+					for i := 0; i < 100; i++ {
+						response, err := restGet(historyEndPoint + "?failed=true&exitcode=1")
+						So(err, ShouldBeNil)
+						So(response.StatusCode, ShouldEqual, http.StatusOK)
+						responseData, err := ioutil.ReadAll(response.Body)
+						So(err, ShouldBeNil)
+						err = json.Unmarshal(responseData, &jstati)
+						So(err, ShouldBeNil)
+						if len(jstati) > 0 {
+							break
+						}
+						<-time.After(10 * time.Millisecond)
+					}
+					So(len(jstati), ShouldEqual, 1)
+					So(jstati[0].Key, ShouldEqual, "db1e7d99becace3306c1c2470331c78e")
+					So(jstati[0].Exitcode, ShouldEqual, 1)
+
+					response, err := restGet(historyEndPoint + "?cmd=echo&exitcode=0")
+					So(err, ShouldBeNil)
+					responseData, err := ioutil.ReadAll(response.Body)
+					So(err, ShouldBeNil)
+					var jstati2 []jstatus
+					err = json.Unmarshal(responseData, &jstati2)
+					So(err, ShouldBeNil)
+					So(len(jstati2), ShouldEqual, 0)
+
+					response, err = restGet(historyEndPoint + "?from=yesterday")
+					So(err, ShouldBeNil)
+					So(response.StatusCode, ShouldEqual, http.StatusBadRequest)
+				})
 			})
 		})
 
@@ -279,7 +429,7 @@ func TestREST(t *testing.T) {
 			inputJobs := []*JobViaJSON{{RepGrp: "foo"}}
 			jsonValue, err := json.Marshal(inputJobs)
 			So(err, ShouldBeNil)
-			response, err := http.Post(jobsEndPoint+"/", "application/json", bytes.NewBuffer(jsonValue))
+			response, err := restPost(jobsEndPoint+"/", bytes.NewBuffer(jsonValue))
 			So(err, ShouldBeNil)
 			So(response.StatusCode, ShouldEqual, 400)
 			responseData, err := ioutil.ReadAll(response.Body)
@@ -294,7 +444,7 @@ func TestREST(t *testing.T) {
 			bs := fmt.Sprintf("&on_success=%s&on_failure=%s&on_exit=%s", url.QueryEscape(`[{"cleanup":true}]`), url.QueryEscape(`[{"run":"foo"}]`), url.QueryEscape(`[{"cleanup_all":true}]`))
 			mountJSON := `[{"Mount":"/tmp/wr_mnt","Targets":[{"Profile":"default","Path":"mybucket/subdir","Write":true}]}]`
 			mounts := fmt.Sprintf("&mounts=%s", url.QueryEscape(mountJSON))
-			response, err := http.Post(jobsEndPoint+"/?rep_grp=defaultedRepGrp&cwd=/tmp/foo&cpus=2&dep_grps=a,b,c&deps=x,y&change_home=true&memory=3G&time=4m"+bs+mounts, "application/json", bytes.NewBuffer(jsonValue))
+			response, err := restPost(jobsEndPoint+"/?rep_grp=defaultedRepGrp&cwd=/tmp/foo&cpus=2&dep_grps=a,b,c&deps=x,y&change_home=true&memory=3G&time=4m"+bs+mounts, bytes.NewBuffer(jsonValue))
 			So(err, ShouldBeNil)
 			responseData, err := ioutil.ReadAll(response.Body)
 			So(err, ShouldBeNil)
@@ -317,8 +467,41 @@ func TestREST(t *testing.T) {
 			So(jstati[0].Mounts, ShouldEqual, mountJSON)
 		})
 
+		Convey("You can POST lsf options, which put jobs in their own scheduler groups", func() {
+			inputJobs := []*JobViaJSON{{Cmd: "echo lsf1", LSFQueue: "yesterday", LSFMisc: "-x"}, {Cmd: "echo lsf2"}}
+			jsonValue, err := json.Marshal(inputJobs)
+			So(err, ShouldBeNil)
+			response, err := restPost(jobsEndPoint+"/?lsf_project=proj&lsf_hosts=hostA%20hostB", bytes.NewBuffer(jsonValue))
+			So(err, ShouldBeNil)
+			So(response.StatusCode, ShouldEqual, http.StatusCreated)
+
+			jq, err := Connect(addr, config.ManagerCAFile, config.ManagerCertDomain, token, clientConnectTime)
+			So(err, ShouldBeNil)
+			defer jq.Disconnect()
+			jobs, err := jq.GetByEssences([]*JobEssence{{Cmd: "echo lsf1"}, {Cmd: "echo lsf2"}})
+			So(err, ShouldBeNil)
+			So(len(jobs), ShouldEqual, 2)
+			So(jobs[0].Requirements.Other, ShouldResemble, map[string]string{"lsf_queue": "yesterday", "lsf_misc": "-x", "lsf_project": "proj", "lsf_hosts": "hostA hostB"})
+			So(jobs[1].Requirements.Other, ShouldResemble, map[string]string{"lsf_project": "proj", "lsf_hosts": "hostA hostB"})
+			So(jobs[0].Requirements.Stringify(), ShouldNotEqual, jobs[1].Requirements.Stringify())
+
+			jvj := &JobViaJSON{LSFQueue: "normal"}
+			jm, err := jvj.Modifier()
+			So(err, ShouldBeNil)
+			So(jm.OtherSet, ShouldBeTrue)
+			jm.Other["lsf_misc"] = ""
+			modified, err := jq.Modify([]*JobEssence{{Cmd: "echo lsf1"}}, jm)
+			So(err, ShouldBeNil)
+			So(modified, ShouldEqual, 1)
+
+			jobs, err = jq.GetByEssences([]*JobEssence{{Cmd: "echo lsf1"}})
+			So(err, ShouldBeNil)
+			So(len(jobs), ShouldEqual, 1)
+			So(jobs[0].Requirements.Other, ShouldResemble, map[string]string{"lsf_queue": "normal", "lsf_project": "proj", "lsf_hosts": "hostA hostB"})
+		})
+
 		Convey("Initial GET queries on the warnings endpoint return nothing", func() {
-			response, err := http.Get(warningsEndPoint)
+			response, err := restGet(warningsEndPoint)
 			So(err, ShouldBeNil)
 			responseData, err := ioutil.ReadAll(response.Body)
 			So(err, ShouldBeNil)
@@ -345,7 +528,7 @@ func TestREST(t *testing.T) {
 				So(len(server.schedIssues), ShouldEqual, 2)
 				server.simutex.Unlock()
 
-				response, err := http.Get(warningsEndPoint)
+				response, err := restGet(warningsEndPoint)
 				So(err, ShouldBeNil)
 				responseData, err := ioutil.ReadAll(response.Body)
 				So(err, ShouldBeNil)
@@ -362,7 +545,7 @@ func TestREST(t *testing.T) {
 		})
 
 		Convey("Initial GET queries on the warnings and servers endpoints return nothing", func() {
-			response, err := http.Get(serversEndPoint)
+			response, err := restGet(serversEndPoint)
 			So(err, ShouldBeNil)
 			responseData, err := ioutil.ReadAll(response.Body)
 			So(err, ShouldBeNil)
@@ -384,7 +567,7 @@ func TestREST(t *testing.T) {
 				So(len(server.badServers), ShouldEqual, 1)
 				server.bsmutex.Unlock()
 
-				response, err := http.Get(serversEndPoint)
+				response, err := restGet(serversEndPoint)
 				So(err, ShouldBeNil)
 				responseData, err := ioutil.ReadAll(response.Body)
 				So(err, ShouldBeNil)
@@ -395,16 +578,11 @@ func TestREST(t *testing.T) {
 				So(len(servers), ShouldEqual, 1)
 				So(servers[0].Name, ShouldEqual, "name")
 
-				req, err := http.NewRequest(http.MethodDelete, serversEndPoint, nil)
-				So(err, ShouldBeNil)
-				client := http.DefaultClient
-				response, err = client.Do(req)
+				response, err = authedRequest(http.MethodDelete, serversEndPoint, nil)
 				So(err, ShouldBeNil)
 				So(response.StatusCode, ShouldEqual, http.StatusBadRequest)
 
-				req, err = http.NewRequest(http.MethodDelete, serversEndPoint+"?id=serverid1", nil)
-				So(err, ShouldBeNil)
-				response, err = client.Do(req)
+				response, err = authedRequest(http.MethodDelete, serversEndPoint+"?id=serverid1", nil)
 				So(err, ShouldBeNil)
 				So(response.StatusCode, ShouldEqual, http.StatusNotModified) // because the fake server doesn't actually exist
 
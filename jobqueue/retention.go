@@ -0,0 +1,183 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for pruning old complete jobs from the database,
+// according to a RetentionPolicy.
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/VertebrateResequencing/wr/internal"
+)
+
+// these global variables are primarily exported for testing purposes; you
+// probably shouldn't change them
+var (
+	// ServerPruneTicker is how often the server prunes its database according
+	// to its RetentionPolicy.
+	ServerPruneTicker = 1 * time.Hour
+)
+
+// RetentionPolicy says how long the server keeps complete jobs in its
+// database, for use in ServerConfig. Jobs that completed longer ago than
+// MaxAge, along with their STDOUT/ERR, environment variables and runs in the
+// history (see HistoryQuery), are regularly removed. RepGroupMaxAges override
+// MaxAge for jobs with matching RepGroups. A max age of 0 keeps jobs forever.
+//
+// If KeepStats is true, removed jobs' runs are kept in the history, so you can
+// still find out what ran when and where, with what exit code and resource
+// usage, but not what the jobs' STDOUT/ERR or environment were.
+//
+// The stats the server uses to learn the memory and time requirements of jobs
+// are always kept.
+type RetentionPolicy struct {
+	MaxAge          time.Duration
+	RepGroupMaxAges []*RepGroupMaxAge
+	KeepStats       bool
+}
+
+// RepGroupMaxAge is a max age that applies to jobs with a RepGroup matching
+// the RepGroup glob (as understood by path.Match()).
+type RepGroupMaxAge struct {
+	RepGroup string
+	MaxAge   time.Duration
+}
+
+// NewRetentionPolicy creates a RetentionPolicy from strings, as found in wr's
+// config files. maxAge is a duration like "720h", or "" for 0. repGroupMaxAges
+// is a comma separated list of glob=duration, like "tmp*=24h,keep*=0"; the
+// first glob that matches a job's RepGroup sets its max age. keepStats sets
+// KeepStats.
+func NewRetentionPolicy(maxAge string, repGroupMaxAges string, keepStats bool) (*RetentionPolicy, error) {
+	rp := &RetentionPolicy{KeepStats: keepStats}
+	var err error
+	rp.MaxAge, err = parseMaxAge(maxAge)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range strings.Split(repGroupMaxAges, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("retention rule [%s] is not in the form glob=duration", rule)
+		}
+		if _, err = path.Match(parts[0], ""); err != nil {
+			return nil, fmt.Errorf("retention rule [%s] has a bad glob: %s", rule, err)
+		}
+		age, errp := parseMaxAge(parts[1])
+		if errp != nil {
+			return nil, fmt.Errorf("retention rule [%s] has a bad duration: %s", rule, errp)
+		}
+		rp.RepGroupMaxAges = append(rp.RepGroupMaxAges, &RepGroupMaxAge{RepGroup: parts[0], MaxAge: age})
+	}
+	return rp, nil
+}
+
+// parseMaxAge parses a duration, treating "" as 0.
+func parseMaxAge(age string) (time.Duration, error) {
+	if age == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(age)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("max age can't be negative")
+	}
+	return d, nil
+}
+
+// maxAge returns the max age of jobs with the given RepGroup.
+func (rp *RetentionPolicy) maxAge(repGroup string) time.Duration {
+	for _, rgma := range rp.RepGroupMaxAges {
+		if matched, _ := path.Match(rgma.RepGroup, repGroup); matched {
+			return rgma.MaxAge
+		}
+	}
+	return rp.MaxAge
+}
+
+// minAge returns the smallest non-zero max age of the policy, or 0 if the
+// policy keeps all jobs forever.
+func (rp *RetentionPolicy) minAge() time.Duration {
+	min := rp.MaxAge
+	for _, rgma := range rp.RepGroupMaxAges {
+		if rgma.MaxAge > 0 && (min == 0 || rgma.MaxAge < min) {
+			min = rgma.MaxAge
+		}
+	}
+	return min
+}
+
+// expired tells you if a job with the given RepGroup that ended at the given
+// time should no longer be kept.
+func (rp *RetentionPolicy) expired(repGroup string, ended time.Time, now time.Time) bool {
+	if ended.IsZero() {
+		return false
+	}
+	age := rp.maxAge(repGroup)
+	return age > 0 && now.Sub(ended) > age
+}
+
+// pruneLoop prunes our database according to the given RetentionPolicy
+// straight away and then every ServerPruneTicker, until stopPrune is closed.
+func (s *Server) pruneLoop(rp *RetentionPolicy) {
+	defer internal.LogPanic(s.Logger, "jobqueue prune", true)
+	ticker := time.NewTicker(ServerPruneTicker)
+	defer ticker.Stop()
+	s.prune(rp, time.Now())
+	for {
+		select {
+		case now := <-ticker.C:
+			s.prune(rp, now)
+		case <-s.stopPrune:
+			return
+		}
+	}
+}
+
+// prune does a single pruning of our database, unless we're shutting down.
+func (s *Server) prune(rp *RetentionPolicy, now time.Time) {
+	s.pruneMutex.Lock()
+	defer s.pruneMutex.Unlock()
+	select {
+	case <-s.stopPrune:
+		return
+	default:
+	}
+
+	start := time.Now()
+	ps, err := s.db.prune(rp, now)
+	if err != nil {
+		s.Error("Pruning the database failed", "err", err)
+		return
+	}
+	if ps.total() > 0 {
+		s.Debug("pruned database", "jobs", ps.Jobs, "runs", ps.Runs, "lookups", ps.Lookups, "std", ps.Std, "envs", ps.Envs, "took", time.Since(start))
+	}
+}
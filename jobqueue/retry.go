@@ -0,0 +1,309 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the implementation of Job retry policies.
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// jobAttemptHistoryMax is the maximum number of JobAttempts we remember for a
+// Job; beyond this only the most recent are kept.
+const jobAttemptHistoryMax = 50
+
+// failReasonNames maps the short names users can use for FailReasons in a
+// RetryPolicyViaJSON to the FailReason* values themselves.
+var failReasonNames = map[string]string{
+	"env":      FailReasonEnv,
+	"cwd":      FailReasonCwd,
+	"start":    FailReasonStart,
+	"cperm":    FailReasonCPerm,
+	"cfound":   FailReasonCFound,
+	"cexit":    FailReasonCExit,
+	"exit":     FailReasonExit,
+	"ram":      FailReasonRAM,
+	"time":     FailReasonTime,
+	"abnormal": FailReasonAbnormal,
+	"lost":     FailReasonLost,
+	"signal":   FailReasonSignal,
+	"resource": FailReasonResource,
+	"mount":    FailReasonMount,
+	"upload":   FailReasonUpload,
+	"killed":   FailReasonKilled,
+	"outputs":  FailReasonOutputs,
+	"capture":  FailReasonCapture,
+}
+
+// RetryFailReason returns the FailReason* value corresponding to the given short
+// name (eg. "signal" for FailReasonSignal). The FailReason* values themselves
+// are also accepted.
+func RetryFailReason(name string) (string, error) {
+	if reason, exists := failReasonNames[name]; exists {
+		return reason, nil
+	}
+	for _, reason := range failReasonNames {
+		if reason == name {
+			return reason, nil
+		}
+	}
+	names := make([]string, 0, len(failReasonNames))
+	for short := range failReasonNames {
+		names = append(names, short)
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("unknown fail reason '%s'; it must be one of %v", name, names)
+}
+
+// RetryPolicy describes when and how a Job that fails should be retried, within
+// the limit of its Retries.
+//
+// By default, a failed Job is retried whatever the reason for the failure. If
+// ExitCodes or FailReasons are set, it is only retried if its Cmd exited with
+// one of the ExitCodes, or it failed with one of the FailReasons (FailReason*
+// values); otherwise it is buried immediately.
+//
+// By default, a retry happens after ClientReleaseDelay. Backoff, if set,
+// replaces that initial delay, and if MaxBackoff is greater, the delay doubles
+// after each failure up to a maximum of MaxBackoff. Jitter, a fraction between
+// 0 and 1, randomly reduces each delay by up to that proportion, so that many
+// Jobs that failed at the same time don't all retry at the same time.
+//
+// If OtherHost is true, for ServerRetryHostGrace after the delay the Job will
+// not be reserved by clients running on any host that it previously failed on,
+// giving clients on other hosts a chance to run it first. It's only a
+// preference: after the grace period, or if the scheduler only has one host,
+// hosts it failed on can reserve it.
+type RetryPolicy struct {
+	ExitCodes   []int
+	FailReasons []string
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Jitter      float64
+	OtherHost   bool
+}
+
+// retryable tells you if a failure with the given exit details and FailReason
+// should be retried according to this policy.
+func (rp *RetryPolicy) retryable(exited bool, exitcode int, failreason string) bool {
+	if len(rp.ExitCodes) == 0 && len(rp.FailReasons) == 0 {
+		return true
+	}
+	for _, reason := range rp.FailReasons {
+		if reason == failreason {
+			return true
+		}
+	}
+	if exited && exitcode != 0 {
+		for _, code := range rp.ExitCodes {
+			if code == exitcode {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait before retrying, given the number of times
+// the Job has now failed.
+func (rp *RetryPolicy) delay(failures int) time.Duration {
+	d := ClientReleaseDelay
+	if rp.Backoff > 0 {
+		d = rp.Backoff
+	}
+	if rp.MaxBackoff > d {
+		for i := 1; i < failures && d < rp.MaxBackoff; i++ {
+			d *= 2
+		}
+		if d > rp.MaxBackoff {
+			d = rp.MaxBackoff
+		}
+	}
+	if rp.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * rp.Jitter * float64(d)) // #nosec
+	}
+	return d
+}
+
+// RetryPolicyViaJSON is the friendly form of RetryPolicy used when describing
+// it in JSON. FailReasons can be short names (see RetryFailReason()), and the
+// backoffs are durations with a unit suffix, eg. 30s for 30 seconds.
+type RetryPolicyViaJSON struct {
+	ExitCodes   []int    `json:"exit_codes"`
+	FailReasons []string `json:"fail_reasons"`
+	Backoff     string   `json:"backoff"`
+	MaxBackoff  string   `json:"max_backoff"`
+	Jitter      float64  `json:"jitter"`
+	OtherHost   bool     `json:"other_host"`
+}
+
+// RetryPolicy converts the friendly RetryPolicyViaJSON struct to a real
+// RetryPolicy.
+func (rpj *RetryPolicyViaJSON) RetryPolicy() (*RetryPolicy, error) {
+	rp := &RetryPolicy{
+		ExitCodes: rpj.ExitCodes,
+		Jitter:    rpj.Jitter,
+		OtherHost: rpj.OtherHost,
+	}
+
+	for _, name := range rpj.FailReasons {
+		reason, err := RetryFailReason(name)
+		if err != nil {
+			return nil, err
+		}
+		rp.FailReasons = append(rp.FailReasons, reason)
+	}
+
+	var err error
+	if rpj.Backoff != "" {
+		rp.Backoff, err = time.ParseDuration(rpj.Backoff)
+		if err != nil {
+			return nil, fmt.Errorf("backoff value (%s) was not specified correctly: %s", rpj.Backoff, err)
+		}
+	}
+	if rpj.MaxBackoff != "" {
+		rp.MaxBackoff, err = time.ParseDuration(rpj.MaxBackoff)
+		if err != nil {
+			return nil, fmt.Errorf("max_backoff value (%s) was not specified correctly: %s", rpj.MaxBackoff, err)
+		}
+	}
+	if rp.Backoff < 0 || rp.MaxBackoff < 0 {
+		return nil, fmt.Errorf("backoff and max_backoff can't be negative")
+	}
+	if rp.Jitter < 0 || rp.Jitter > 1 {
+		return nil, fmt.Errorf("jitter value (%g) must be between 0 and 1", rp.Jitter)
+	}
+
+	return rp, nil
+}
+
+// ViaJSON converts a RetryPolicy back to the friendly RetryPolicyViaJSON form,
+// using the short names of its FailReasons.
+func (rp *RetryPolicy) ViaJSON() *RetryPolicyViaJSON {
+	rpj := &RetryPolicyViaJSON{
+		ExitCodes: rp.ExitCodes,
+		Jitter:    rp.Jitter,
+		OtherHost: rp.OtherHost,
+	}
+	for _, reason := range rp.FailReasons {
+		name := reason
+		for short, r := range failReasonNames {
+			if r == reason {
+				name = short
+				break
+			}
+		}
+		rpj.FailReasons = append(rpj.FailReasons, name)
+	}
+	if rp.Backoff > 0 {
+		rpj.Backoff = rp.Backoff.String()
+	}
+	if rp.MaxBackoff > 0 {
+		rpj.MaxBackoff = rp.MaxBackoff.String()
+	}
+	return rpj
+}
+
+// JobAttempt records what happened when a Job's Cmd was run.
+type JobAttempt struct {
+	Host       string
+	Exitcode   int
+	FailReason string
+	StartTime  time.Time
+	EndTime    time.Time
+}
+
+// WallTime returns the time the attempt took to run.
+func (ja *JobAttempt) WallTime() time.Duration {
+	return ja.EndTime.Sub(ja.StartTime)
+}
+
+// recordAttempt adds the outcome of the most recent run of the Job's Cmd to its
+// AttemptHistory. The Job must be locked, and its end state set.
+func (j *Job) recordAttempt() {
+	if j.StartTime.IsZero() || j.EndTime.IsZero() || j.Skipped {
+		return
+	}
+	if n := len(j.AttemptHistory); n > 0 && j.AttemptHistory[n-1].StartTime.Equal(j.StartTime) {
+		// we already recorded this attempt, eg. the client retried a request
+		return
+	}
+	j.AttemptHistory = append(j.AttemptHistory, &JobAttempt{
+		Host:       j.Host,
+		Exitcode:   j.Exitcode,
+		FailReason: j.FailReason,
+		StartTime:  j.StartTime,
+		EndTime:    j.EndTime,
+	})
+	if len(j.AttemptHistory) > jobAttemptHistoryMax {
+		j.AttemptHistory = j.AttemptHistory[len(j.AttemptHistory)-jobAttemptHistoryMax:]
+	}
+}
+
+// applyRetryPolicy sets UntilBuried to 0 if the Job's RetryPolicy says its
+// current failure should not be retried. The Job must be locked.
+func (j *Job) applyRetryPolicy() {
+	if j.RetryPolicy == nil || j.FailReason == "" {
+		return
+	}
+	if !j.RetryPolicy.retryable(j.Exited, j.Exitcode, j.FailReason) {
+		j.UntilBuried = 0
+	}
+}
+
+// retryDelay returns how long a Job that is being released should be delayed
+// for, according to its RetryPolicy, and notes the hosts it should avoid if the
+// policy wants it to retry on a different one. The Job must be locked.
+func (j *Job) retryDelay() time.Duration {
+	if j.RetryPolicy == nil {
+		return ClientReleaseDelay
+	}
+	failures := int(j.Retries) + 1 - int(j.UntilBuried)
+	d := j.RetryPolicy.delay(failures)
+	if j.RetryPolicy.OtherHost {
+		j.avoidHostsUntil = time.Now().Add(d + ServerRetryHostGrace)
+	}
+	return d
+}
+
+// avoidsHost tells you if a client on the given host should not yet reserve
+// this Job, because its RetryPolicy prefers it to be retried on a different
+// host to one it recently failed on.
+func (j *Job) avoidsHost(host string) bool {
+	j.RLock()
+	defer j.RUnlock()
+	if host == "" || j.RetryPolicy == nil || !j.RetryPolicy.OtherHost || time.Now().After(j.avoidHostsUntil) {
+		return false
+	}
+	for _, attempt := range j.AttemptHistory {
+		if attempt.Host == host && attempt.FailReason != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// soleHost tells you if our scheduler only has the one host to run jobs on, in
+// which case Jobs can't avoid the hosts they failed on.
+func (s *Server) soleHost() bool {
+	return s.ServerInfo.Scheduler == "local" || s.scheduler.ServerCount() == 0
+}
@@ -518,6 +518,11 @@ func (s *local) hostToID(host string) string {
 	return ""
 }
 
+// serverCount always returns -1, since we're not in the cloud.
+func (s *local) serverCount() int {
+	return -1
+}
+
 // setMessageCallBack does nothing at the moment, since we don't generate any
 // messages for the user.
 func (s *local) setMessageCallBack(cb MessageCallBack) {}
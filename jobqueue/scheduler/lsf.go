@@ -36,6 +36,16 @@ import (
 	"github.com/inconshreveable/log15"
 )
 
+// lsfOtherOptions maps the Requirements.Other keys we understand to the bsub
+// options they become. (lsf_queue is dealt with by determineQueue(), and
+// lsf_misc is passed through as-is.)
+var lsfOtherOptions = [][2]string{
+	{"lsf_resources", "-R"},
+	{"lsf_hosts", "-m"},
+	{"lsf_project", "-P"},
+	{"lsf_group", "-G"},
+}
+
 // lsf is our implementer of scheduleri
 type lsf struct {
 	config             *ConfigLSF
@@ -44,6 +54,7 @@ type lsf struct {
 	bsubRegex          *regexp.Regexp
 	memLimitMultiplier float32
 	queues             map[string]map[string]int
+	queueHosts         map[string]map[string]bool
 	sortedqs           map[int][]string
 	sortedqKeys        []int
 	log15.Logger
@@ -115,6 +126,7 @@ func (s *lsf) initialize(config interface{}, logger log15.Logger) error {
 	}
 	bqScanner := bufio.NewScanner(bqout)
 	s.queues = make(map[string]map[string]int)
+	s.queueHosts = make(map[string]map[string]bool)
 	queue := ""
 	nextIsPrio := false
 	lookingAtDefaults := false
@@ -250,6 +262,22 @@ func (s *lsf) initialize(config interface{}, logger log15.Logger) error {
 				if matches[2] != "all" {
 					s.queues[queue][kind] = len(vals)
 					updateHighest(kind, len(vals))
+
+					// remember the hosts so we can validate lsf_hosts against
+					// them, but only if they're all plain host names, since
+					// we don't know the members of host groups
+					hosts := make(map[string]bool)
+					for _, val := range vals {
+						host := lsfHostName(val)
+						if strings.HasSuffix(val, "/") || host == "all" || host == "others" || strings.HasPrefix(host, "~") {
+							hosts = nil
+							break
+						}
+						hosts[host] = true
+					}
+					if hosts != nil {
+						s.queueHosts[queue] = hosts
+					}
 				}
 			}
 		}
@@ -421,10 +449,12 @@ func (s *lsf) schedule(cmd string, req *Requirements, count int) error {
 	if req.Cores > 1 {
 		bsubArgs = append(bsubArgs, "-n", fmt.Sprintf("%d", req.Cores))
 	}
-	// if len(req.Other) > 0 {
-	// *** not yet implemented; would check this map for lsf-related keys
-	// and handle them appropriately...
-	// }
+	for _, option := range lsfOtherOptions {
+		if val := req.Other[option[0]]; val != "" {
+			bsubArgs = append(bsubArgs, option[1], val)
+		}
+	}
+	bsubArgs = append(bsubArgs, strings.Fields(req.Other["lsf_misc"])...)
 
 	// for checkCmd() to work efficiently we must always set a job name that
 	// corresponds to the cmd. It must also be unique otherwise LSF would not
@@ -502,12 +532,23 @@ func (s *lsf) busy() bool {
 }
 
 // determineQueue picks a queue, preferring ones that are more likely to run our
-// job the soonest (amongst those that are capable of running it). *** globalMax
-// option and associated code may be removed if we never have a way for user
-// to pass this in.
+// job the soonest (amongst those that are capable of running it). If the req
+// specifies an lsf_queue, only that queue is considered, and if it specifies
+// lsf_hosts, queues known to not include those hosts are not considered.
+// *** globalMax option and associated code may be removed if we never have a
+// way for user to pass this in.
 func (s *lsf) determineQueue(req *Requirements, globalMax int) (string, error) {
 	seconds := req.Time.Seconds()
 	mb := req.RAM
+	wanted := req.Other["lsf_queue"]
+	var hosts []string
+	for _, host := range strings.Fields(req.Other["lsf_hosts"]) {
+		host = lsfHostName(host)
+		if host == "all" || host == "others" || strings.HasPrefix(host, "~") {
+			continue
+		}
+		hosts = append(hosts, host)
+	}
 	sortedQueue := 0
 	if globalMax > 0 {
 		for _, queueKey := range s.sortedqKeys {
@@ -518,7 +559,28 @@ func (s *lsf) determineQueue(req *Requirements, globalMax int) (string, error) {
 		}
 	}
 
-	for _, queue := range s.sortedqs[sortedQueue] {
+	candidates := s.sortedqs[sortedQueue]
+	if wanted != "" {
+		if _, usable := s.queues[wanted]; !usable {
+			return "", Error{"lsf", "determineQueue", ErrImpossible}
+		}
+		candidates = []string{wanted}
+	}
+
+	for _, queue := range candidates {
+		if qhosts, restricted := s.queueHosts[queue]; restricted {
+			missing := false
+			for _, host := range hosts {
+				if !qhosts[host] {
+					missing = true
+					break
+				}
+			}
+			if missing {
+				continue
+			}
+		}
+
 		memLimit := s.queues[queue]["memlimit"]
 		if memLimit > 0 && memLimit < mb {
 			continue
@@ -641,11 +703,27 @@ func (s *lsf) parseBjobs(jobPrefix string, callback bjobsCB) error {
 	return err
 }
 
+// lsfHostName strips the preference (+n) and cluster (@cluster) suffixes and
+// host group (/) suffix from a host specification, as seen in bqueues -l
+// output and bsub -m options.
+func lsfHostName(spec string) string {
+	spec = strings.TrimSuffix(spec, "/")
+	if i := strings.IndexAny(spec, "+@"); i > 0 {
+		spec = spec[:i]
+	}
+	return spec
+}
+
 // hostToID always returns an empty string, since we're not in the cloud.
 func (s *lsf) hostToID(host string) string {
 	return ""
 }
 
+// serverCount always returns -1, since we're not in the cloud.
+func (s *lsf) serverCount() int {
+	return -1
+}
+
 // setMessageCallBack does nothing at the moment, since we don't generate any
 // messages for the user.
 func (s *lsf) setMessageCallBack(cb MessageCallBack) {}
@@ -1127,6 +1127,21 @@ func (s *opst) hostToID(host string) string {
 	return server.ID
 }
 
+// serverCount tells you how many servers we've spawned that haven't been
+// destroyed yet.
+func (s *opst) serverCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	count := 0
+	for sid, server := range s.servers {
+		if sid == "localhost" || server.Destroyed() {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
 // setMessageCallBack sets the given callback.
 func (s *opst) setMessageCallBack(cb MessageCallBack) {
 	s.cbmutex.Lock()
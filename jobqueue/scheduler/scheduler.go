@@ -21,7 +21,7 @@ Package scheduler lets the jobqueue server interact with the configured job
 scheduler (if any) to submit jobqueue runner clients and have them run on a
 compute cluster (or local machine).
 
-Currently implemented schedulers are local, LSF and OpenStack. The
+Currently implemented schedulers are local, LSF, Slurm and OpenStack. The
 implementation of each supported scheduler type is in its own .go file.
 
 It's a pseudo plug-in system in that it is designed so that you can easily add a
@@ -148,6 +148,7 @@ type scheduleri interface {
 	reserveTimeout() int                                      // achieve the aims of ReserveTimeout()
 	maxQueueTime(req *Requirements) time.Duration             // achieve the aims of MaxQueueTime()
 	hostToID(host string) string                              // achieve the aims of HostToID()
+	serverCount() int                                         // achieve the aims of ServerCount()
 	setMessageCallBack(MessageCallBack)                       // achieve the aims of SetMessageCallBack()
 	setBadServerCallBack(BadServerCallBack)                   // achieve the aims of SetBadServerCallBack()
 	cleanup()                                                 // do any clean up once you've finished using the job scheduler
@@ -164,9 +165,9 @@ type Scheduler struct {
 }
 
 // New creates a new Scheduler to interact with the given job scheduler.
-// Possible names so far are "lsf", "local" and "openstack". You must also
-// provide a config struct appropriate for your chosen scheduler, eg. for the
-// local scheduler you will provide a ConfigLocal.
+// Possible names so far are "lsf", "slurm", "local" and "openstack". You must
+// also provide a config struct appropriate for your chosen scheduler, eg. for
+// the local scheduler you will provide a ConfigLocal.
 //
 // Providing a logger allows for debug messages to be logged somewhere, along
 // with any "harmless" or unreturnable errors. If not supplied, we use a default
@@ -176,6 +177,8 @@ func New(name string, config interface{}, logger ...log15.Logger) (*Scheduler, e
 	switch name {
 	case "lsf":
 		s = &Scheduler{impl: new(lsf)}
+	case "slurm":
+		s = &Scheduler{impl: new(slurm)}
 	case "local":
 		s = &Scheduler{impl: new(local)}
 	case "openstack":
@@ -290,6 +293,13 @@ func (s *Scheduler) HostToID(host string) string {
 	return s.impl.hostToID(host)
 }
 
+// ServerCount tells you how many servers a cloud-based scheduler currently has
+// up and available to run commands on, not counting the one we're running on.
+// Non-cloud schedulers always return -1.
+func (s *Scheduler) ServerCount() int {
+	return s.impl.serverCount()
+}
+
 // Cleanup means you've finished using a scheduler and it can delete any
 // remaining jobs in its system and clean up any other used resources.
 func (s *Scheduler) Cleanup() {
@@ -238,6 +238,40 @@ func TestLocal(t *testing.T) {
 }
 
 func TestLSF(t *testing.T) {
+	Convey("determineQueue() honours lsf_queue and lsf_hosts", t, func() {
+		s := &lsf{
+			queues: map[string]map[string]int{
+				"normal": {"memlimit": 10000, "runlimit": 43200},
+				"long":   {"memlimit": 10000, "runlimit": 259200},
+				"hosted": {"memlimit": 10000, "runlimit": 259200},
+			},
+			queueHosts: map[string]map[string]bool{"hosted": {"hostA": true, "hostB": true}},
+			sortedqs:   map[int][]string{0: {"normal", "long", "hosted"}},
+		}
+		queueFor := func(hours int, other map[string]string) string {
+			queue, err := s.determineQueue(&Requirements{100, time.Duration(hours) * time.Hour, 1, 0, other}, 0)
+			if err != nil {
+				return err.(Error).Err
+			}
+			return queue
+		}
+
+		So(queueFor(1, otherReqs), ShouldEqual, "normal")
+		So(queueFor(13, otherReqs), ShouldEqual, "long")
+		So(queueFor(1, map[string]string{"lsf_queue": "long"}), ShouldEqual, "long")
+		So(queueFor(100, map[string]string{"lsf_queue": "long"}), ShouldEqual, ErrImpossible)
+		So(queueFor(1, map[string]string{"lsf_queue": "foo"}), ShouldEqual, ErrImpossible)
+		So(queueFor(1, map[string]string{"lsf_hosts": "hostA+2 hostB"}), ShouldEqual, "normal")
+		So(queueFor(1, map[string]string{"lsf_queue": "hosted", "lsf_hosts": "hostA"}), ShouldEqual, "hosted")
+		So(queueFor(1, map[string]string{"lsf_queue": "hosted", "lsf_hosts": "others ~hostC hostB@cluster1"}), ShouldEqual, "hosted")
+		So(queueFor(1, map[string]string{"lsf_queue": "hosted", "lsf_hosts": "hostC"}), ShouldEqual, ErrImpossible)
+
+		So(lsfHostName("hostA"), ShouldEqual, "hostA")
+		So(lsfHostName("hgroup/"), ShouldEqual, "hgroup")
+		So(lsfHostName("hostA+2"), ShouldEqual, "hostA")
+		So(lsfHostName("hostA@cluster1"), ShouldEqual, "hostA")
+	})
+
 	// check if LSF seems to be installed
 	_, err := exec.LookPath("lsadmin")
 	if err == nil {
@@ -409,6 +443,194 @@ func TestLSF(t *testing.T) {
 	})
 }
 
+func TestSlurm(t *testing.T) {
+	// check if slurm seems to be installed
+	_, err := exec.LookPath("scontrol")
+	if err == nil {
+		_, err = exec.LookPath("sinfo")
+	}
+	if err != nil {
+		Convey("You can't get a new slurm scheduler without slurm being installed", t, func() {
+			_, err := New("slurm", &ConfigSlurm{"development", "bash"}, testLogger)
+			So(err, ShouldNotBeNil)
+		})
+	}
+
+	// regardless, we test against stub slurm commands that fake a cluster
+	stubDir, err := ioutil.TempDir("", "wr_schedulers_slurm_test_stubs_")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(stubDir)
+	writeSlurmStubs(stubDir)
+	origPath := os.Getenv("PATH")
+	err = os.Setenv("PATH", stubDir+":"+origPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		errs := os.Setenv("PATH", origPath)
+		if errs != nil {
+			log.Fatal(errs)
+		}
+	}()
+	sbatchArgsFile := filepath.Join(stubDir, "sbatch.args")
+	squeueFile := filepath.Join(stubDir, "squeue.out")
+	lines := func(path string) []string {
+		content, errr := ioutil.ReadFile(path)
+		if errr != nil || len(content) == 0 {
+			return nil
+		}
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+
+	Convey("You can get a new slurm scheduler", t, func() {
+		os.Remove(sbatchArgsFile)
+		os.Remove(squeueFile)
+		s, err := New("slurm", &ConfigSlurm{"development", "bash"}, testLogger)
+		So(err, ShouldBeNil)
+		So(s, ShouldNotBeNil)
+
+		possibleReq := &Requirements{100, 1 * time.Minute, 1, 1, otherReqs}
+		impossibleReq := &Requirements{9999999999, 999999 * time.Hour, 99999, 20, otherReqs}
+
+		Convey("ReserveTimeout() returns 1 second", func() {
+			So(s.ReserveTimeout(), ShouldEqual, 1)
+		})
+
+		Convey("Only usable partitions are considered", func() {
+			So(s.impl.(*slurm).sortedps, ShouldResemble, []string{"debug", "normal", "long", "basement"})
+		})
+
+		Convey("determinePartition() picks the best partition depending on given resource requirements", func() {
+			sl := s.impl.(*slurm)
+			partition, err := sl.determinePartition(possibleReq)
+			So(err, ShouldBeNil)
+			So(partition.name, ShouldEqual, "debug")
+
+			partition, err = sl.determinePartition(&Requirements{100, 1 * time.Hour, 1, 1, otherReqs})
+			So(err, ShouldBeNil)
+			So(partition.name, ShouldEqual, "normal")
+
+			partition, err = sl.determinePartition(&Requirements{100000, 1 * time.Hour, 1, 1, otherReqs})
+			So(err, ShouldBeNil)
+			So(partition.name, ShouldEqual, "long")
+
+			partition, err = sl.determinePartition(&Requirements{1, 96 * time.Hour, 1, 1, otherReqs})
+			So(err, ShouldBeNil)
+			So(partition.name, ShouldEqual, "basement")
+
+			_, err = sl.determinePartition(&Requirements{20000, 96 * time.Hour, 1, 1, otherReqs})
+			So(err, ShouldNotBeNil)
+
+			partition, err = sl.determinePartition(&Requirements{20000, 96 * time.Hour, 4, 1, otherReqs})
+			So(err, ShouldBeNil)
+			So(partition.name, ShouldEqual, "basement")
+
+			partition, err = sl.determinePartition(&Requirements{100, 1 * time.Hour, 64, 1, otherReqs})
+			So(err, ShouldBeNil)
+			So(partition.name, ShouldEqual, "basement")
+
+			partition, err = sl.determinePartition(&Requirements{100, 1 * time.Minute, 1, 200, otherReqs})
+			So(err, ShouldBeNil)
+			So(partition.name, ShouldEqual, "long")
+
+			partition, err = sl.determinePartition(&Requirements{100, 1 * time.Minute, 1, 1, map[string]string{"slurm_partition": "normal"}})
+			So(err, ShouldBeNil)
+			So(partition.name, ShouldEqual, "normal")
+
+			_, err = sl.determinePartition(&Requirements{100, 1 * time.Minute, 1, 1, map[string]string{"slurm_partition": "private"}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("MaxQueueTime() returns appropriate times depending on the requirements", func() {
+			So(s.MaxQueueTime(possibleReq).Minutes(), ShouldEqual, 30)
+			So(s.MaxQueueTime(&Requirements{1, 1 * time.Hour, 1, 1, otherReqs}).Minutes(), ShouldEqual, 720)
+			So(s.MaxQueueTime(&Requirements{1, 96 * time.Hour, 1, 1, otherReqs}), ShouldEqual, infiniteQueueTime)
+		})
+
+		Convey("Busy() starts off false", func() {
+			So(s.Busy(), ShouldBeFalse)
+		})
+
+		Convey("Schedule() gives impossible error when given impossible reqs", func() {
+			err := s.Schedule("foo", impossibleReq, 1)
+			So(err, ShouldNotBeNil)
+			serr, ok := err.(Error)
+			So(ok, ShouldBeTrue)
+			So(serr.Err, ShouldEqual, ErrImpossible)
+		})
+
+		Convey("Schedule() submits a job array with sbatch", func() {
+			err := s.Schedule("echo 1", possibleReq, 5)
+			So(err, ShouldBeNil)
+			So(s.Busy(), ShouldBeTrue)
+
+			args := lines(sbatchArgsFile)
+			So(len(args), ShouldEqual, 1)
+			So(args[0], ShouldStartWith, "--array=1-5 --job-name=wrd_")
+			So(args[0], ShouldEndWith, "--parsable --partition=debug --nodes=1 --ntasks=1 --time=30 --mem=100M --tmp=1G --output=/dev/null --error=/dev/null --wrap=echo 1")
+			So(len(lines(squeueFile)), ShouldEqual, 5)
+
+			Convey("Scheduling again with the same count does nothing", func() {
+				err = s.Schedule("echo 1", possibleReq, 5)
+				So(err, ShouldBeNil)
+				So(len(lines(sbatchArgsFile)), ShouldEqual, 1)
+				So(len(lines(squeueFile)), ShouldEqual, 5)
+			})
+
+			Convey("You can Schedule() again to drop the count, cancelling only pending jobs", func() {
+				err = s.Schedule("echo 1", possibleReq, 3)
+				So(err, ShouldBeNil)
+				So(len(lines(squeueFile)), ShouldEqual, 3)
+
+				queued := lines(squeueFile)
+				queued[0] = strings.Replace(queued[0], "PENDING", "RUNNING", 1)
+				err = ioutil.WriteFile(squeueFile, []byte(strings.Join(queued, "\n")+"\n"), 0600)
+				So(err, ShouldBeNil)
+
+				err = s.Schedule("echo 1", possibleReq, 0)
+				So(err, ShouldBeNil)
+				queued = lines(squeueFile)
+				So(len(queued), ShouldEqual, 1)
+				So(queued[0], ShouldContainSubstring, "|RUNNING|")
+				So(s.Busy(), ShouldBeTrue)
+			})
+
+			Convey("You can Schedule() again to increase the count", func() {
+				err = s.Schedule("echo 1", possibleReq, 8)
+				So(err, ShouldBeNil)
+				args = lines(sbatchArgsFile)
+				So(len(args), ShouldEqual, 2)
+				So(args[1], ShouldStartWith, "--array=1-3 ")
+				So(len(lines(squeueFile)), ShouldEqual, 8)
+			})
+
+			Convey("Cleanup() cancels all the jobs", func() {
+				s.Cleanup()
+				So(len(lines(squeueFile)), ShouldEqual, 0)
+				So(s.Busy(), ShouldBeFalse)
+			})
+		})
+
+		Convey("Schedule() passes cores and Other requirements to sbatch", func() {
+			other := map[string]string{"slurm_account": "proj", "slurm_gres": "gpu:1", "slurm_misc": "--exclusive --comment=foo"}
+			err := s.Schedule("echo 2", &Requirements{1000, 1 * time.Hour, 2, 0, other}, 1)
+			So(err, ShouldBeNil)
+
+			args := lines(sbatchArgsFile)
+			So(len(args), ShouldEqual, 1)
+			So(args[0], ShouldStartWith, "--job-name=wrd_")
+			So(args[0], ShouldEndWith, "--parsable --partition=normal --nodes=1 --ntasks=1 --time=720 --cpus-per-task=2 --mem=1000M --account=proj --gres=gpu:1 --exclusive --comment=foo --output=/dev/null --error=/dev/null --wrap=echo 2")
+			So(len(lines(squeueFile)), ShouldEqual, 1)
+		})
+
+		Reset(func() {
+			s.Cleanup()
+		})
+	})
+}
+
 func TestOpenstack(t *testing.T) {
 	// check if we have our special openstack-related variable
 	osPrefix := os.Getenv("OS_OS_PREFIX")
@@ -961,3 +1183,60 @@ func novaCountServers(novaCmd string, rName, osPrefix string) int {
 	}
 	return 0
 }
+
+// writeSlurmStubs creates fake slurm commands in the given directory, which
+// report on a small cluster and track submitted jobs in a squeue.out file.
+func writeSlurmStubs(dir string) {
+	stubs := map[string]string{
+		"scontrol": `cat <<'EOF'
+PartitionName=debug AllowGroups=ALL Default=NO DefaultTime=NONE MaxTime=00:30:00 MaxMemPerNode=UNLIMITED MaxCPUsPerNode=UNLIMITED PriorityTier=10 State=UP TotalNodes=2
+PartitionName=normal AllowGroups=ALL Default=YES DefaultTime=01:00:00 MaxTime=12:00:00 MaxMemPerNode=64000 MaxCPUsPerNode=UNLIMITED PriorityTier=1 State=UP TotalNodes=20
+PartitionName=long AllowGroups=ALL Default=NO MaxTime=3-00:00:00 MaxMemPerNode=UNLIMITED PriorityTier=1 State=UP TotalNodes=10
+PartitionName=basement AllowGroups=ALL Default=NO MaxTime=UNLIMITED MaxMemPerCPU=8000 PriorityTier=1 State=UP TotalNodes=10
+PartitionName=down AllowGroups=ALL Default=NO MaxTime=UNLIMITED PriorityTier=100 State=DOWN TotalNodes=50
+PartitionName=private AllowGroups=wr_no_such_group Default=NO MaxTime=UNLIMITED PriorityTier=100 State=UP TotalNodes=50
+EOF`,
+		"sinfo": `cat <<'EOF'
+debug 16000 4 10000
+normal 64000 16 100000
+normal 128000+ 32 100000
+long 256000 32 500000
+basement 256000 64 500000
+down 256000 64 500000
+private 256000 64 500000
+EOF`,
+		"sbatch": `dir=$(dirname "$0")
+echo "$@" >> "$dir/sbatch.args"
+id=$(( $(cat "$dir/sbatch.id" 2>/dev/null || echo 0) + 1 ))
+echo $id > "$dir/sbatch.id"
+name=""
+size=""
+for arg in "$@"; do
+    case $arg in
+        --job-name=*) name=${arg#--job-name=} ;;
+        --array=1-*) size=${arg#--array=1-} ;;
+    esac
+done
+if [ -n "$size" ]; then
+    for i in $(seq 1 $size); do
+        echo "${id}_${i}|PENDING|${name}" >> "$dir/squeue.out"
+    done
+else
+    echo "${id}|PENDING|${name}" >> "$dir/squeue.out"
+fi
+echo $id`,
+		"squeue": `cat "$(dirname "$0")/squeue.out" 2>/dev/null
+exit 0`,
+		"scancel": `dir=$(dirname "$0")
+for id in "$@"; do
+    grep -v "^${id}|" "$dir/squeue.out" > "$dir/squeue.tmp"
+    mv "$dir/squeue.tmp" "$dir/squeue.out"
+done`,
+	}
+	for name, script := range stubs {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/bash\n"+script+"\n"), 0700)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,591 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package scheduler
+
+// This file contains a scheduleri implementation for 'slurm': running jobs
+// via SchedMD's Slurm Workload Manager.
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"os/user"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VertebrateResequencing/wr/internal"
+	"github.com/inconshreveable/log15"
+)
+
+const slurmMaxArraySize = 1000 // the default MaxArraySize is 1001
+
+// slurmFinishedStates are the squeue job states of jobs that are no longer
+// going to run our cmd.
+var slurmFinishedStates = map[string]bool{
+	"BOOT_FAIL":     true,
+	"CANCELLED":     true,
+	"COMPLETED":     true,
+	"COMPLETING":    true,
+	"DEADLINE":      true,
+	"FAILED":        true,
+	"NODE_FAIL":     true,
+	"OUT_OF_MEMORY": true,
+	"PREEMPTED":     true,
+	"TIMEOUT":       true,
+}
+
+// slurmOtherOptions maps the Requirements.Other keys we understand to the
+// sbatch options they become.
+var slurmOtherOptions = [][2]string{
+	{"slurm_account", "--account="},
+	{"slurm_qos", "--qos="},
+	{"slurm_constraint", "--constraint="},
+	{"slurm_gres", "--gres="},
+}
+
+// slurm is our implementer of scheduleri
+type slurm struct {
+	config      *ConfigSlurm
+	user        string
+	sbatchRegex *regexp.Regexp
+	partitions  map[string]*slurmPartition
+	sortedps    []string
+	log15.Logger
+}
+
+// slurmPartition describes the limits of a partition we're allowed to submit
+// to. Limits of 0 mean unlimited (or unknown).
+type slurmPartition struct {
+	name        string
+	priority    int
+	isDefault   bool
+	nodes       int
+	maxTime     time.Duration
+	maxMemNode  int // MB
+	maxMemCPU   int // MB
+	maxCPUsNode int
+	nodeMem     int // MB of RAM on the partition's biggest node
+	nodeCPUs    int // cores on the partition's biggest node
+	nodeTmp     int // MB of temporary disk on the partition's biggest node
+}
+
+// ConfigSlurm represents the configuration options required by the Slurm
+// scheduler. All are required with no usable defaults.
+type ConfigSlurm struct {
+	// deployment is one of "development" or "production".
+	Deployment string
+
+	// shell is the shell to use to run the commands to interact with your job
+	// scheduler; 'bash' is recommended.
+	Shell string
+}
+
+// initialize finds out about slurm's partitions and nodes
+func (s *slurm) initialize(config interface{}, logger log15.Logger) error {
+	s.config = config.(*ConfigSlurm)
+	s.Logger = logger.New("scheduler", "slurm")
+	s.sbatchRegex = regexp.MustCompile(`^(\d+)(?:;\S+)?\s*$`)
+
+	me, err := internal.Username()
+	if err != nil {
+		return Error{"slurm", "initialize", fmt.Sprintf("could not get current user: %s", err)}
+	}
+	s.user = me
+
+	// parse scontrol to figure out what usable partitions we have, and what
+	// their limits are
+	scout, err := exec.Command(s.config.Shell, "-c", "scontrol -o show partition").Output() // #nosec
+	if err != nil {
+		return Error{"slurm", "initialize", fmt.Sprintf("failed to run [scontrol -o show partition]: %s", err)}
+	}
+	s.partitions = make(map[string]*slurmPartition)
+	var groups map[string]bool
+	groupsChecked := false
+	for _, line := range strings.Split(string(scout), "\n") {
+		fields := make(map[string]string)
+		for _, kv := range strings.Fields(line) {
+			if i := strings.Index(kv, "="); i > 0 {
+				fields[kv[:i]] = kv[i+1:]
+			}
+		}
+		name := fields["PartitionName"]
+		if name == "" || fields["State"] != "UP" {
+			continue
+		}
+
+		if allowed := fields["AllowGroups"]; allowed != "" && allowed != "ALL" {
+			if !groupsChecked {
+				var errg error
+				groups, errg = slurmUserGroups()
+				if errg != nil {
+					// sbatch will tell us if we weren't allowed after all
+					s.Warn("initialize could not determine user groups", "err", errg)
+				}
+				groupsChecked = true
+			}
+			if groups != nil && !slurmInGroups(allowed, groups) {
+				continue
+			}
+		}
+
+		p := &slurmPartition{name: name, isDefault: fields["Default"] == "YES"}
+		priorityKey := "PriorityTier"
+		if _, exists := fields[priorityKey]; !exists {
+			priorityKey = "Priority" // older versions of slurm
+		}
+		var errp error
+		parse := func(key string) int {
+			val, errl := slurmLimit(fields[key])
+			if errl != nil {
+				errp = errl
+			}
+			return val
+		}
+		p.priority = parse(priorityKey)
+		p.nodes = parse("TotalNodes")
+		p.maxMemNode = parse("MaxMemPerNode")
+		p.maxMemCPU = parse("MaxMemPerCPU")
+		p.maxCPUsNode = parse("MaxCPUsPerNode")
+		if errp == nil {
+			p.maxTime, errp = parseSlurmTime(fields["MaxTime"])
+		}
+		if errp != nil {
+			return Error{"slurm", "initialize", fmt.Sprintf("failed to parse [scontrol -o show partition]: %s", errp)}
+		}
+		s.partitions[name] = p
+	}
+
+	// parse sinfo to find out how big the nodes in each partition are; there
+	// can be multiple lines per partition when it has different kinds of node
+	sicmd := exec.Command(s.config.Shell, "-c", `sinfo -h -o "%R %m %c %d"`) // #nosec
+	siout, err := sicmd.StdoutPipe()
+	if err != nil {
+		return Error{"slurm", "initialize", fmt.Sprintf("failed to create pipe for [sinfo]: %s", err)}
+	}
+	if err = sicmd.Start(); err != nil {
+		return Error{"slurm", "initialize", fmt.Sprintf("failed to start [sinfo]: %s", err)}
+	}
+	siScanner := bufio.NewScanner(siout)
+	for siScanner.Scan() {
+		fields := strings.Fields(siScanner.Text())
+		if len(fields) != 4 {
+			continue
+		}
+		p, usable := s.partitions[fields[0]]
+		if !usable {
+			continue
+		}
+
+		var vals [3]int
+		for i, field := range fields[1:] {
+			vals[i], err = slurmLimit(field)
+			if err != nil {
+				return Error{"slurm", "initialize", fmt.Sprintf("failed to parse [sinfo]: %s", err)}
+			}
+		}
+		if vals[0] > p.nodeMem {
+			p.nodeMem = vals[0]
+		}
+		if vals[1] > p.nodeCPUs {
+			p.nodeCPUs = vals[1]
+		}
+		if vals[2] > p.nodeTmp {
+			p.nodeTmp = vals[2]
+		}
+	}
+	if serr := siScanner.Err(); serr != nil {
+		return Error{"slurm", "initialize", fmt.Sprintf("failed to read everything from [sinfo]: %s", serr)}
+	}
+	if err = sicmd.Wait(); err != nil {
+		return Error{"slurm", "initialize", fmt.Sprintf("failed to finish running [sinfo]: %s", err)}
+	}
+
+	// sort the partitions, those most likely to run jobs sooner coming first:
+	// higher priority tiers get scheduled first, then we prefer the site's
+	// default, then those with more nodes, then those with lower time limits,
+	// since we suppose they might be less busy
+	for name := range s.partitions {
+		s.sortedps = append(s.sortedps, name)
+	}
+	sort.Slice(s.sortedps, func(i, j int) bool {
+		pi, pj := s.partitions[s.sortedps[i]], s.partitions[s.sortedps[j]]
+		if pi.priority != pj.priority {
+			return pi.priority > pj.priority
+		}
+		if pi.isDefault != pj.isDefault {
+			return pi.isDefault
+		}
+		if pi.nodes != pj.nodes {
+			return pi.nodes > pj.nodes
+		}
+		if pi.maxTime != pj.maxTime {
+			return pj.maxTime == infiniteQueueTime || (pi.maxTime != infiniteQueueTime && pi.maxTime < pj.maxTime)
+		}
+		return pi.name < pj.name
+	})
+
+	return nil
+}
+
+// reserveTimeout achieves the aims of ReserveTimeout().
+func (s *slurm) reserveTimeout() int {
+	return defaultReserveTimeout
+}
+
+// maxQueueTime achieves the aims of MaxQueueTime().
+func (s *slurm) maxQueueTime(req *Requirements) time.Duration {
+	partition, err := s.determinePartition(req)
+	if err == nil {
+		return partition.maxTime
+	}
+	return infiniteQueueTime
+}
+
+// schedule achieves the aims of Schedule(). Note that if rescheduling a cmd
+// at a lower count, we cannot guarantee that only that number get run; it may
+// end up being a few more.
+//
+// As well as the RAM, Time, Cores and Disk of the req, these keys in req.Other
+// are understood: slurm_partition (only consider this partition),
+// slurm_account, slurm_qos, slurm_constraint and slurm_gres (which become the
+// corresponding sbatch options) and slurm_misc (whitespace separated sbatch
+// options that are passed through as-is).
+func (s *slurm) schedule(cmd string, req *Requirements, count int) error {
+	// find the best partition for these resource requirements
+	partition, err := s.determinePartition(req)
+	if err != nil {
+		return err // impossible to run cmd with these reqs
+	}
+
+	// get the details of everything already in the scheduler for this cmd,
+	// removing from the queue anything pending when we're over the desired
+	// count
+	scheduledCount, err := s.checkCmd(cmd, count)
+	if err != nil {
+		return err
+	}
+	stillNeeded := count - scheduledCount
+	if stillNeeded < 1 {
+		return nil
+	}
+
+	// our runners can run many cmds one after the other, so we ask for as
+	// much time as the partition allows; MaxQueueTime() tells the runners how
+	// long that is
+	sbatchArgs := []string{"--parsable", "--partition=" + partition.name, "--nodes=1", "--ntasks=1", "--time=" + slurmTimeLimit(partition.maxTime)}
+	if req.Cores > 1 {
+		sbatchArgs = append(sbatchArgs, fmt.Sprintf("--cpus-per-task=%d", req.Cores))
+	}
+	if req.RAM > 0 {
+		sbatchArgs = append(sbatchArgs, fmt.Sprintf("--mem=%dM", req.RAM))
+	}
+	if req.Disk > 0 {
+		sbatchArgs = append(sbatchArgs, fmt.Sprintf("--tmp=%dG", req.Disk))
+	}
+	for _, option := range slurmOtherOptions {
+		if val := req.Other[option[0]]; val != "" {
+			sbatchArgs = append(sbatchArgs, option[1]+val)
+		}
+	}
+	sbatchArgs = append(sbatchArgs, strings.Fields(req.Other["slurm_misc"])...)
+
+	// for checkCmd() to work efficiently we must always set a job name that
+	// corresponds to the cmd. We keep them unique so they look the same as
+	// those of the other schedulers
+	sbatchArgs = append(sbatchArgs, "--output=/dev/null", "--error=/dev/null", "--wrap="+cmd)
+	for stillNeeded > 0 {
+		size := stillNeeded
+		if size > slurmMaxArraySize {
+			size = slurmMaxArraySize
+		}
+		stillNeeded -= size
+
+		args := append([]string{"--job-name=" + jobName(cmd, s.config.Deployment, true)}, sbatchArgs...)
+		if size > 1 {
+			args = append([]string{fmt.Sprintf("--array=1-%d", size)}, args...)
+		}
+
+		// submit to the partition; unlike bsub, sbatch only returns once the
+		// job is known to the controller, so squeue will immediately see it
+		// and we don't need to wait before busy() will work
+		sbatchout, err := exec.Command("sbatch", args...).Output() // #nosec
+		if err != nil {
+			return Error{"slurm", "schedule", fmt.Sprintf("failed to run sbatch %s: %s", args, err)}
+		}
+		if !s.sbatchRegex.Match(sbatchout) {
+			return Error{"slurm", "schedule", fmt.Sprintf("sbatch %s returned unexpected output: %s", args, sbatchout)}
+		}
+	}
+
+	return nil
+}
+
+// busy returns true if there are any jobs with our jobName() prefix in any
+// partition.
+func (s *slurm) busy() bool {
+	count, err := s.checkCmd("", -1)
+	if err != nil {
+		// busy() doesn't return an error, so just assume we're busy
+		return true
+	}
+	return count > 0
+}
+
+// determinePartition picks a partition, preferring ones that are more likely
+// to run our job the soonest (amongst those that are capable of running it).
+func (s *slurm) determinePartition(req *Requirements) (*slurmPartition, error) {
+	wanted := req.Other["slurm_partition"]
+	cores := req.Cores
+	if cores < 1 {
+		cores = 1
+	}
+
+	for _, name := range s.sortedps {
+		if wanted != "" && name != wanted {
+			continue
+		}
+		p := s.partitions[name]
+
+		if p.maxTime != infiniteQueueTime && p.maxTime < req.Time {
+			continue
+		}
+		if (p.maxMemNode > 0 && p.maxMemNode < req.RAM) || (p.maxMemCPU > 0 && p.maxMemCPU*cores < req.RAM) || (p.nodeMem > 0 && p.nodeMem < req.RAM) {
+			continue
+		}
+		if (p.maxCPUsNode > 0 && p.maxCPUsNode < cores) || (p.nodeCPUs > 0 && p.nodeCPUs < cores) {
+			continue
+		}
+		if p.nodeTmp > 0 && p.nodeTmp < req.Disk*1000 {
+			continue
+		}
+
+		return p, nil
+	}
+
+	return nil, Error{"slurm", "determinePartition", ErrImpossible}
+}
+
+// checkCmd asks slurm how many of the supplied cmd are in the queue, and if
+// max >= 0 is supplied, cancels any extraneous pending jobs for the cmd. If the
+// supplied cmd is the empty string, it will report/act on all cmds submitted
+// by schedule() for this deployment.
+func (s *slurm) checkCmd(cmd string, max int) (count int, err error) {
+	var jobPrefix string
+	if cmd == "" {
+		jobPrefix = fmt.Sprintf("wr%s_", s.config.Deployment[0:1])
+	} else {
+		jobPrefix = jobName(cmd, s.config.Deployment, false)
+	}
+
+	// as with lsf, there's a race condition where some jobs we decide to
+	// cancel may start running before we cancel them, which we allow
+	var toCancel []string
+	cb := func(id, state string) {
+		count++
+		if max >= 0 && count > max && state == "PENDING" {
+			toCancel = append(toCancel, id)
+			count--
+		}
+	}
+	err = s.parseSqueue(jobPrefix, cb)
+
+	if len(toCancel) > 0 {
+		errc := exec.Command("scancel", toCancel...).Run() // #nosec
+		if errc != nil {
+			s.Warn("checkCmd scancel failed", "err", errc)
+		}
+	}
+
+	return count, err
+}
+
+type squeueCB func(id, state string)
+
+// parseSqueue runs squeue for our user, filters on a job name prefix, excludes
+// finished jobs and gives the job id (including any array index) and state of
+// each remaining job to your callback.
+func (s *slurm) parseSqueue(jobPrefix string, callback squeueCB) error {
+	sqcmd := exec.Command(s.config.Shell, "-c", fmt.Sprintf(`squeue -h -r -u %s -o "%%i|%%T|%%j"`, s.user)) // #nosec
+	sqout, err := sqcmd.StdoutPipe()
+	if err != nil {
+		return Error{"slurm", "parseSqueue", fmt.Sprintf("failed to create pipe for [squeue]: %s", err)}
+	}
+	err = sqcmd.Start()
+	if err != nil {
+		return Error{"slurm", "parseSqueue", fmt.Sprintf("failed to start [squeue]: %s", err)}
+	}
+	sqScanner := bufio.NewScanner(sqout)
+
+	for sqScanner.Scan() {
+		fields := strings.Split(sqScanner.Text(), "|")
+		if len(fields) != 3 || !strings.HasPrefix(fields[2], jobPrefix) || slurmFinishedStates[fields[1]] {
+			continue
+		}
+		callback(fields[0], fields[1])
+	}
+
+	if err = sqScanner.Err(); err != nil {
+		return Error{"slurm", "parseSqueue", fmt.Sprintf("failed to read everything from [squeue]: %s", err)}
+	}
+	err = sqcmd.Wait()
+	if err != nil {
+		err = Error{"slurm", "parseSqueue", fmt.Sprintf("failed to finish running [squeue]: %s", err)}
+	}
+	return err
+}
+
+// hostToID always returns an empty string, since we're not in the cloud.
+func (s *slurm) hostToID(host string) string {
+	return ""
+}
+
+// serverCount always returns -1, since we're not in the cloud.
+func (s *slurm) serverCount() int {
+	return -1
+}
+
+// setMessageCallBack does nothing at the moment, since we don't generate any
+// messages for the user.
+func (s *slurm) setMessageCallBack(cb MessageCallBack) {}
+
+// setBadServerCallBack does nothing, since we're not a cloud-based scheduler.
+func (s *slurm) setBadServerCallBack(cb BadServerCallBack) {}
+
+// cleanup scancels any remaining jobs we created
+func (s *slurm) cleanup() {
+	var toCancel []string
+	cb := func(id, state string) {
+		toCancel = append(toCancel, id)
+	}
+	err := s.parseSqueue(fmt.Sprintf("wr%s_", s.config.Deployment[0:1]), cb)
+	if err != nil {
+		s.Error("cleanup parse squeue failed", "err", err)
+	}
+	if len(toCancel) > 0 {
+		err = exec.Command("scancel", toCancel...).Run() // #nosec
+		if err != nil {
+			s.Warn("cleanup scancel failed", "err", err)
+		}
+	}
+}
+
+// slurmLimit parses a number from scontrol or sinfo output, treating the ways
+// slurm says "no limit" as 0 and ignoring the trailing + sinfo uses to say
+// some nodes have more.
+func slurmLimit(val string) (int, error) {
+	switch val {
+	case "", "UNLIMITED", "INFINITE", "NONE", "N/A":
+		return 0, nil
+	}
+	return strconv.Atoi(strings.TrimSuffix(val, "+"))
+}
+
+// parseSlurmTime parses the time formats slurm uses for time limits, which are
+// "minutes", "minutes:seconds", "hours:minutes:seconds", "days-hours",
+// "days-hours:minutes" and "days-hours:minutes:seconds". No limit is returned
+// as infiniteQueueTime.
+func parseSlurmTime(val string) (time.Duration, error) {
+	switch val {
+	case "", "UNLIMITED", "INFINITE", "NONE":
+		return infiniteQueueTime, nil
+	}
+
+	var days int
+	hms := val
+	hasDays := false
+	if i := strings.Index(val, "-"); i >= 0 {
+		var err error
+		days, err = strconv.Atoi(val[:i])
+		if err != nil {
+			return 0, fmt.Errorf("bad time limit [%s]", val)
+		}
+		hms = val[i+1:]
+		hasDays = true
+	}
+
+	var nums []int
+	for _, part := range strings.Split(hms, ":") {
+		num, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("bad time limit [%s]", val)
+		}
+		nums = append(nums, num)
+	}
+
+	var hours, mins, secs int
+	switch {
+	case len(nums) == 3:
+		hours, mins, secs = nums[0], nums[1], nums[2]
+	case len(nums) == 2 && hasDays:
+		hours, mins = nums[0], nums[1]
+	case len(nums) == 2:
+		mins, secs = nums[0], nums[1]
+	case len(nums) == 1 && hasDays:
+		hours = nums[0]
+	case len(nums) == 1:
+		mins = nums[0]
+	default:
+		return 0, fmt.Errorf("bad time limit [%s]", val)
+	}
+
+	return time.Duration(days)*24*time.Hour + time.Duration(hours)*time.Hour + time.Duration(mins)*time.Minute + time.Duration(secs)*time.Second, nil
+}
+
+// slurmTimeLimit converts a duration to an sbatch --time value in minutes.
+func slurmTimeLimit(d time.Duration) string {
+	if d == infiniteQueueTime {
+		return "UNLIMITED"
+	}
+	return strconv.Itoa(int(d.Minutes()))
+}
+
+// slurmUserGroups returns the names of the groups the current user belongs to.
+func slurmUserGroups() (map[string]bool, error) {
+	u, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string]bool)
+	for _, gid := range gids {
+		g, err := user.LookupGroupId(gid)
+		if err != nil {
+			continue
+		}
+		groups[g.Name] = true
+	}
+	return groups, nil
+}
+
+// slurmInGroups tells you if any of the given groups are in the comma
+// separated list of groups a partition allows.
+func slurmInGroups(allowed string, groups map[string]bool) bool {
+	for _, group := range strings.Split(allowed, ",") {
+		if groups[group] {
+			return true
+		}
+	}
+	return false
+}
@@ -22,12 +22,15 @@ package jobqueue
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
 	"sync"
 	"syscall"
 	"time"
@@ -36,9 +39,10 @@ import (
 	"github.com/VertebrateResequencing/wr/internal"
 	"github.com/VertebrateResequencing/wr/jobqueue/scheduler"
 	"github.com/VertebrateResequencing/wr/queue"
+	"github.com/VertebrateResequencing/wr/rp"
 	"github.com/go-mangos/mangos"
 	"github.com/go-mangos/mangos/protocol/rep"
-	"github.com/go-mangos/mangos/transport/tcp"
+	"github.com/go-mangos/mangos/transport/tlstcp"
 	"github.com/gorilla/websocket"
 	"github.com/grafov/bcast" // *** must be commit e9affb593f6c871f9b4c3ee6a3c77d421fe953df or status web page updates break in certain cases
 	"github.com/inconshreveable/log15"
@@ -65,6 +69,13 @@ const (
 	ErrMustReserve    = "you must Reserve() a Job before passing it to other methods"
 	ErrDBError        = "failed to use database"
 	ErrWrongUser      = "you did not start this server: permission denied"
+	ErrWrongToken     = "your token was not correct: permission denied"
+	ErrCronExists     = "a cron schedule with that name already exists"
+	ErrCopyTooBig     = "file is too big to copy to the manager"
+	ErrCopyFailed     = "failed to copy file to the manager"
+	ErrNoCapturedStd  = "job's complete STDOUT/STDERR was not captured"
+	ErrNotifyExists   = "a subscription with that name already exists"
+	ErrTooManyTails   = "too many jobs are already being tailed"
 	ServerModeNormal  = "started"
 	ServerModeDrain   = "draining"
 )
@@ -78,6 +89,8 @@ var (
 	ServerReserveTicker   = 1 * time.Second
 	ServerCheckRunnerTime = 1 * time.Minute
 	ServerLogClientErrors = true
+	ServerRetryHostGrace  = 30 * time.Second
+	ServerMaxTails        = 100
 )
 
 // Error records an error and the operation and item that caused it.
@@ -101,15 +114,24 @@ type itemErr struct {
 // serverResponse is the struct that the server sends to clients over the
 // network in response to their clientRequest.
 type serverResponse struct {
-	Err        string // string instead of error so we can decode on the client side
-	Added      int
-	Existed    int
-	KillCalled bool
-	Job        *Job
-	Jobs       []*Job
-	SInfo      *ServerInfo
-	SStats     *ServerStats
-	DB         []byte
+	Err         string // string instead of error so we can decode on the client side
+	Added       int
+	Existed     int
+	KillCalled  bool
+	Job         *Job
+	Jobs        []*Job
+	Schedules   []*CronSchedule
+	LimitGroups []*LimitGroup
+	Graph       *JobGraph
+	SInfo       *ServerInfo
+	SStats      *ServerStats
+	DB          []byte
+	Std         []byte
+	Output      *outputChunk
+	Tail        bool
+	Subs        []*Subscription
+	DBSizes     [2]int64
+	Replica     *replica
 }
 
 // ServerInfo holds basic addressing info about the server.
@@ -123,6 +145,9 @@ type ServerInfo struct {
 	Deployment   string   // deployment the server is running under
 	Scheduler    string   // the name of the scheduler that jobs are being submitted to
 	Mode         string   // ServerModeNormal if the server is running normally, or ServerModeDrain if draining
+	CopyDir      string   // absolute path to where CopyToManager Behaviours copy files to
+	CopyMaxSize  int64    // maximum size in bytes of a file that can be copied to the manager
+	Standbys     []string // ip:port of the standby servers replicating this server (see Standby())
 }
 
 // ServerStats holds information about the jobqueue server for sending to
@@ -174,6 +199,7 @@ type schedulerIssue struct {
 type Server struct {
 	ServerInfo         *ServerInfo
 	allowedUsers       map[string]bool
+	token              []byte
 	sock               mangos.Socket
 	ch                 codec.Handle
 	db                 *db
@@ -191,6 +217,7 @@ type Server struct {
 	sgroupcounts    map[string]int
 	sgrouptrigs     map[string]int
 	sgtr            map[string]*scheduler.Requirements
+	sgerrs          map[string]string // the last scheduling error of each group, if it hasn't since succeeded
 	sgcmutex        sync.Mutex
 	racmutex        sync.RWMutex // to protect the readyaddedcallback
 	rc              string       // runner command string compatible with fmt.Sprintf(..., schedulerGroup, deployment, serverAddr, reserveTimeout, maxMinsAllowed)
@@ -212,6 +239,24 @@ type Server struct {
 	timings         map[string]*timingAvg
 	tmutex          sync.Mutex
 	ssmutex         sync.RWMutex // "server state mutex" to protect up, drain, blocking and ServerInfo.Mode
+	cronSchedules   map[string]*CronSchedule
+	cronmutex       sync.Mutex
+	stopCron        chan bool
+	limitGroups     map[string]*LimitGroup
+	limitProtectors map[string]*rp.Protector
+	lgmutex         sync.RWMutex
+	tails           map[string]*outputTail
+	tailMutex       sync.Mutex
+	subscriptions   map[string]*Subscription
+	finishedRGs     map[string]bool
+	notifymutex     sync.Mutex
+	stopNotify      chan bool
+	stopPrune       chan bool
+	pruneMutex      sync.Mutex
+	standbys        map[string]time.Time // protected by ssmutex
+	adoptable       map[string]*runningJob
+	adoptUntil      time.Time
+	adoptMutex      sync.Mutex
 	log15.Logger
 }
 
@@ -231,8 +276,35 @@ type ServerConfig struct {
 	// Port for the web interface.
 	WebPort string
 
-	// Name of the desired scheduler (eg. "local" or "lsf" or "openstack") that
-	// jobs will be submitted to.
+	// TokenFile is the path to a file containing the secret token that clients
+	// must supply to be allowed to use the server. If the file doesn't exist, a
+	// new token is generated and written to it, readable only by the current
+	// user. Give the token to Connect(), supply it to the REST API as a bearer
+	// token in the Authorization header, and supply it to the web interface as
+	// a "token" query parameter when first visiting it (it is then swapped for
+	// a cookie).
+	TokenFile string
+
+	// CAFile is the path to the PEM encoded certificate of the certificate
+	// authority that signed CertFile. Clients need this to verify the server.
+	// If CertFile and KeyFile don't exist (or are not valid), a new CA
+	// certificate is generated and written to this path.
+	CAFile string
+
+	// CertFile and KeyFile are the paths to the PEM encoded certificate and
+	// private key the server uses to secure all communication with clients
+	// (including the REST API and web interface) using TLS. If they don't exist
+	// or the certificate has expired, new ones are generated (signed by a new
+	// CA, see CAFile) and written to these paths.
+	CertFile string
+	KeyFile  string
+
+	// CertDomain is the domain that newly generated certificates are valid for,
+	// which clients must supply to Connect(). Defaults to "localhost".
+	CertDomain string
+
+	// Name of the desired scheduler (eg. "local" or "lsf" or "slurm" or
+	// "openstack") that jobs will be submitted to.
 	SchedulerName string
 
 	// SchedulerConfig should define the config options needed by the chosen
@@ -262,6 +334,20 @@ type ServerConfig struct {
 	// databases are deleted and recreated on start up by default.
 	Deployment string
 
+	// Absolute path to the directory that files copied back from jobs by
+	// CopyToManager Behaviours will be stored in. Defaults to a directory
+	// named "copied" in the same directory as DBFile.
+	CopyDir string
+
+	// CopyMaxSize is the maximum size in bytes of a file that CopyToManager
+	// Behaviours can copy to CopyDir. Defaults to 100MB.
+	CopyMaxSize int64
+
+	// Retention says how long to keep complete jobs in the database before
+	// removing them (see RetentionPolicy). Defaults to nil, which keeps
+	// everything forever.
+	Retention *RetentionPolicy
+
 	// CIDR is the IP address range of your network. When the server needs to
 	// know its own IP address, it uses this CIDR to confirm it got it correct
 	// (ie. it picked the correct network interface). You can leave this unset,
@@ -285,6 +371,9 @@ type ServerConfig struct {
 	// If this is unset, nothing is logged (defaults to a logger using a
 	// log15.DiscardHandler()).
 	Logger log15.Logger
+
+	// takeover is set by Standby() when taking over from its primary server.
+	takeover *takeover
 }
 
 // Serve is for use by a server executable and makes it start listening on
@@ -301,6 +390,10 @@ type ServerConfig struct {
 // logged but otherwise ignored. If it creates a db file or recreates one from
 // backup, it will say what it did in the returned msg string.
 //
+// All communication with clients is encrypted using TLS, and clients must
+// supply the token stored in the configured TokenFile (which will be created if
+// necessary, as will the certificates; see ServerConfig).
+//
 // It also spawns your runner clients as needed, running them via the configured
 // job scheduler, using the configured shell. It determines the command line to
 // execute for your runner client from the configured RunnerCmd string you
@@ -336,6 +429,30 @@ func Serve(config ServerConfig) (s *Server, msg string, err error) {
 		allowedUsers = append(allowedUsers, owner)
 	}
 
+	// clients must also prove they have access to our secret token, and we
+	// encrypt everything with TLS, creating our own certificates if we have
+	// to
+	token, err := internal.ReadOrCreateToken(config.TokenFile)
+	if err != nil {
+		return s, msg, err
+	}
+	certDomain := config.CertDomain
+	if certDomain == "" {
+		certDomain = "localhost"
+	}
+	if internal.CheckCerts(config.CertFile, config.KeyFile) != nil {
+		err = internal.GenerateCerts(config.CAFile, config.CertFile, config.KeyFile, certDomain)
+		if err != nil {
+			return s, msg, err
+		}
+		serverLogger.Info("created new TLS certificates", "ca", config.CAFile, "cert", config.CertFile, "domain", certDomain)
+	}
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return s, msg, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
 	sock, err := rep.NewSocket()
 	if err != nil {
 		return s, msg, err
@@ -361,9 +478,9 @@ func Serve(config ServerConfig) (s *Server, msg string, err error) {
 		return s, msg, err
 	}
 
-	sock.AddTransport(tcp.NewTransport())
+	sock.AddTransport(tlstcp.NewTransport())
 
-	if err = sock.Listen("tcp://0.0.0.0:" + config.Port); err != nil {
+	if err = sock.ListenOptions("tls+tcp://0.0.0.0:"+config.Port, map[string]interface{}{mangos.OptionTLSConfig: tlsConfig}); err != nil {
 		return s, msg, err
 	}
 
@@ -398,6 +515,20 @@ func Serve(config ServerConfig) (s *Server, msg string, err error) {
 		host = "localhost"
 	}
 
+	// jobs may want to copy files back to us, which we'll store in copyDir
+	copyDir := config.CopyDir
+	if copyDir == "" {
+		copyDir = filepath.Join(filepath.Dir(config.DBFile), "copied")
+	}
+	err = os.MkdirAll(copyDir, os.ModePerm)
+	if err != nil {
+		return s, msg, err
+	}
+	copyMaxSize := config.CopyMaxSize
+	if copyMaxSize <= 0 {
+		copyMaxSize = copyDefaultMaxSize
+	}
+
 	// we will spawn runner clients via the requested job scheduler
 	sch, err := scheduler.New(config.SchedulerName, config.SchedulerConfig, serverLogger)
 	if err != nil {
@@ -405,14 +536,15 @@ func Serve(config ServerConfig) (s *Server, msg string, err error) {
 	}
 
 	// we need to persist stuff to disk, and we do so using boltdb
-	db, msg, err := initDB(config.DBFile, config.DBFileBackup, config.Deployment, serverLogger)
+	db, msg, err := initDB(config.DBFile, config.DBFileBackup, config.Deployment, config.takeover != nil, serverLogger)
 	if err != nil {
 		return s, msg, err
 	}
 
 	s = &Server{
-		ServerInfo:         &ServerInfo{AllowedUsers: allowedUsers, Addr: ip + ":" + config.Port, Host: host, Port: config.Port, WebPort: config.WebPort, PID: os.Getpid(), Deployment: config.Deployment, Scheduler: config.SchedulerName, Mode: ServerModeNormal},
+		ServerInfo:         &ServerInfo{AllowedUsers: allowedUsers, Addr: ip + ":" + config.Port, Host: host, Port: config.Port, WebPort: config.WebPort, PID: os.Getpid(), Deployment: config.Deployment, Scheduler: config.SchedulerName, Mode: ServerModeNormal, CopyDir: copyDir, CopyMaxSize: copyMaxSize},
 		allowedUsers:       allowedUsersMap,
+		token:              token,
 		sock:               sock,
 		ch:                 new(codec.BincHandle),
 		rpl:                &rgToKeys{lookup: make(map[string]map[string]bool)},
@@ -426,6 +558,7 @@ func Serve(config ServerConfig) (s *Server, msg string, err error) {
 		sgroupcounts:       make(map[string]int),
 		sgrouptrigs:        make(map[string]int),
 		sgtr:               make(map[string]*scheduler.Requirements),
+		sgerrs:             make(map[string]string),
 		rc:                 config.RunnerCmd,
 		wsconns:            make(map[string]*websocket.Conn),
 		statusCaster:       bcast.NewGroup(),
@@ -434,6 +567,16 @@ func Serve(config ServerConfig) (s *Server, msg string, err error) {
 		schedCaster:        bcast.NewGroup(),
 		schedIssues:        make(map[string]*schedulerIssue),
 		timings:            make(map[string]*timingAvg),
+		cronSchedules:      make(map[string]*CronSchedule),
+		stopCron:           make(chan bool),
+		limitGroups:        make(map[string]*LimitGroup),
+		limitProtectors:    make(map[string]*rp.Protector),
+		tails:              make(map[string]*outputTail),
+		subscriptions:      make(map[string]*Subscription),
+		finishedRGs:        make(map[string]bool),
+		stopNotify:         make(chan bool),
+		stopPrune:          make(chan bool),
+		standbys:           make(map[string]time.Time),
 		Logger:             serverLogger,
 	}
 
@@ -460,6 +603,43 @@ func Serve(config ServerConfig) (s *Server, msg string, err error) {
 		}
 	}
 
+	// restore the limits users set on their limit groups
+	err = s.loadLimitGroups()
+	if err != nil {
+		return nil, msg, err
+	}
+
+	// if we're a standby taking over, the jobs that were running can carry on
+	if config.takeover != nil {
+		s.awaitAdoption(config.takeover.running)
+	}
+
+	// restore the webhooks users subscribed to notifications with
+	err = s.loadSubscriptions()
+	if err != nil {
+		return nil, msg, err
+	}
+
+	// start firing any recurring jobs we were asked to schedule
+	err = s.loadCronSchedules()
+	if err != nil {
+		return nil, msg, err
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.cronLoop()
+	}()
+
+	// regularly remove old complete jobs from the database
+	if config.Retention != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.pruneLoop(config.Retention)
+		}()
+	}
+
 	// set up responding to command-line clients
 	wg.Add(1)
 	go func() {
@@ -541,15 +721,22 @@ func Serve(config ServerConfig) (s *Server, msg string, err error) {
 
 		mux := http.NewServeMux()
 		mux.HandleFunc("/", webInterfaceStatic(s))
-		mux.HandleFunc("/status_ws", webInterfaceStatusWS(s))
-		mux.HandleFunc(restJobsEndpoint, restJobs(s))
-		mux.HandleFunc(restWarningsEndpoint, restWarnings(s))
-		mux.HandleFunc(restBadServersEndpoint, restBadServers(s))
-		srv := &http.Server{Addr: "0.0.0.0:" + config.WebPort, Handler: mux}
+		mux.HandleFunc("/status_ws", authenticated(s, webInterfaceStatusWS(s)))
+		mux.HandleFunc("/tail_ws", authenticated(s, webInterfaceTailWS(s)))
+		mux.HandleFunc(restJobsEndpoint, authenticated(s, restJobs(s)))
+		mux.HandleFunc(restWarningsEndpoint, authenticated(s, restWarnings(s)))
+		mux.HandleFunc(restBadServersEndpoint, authenticated(s, restBadServers(s)))
+		mux.HandleFunc(restGraphEndpoint, authenticated(s, restGraph(s)))
+		mux.HandleFunc(restCopiedEndpoint, authenticated(s, restCopied(s)))
+		mux.HandleFunc(restStdEndpoint, authenticated(s, restStd(s)))
+		mux.HandleFunc(restHistoryEndpoint, authenticated(s, restHistory(s)))
+		mux.HandleFunc(metricsEndpoint, authenticated(s, metrics(s)))
+		mux.HandleFunc(healthzEndpoint, healthz(s))
+		srv := &http.Server{Addr: "0.0.0.0:" + config.WebPort, Handler: mux, TLSConfig: tlsConfig}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			errs := srv.ListenAndServe() // *** should use ListenAndServeTLS, which needs certs (http package has cert creation)...
+			errs := srv.ListenAndServeTLS("", "") // our TLSConfig already has our certificate
 			if errs != nil && errs != http.ErrServerClosed {
 				s.Error("server web interface had problems", "err", errs)
 			}
@@ -589,6 +776,9 @@ func Serve(config ServerConfig) (s *Server, msg string, err error) {
 			s.bsmutex.Unlock()
 
 			if !skip {
+				if server.IsBad() {
+					s.notifyBadServer(server)
+				}
 				s.badServerCaster.Send(&badServer{
 					ID:      server.ID,
 					Name:    server.Name,
@@ -619,10 +809,11 @@ func Serve(config ServerConfig) (s *Server, msg string, err error) {
 			}
 			s.simutex.Unlock()
 			s.schedCaster.Send(si)
+			s.notify(&Notification{Event: NotifyEventScheduler, Time: time.Now(), Msg: msg})
 		}
 		s.scheduler.SetMessageCallBack(messageCB)
 
-		// wait a while for ListenAndServe() to start listening
+		// wait a while for ListenAndServeTLS() to start listening
 		<-time.After(10 * time.Millisecond)
 		ready <- true
 	}()
@@ -759,7 +950,7 @@ func (s *Server) createQueue() {
 		for _, inter := range allitemdata {
 			job := inter.(*Job)
 
-			// depending on job.Override, get memory and time
+			// depending on job.Override, get memory, time, disk and cores
 			// recommendations, which are rounded to get fewer larger
 			// groups
 			noRec := false
@@ -774,6 +965,15 @@ func (s *Server) createQueue() {
 						groupToReqs[job.ReqGroup] = nil
 					} else {
 						recommendedReq = &scheduler.Requirements{RAM: recm, Time: time.Duration(recs) * time.Second}
+
+						// disk and cores can't be learned from every job,
+						// so we may not have recommendations for them
+						if recd, errd := s.db.recommendedReqGroupDisk(job.ReqGroup); errd == nil {
+							recommendedReq.Disk = recd
+						}
+						if recc, errc := s.db.recommendedReqGroupCores(job.ReqGroup); errc == nil {
+							recommendedReq.Cores = recc
+						}
 						groupToReqs[job.ReqGroup] = recommendedReq
 					}
 				}
@@ -787,9 +987,21 @@ func (s *Server) createQueue() {
 						if recommendedReq.Time > job.Requirements.Time {
 							job.Requirements.Time = recommendedReq.Time
 						}
+						if recommendedReq.Disk > job.Requirements.Disk {
+							job.Requirements.Disk = recommendedReq.Disk
+						}
+						if recommendedReq.Cores > job.Requirements.Cores {
+							job.Requirements.Cores = recommendedReq.Cores
+						}
 					} else {
 						job.Requirements.RAM = recommendedReq.RAM
 						job.Requirements.Time = recommendedReq.Time
+						if recommendedReq.Disk > 0 {
+							job.Requirements.Disk = recommendedReq.Disk
+						}
+						if recommendedReq.Cores > 0 {
+							job.Requirements.Cores = recommendedReq.Cores
+						}
 					}
 					job.Unlock()
 				} else {
@@ -987,9 +1199,10 @@ func (s *Server) createQueue() {
 			job := inter.(*Job)
 
 			// if we change from running, mark that we have not scheduled a
-			// runner for the job
+			// runner for the job, and let other jobs in its limit groups run
 			if from == JobStateRunning {
 				job.setScheduledRunner(false)
+				s.releaseLimits(job)
 
 				job.RLock()
 				l := job.Lost
@@ -1016,6 +1229,9 @@ func (s *Server) createQueue() {
 				s.statusCaster.Send(&jstateCount{group, JobStateLost, to, count})
 			}
 		}
+
+		// and tell anyone who subscribed to hear about it
+		s.notifyJobs(from, to, data)
 	})
 
 	// we set a callback for running items that hit their ttr because the
@@ -1060,6 +1276,7 @@ func (s *Server) enqueueItems(itemdefs []*queue.ItemDef) (added, dups int, err e
 			s.rpl.lookup[rp] = make(map[string]bool)
 		}
 		s.rpl.lookup[rp][itemdef.Key] = true
+		s.repGroupStarted(rp)
 	}
 	s.rpl.Unlock()
 
@@ -1070,6 +1287,13 @@ func (s *Server) enqueueItems(itemdefs []*queue.ItemDef) (added, dups int, err e
 // queue. It returns 2 errors; the first is one of our Err constant strings,
 // the second is the actual error with more details.
 func (s *Server) createJobs(inputJobs []*Job, envkey string, ignoreComplete bool) (added, dups, alreadyComplete int, srerr string, qerr error) {
+	// expand any job arrays in to their constituent jobs
+	inputJobs, qerr = expandJobArrays(inputJobs)
+	if qerr != nil {
+		srerr = ErrBadRequest
+		return added, dups, alreadyComplete, srerr, qerr
+	}
+
 	// create itemdefs for the jobs
 	for _, job := range inputJobs {
 		job.Lock()
@@ -1153,6 +1377,154 @@ func (s *Server) createJobs(inputJobs []*Job, envkey string, ignoreComplete bool
 	return added, dups, alreadyComplete, srerr, qerr
 }
 
+// modifyJobs applies the changes described by the JobModifier to the jobs with
+// the given keys, as long as they are incomplete and not running. The changes
+// are persisted to the database, and the dependencies of any jobs affected by
+// changes to DepGroups or Dependencies are updated. Returns the number of jobs
+// that were modified.
+func (s *Server) modifyJobs(keys []string, jm *JobModifier) (modified int, srerr string, qerr error) {
+	var jobs []*Job
+	var items []*queue.Item
+	oldDepGroups := make(map[string][]string)
+	oldDepDepGroups := make(map[string][]string)
+	changedDepGroups := make(map[string]bool)
+	oldLimitGroups := make(map[string]bool)
+	triggerReady := false
+	for _, jobkey := range keys {
+		item, err := s.q.Get(jobkey)
+		if err != nil || item == nil {
+			continue
+		}
+		iState := item.Stats().State
+		if iState == queue.ItemStateRun || iState == queue.ItemStateRemoved {
+			continue
+		}
+
+		job := item.Data.(*Job)
+		job.Lock()
+		oldRepGroup := job.RepGroup
+		oldDepGroups[jobkey] = job.DepGroups
+		oldDepDepGroups[jobkey] = job.Dependencies.DepGroups()
+		if jm.DepGroupsSet {
+			for _, depGroup := range job.DepGroups {
+				changedDepGroups[depGroup] = true
+			}
+		}
+		if jm.LimitGroupsSet {
+			for _, limitGroup := range job.LimitGroups {
+				oldLimitGroups[limitGroup] = true
+			}
+		}
+		reqsChanged := jm.modify(job)
+		if jm.DepGroupsSet {
+			for _, depGroup := range job.DepGroups {
+				changedDepGroups[depGroup] = true
+			}
+		}
+		newRepGroup := job.RepGroup
+		job.Unlock()
+
+		if iState == queue.ItemStateReady && (reqsChanged || jm.Override != nil) {
+			triggerReady = true
+		}
+
+		if newRepGroup != oldRepGroup {
+			s.rpl.Lock()
+			if m, exists := s.rpl.lookup[oldRepGroup]; exists {
+				delete(m, jobkey)
+			}
+			if _, exists := s.rpl.lookup[newRepGroup]; !exists {
+				s.rpl.lookup[newRepGroup] = make(map[string]bool)
+			}
+			s.rpl.lookup[newRepGroup][jobkey] = true
+			s.rpl.Unlock()
+			s.repGroupStarted(newRepGroup)
+
+			state := itemsStateToJobState[iState]
+			s.statusCaster.Send(&jstateCount{oldRepGroup, state, JobStateNew, 1})
+			s.statusCaster.Send(&jstateCount{newRepGroup, JobStateNew, state, 1})
+		}
+
+		jobs = append(jobs, job)
+		items = append(items, item)
+	}
+
+	if len(jobs) == 0 {
+		return 0, "", nil
+	}
+
+	err := s.db.modifyLiveJobs(jobs, oldDepGroups, oldDepDepGroups)
+	if err != nil {
+		return 0, ErrDBError, err
+	}
+
+	// update the queue with new priorities and dependencies
+	for i, job := range jobs {
+		item := items[i]
+		stats := item.Stats()
+		job.RLock()
+		priority := job.Priority
+		job.RUnlock()
+		if jm.DependenciesSet {
+			deps, errd := job.Dependencies.incompleteJobKeys(s.db)
+			if errd != nil {
+				return modified, ErrDBError, errd
+			}
+			err = s.q.Update(item.Key, job.getSchedulerGroup(), job, priority, stats.Delay, stats.TTR, deps)
+		} else {
+			err = s.q.Update(item.Key, job.getSchedulerGroup(), job, priority, stats.Delay, stats.TTR)
+		}
+		if err != nil {
+			return modified, ErrInternalError, err
+		}
+		modified++
+	}
+
+	// jobs held back by the limit groups they used to be in may now be able to
+	// run
+	for limitGroup := range oldLimitGroups {
+		s.q.ReleaseHeld(limitGroup)
+	}
+
+	// jobs that depend on the DepGroups that were changed need their
+	// dependencies recalculated
+	if len(changedDepGroups) > 0 {
+		_, jobsToUpdate, errr := s.db.retrieveDependentJobs(changedDepGroups, make(map[string]bool))
+		if errr != nil {
+			return modified, ErrDBError, errr
+		}
+		for _, dbjob := range jobsToUpdate {
+			item, errg := s.q.Get(dbjob.key())
+			if errg != nil || item == nil {
+				continue
+			}
+			stats := item.Stats()
+			if stats.State == queue.ItemStateRun {
+				continue
+			}
+			job := item.Data.(*Job)
+			job.RLock()
+			priority := job.Priority
+			deps, errd := job.Dependencies.incompleteJobKeys(s.db)
+			job.RUnlock()
+			if errd != nil {
+				return modified, ErrDBError, errd
+			}
+			err = s.q.Update(item.Key, job.getSchedulerGroup(), job, priority, stats.Delay, stats.TTR, deps)
+			if err != nil {
+				return modified, ErrInternalError, err
+			}
+		}
+	}
+
+	if triggerReady {
+		// recalculate scheduler groups and the resources we need
+		s.q.TriggerReadyAddedCallback()
+	}
+
+	return modified, "", nil
+}
+
 // killJob sets the killCalled property on a job, to change the subsequent
 // behaviour of touching, which should result in an executing job killing
 // itself.
@@ -1175,11 +1547,17 @@ func (s *Server) killJob(jobkey string) (bool, error) {
 
 	if job.Lost {
 		job.UntilBuried--
-		ub := job.UntilBuried
 		job.Exited = true
 		job.Exitcode = -1
 		job.EndTime = time.Now()
 		job.FailReason = FailReasonLost
+		job.recordAttempt()
+		job.applyRetryPolicy()
+		ub := job.UntilBuried
+		var delay time.Duration
+		if ub > 0 {
+			delay = job.retryDelay()
+		}
 		job.Unlock()
 		s.db.updateJobAfterExit(job, []byte{}, []byte{}, false)
 
@@ -1191,6 +1569,10 @@ func (s *Server) killJob(jobkey string) (bool, error) {
 			s.decrementGroupCount(job.getSchedulerGroup())
 			return true, err
 		}
+		err = s.q.SetDelay(item.Key, delay)
+		if err != nil {
+			return true, err
+		}
 		err = s.q.Release(item.Key)
 		if err != nil {
 			return true, err
@@ -1203,6 +1585,68 @@ func (s *Server) killJob(jobkey string) (bool, error) {
 	return true, err
 }
 
+// deleteJob removes a job from the bury/delay/dependent/ready queue and the
+// live bucket. Returns false if the job wasn't in the queue, was running, or
+// has dependents (we can't allow the removal of those, as *queue would regard
+// that as satisfying the dependency and downstream jobs would start).
+func (s *Server) deleteJob(jobkey string) bool {
+	item, err := s.q.Get(jobkey)
+	if err != nil || item == nil {
+		return false
+	}
+	iState := item.Stats().State
+	if iState == queue.ItemStateRun || iState == queue.ItemStateRemoved {
+		return false
+	}
+
+	hasDeps, err := s.q.HasDependents(jobkey)
+	if err != nil || hasDeps {
+		return false
+	}
+
+	err = s.q.Remove(jobkey)
+	if err != nil {
+		return false
+	}
+	s.db.deleteLiveJob(jobkey) //*** probably want to batch this up to delete many at once
+
+	job := item.Data.(*Job)
+	job.RLock()
+	rgroup := job.RepGroup
+	sgroup := job.schedulerGroup
+	job.RUnlock()
+
+	s.rpl.Lock()
+	if m, exists := s.rpl.lookup[rgroup]; exists {
+		delete(m, jobkey)
+	}
+	s.rpl.Unlock()
+
+	if iState == queue.ItemStateReady {
+		s.decrementGroupCount(sgroup)
+	}
+	return true
+}
+
+// reservableBy returns our accept function for ReserveFiltered() when a client
+// on the given host reserves jobs: jobs awaiting adoption can't be reserved,
+// jobs that prefer to be retried on a different host than the ones they failed
+// on avoid those hosts for a short while (unless the given host is the only one
+// our scheduler has), and otherwise jobs are subject to the limits of their
+// limit groups.
+func (s *Server) reservableBy(host string) func(item *queue.Item) (bool, string) {
+	soleHost := s.soleHost()
+	return func(item *queue.Item) (bool, string) {
+		if s.awaitingAdoption(item.Key) {
+			return false, ""
+		}
+		if !soleHost && item.Data.(*Job).avoidsHost(host) {
+			return false, ""
+		}
+		return s.acquireLimits(item)
+	}
+}
+
 // getJobsByKeys gets jobs with the given keys (current and complete)
 func (s *Server) getJobsByKeys(keys []string, getStd bool, getEnv bool) (jobs []*Job, srerr string, qerr string) {
 	var notfound []string
@@ -1240,6 +1684,35 @@ func (s *Server) getJobsByKeys(keys []string, getStd bool, getEnv bool) (jobs []
 	return jobs, srerr, qerr
 }
 
+// getCapturedStd gets part of the complete STDOUT (or STDERR) of the job with
+// the given key, as written to its StdCapture location when it ran.
+func (s *Server) getCapturedStd(key string, stderr bool, offset int64, length int) (data []byte, srerr string, qerr string) {
+	jobs, srerr, qerr := s.getJobsByKeys([]string{key}, false, false)
+	if srerr != "" {
+		return nil, srerr, qerr
+	}
+	if len(jobs) != 1 {
+		return nil, ErrBadJob, ""
+	}
+
+	job := jobs[0]
+	job.RLock()
+	location := job.CapturedStdOut
+	if stderr {
+		location = job.CapturedStdErr
+	}
+	job.RUnlock()
+	if location == "" {
+		return nil, ErrNoCapturedStd, ""
+	}
+
+	data, err := readCapture(location, offset, length)
+	if err != nil {
+		return nil, ErrInternalError, err.Error()
+	}
+	return data, "", ""
+}
+
 // getJobsByRepGroup gets jobs in the given group (current and complete)
 func (s *Server) getJobsByRepGroup(repgroup string, limit int, state JobState, getStd bool, getEnv bool) (jobs []*Job, srerr string, qerr string) {
 	// look in the in-memory queue for matching jobs
@@ -1300,6 +1773,95 @@ func (s *Server) getJobsCurrent(limit int, state JobState, getStd bool, getEnv b
 	return jobs
 }
 
+// getJobsPage gets up to pageSize jobs in the given state (any state if blank;
+// 'reserved' and 'running' are treated as the same state), skipping the first
+// offset of them. Current jobs come first, in the order of the given items
+// (which should be sorted by key), followed by the complete jobs in the given
+// RepGroup (none if blank) in key order. Only the jobs in the page are
+// converted to Jobs, so that paging through very many jobs never needs them all
+// in memory at once.
+func (s *Server) getJobsPage(items []*queue.Item, repgroup string, state JobState, offset int, pageSize int, getStd bool, getEnv bool) (jobs []*Job, srerr string, qerr string) {
+	if state == JobStateRunning {
+		state = JobStateReserved
+	}
+	for _, item := range items {
+		if len(jobs) == pageSize {
+			break
+		}
+		if state != "" {
+			jState := itemsStateToJobState[item.Stats().State]
+			if jState == JobStateReserved {
+				sjob := item.Data.(*Job)
+				sjob.RLock()
+				if sjob.Lost {
+					jState = JobStateLost
+				}
+				sjob.RUnlock()
+			}
+			if jState != state {
+				continue
+			}
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		jobs = append(jobs, s.itemToJob(item, false, false))
+	}
+
+	if repgroup != "" && len(jobs) < pageSize && (state == "" || state == JobStateComplete) {
+		complete, err := s.db.retrieveCompleteJobsByRepGroupPage(repgroup, offset, pageSize-len(jobs))
+		if err != nil {
+			srerr = ErrDBError
+			qerr = err.Error()
+		}
+		for _, cj := range complete {
+			// (see getJobsByRepGroup())
+			cj.RepGroup = repgroup
+		}
+		jobs = append(jobs, complete...)
+	}
+
+	if getEnv || getStd {
+		for _, job := range jobs {
+			s.jobPopulateStdEnv(job, getStd, getEnv)
+		}
+	}
+	return jobs, srerr, qerr
+}
+
+// getJobsByRepGroupPage is like getJobsByRepGroup() with a limit of 0, but
+// only gets a page of the jobs, as per getJobsPage().
+func (s *Server) getJobsByRepGroupPage(repgroup string, state JobState, offset int, pageSize int, getStd bool, getEnv bool) ([]*Job, string, string) {
+	s.rpl.RLock()
+	keys := make([]string, 0, len(s.rpl.lookup[repgroup]))
+	for key := range s.rpl.lookup[repgroup] {
+		keys = append(keys, key)
+	}
+	s.rpl.RUnlock()
+	sort.Strings(keys)
+
+	items := make([]*queue.Item, 0, len(keys))
+	for _, key := range keys {
+		item, err := s.q.Get(key)
+		if err == nil && item != nil {
+			items = append(items, item)
+		}
+	}
+	return s.getJobsPage(items, repgroup, state, offset, pageSize, getStd, getEnv)
+}
+
+// getJobsCurrentPage is like getJobsCurrent() with a limit of 0, but only gets
+// a page of the jobs, as per getJobsPage().
+func (s *Server) getJobsCurrentPage(state JobState, offset int, pageSize int, getStd bool, getEnv bool) []*Job {
+	items := s.q.AllItems()
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+	jobs, _, _ := s.getJobsPage(items, "", state, offset, pageSize, getStd, getEnv)
+	return jobs
+}
+
 // limitJobs handles the limiting of jobs for getJobsByRepGroup() and
 // getJobsCurrent(). States 'reserved' and 'running' are treated as the same
 // state.
@@ -1390,6 +1952,13 @@ func (s *Server) scheduleRunners(group string) {
 
 	if !doClear {
 		err := s.scheduler.Schedule(fmt.Sprintf(rc, group, s.ServerInfo.Deployment, s.ServerInfo.Addr, s.scheduler.ReserveTimeout(), int(s.scheduler.MaxQueueTime(req).Minutes())), req, groupCount)
+		s.sgcmutex.Lock()
+		if err != nil {
+			s.sgerrs[group] = err.Error()
+		} else {
+			delete(s.sgerrs, group)
+		}
+		s.sgcmutex.Unlock()
 		if err != nil {
 			problem := true
 			if serr, ok := err.(scheduler.Error); ok && serr.Err == scheduler.ErrImpossible {
@@ -1500,6 +2069,7 @@ func (s *Server) clearSchedulerGroup(schedulerGroup string) {
 		delete(s.sgroupcounts, schedulerGroup)
 		delete(s.sgrouptrigs, schedulerGroup)
 		delete(s.sgtr, schedulerGroup)
+		delete(s.sgerrs, schedulerGroup)
 		s.sgcmutex.Unlock()
 		err := s.scheduler.Schedule(fmt.Sprintf(s.rc, schedulerGroup, s.ServerInfo.Deployment, s.ServerInfo.Addr, s.scheduler.ReserveTimeout(), int(s.scheduler.MaxQueueTime(req).Minutes())), req, 0)
 		if err != nil {
@@ -1580,6 +2150,17 @@ func (s *Server) shutdown(reason string, wait bool, stopSigHandling bool) {
 	s.drain = true
 	s.ServerInfo.Mode = ServerModeDrain
 	s.ssmutex.Unlock()
+
+	// stop firing cron schedules, waiting for any current firing to finish
+	close(s.stopCron)
+	s.cronmutex.Lock()
+	s.cronmutex.Unlock()
+
+	// stop pruning the database, waiting for any current pruning to finish
+	close(s.stopPrune)
+	s.pruneMutex.Lock()
+	s.pruneMutex.Unlock()
+
 	s.krmutex.Lock()
 	s.killRunners = true
 	s.krmutex.Unlock()
@@ -1602,6 +2183,9 @@ func (s *Server) shutdown(reason string, wait bool, stopSigHandling bool) {
 	// stop the scheduler
 	s.scheduler.Cleanup()
 
+	// stop sending notifications
+	s.stopNotifications()
+
 	// graceful shutdown of all websocket-related goroutines and connections
 	s.statusCaster.Close()
 	s.badServerCaster.Close()
@@ -22,6 +22,7 @@ package jobqueue
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"fmt"
 	"sync"
 	"time"
@@ -48,17 +49,22 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 	var sr *serverResponse
 	var srerr string
 	var qerr string
+	start := time.Now()
+	var waited time.Duration // time spent blocked waiting for the client
 
 	s.ssmutex.RLock()
 	up := s.up
 	drain := s.drain
 	s.ssmutex.RUnlock()
 
-	// check that the client making the request has the expected username; NB:
-	// *** this is not real security, since the client could just lie about its
-	// username! Right now this is intended to stop accidental use of someone
-	// else's jobqueue server
-	if cr.User == "" || !s.allowedUsers[cr.User] {
+	// check that the client making the request knows our secret token, and
+	// has the expected username (the latter is not real security, since the
+	// client could just lie about its username, but stops accidental use of
+	// someone else's jobqueue server when they've shared their token)
+	if subtle.ConstantTimeCompare(cr.Token, s.token) != 1 {
+		srerr = ErrWrongToken
+		qerr = "Client denied access (bad token)"
+	} else if cr.User == "" || !s.allowedUsers[cr.User] {
 		srerr = ErrWrongUser
 		qerr = fmt.Sprintf("User %s denied access (only %s allowed)", cr.User, s.ServerInfo.AllowedUsers)
 	} else if s.q == nil || (!up && !drain) {
@@ -87,6 +93,28 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 			} else {
 				sr = &serverResponse{DB: b.Bytes()}
 			}
+		case "replicate":
+			// give a standby what it needs to take over from us
+			if cr.Replica == nil {
+				srerr = ErrBadRequest
+			} else {
+				rep, err := s.replicate(cr.Replica)
+				if err != nil {
+					srerr = ErrDBError
+					qerr = err.Error()
+				} else {
+					sr = &serverResponse{Replica: rep}
+				}
+			}
+		case "compact":
+			s.Debug("compact requested")
+			before, after, err := s.db.compact()
+			if err != nil {
+				srerr = ErrDBError
+				qerr = err.Error()
+			} else {
+				sr = &serverResponse{DBSizes: [2]int64{before, after}}
+			}
 		case "drain":
 			s.Debug("drain requested")
 			err := s.Drain()
@@ -132,6 +160,7 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 				// first just try to Reserve normally
 				var item *queue.Item
 				var err error
+				reservable := s.reservableBy(cr.Host)
 				if cr.SchedulerGroup != "" {
 					// if this is the first job that the client is trying to
 					// reserve, and if we don't actually want any more clients
@@ -147,10 +176,10 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 					}
 
 					if !skip {
-						item, err = s.q.Reserve(cr.SchedulerGroup)
+						item, err = s.q.ReserveFiltered(reservable, cr.SchedulerGroup)
 					}
 				} else {
-					item, err = s.q.Reserve()
+					item, err = s.q.ReserveFiltered(reservable)
 				}
 
 				if err != nil {
@@ -158,6 +187,7 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 						// there's nothing in the ready sub queue right now, so every
 						// second try and Reserve() from the queue until either we get
 						// an item, or we exceed the client's timeout
+						waitStart := time.Now()
 						var stop <-chan time.Time
 						if cr.Timeout.Nanoseconds() > 0 {
 							stop = time.After(cr.Timeout)
@@ -173,7 +203,7 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 							for {
 								select {
 								case <-ticker.C:
-									itemr, err := s.q.Reserve(cr.SchedulerGroup)
+									itemr, err := s.q.ReserveFiltered(reservable, cr.SchedulerGroup)
 									if err != nil {
 										if qerr, ok := err.(queue.Error); ok && qerr.Err == queue.ErrNothingReady {
 											continue
@@ -199,6 +229,7 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 						}()
 						itemerr := <-itemerrch
 						close(itemerrch)
+						waited = time.Since(waitStart)
 						item = itemerr.item
 						srerr = itemerr.err
 					}
@@ -215,6 +246,7 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 					sjob.StartTime = tnil
 					sjob.EndTime = tnil
 					sjob.PeakRAM = 0
+					sjob.PeakDisk = 0
 					sjob.Exitcode = -1
 					sgroup := sjob.schedulerGroup
 					sjob.Unlock()
@@ -254,6 +286,42 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 					job.Lost = false
 				}
 				job.Unlock()
+				if srerr == "" {
+					sr = &serverResponse{Tail: s.tailWanted(job.key())}
+				}
+			}
+		case "copy":
+			// store part of a file the job wants copied to us
+			var job *Job
+			_, job, srerr = s.getij(cr)
+			if srerr == "" {
+				if cr.Chunk == nil {
+					srerr = ErrBadRequest
+				} else {
+					srerr, qerr = s.receiveChunk(job, cr.Chunk)
+				}
+			}
+		case "jstream":
+			// store recent output of a running job for tailers
+			var job *Job
+			_, job, srerr = s.getij(cr)
+			if srerr == "" {
+				if cr.Output == nil {
+					srerr = ErrBadRequest
+				} else {
+					sr = &serverResponse{Tail: s.tailReceive(job.key(), cr.Output)}
+				}
+			}
+		case "jtail":
+			// get the recent output of a job, registering our interest in it
+			if len(cr.Keys) != 1 || cr.Output == nil {
+				srerr = ErrBadRequest
+			} else {
+				var chunk *outputChunk
+				chunk, srerr = s.tailRead(cr.Keys[0], cr.Output)
+				if srerr == "" {
+					sr = &serverResponse{Output: chunk}
+				}
 			}
 		case "jtouch":
 			var job *Job
@@ -280,7 +348,10 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 					if err != nil {
 						srerr = ErrInternalError
 						qerr = err.Error()
-					} else if lost {
+					} else {
+						s.touchLimits(job)
+					}
+					if err == nil && lost {
 						job.Lock()
 						job.Lost = false
 						job.EndTime = time.Time{}
@@ -292,7 +363,7 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 						s.statusCaster.Send(&jstateCount{job.RepGroup, JobStateLost, JobStateRunning, 1})
 					}
 				}
-				sr = &serverResponse{KillCalled: killCalled}
+				sr = &serverResponse{KillCalled: killCalled, Tail: s.tailWanted(job.key())}
 			}
 		case "jarchive":
 			// remove the job from the queue, rpl and live bucket and add to
@@ -317,6 +388,7 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 					key := job.key()
 					job.State = JobStateComplete
 					job.FailReason = ""
+					job.recordAttempt()
 					sgroup := job.schedulerGroup
 					rgroup := job.RepGroup
 					job.Unlock()
@@ -362,6 +434,8 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 				if job.Exited && job.Exitcode != 0 {
 					job.updateRecsAfterFailure()
 				}
+				job.recordAttempt()
+				job.applyRetryPolicy()
 				if job.UntilBuried <= 0 {
 					sgroup := job.schedulerGroup
 					job.Unlock()
@@ -376,7 +450,12 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 					}
 				} else {
 					sgroup := job.schedulerGroup
+					delay := job.retryDelay()
 					job.Unlock()
+					errd := s.q.SetDelay(item.Key, delay)
+					if errd != nil {
+						s.Warn("release queue SetDelay failed", "err", errd)
+					}
 					err := s.q.Release(item.Key)
 					if err != nil {
 						srerr = ErrInternalError
@@ -397,6 +476,7 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 				job.updateAfterExit(cr.JobEndState)
 				job.Lock()
 				job.FailReason = cr.Job.FailReason
+				job.recordAttempt()
 				sgroup := job.schedulerGroup
 				job.Unlock()
 				err := s.q.Bury(item.Key)
@@ -435,29 +515,15 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 				sr = &serverResponse{Existed: kicked}
 			}
 		case "jdel":
-			// remove the jobs from the bury queue and the live bucket
+			// remove the jobs from the bury/delay/dependent/ready queue and the
+			// live bucket
 			if cr.Keys == nil {
 				srerr = ErrBadRequest
 			} else {
 				deleted := 0
 				for _, jobkey := range cr.Keys {
-					item, err := s.q.Get(jobkey)
-					if err != nil || item.Stats().State != queue.ItemStateBury {
-						continue
-					}
-
-					// we can't allow the removal of jobs that have dependencies, as
-					// *queue would regard that as satisfying the dependency and
-					// downstream jobs would start
-					hasDeps, err := s.q.HasDependents(jobkey)
-					if err != nil || hasDeps {
-						continue
-					}
-
-					err = s.q.Remove(jobkey)
-					if err == nil {
+					if s.deleteJob(jobkey) {
 						deleted++
-						s.db.deleteLiveJob(jobkey) //*** probably want to batch this up to delete many at once
 					}
 				}
 				s.Debug("deleted jobs", "count", deleted)
@@ -484,6 +550,113 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 				s.Debug("killed jobs", "count", killable)
 				sr = &serverResponse{Existed: killable}
 			}
+		case "jmod":
+			// change the properties of the jobs; as per jkick, client doesn't
+			// have to be the Reserve() owner of these jobs, and we only allow
+			// changes to jobs that aren't running
+			if cr.Keys == nil || cr.Modifier == nil {
+				srerr = ErrBadRequest
+			} else {
+				modified, thisSrerr, err := s.modifyJobs(cr.Keys, cr.Modifier)
+				if err != nil {
+					srerr = thisSrerr
+					qerr = err.Error()
+				} else {
+					s.Debug("modified jobs", "count", modified)
+					sr = &serverResponse{Existed: modified}
+				}
+			}
+		case "cadd":
+			// add a cron schedule that will add its job to the queue
+			// repeatedly
+			if cr.Schedule == nil {
+				srerr = ErrBadRequest
+			} else {
+				var err error
+				srerr, err = s.addCronSchedule(cr.Schedule)
+				if err != nil {
+					qerr = err.Error()
+				}
+			}
+		case "cget":
+			// get all the cron schedules
+			schedules := s.getCronSchedules()
+			if len(schedules) > 0 {
+				sr = &serverResponse{Schedules: schedules}
+			}
+		case "cdel":
+			// remove cron schedules by name
+			if cr.Keys == nil {
+				srerr = ErrBadRequest
+			} else {
+				removed := 0
+				for _, name := range cr.Keys {
+					if s.removeCronSchedule(name) {
+						removed++
+					}
+				}
+				sr = &serverResponse{Existed: removed}
+			}
+		case "nadd":
+			// add a webhook subscription to notifications
+			if cr.Subscription == nil {
+				srerr = ErrBadRequest
+			} else {
+				var err error
+				srerr, err = s.addSubscription(cr.Subscription)
+				if err != nil {
+					qerr = err.Error()
+				}
+			}
+		case "nget":
+			// get all the subscriptions
+			subs := s.getSubscriptions()
+			if len(subs) > 0 {
+				sr = &serverResponse{Subs: subs}
+			}
+		case "ndel":
+			// remove subscriptions by name
+			if cr.Keys == nil {
+				srerr = ErrBadRequest
+			} else {
+				removed := 0
+				for _, name := range cr.Keys {
+					if s.removeSubscription(name) {
+						removed++
+					}
+				}
+				sr = &serverResponse{Existed: removed}
+			}
+		case "lset":
+			// create or change the limits of a limit group
+			if cr.LimitGroup == nil {
+				srerr = ErrBadRequest
+			} else {
+				var err error
+				srerr, err = s.setLimitGroup(cr.LimitGroup)
+				if err != nil {
+					qerr = err.Error()
+				}
+			}
+		case "lget":
+			// get all the limit groups
+			limits := s.getLimitGroups()
+			if len(limits) > 0 {
+				sr = &serverResponse{LimitGroups: limits}
+			}
+		case "ldel":
+			// remove limit groups by name
+			if cr.Keys == nil {
+				srerr = ErrBadRequest
+			} else {
+				removed := 0
+				for _, name := range cr.Keys {
+					if s.removeLimitGroup(name) {
+						removed++
+					}
+				}
+				sr = &serverResponse{Existed: removed}
+			}
 		case "getbc":
 			// get jobs by their keys (which come from their Cmds & Cwds)
 			if cr.Keys == nil {
@@ -501,20 +674,65 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 				srerr = ErrBadRequest
 			} else {
 				var jobs []*Job
-				jobs, srerr, qerr = s.getJobsByRepGroup(cr.Job.RepGroup, cr.Limit, cr.State, cr.GetStd, cr.GetEnv)
+				if cr.PageSize > 0 {
+					jobs, srerr, qerr = s.getJobsByRepGroupPage(cr.Job.RepGroup, cr.State, int(cr.Offset), cr.PageSize, cr.GetStd, cr.GetEnv)
+				} else {
+					jobs, srerr, qerr = s.getJobsByRepGroup(cr.Job.RepGroup, cr.Limit, cr.State, cr.GetStd, cr.GetEnv)
+				}
 				if len(jobs) > 0 {
 					sr = &serverResponse{Jobs: jobs}
 				}
 			}
+		case "gethist":
+			// search the history of jobs that have finished running
+			if cr.History == nil {
+				srerr = ErrBadRequest
+			} else {
+				var jobs []*Job
+				jobs, srerr, qerr = s.getJobHistory(cr.History)
+				if len(jobs) > 0 {
+					sr = &serverResponse{Jobs: jobs}
+				}
+			}
+		case "getgraph":
+			// get the dependency graph of a RepGroup
+			if cr.Job == nil || cr.Job.RepGroup == "" {
+				srerr = ErrBadRequest
+			} else {
+				var graph *JobGraph
+				graph, srerr, qerr = s.getJobGraph(cr.Job.RepGroup)
+				if graph != nil {
+					sr = &serverResponse{Graph: graph}
+				}
+			}
+		case "getstd":
+			// get part of the complete STDOUT/STDERR of a job
+			if len(cr.Keys) != 1 || cr.Offset < 0 {
+				srerr = ErrBadRequest
+			} else {
+				var data []byte
+				data, srerr, qerr = s.getCapturedStd(cr.Keys[0], cr.Stderr, cr.Offset, cr.Limit)
+				if srerr == "" {
+					sr = &serverResponse{Std: data}
+				}
+			}
 		case "getin":
 			// get all jobs in the jobqueue
-			jobs := s.getJobsCurrent(cr.Limit, cr.State, cr.GetStd, cr.GetEnv)
+			var jobs []*Job
+			if cr.PageSize > 0 {
+				jobs = s.getJobsCurrentPage(cr.State, int(cr.Offset), cr.PageSize, cr.GetStd, cr.GetEnv)
+			} else {
+				jobs = s.getJobsCurrent(cr.Limit, cr.State, cr.GetStd, cr.GetEnv)
+			}
 			if len(jobs) > 0 {
 				sr = &serverResponse{Jobs: jobs}
 			}
 		default:
 			srerr = ErrUnknownCommand
 		}
+		if srerr != ErrUnknownCommand {
+			s.logTimings(cr.Method, time.Since(start)-waited)
+		}
 	}
 
 	// on error, just send the error back to client and return a more detailed
@@ -543,8 +761,8 @@ func (s *Server) handleRequest(m *mangos.Message) error {
 	return s.reply(m, sr) // *** log failure to reply?
 }
 
-// logTimings will log the average took after 1000 calls to this message with
-// the same desc.
+// logTimings will debug log the average took after 1000 calls to this message
+// with the same desc. It also keeps running totals for our metrics.
 func (s *Server) logTimings(desc string, took time.Duration) {
 	if desc == "" {
 		return
@@ -557,7 +775,7 @@ func (s *Server) logTimings(desc string, took time.Duration) {
 	avg := s.timings[desc].store(took.Seconds())
 	s.tmutex.Unlock()
 	if avg > 0 {
-		s.Info("timing", "desc", desc, "avg", avg)
+		s.Debug("timing", "desc", desc, "avg", avg)
 	}
 }
 
@@ -565,6 +783,8 @@ func (s *Server) logTimings(desc string, took time.Duration) {
 type timingAvg struct {
 	timings [1000]float64
 	count   int
+	total   uint64
+	sum     float64
 	sync.Mutex
 }
 
@@ -575,6 +795,8 @@ func (a *timingAvg) store(s float64) float64 {
 	defer a.Unlock()
 	a.timings[a.count] = s
 	a.count++
+	a.total++
+	a.sum += s
 	if a.count == 1000 {
 		sum := float64(0)
 		for i := range &a.timings {
@@ -587,6 +809,13 @@ func (a *timingAvg) store(s float64) float64 {
 	return 0
 }
 
+// totals returns the number of floats ever stored, and their sum.
+func (a *timingAvg) totals() (uint64, float64) {
+	a.Lock()
+	defer a.Unlock()
+	return a.total, a.sum
+}
+
 // for the many j* methods in handleRequest, we do this common stuff to get
 // the desired item and job. The returned string is one of our Err* constants.
 func (s *Server) getij(cr *clientRequest) (*queue.Item, *Job, string) {
@@ -596,6 +825,11 @@ func (s *Server) getij(cr *clientRequest) (*queue.Item, *Job, string) {
 	}
 
 	item, err := s.q.Get(cr.Job.key())
+	if err == nil && item.Stats().State == queue.ItemStateReady {
+		// this may be the runner of a job that was running on the primary
+		// server we took over from
+		s.adopt(item, cr.ClientID)
+	}
 	if err != nil || item.Stats().State != queue.ItemStateRun {
 		return item, nil, ErrBadJob
 	}
@@ -632,15 +866,18 @@ func (s *Server) itemToJob(item *queue.Item, getStd bool, getEnv bool) *Job {
 		RepGroup:     sjob.RepGroup,
 		ReqGroup:     sjob.ReqGroup,
 		DepGroups:    sjob.DepGroups,
+		LimitGroups:  sjob.LimitGroups,
 		Cmd:          sjob.Cmd,
 		Cwd:          sjob.Cwd,
 		CwdMatters:   sjob.CwdMatters,
 		ChangeHome:   sjob.ChangeHome,
 		ActualCwd:    sjob.ActualCwd,
 		Requirements: req,
+		Override:     sjob.Override,
 		Priority:     sjob.Priority,
 		Retries:      sjob.Retries,
 		PeakRAM:      sjob.PeakRAM,
+		PeakDisk:     sjob.PeakDisk,
 		Exited:       sjob.Exited,
 		Exitcode:     sjob.Exitcode,
 		FailReason:   sjob.FailReason,
@@ -651,6 +888,8 @@ func (s *Server) itemToJob(item *queue.Item, getStd bool, getEnv bool) *Job {
 		HostID:       sjob.HostID,
 		HostIP:       sjob.HostIP,
 		CPUtime:      sjob.CPUtime,
+		IORead:       sjob.IORead,
+		IOWrite:      sjob.IOWrite,
 		State:        state,
 		Attempts:     sjob.Attempts,
 		UntilBuried:  sjob.UntilBuried,
@@ -660,7 +899,22 @@ func (s *Server) itemToJob(item *queue.Item, getStd bool, getEnv bool) *Job {
 		Dependencies: sjob.Dependencies,
 		Behaviours:   sjob.Behaviours,
 		MountConfigs: sjob.MountConfigs,
+		ArrayIndex:   sjob.ArrayIndex,
+		CopiedFiles:  sjob.CopiedFiles,
+		Inputs:       sjob.Inputs,
+		Outputs:      sjob.Outputs,
+		Skipped:      sjob.Skipped,
+		StdCapture:   sjob.StdCapture,
+		StdCompress:  sjob.StdCompress,
+		KillGrace:    sjob.KillGrace,
+		TimeLimit:    sjob.TimeLimit,
 	}
+	job.SkipIfUpToDate = sjob.SkipIfUpToDate
+	job.TimeLimitMult = sjob.TimeLimitMult
+	job.RetryPolicy = sjob.RetryPolicy
+	job.AttemptHistory = sjob.AttemptHistory
+	job.CapturedStdOut = sjob.CapturedStdOut
+	job.CapturedStdErr = sjob.CapturedStdErr
 
 	if !sjob.StartTime.IsZero() && state == JobStateReserved {
 		job.State = JobStateRunning
@@ -23,6 +23,7 @@ package jobqueue
 // with the job queue using JSON over HTTP.
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -42,6 +43,10 @@ const (
 	restJobsEndpoint       = "/rest/v1/jobs/"
 	restWarningsEndpoint   = "/rest/v1/warnings/"
 	restBadServersEndpoint = "/rest/v1/servers/"
+	restGraphEndpoint      = "/rest/v1/graph/"
+	restCopiedEndpoint     = "/rest/v1/copied/"
+	restStdEndpoint        = "/rest/v1/std/"
+	restHistoryEndpoint    = "/rest/v1/history/"
 	restFormTrue           = "true"
 )
 
@@ -66,16 +71,39 @@ type JobViaJSON struct {
 	Retries     *int              `json:"retries"`
 	RepGrp      string            `json:"rep_grp"`
 	DepGrps     []string          `json:"dep_grps"`
+	LimitGrps   []string          `json:"limit_grps"`
 	Deps        []string          `json:"deps"`
 	CmdDeps     Dependencies      `json:"cmd_deps"`
 	OnFailure   BehavioursViaJSON `json:"on_failure"`
 	OnSuccess   BehavioursViaJSON `json:"on_success"`
 	OnExit      BehavioursViaJSON `json:"on_exit"`
-	Env         []string          `json:"env"`
-	CloudOS     string            `json:"cloud_os"`
-	CloudUser   string            `json:"cloud_username"`
-	CloudScript string            `json:"cloud_script"`
-	CloudOSRam  *int              `json:"cloud_ram"`
+	Inputs      []string          `json:"inputs"`
+	Outputs     []string          `json:"outputs"`
+	SkipIfUTD   bool              `json:"skip_if_up_to_date"`
+	StdCapture  string            `json:"std_capture"`
+	StdCompress bool              `json:"std_compress"`
+	// KillGrace is a duration with a unit suffix, eg. 30s for 30 seconds.
+	KillGrace string `json:"kill_grace"`
+	// TimeLimit is either a duration with a unit suffix, eg. 3h for 3 hours,
+	// or a multiple of the expected time with an x suffix, eg. 2x.
+	TimeLimit   string              `json:"time_limit"`
+	RetryPolicy *RetryPolicyViaJSON `json:"retry_policy"`
+	Env         []string            `json:"env"`
+	CloudOS     string              `json:"cloud_os"`
+	CloudUser   string              `json:"cloud_username"`
+	CloudScript string              `json:"cloud_script"`
+	CloudOSRam  *int                `json:"cloud_ram"`
+	// LSF* are passed through to bsub as its -q, -R, -m, -P and -G options.
+	LSFQueue     string `json:"lsf_queue"`
+	LSFResources string `json:"lsf_resources"`
+	LSFHosts     string `json:"lsf_hosts"`
+	LSFProject   string `json:"lsf_project"`
+	LSFGroup     string `json:"lsf_group"`
+	// LSFMisc is whitespace separated bsub options to pass through as-is.
+	LSFMisc string `json:"lsf_misc"`
+	// Params makes Cmd a template for an array of jobs. Each is of the form
+	// name=spec, as described for ParseArrayParams().
+	Params []string `json:"params"`
 }
 
 // JobDefaults is supplied to JobViaJSON.Convert() to provide default values for
@@ -94,25 +122,41 @@ type JobDefaults struct {
 	// Time is the amount of time each cmd will run for. Defaults to 1 hour.
 	Time time.Duration
 	// Disk is the number of Gigabytes cmds will use.
-	Disk      int
-	Override  int
-	Priority  int
-	Retries   int
-	DepGroups []string
-	Deps      Dependencies
+	Disk        int
+	Override    int
+	Priority    int
+	Retries     int
+	DepGroups   []string
+	LimitGroups []string
+	Deps        Dependencies
 	// Env is a comma separated list of key=val pairs.
 	Env          string
 	OnFailure    Behaviours
 	OnSuccess    Behaviours
 	OnExit       Behaviours
 	MountConfigs MountConfigs
-	CloudOS      string
-	CloudUser    string
+	// SkipIfUpToDate is applied to jobs that have Outputs.
+	SkipIfUpToDate bool
+	StdCapture     string
+	StdCompress    bool
+	KillGrace      time.Duration
+	TimeLimit      time.Duration
+	TimeLimitMult  float64
+	RetryPolicy    *RetryPolicy
+	CloudOS        string
+	CloudUser      string
 	// CloudScript is the local path to a script.
 	CloudScript string
 	// CloudOSRam is the number of Megabytes that CloudOS needs to run. Defaults
 	// to 1000.
 	CloudOSRam    int
+	LSFQueue      string
+	LSFResources  string
+	LSFHosts      string
+	LSFProject    string
+	LSFGroup      string
+	LSFMisc       string
+	ArrayParams   ArrayParams
 	compressedEnv []byte
 	osRAM         string
 }
@@ -180,6 +224,7 @@ func (jvj *JobViaJSON) Convert(jd *JobDefaults) (*Job, error) {
 	var dur time.Duration
 	var envOverride []byte
 	var depGroups []string
+	var limitGroups []string
 	var deps Dependencies
 	var behaviours Behaviours
 	var mounts MountConfigs
@@ -287,6 +332,12 @@ func (jvj *JobViaJSON) Convert(jd *JobDefaults) (*Job, error) {
 		depGroups = jvj.DepGrps
 	}
 
+	if len(jvj.LimitGrps) == 0 {
+		limitGroups = jd.LimitGroups
+	} else {
+		limitGroups = jvj.LimitGrps
+	}
+
 	if len(jvj.Deps) == 0 && len(jvj.CmdDeps) == 0 {
 		deps = jd.Deps
 	} else {
@@ -336,7 +387,99 @@ func (jvj *JobViaJSON) Convert(jd *JobDefaults) (*Job, error) {
 		mounts = jd.MountConfigs
 	}
 
+	skipIfUpToDate := jd.SkipIfUpToDate
+	if jvj.SkipIfUTD {
+		skipIfUpToDate = true
+	}
+
+	stdCapture := jvj.StdCapture
+	stdCompress := jvj.StdCompress
+	if stdCapture == "" {
+		stdCapture = jd.StdCapture
+		stdCompress = stdCompress || jd.StdCompress
+	}
+
+	killGrace := jd.KillGrace
+	if jvj.KillGrace != "" {
+		var err error
+		killGrace, err = time.ParseDuration(jvj.KillGrace)
+		if err != nil {
+			return nil, fmt.Errorf("kill_grace value (%s) was not specified correctly: %s", jvj.KillGrace, err)
+		}
+	}
+	if killGrace < 0 {
+		return nil, fmt.Errorf("kill_grace value (%s) can't be negative", killGrace)
+	}
+
+	timeLimit := jd.TimeLimit
+	timeLimitMult := jd.TimeLimitMult
+	if jvj.TimeLimit != "" {
+		var err error
+		timeLimit, timeLimitMult, err = ParseTimeLimit(jvj.TimeLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	retryPolicy := jd.RetryPolicy
+	if jvj.RetryPolicy != nil {
+		var err error
+		retryPolicy, err = jvj.RetryPolicy.RetryPolicy()
+		if err != nil {
+			return nil, fmt.Errorf("retry_policy was not specified correctly: %s", err)
+		}
+	}
+
 	// scheduler-specific options
+	other, err := jvj.otherRequirements(jd)
+	if err != nil {
+		return nil, err
+	}
+
+	var aps ArrayParams
+	if len(jvj.Params) > 0 {
+		var err error
+		aps, err = ParseArrayParams(jvj.Params)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(jd.ArrayParams) > 0 {
+		aps = jd.ArrayParams
+	}
+
+	return &Job{
+		RepGroup:       repg,
+		Cmd:            cmd,
+		Cwd:            cwd,
+		CwdMatters:     cwdMatters,
+		ChangeHome:     changeHome,
+		ReqGroup:       rg,
+		Requirements:   &jqs.Requirements{RAM: mb, Time: dur, Cores: cpus, Disk: disk, Other: other},
+		Override:       uint8(override),
+		Priority:       uint8(priority),
+		Retries:        uint8(retries),
+		DepGroups:      depGroups,
+		LimitGroups:    limitGroups,
+		Dependencies:   deps,
+		EnvOverride:    envOverride,
+		Behaviours:     behaviours,
+		MountConfigs:   mounts,
+		Inputs:         jvj.Inputs,
+		Outputs:        jvj.Outputs,
+		SkipIfUpToDate: skipIfUpToDate,
+		StdCapture:     stdCapture,
+		StdCompress:    stdCompress,
+		KillGrace:      killGrace,
+		TimeLimit:      timeLimit,
+		TimeLimitMult:  timeLimitMult,
+		RetryPolicy:    retryPolicy,
+		ArrayParams:    aps,
+	}, nil
+}
+
+// otherRequirements returns the scheduler-specific Requirements.Other values
+// for our cloud_* and lsf_* options, considering the supplied defaults.
+func (jvj *JobViaJSON) otherRequirements(jd *JobDefaults) (map[string]string, error) {
 	other := make(map[string]string)
 	if jvj.CloudOS != "" {
 		other["cloud_os"] = jvj.CloudOS
@@ -367,24 +510,194 @@ func (jvj *JobViaJSON) Convert(jd *JobDefaults) (*Job, error) {
 	} else if jd.CloudOSRam != 0 {
 		other["cloud_os_ram"] = jd.DefaultCloudOSRam()
 	}
+	for key, vals := range map[string][2]string{
+		"lsf_queue":     {jvj.LSFQueue, jd.LSFQueue},
+		"lsf_resources": {jvj.LSFResources, jd.LSFResources},
+		"lsf_hosts":     {jvj.LSFHosts, jd.LSFHosts},
+		"lsf_project":   {jvj.LSFProject, jd.LSFProject},
+		"lsf_group":     {jvj.LSFGroup, jd.LSFGroup},
+		"lsf_misc":      {jvj.LSFMisc, jd.LSFMisc},
+	} {
+		if vals[0] != "" {
+			other[key] = vals[0]
+		} else if vals[1] != "" {
+			other[key] = vals[1]
+		}
+	}
+	return other, nil
+}
 
-	return &Job{
-		RepGroup:     repg,
-		Cmd:          cmd,
-		Cwd:          cwd,
-		CwdMatters:   cwdMatters,
-		ChangeHome:   changeHome,
-		ReqGroup:     rg,
-		Requirements: &jqs.Requirements{RAM: mb, Time: dur, Cores: cpus, Disk: disk, Other: other},
-		Override:     uint8(override),
-		Priority:     uint8(priority),
-		Retries:      uint8(retries),
-		DepGroups:    depGroups,
-		Dependencies: deps,
-		EnvOverride:  envOverride,
-		Behaviours:   behaviours,
-		MountConfigs: mounts,
-	}, nil
+// Modifier is like Convert(), but instead of creating a new Job, creates a
+// JobModifier for changing existing Jobs. Only properties that are set are
+// included in the modification. Cmd, Cwd, CwdMatters and MountConfigs can't be
+// set since they determine the identity of a Job, and ChangeHome, ReqGrp,
+// behaviours, inputs, outputs, std capture, kill grace, time limit and retry
+// policy can't be changed after a Job has been added; you'll get an error if
+// any of these are set. Cloud and lsf options that are set replace the
+// corresponding values in the Job's Requirements.Other.
+func (jvj *JobViaJSON) Modifier() (*JobModifier, error) {
+	if jvj.Cmd != "" || jvj.Cwd != "" || jvj.CwdMatters || len(jvj.MountConfigs) > 0 {
+		return nil, fmt.Errorf("cmd, cwd, cwd_matters and mounts can't be modified, since they determine the identity of a job; remove the job and add it again instead")
+	}
+	if len(jvj.Params) > 0 {
+		return nil, fmt.Errorf("params can't be modified; remove the jobs and add them again instead")
+	}
+	if len(jvj.Inputs) > 0 || len(jvj.Outputs) > 0 || jvj.SkipIfUTD {
+		return nil, fmt.Errorf("inputs, outputs and skip_if_up_to_date can't be modified; remove the job and add it again instead")
+	}
+	if jvj.StdCapture != "" || jvj.StdCompress {
+		return nil, fmt.Errorf("std_capture and std_compress can't be modified; remove the job and add it again instead")
+	}
+	if jvj.KillGrace != "" {
+		return nil, fmt.Errorf("kill_grace can't be modified; remove the job and add it again instead")
+	}
+	if jvj.TimeLimit != "" {
+		return nil, fmt.Errorf("time_limit can't be modified; remove the job and add it again instead")
+	}
+	if jvj.RetryPolicy != nil {
+		return nil, fmt.Errorf("retry_policy can't be modified; remove the job and add it again instead")
+	}
+	if jvj.ChangeHome || jvj.ReqGrp != "" || len(jvj.OnFailure) > 0 || len(jvj.OnSuccess) > 0 || len(jvj.OnExit) > 0 {
+		return nil, fmt.Errorf("change_home, req_grp, on_failure, on_success and on_exit options can't be modified")
+	}
+
+	jm := &JobModifier{
+		RepGroup: jvj.RepGrp,
+		Cores:    jvj.CPUs,
+		Disk:     jvj.Disk,
+	}
+
+	if jvj.Memory != "" {
+		mb, err := bytefmt.ToMegabytes(jvj.Memory)
+		if err != nil {
+			return nil, fmt.Errorf("memory value (%s) was not specified correctly: %s", jvj.Memory, err)
+		}
+		ram := int(mb)
+		jm.RAM = &ram
+	}
+
+	if jvj.Time != "" {
+		dur, err := time.ParseDuration(jvj.Time)
+		if err != nil {
+			return nil, fmt.Errorf("time value (%s) was not specified correctly: %s", jvj.Time, err)
+		}
+		jm.Time = &dur
+	}
+
+	other, err := jvj.otherRequirements(&JobDefaults{})
+	if err != nil {
+		return nil, err
+	}
+	if len(other) > 0 {
+		jm.Other = other
+		jm.OtherSet = true
+	}
+
+	if jvj.Override != nil {
+		if *jvj.Override < 0 || *jvj.Override > 2 {
+			return nil, fmt.Errorf("override value (%d) is not in the range 0..2", *jvj.Override)
+		}
+		override := uint8(*jvj.Override)
+		jm.Override = &override
+	}
+
+	if jvj.Priority != nil {
+		if *jvj.Priority < 0 || *jvj.Priority > 255 {
+			return nil, fmt.Errorf("priority value (%d) is not in the range 0..255", *jvj.Priority)
+		}
+		priority := uint8(*jvj.Priority)
+		jm.Priority = &priority
+	}
+
+	if jvj.Retries != nil {
+		if *jvj.Retries < 0 || *jvj.Retries > 255 {
+			return nil, fmt.Errorf("retries value (%d) is not in the range 0..255", *jvj.Retries)
+		}
+		retries := uint8(*jvj.Retries)
+		jm.Retries = &retries
+	}
+
+	if jvj.DepGrps != nil {
+		jm.DepGroups = jvj.DepGrps
+		jm.DepGroupsSet = true
+	}
+
+	if jvj.LimitGrps != nil {
+		jm.LimitGroups = jvj.LimitGrps
+		jm.LimitGroupsSet = true
+	}
+
+	if jvj.Deps != nil || jvj.CmdDeps != nil {
+		var deps Dependencies
+		if len(jvj.CmdDeps) > 0 {
+			deps = jvj.CmdDeps
+		}
+		for _, depgroup := range jvj.Deps {
+			deps = append(deps, NewDepGroupDependency(depgroup))
+		}
+		jm.Dependencies = deps
+		jm.DependenciesSet = true
+	}
+
+	if jvj.Env != nil {
+		err := jm.SetEnvOverride(jvj.Env)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return jm, nil
+}
+
+// ParseTimeLimit parses a time limit string, which is either a duration with a
+// unit suffix (eg. "3h"), returned as the first value, or a multiple of a Job's
+// expected time with an "x" suffix (eg. "2x"), returned as the second value.
+func ParseTimeLimit(limit string) (time.Duration, float64, error) {
+	if strings.HasSuffix(limit, "x") {
+		mult, err := strconv.ParseFloat(strings.TrimSuffix(limit, "x"), 64)
+		if err != nil || mult <= 0 {
+			return 0, 0, fmt.Errorf("time_limit value (%s) was not specified correctly: the multiple must be a positive number", limit)
+		}
+		return 0, mult, nil
+	}
+
+	d, err := time.ParseDuration(limit)
+	if err != nil {
+		return 0, 0, fmt.Errorf("time_limit value (%s) was not specified correctly: %s", limit, err)
+	}
+	if d < 0 {
+		return 0, 0, fmt.Errorf("time_limit value (%s) can't be negative", limit)
+	}
+	return d, 0, nil
+}
+
+// authenticated wraps a http handler so that it only handles requests that
+// supply our token, either as a bearer token in the Authorization header, or in
+// the cookie webInterfaceStatic() gives the web interface (since browsers can't
+// set headers on websocket connections). Other requests get a
+// http.StatusUnauthorized response.
+func authenticated(s *Server, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		} else if cookie, err := r.Cookie(s.tokenCookieName()); err == nil {
+			token = cookie.Value
+		}
+		if subtle.ConstantTimeCompare([]byte(token), s.token) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "a valid token is required", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// tokenCookieName returns the name of the cookie that holds our token for the
+// web interface. It includes our web port, since browsers don't keep separate
+// cookies for the different ports of a host.
+func (s *Server) tokenCookieName() string {
+	return "wr_token_" + s.ServerInfo.WebPort
 }
 
 // restJobs lets you do CRUD on jobs in the "cmds" queue.
@@ -433,10 +746,12 @@ func restJobs(s *Server) http.HandlerFunc {
 }
 
 // restJobsStatus gets the status of the requested jobs in the given queue. The
-// request url can be suffixed with comma separated job keys or RepGroups.
-// Possible query parameters are std, env (which can take a "true" value), limit
-// (a number) and state (one of delayed|ready|reserved|running|lost|buried|
-// dependent|complete). Returns the Jobs, a http.Status* value and error.
+// request url can be suffixed with comma separated job keys or RepGroups; a
+// RepGroup can itself be suffixed with [index] or [first-last] to get just
+// those elements of a job array. Possible query parameters are std, env (which
+// can take a "true" value), limit (a number) and state (one of delayed|ready|
+// reserved|running|lost|buried|dependent|complete). Returns the Jobs, a
+// http.Status* value and error.
 func restJobsStatus(r *http.Request, s *Server) ([]*Job, int, error) {
 	// handle possible ?query parameters
 	var getStd, getEnv bool
@@ -491,6 +806,19 @@ func restJobsStatus(r *http.Request, s *Server) ([]*Job, int, error) {
 				}
 			}
 
+			// id might be an address of some elements of a job array
+			if repGroup, first, last, ok := ParseArrayAddress(id); ok {
+				theseJobs, _, qerr := s.getJobsByRepGroup(repGroup, 0, state, getStd, getEnv)
+				if qerr != "" {
+					return nil, http.StatusInternalServerError, fmt.Errorf(qerr)
+				}
+				theseJobs = FilterArrayIndices(theseJobs, first, last)
+				if len(theseJobs) > 0 {
+					jobs = append(jobs, theseJobs...)
+					continue
+				}
+			}
+
 			// id might be a Job.RepGroup
 			theseJobs, _, qerr := s.getJobsByRepGroup(id, limit, state, getStd, getEnv)
 			if qerr != "" {
@@ -512,28 +840,37 @@ func restJobsStatus(r *http.Request, s *Server) ([]*Job, int, error) {
 //
 // It optionally takes parameters to use as defaults for the job properties,
 // which correspond to the json properties of a JobViaJSON (except for cmd and
-// cmd_deps). For dep_grps, deps and env, which normally take []string, provide
-// a comma-separated list. mounts, on_failure, on_success and on_exit values
-// should be supplied as url query escaped JSON strings.
+// cmd_deps). For dep_grps, limit_grps, deps and env, which normally take
+// []string, provide a comma-separated list. mounts, on_failure, on_success,
+// on_exit and retry_policy values should be supplied as url query escaped JSON
+// strings.
 //
 // The returned int is a http.Status* variable.
 func restJobsAdd(r *http.Request, s *Server) ([]*Job, int, error) {
 	// handle possible ?query parameters
 	jd := &JobDefaults{
-		Cwd:         r.Form.Get("cwd"),
-		RepGrp:      r.Form.Get("rep_grp"),
-		ReqGrp:      r.Form.Get("req_grp"),
-		CPUs:        urlStringToInt(r.Form.Get("cpus")),
-		Disk:        urlStringToInt(r.Form.Get("disk")),
-		Override:    urlStringToInt(r.Form.Get("override")),
-		Priority:    urlStringToInt(r.Form.Get("priority")),
-		Retries:     urlStringToInt(r.Form.Get("retries")),
-		DepGroups:   urlStringToSlice(r.Form.Get("dep_grps")),
-		Env:         r.Form.Get("env"),
-		CloudOS:     r.Form.Get("cloud_os"),
-		CloudUser:   r.Form.Get("cloud_username"),
-		CloudScript: r.Form.Get("cloud_script"),
-		CloudOSRam:  urlStringToInt(r.Form.Get("cloud_ram")),
+		Cwd:          r.Form.Get("cwd"),
+		RepGrp:       r.Form.Get("rep_grp"),
+		ReqGrp:       r.Form.Get("req_grp"),
+		CPUs:         urlStringToInt(r.Form.Get("cpus")),
+		Disk:         urlStringToInt(r.Form.Get("disk")),
+		Override:     urlStringToInt(r.Form.Get("override")),
+		Priority:     urlStringToInt(r.Form.Get("priority")),
+		Retries:      urlStringToInt(r.Form.Get("retries")),
+		DepGroups:    urlStringToSlice(r.Form.Get("dep_grps")),
+		LimitGroups:  urlStringToSlice(r.Form.Get("limit_grps")),
+		Env:          r.Form.Get("env"),
+		StdCapture:   r.Form.Get("std_capture"),
+		CloudOS:      r.Form.Get("cloud_os"),
+		CloudUser:    r.Form.Get("cloud_username"),
+		CloudScript:  r.Form.Get("cloud_script"),
+		CloudOSRam:   urlStringToInt(r.Form.Get("cloud_ram")),
+		LSFQueue:     r.Form.Get("lsf_queue"),
+		LSFResources: r.Form.Get("lsf_resources"),
+		LSFHosts:     r.Form.Get("lsf_hosts"),
+		LSFProject:   r.Form.Get("lsf_project"),
+		LSFGroup:     r.Form.Get("lsf_group"),
+		LSFMisc:      r.Form.Get("lsf_misc"),
 	}
 	if r.Form.Get("cwd_matters") == restFormTrue {
 		jd.CwdMatters = true
@@ -541,6 +878,12 @@ func restJobsAdd(r *http.Request, s *Server) ([]*Job, int, error) {
 	if r.Form.Get("change_home") == restFormTrue {
 		jd.ChangeHome = true
 	}
+	if r.Form.Get("skip_if_up_to_date") == restFormTrue {
+		jd.SkipIfUpToDate = true
+	}
+	if r.Form.Get("std_compress") == restFormTrue {
+		jd.StdCompress = true
+	}
 	if r.Form.Get("memory") != "" {
 		mb, err := bytefmt.ToMegabytes(r.Form.Get("memory"))
 		if err != nil {
@@ -555,6 +898,20 @@ func restJobsAdd(r *http.Request, s *Server) ([]*Job, int, error) {
 			return nil, http.StatusBadRequest, err
 		}
 	}
+	if r.Form.Get("kill_grace") != "" {
+		var err error
+		jd.KillGrace, err = time.ParseDuration(r.Form.Get("kill_grace"))
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	if r.Form.Get("time_limit") != "" {
+		var err error
+		jd.TimeLimit, jd.TimeLimitMult, err = ParseTimeLimit(r.Form.Get("time_limit"))
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
 	defaultDeps := urlStringToSlice(r.Form.Get("deps"))
 	if len(defaultDeps) > 0 {
 		for _, depgroup := range defaultDeps {
@@ -591,6 +948,17 @@ func restJobsAdd(r *http.Request, s *Server) ([]*Job, int, error) {
 			jd.OnExit = bvj.Behaviours(OnExit)
 		}
 	}
+	if r.Form.Get("retry_policy") != "" {
+		var rpj RetryPolicyViaJSON
+		err := urlStringToStruct(r.Form.Get("retry_policy"), &rpj)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		jd.RetryPolicy, err = rpj.RetryPolicy()
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
 	if r.Form.Get("mounts") != "" {
 		var mcs MountConfigs
 		err := urlStringToStruct(r.Form.Get("mounts"), &mcs)
@@ -619,6 +987,13 @@ func restJobsAdd(r *http.Request, s *Server) ([]*Job, int, error) {
 		inputJobs = append(inputJobs, job)
 	}
 
+	// expand any job arrays now, so that we can find the resulting jobs in the
+	// queue afterwards
+	inputJobs, err = expandJobArrays(inputJobs)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("There was a problem interpreting your job array: %s", err)
+	}
+
 	envkey, err := s.db.storeEnv([]byte{})
 	if err != nil {
 		return nil, http.StatusInternalServerError, err
@@ -736,6 +1111,219 @@ func restBadServers(s *Server) http.HandlerFunc {
 	}
 }
 
+// restGraph lets you get the dependency graph of the jobs in a RepGroup. The
+// request url must be suffixed with the RepGroup. The graph is returned as
+// JSON, unless the format query parameter is "dot", in which case it is
+// returned in the DOT language.
+func restGraph(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET is supported", http.StatusBadRequest)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("form parsing error: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		if len(r.URL.Path) <= len(restGraphEndpoint) {
+			http.Error(w, "a RepGroup is required", http.StatusBadRequest)
+			return
+		}
+		repgroup := r.URL.Path[len(restGraphEndpoint):]
+
+		graph, srerr, qerr := s.getJobGraph(repgroup)
+		if srerr != "" {
+			http.Error(w, fmt.Sprintf("%s (%s)", srerr, qerr), http.StatusInternalServerError)
+			return
+		}
+		if len(graph.Nodes) == 0 {
+			http.Error(w, "No jobs with that RepGroup", http.StatusNotFound)
+			return
+		}
+
+		switch r.Form.Get("format") {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.WriteHeader(http.StatusOK)
+			encoder := json.NewEncoder(w)
+			encoder.SetEscapeHTML(false)
+			erre := encoder.Encode(graph)
+			if erre != nil {
+				s.Warn("restGraph failed to encode graph", "err", erre)
+			}
+		case "dot":
+			w.Header().Set("Content-Type", "text/vnd.graphviz; charset=UTF-8")
+			w.WriteHeader(http.StatusOK)
+			_, erre := w.Write([]byte(graph.DOT()))
+			if erre != nil {
+				s.Warn("restGraph failed to write graph", "err", erre)
+			}
+		default:
+			http.Error(w, "format must be json or dot", http.StatusBadRequest)
+		}
+	}
+}
+
+// restCopied lets you browse and GET the files that CopyToManager Behaviours
+// have copied to the server's CopyDir. Paths after the endpoint are the
+// relative paths given in a Job's CopiedFiles.
+func restCopied(s *Server) http.HandlerFunc {
+	fs := http.StripPrefix(restCopiedEndpoint, http.FileServer(http.Dir(s.ServerInfo.CopyDir)))
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Only GET is supported", http.StatusBadRequest)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, copyTmpSuffix) {
+			http.NotFound(w, r)
+			return
+		}
+		fs.ServeHTTP(w, r)
+	}
+}
+
+// restStd lets you GET part of the complete STDOUT or STDERR of a job that had
+// StdCapture set. The request url must be suffixed with the job's key. The
+// stream query parameter can be "stdout" (the default) or "stderr", and the
+// offset and length parameters select the range of bytes to return (by
+// default, the first 1MB). Fewer bytes than the length are returned once the
+// end of the output is reached.
+func restStd(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET is supported", http.StatusBadRequest)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("form parsing error: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		if len(r.URL.Path) <= len(restStdEndpoint) {
+			http.Error(w, "a job key is required", http.StatusBadRequest)
+			return
+		}
+		key := r.URL.Path[len(restStdEndpoint):]
+
+		var stderr bool
+		switch r.Form.Get("stream") {
+		case "", "stdout":
+		case "stderr":
+			stderr = true
+		default:
+			http.Error(w, "stream must be stdout or stderr", http.StatusBadRequest)
+			return
+		}
+		offset := urlStringToInt(r.Form.Get("offset"))
+		if offset < 0 {
+			http.Error(w, "offset can't be negative", http.StatusBadRequest)
+			return
+		}
+
+		data, srerr, qerr := s.getCapturedStd(key, stderr, int64(offset), urlStringToInt(r.Form.Get("length")))
+		switch srerr {
+		case "":
+		case ErrBadJob, ErrNoCapturedStd:
+			http.Error(w, srerr, http.StatusNotFound)
+			return
+		default:
+			http.Error(w, fmt.Sprintf("%s (%s)", srerr, qerr), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(data)
+		if err != nil {
+			s.Warn("restStd failed to write output", "err", err)
+		}
+	}
+}
+
+// restHistory lets you GET the runs of jobs in the history of jobs that have
+// finished running, most recently ended first, in the same format as GETs on
+// restJobsEndpoint. The possible query parameters correspond to the properties
+// of a HistoryQuery: from and to (RFC3339 times), cmd, regex (which can take a
+// "true" value to treat cmd as a regular expression), rep_grp, host, exitcode,
+// fail_reason, failed (which can take a "true" value), offset and limit (which
+// defaults to 100; 0 returns all matching runs).
+func restHistory(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET is supported", http.StatusBadRequest)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("form parsing error: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		q := &HistoryQuery{
+			Cmd:        r.Form.Get("cmd"),
+			CmdRegex:   r.Form.Get("regex") == restFormTrue,
+			RepGroup:   r.Form.Get("rep_grp"),
+			Host:       r.Form.Get("host"),
+			FailReason: r.Form.Get("fail_reason"),
+			Failed:     r.Form.Get("failed") == restFormTrue,
+			Offset:     urlStringToInt(r.Form.Get("offset")),
+			Limit:      100,
+		}
+		if r.Form.Get("limit") != "" {
+			q.Limit = urlStringToInt(r.Form.Get("limit"))
+		}
+		if r.Form.Get("exitcode") != "" {
+			exitcode, errc := strconv.Atoi(r.Form.Get("exitcode"))
+			if errc != nil {
+				http.Error(w, fmt.Sprintf("bad exitcode: %s", errc), http.StatusBadRequest)
+				return
+			}
+			q.Exitcode = &exitcode
+		}
+		for param, t := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+			if r.Form.Get(param) == "" {
+				continue
+			}
+			*t, err = time.Parse(time.RFC3339, r.Form.Get(param))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("bad %s time: %s", param, err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		jobs, srerr, qerr := s.getJobHistory(q)
+		switch srerr {
+		case "":
+		case ErrBadRequest:
+			http.Error(w, qerr, http.StatusBadRequest)
+			return
+		default:
+			http.Error(w, fmt.Sprintf("%s (%s)", srerr, qerr), http.StatusInternalServerError)
+			return
+		}
+
+		jstati := make([]jstatus, len(jobs))
+		for i, job := range jobs {
+			jstati[i] = jobToStatus(job)
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		erre := encoder.Encode(jstati)
+		if erre != nil {
+			s.Warn("restHistory failed to encode job statuses", "err", erre)
+		}
+	}
+}
+
 // urlStringToInt takes a possible string from a url parameter value and
 // converts it to an int. If the value is "", or if the value isn't a number,
 // returns 0.
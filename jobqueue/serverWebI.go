@@ -21,10 +21,13 @@ package jobqueue
 // This file contains the web interface code of the server.
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"net/http"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/VertebrateResequencing/wr/internal"
 	"github.com/VertebrateResequencing/wr/queue"
@@ -67,6 +70,7 @@ type jstatus struct {
 	Key          string
 	RepGroup     string
 	DepGroups    []string
+	LimitGroups  []string
 	Dependencies []string
 	Cmd          string
 	State        JobState
@@ -97,8 +101,20 @@ type jstatus struct {
 	StdErr        string
 	StdOut        string
 	// Env        []string //*** not sending Env until we have https implemented
-	Attempts uint32
-	Similar  int
+	Attempts    uint32
+	Similar     int
+	ArrayIndex  int
+	CopiedFiles []string
+	CapturedStd bool
+}
+
+// jtail is the output of a job that we send to the tail websocket on the status
+// webpage.
+type jtail struct {
+	Stdout string
+	Stderr string
+	State  JobState
+	Error  string
 }
 
 // webInterfaceStatic is a http handler for our static documents in static.go
@@ -113,6 +129,28 @@ func webInterfaceStatic(s *Server) http.HandlerFunc {
 			path = "/status.html"
 		}
 
+		// the first time our home page is visited the user supplies our token
+		// in the URL; we swap it for a cookie and send them back without it,
+		// so that it doesn't linger in the address bar, history and the URLs
+		// of our other requests
+		if token := r.URL.Query().Get("token"); token != "" && path == "/status.html" {
+			if subtle.ConstantTimeCompare([]byte(token), s.token) == 1 {
+				http.SetCookie(w, &http.Cookie{
+					Name:     s.tokenCookieName(),
+					Value:    token,
+					Path:     "/",
+					Secure:   true,
+					HttpOnly: true,
+				})
+			}
+			query := r.URL.Query()
+			query.Del("token")
+			u := *r.URL
+			u.RawQuery = query.Encode()
+			http.Redirect(w, r, u.String(), http.StatusSeeOther)
+			return
+		}
+
 		// during development, to avoid having to rebuild and restart manager on
 		// every change to a file in static dir, do:
 		// $ esc -pkg jobqueue -prefix $GOPATH/src/github.com/VertebrateResequencing/wr/static -private -o jobqueue/static.go $GOPATH/src/github.com/VertebrateResequencing/wr/static
@@ -430,6 +468,83 @@ func webInterfaceStatusWS(s *Server) http.HandlerFunc {
 	}
 }
 
+// webInterfaceTailWS writes the output of the job with the key given in the
+// key query parameter to a websocket as it is produced, until the job is no
+// longer running or the client goes away.
+func webInterfaceTailWS(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "a job key is required", http.StatusBadRequest)
+			return
+		}
+
+		conn, ok := webSocket(w, r)
+		if !ok {
+			s.Error("Failed to set up websocket", "Host", r.Host)
+			return
+		}
+		storedName := s.storeWebSocketConnection(conn)
+		defer s.closeWebSocketConnection(storedName)
+
+		// the client doesn't send us anything, but we have to read to find out
+		// when it goes away
+		gone := make(chan bool)
+		go func() {
+			defer internal.LogPanic(s.Logger, "jobqueue websocket tail reading", true)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					close(gone)
+					return
+				}
+			}
+		}()
+
+		want := &outputChunk{StdoutOffset: -1, StderrOffset: -1}
+		seenRunning := false
+		var lastState JobState
+		for {
+			got, srerr := s.tailRead(key, want)
+			if srerr != "" {
+				if errw := conn.WriteJSON(jtail{Error: srerr}); errw != nil {
+					s.Warn("tail websocket failed to send JSON to client", "err", errw)
+				}
+				return
+			}
+			if len(got.Stdout) > 0 || len(got.Stderr) > 0 || got.State != lastState {
+				var stdout, stderr bytes.Buffer
+				errw := tailWrite(&stdout, want.StdoutOffset, got.StdoutOffset, got.Stdout)
+				if errw == nil {
+					errw = tailWrite(&stderr, want.StderrOffset, got.StderrOffset, got.Stderr)
+				}
+				if errw == nil {
+					errw = conn.WriteJSON(jtail{Stdout: stdout.String(), Stderr: stderr.String(), State: got.State})
+				}
+				if errw != nil {
+					s.Warn("tail websocket failed to send JSON to client", "err", errw)
+					return
+				}
+				lastState = got.State
+			}
+			want.StdoutOffset = got.StdoutOffset + int64(len(got.Stdout))
+			want.StderrOffset = got.StderrOffset + int64(len(got.Stderr))
+
+			if tailFinished(got.State, seenRunning) {
+				return
+			}
+			if tailRunning(got.State) {
+				seenRunning = true
+			}
+
+			select {
+			case <-gone:
+				return
+			case <-time.After(ClientTailInterval):
+			}
+		}
+	}
+}
+
 func jobToStatus(job *Job) jstatus {
 	stderr, _ := job.StdErr()
 	stdout, _ := job.StdOut()
@@ -449,6 +564,7 @@ func jobToStatus(job *Job) jstatus {
 		Key:           job.key(),
 		RepGroup:      job.RepGroup,
 		DepGroups:     job.DepGroups,
+		LimitGroups:   job.LimitGroups,
 		Dependencies:  job.Dependencies.Stringify(),
 		Cmd:           job.Cmd,
 		State:         state,
@@ -475,6 +591,9 @@ func jobToStatus(job *Job) jstatus {
 		Ended:         job.EndTime.Unix(),
 		Attempts:      job.Attempts,
 		Similar:       job.Similar,
+		ArrayIndex:    job.ArrayIndex,
+		CopiedFiles:   job.CopiedFiles,
+		CapturedStd:   job.CapturedStdOut != "",
 		StdErr:        stderr,
 		StdOut:        stdout,
 		// Env:           env,
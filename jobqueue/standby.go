@@ -0,0 +1,398 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for running a server as a hot standby of another
+// server, taking over from it if it dies.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/VertebrateResequencing/wr/internal"
+	"github.com/VertebrateResequencing/wr/queue"
+	bolt "github.com/coreos/bbolt"
+	"github.com/inconshreveable/log15"
+	"github.com/satori/go.uuid"
+)
+
+// these global variables are primarily exported for testing purposes; you
+// probably shouldn't change them
+var (
+	// StandbyReplicateInterval is how often a standby asks its primary server
+	// for any changes to its database.
+	StandbyReplicateInterval = 5 * time.Second
+
+	// StandbyFailoverTimeout is how long a standby's primary server must have
+	// been unreachable before the standby takes over from it. It is also how
+	// long a server remembers a standby for after it last asked for changes.
+	StandbyFailoverTimeout = 30 * time.Second
+
+	// StandbyMaxChanges is how many database changes a server remembers for
+	// its standbys. A standby that falls further behind than this is sent the
+	// whole database instead of the changes.
+	StandbyMaxChanges = 100000
+)
+
+// replicaRequest is sent by a standby to its primary server to get a replica.
+type replicaRequest struct {
+	Addr  string // the ip:port the standby will serve on if it takes over
+	Epoch int64  // the epoch of the database the standby has
+	TxID  int    // the id of the last database transaction the standby has
+}
+
+// replica is what a primary server sends to a standby, so that the standby can
+// take over from it.
+type replica struct {
+	Epoch   int64         // changes when database transaction ids start over
+	TxID    int           // the id of the last database transaction
+	DB      []byte        // the whole database, if the standby was too far behind
+	Changes []*dbChange   // otherwise, what changed since the standby's TxID
+	Running []*runningJob // the jobs that are currently running
+}
+
+// runningJob describes a job that is running on a primary server, so that if a
+// standby takes over, the runner running it can carry on and report to the
+// standby.
+type runningJob struct {
+	Key        string
+	ReservedBy uuid.UUID
+	Host       string
+	HostID     string
+	HostIP     string
+	Pid        int
+	StartTime  time.Time
+	Attempts   uint32
+}
+
+// takeover is given to Serve() by Standby() when it takes over from its
+// primary server.
+type takeover struct {
+	running []*runningJob
+}
+
+// Standby is for use by a server executable that should be a hot standby for
+// another server executable that is using Serve() (the "primary"), at the given
+// address (host:port).
+//
+// It connects to the primary as a client, so config.TokenFile must contain the
+// primary's token, and config.CAFile, CertFile and KeyFile must be copies of
+// the primary's, so that clients will trust us if we take over. We start by
+// replacing the database at config.DBFile with a copy of the primary's. Then
+// every StandbyReplicateInterval we apply any changes made to the primary's
+// database since we last asked (if we fall more than StandbyMaxChanges behind,
+// we get a whole copy again). The primary tells its clients about us, so that
+// they will be able to reach us if we take over.
+//
+// When the primary has been unreachable for StandbyFailoverTimeout, we take
+// over from it by calling Serve() with the given config (but without deleting
+// the database, even in development), returning what that returns. Jobs that
+// were running on the primary are not run again straight away: their runners
+// can carry on running them and report to us instead, as long as they get in
+// touch within ServerItemTTR.
+//
+// NB: if you stop the primary deliberately, stop its standbys first, or one of
+// them will take over. If the primary was only unreachable from the standby
+// (and not dead), you will end up with 2 servers running the same jobs.
+//
+// Until it takes over, this blocks, unless it receives a SIGINT or SIGTERM, in
+// which case it returns a nil *Server and an Error with Err ErrClosedInt or
+// ErrClosedTerm. It returns an error straight away if it can't get a replica
+// from the primary.
+func Standby(primary string, config ServerConfig) (*Server, string, error) {
+	l := config.Logger
+	if l == nil {
+		l = log15.New()
+		l.SetHandler(log15.DiscardHandler())
+	} else {
+		l = l.New()
+	}
+	defer internal.LogPanic(l, "jobqueue standby", true)
+
+	token, err := ioutil.ReadFile(config.TokenFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("a standby needs a copy of the primary's token file: %s", err)
+	}
+	for _, path := range []string{config.CAFile, config.CertFile, config.KeyFile} {
+		if _, err = os.Stat(path); err != nil {
+			return nil, "", fmt.Errorf("a standby needs a copy of the primary's certificate files: %s", err)
+		}
+	}
+	ip, err := CurrentIP(config.CIDR)
+	if err != nil || ip == "" {
+		return nil, "", Error{"Standby", "", ErrNoHost}
+	}
+	rr := &replicaRequest{Addr: ip + ":" + config.Port}
+
+	jq, err := Connect(primary, config.CAFile, config.CertDomain, token, StandbyReplicateInterval)
+	if err != nil {
+		return nil, "", err
+	}
+	rep, err := jq.replicate(rr)
+	if err == nil {
+		err = saveReplica(rep, config.DBFile)
+	}
+	if err != nil {
+		errd := jq.Disconnect()
+		if errd != nil {
+			l.Warn("standby disconnect failed", "err", errd)
+		}
+		return nil, "", err
+	}
+	rr.Epoch = rep.Epoch
+	rr.TxID = rep.TxID
+	running := rep.Running
+	l.Info("standing by", "primary", primary)
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(StandbyReplicateInterval)
+	lastContact := time.Now()
+	for {
+		select {
+		case sig := <-sigs:
+			ticker.Stop()
+			signal.Stop(sigs)
+			reason := ErrClosedTerm
+			if sig == os.Interrupt {
+				reason = ErrClosedInt
+			}
+			err = jq.Disconnect()
+			if err != nil {
+				l.Warn("standby disconnect failed", "err", err)
+			}
+			return nil, "", Error{"Standby", "", reason}
+		case <-ticker.C:
+			rep, err = jq.replicate(rr)
+			if err == nil {
+				err = saveReplica(rep, config.DBFile)
+				if err != nil {
+					l.Error("standby failed to save replica", "err", err)
+
+					// get a whole copy of the database next time
+					rr.Epoch = 0
+					continue
+				}
+				rr.Epoch = rep.Epoch
+				rr.TxID = rep.TxID
+				running = rep.Running
+				lastContact = time.Now()
+				continue
+			}
+			if time.Since(lastContact) < StandbyFailoverTimeout {
+				continue
+			}
+		}
+
+		// the primary is gone, take over
+		ticker.Stop()
+		signal.Stop(sigs)
+		err = jq.Disconnect()
+		if err != nil {
+			l.Warn("standby disconnect failed", "err", err)
+		}
+		l.Warn("primary unreachable, taking over", "primary", primary, "running", len(running))
+		config.takeover = &takeover{running: running}
+		return Serve(config)
+	}
+}
+
+// saveReplica writes the whole database in the given replica (if any) to the
+// given path, replacing what was there, or otherwise applies the replica's
+// changes to the database at that path.
+func saveReplica(rep *replica, path string) error {
+	if rep.DB == nil {
+		if len(rep.Changes) == 0 {
+			return nil
+		}
+		return applyChanges(rep.Changes, path)
+	}
+	tmpPath := path + ".replica"
+	err := ioutil.WriteFile(tmpPath, rep.DB, dbFilePermission)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// applyChanges makes the given changes to the database at the given path in a
+// single transaction.
+func applyChanges(changes []*dbChange, path string) error {
+	boltdb, err := bolt.Open(path, dbFilePermission, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	err = boltdb.Update(func(tx *bolt.Tx) error {
+		for _, change := range changes {
+			b, errf := tx.CreateBucketIfNotExists(change.Bucket)
+			if errf != nil {
+				return errf
+			}
+			if change.Deleted {
+				errf = b.Delete(change.Key)
+			} else {
+				errf = b.Put(change.Key, change.Value)
+			}
+			if errf != nil {
+				return errf
+			}
+		}
+		return nil
+	})
+	errc := boltdb.Close()
+	if err == nil {
+		err = errc
+	}
+	return err
+}
+
+// replicate is used by the server to respond to a standby's replicaRequest. We
+// remember the standby (so our clients can learn of it), and give it what
+// changed in our database since the standby last asked (or the whole database
+// if it is too far behind), along with the jobs that are currently running.
+func (s *Server) replicate(rr *replicaRequest) (*replica, error) {
+	now := time.Now()
+	s.ssmutex.Lock()
+	s.standbys[rr.Addr] = now
+	addrs := make([]string, 0, len(s.standbys))
+	for addr, last := range s.standbys {
+		if now.Sub(last) > StandbyFailoverTimeout {
+			delete(s.standbys, addr)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	s.ServerInfo.Standbys = addrs
+	s.ssmutex.Unlock()
+
+	rep, err := s.db.replicate(rr.Epoch, rr.TxID)
+	if err != nil {
+		return nil, err
+	}
+
+	var running []*runningJob
+	for _, data := range s.q.GetRunningData() {
+		job := data.(*Job)
+		job.RLock()
+		running = append(running, &runningJob{
+			Key:        job.key(),
+			ReservedBy: job.ReservedBy,
+			Host:       job.Host,
+			HostID:     job.HostID,
+			HostIP:     job.HostIP,
+			Pid:        job.Pid,
+			StartTime:  job.StartTime,
+			Attempts:   job.Attempts,
+		})
+		job.RUnlock()
+	}
+	rep.Running = running
+	return rep, nil
+}
+
+// awaitAdoption is used when we take over from a primary server, to let the
+// runners of the given jobs (which were running on the primary) carry on
+// running them, reporting to us, as long as they get in touch within
+// ServerItemTTR.
+func (s *Server) awaitAdoption(running []*runningJob) {
+	s.adoptMutex.Lock()
+	defer s.adoptMutex.Unlock()
+	s.adoptable = make(map[string]*runningJob)
+	for _, rj := range running {
+		s.adoptable[rj.Key] = rj
+	}
+	s.adoptUntil = time.Now().Add(ServerItemTTR)
+}
+
+// awaitingAdoption tells you if the job with the given key was running on the
+// primary server we took over from, and its runner still has time to get in
+// touch with us.
+func (s *Server) awaitingAdoption(key string) bool {
+	s.adoptMutex.Lock()
+	defer s.adoptMutex.Unlock()
+	if len(s.adoptable) == 0 {
+		return false
+	}
+	if time.Now().After(s.adoptUntil) {
+		s.adoptable = nil
+		return false
+	}
+	_, awaiting := s.adoptable[key]
+	return awaiting
+}
+
+// adopt reserves the given ready item for the given client, if its job was
+// running on the primary server we took over from, reserved by that client.
+// Returns true if the item is now running again.
+func (s *Server) adopt(item *queue.Item, clientID uuid.UUID) bool {
+	if !s.awaitingAdoption(item.Key) {
+		return false
+	}
+	s.adoptMutex.Lock()
+	rj := s.adoptable[item.Key]
+	if rj == nil || !uuid.Equal(rj.ReservedBy, clientID) {
+		s.adoptMutex.Unlock()
+		return false
+	}
+	s.adoptMutex.Unlock()
+
+	// (while still adoptable, no-one else can reserve it)
+	job := item.Data.(*Job)
+	_, err := s.q.ReserveFiltered(func(i *queue.Item) (bool, string) {
+		if i.Key != item.Key {
+			return false, ""
+		}
+		return s.acquireLimits(i)
+	}, job.getSchedulerGroup())
+
+	s.adoptMutex.Lock()
+	delete(s.adoptable, item.Key)
+	s.adoptMutex.Unlock()
+	if err != nil {
+		s.Warn("failed to adopt running job", "key", item.Key, "err", err)
+		return false
+	}
+
+	job.Lock()
+	job.ReservedBy = rj.ReservedBy
+	job.Host = rj.Host
+	job.HostID = rj.HostID
+	job.HostIP = rj.HostIP
+	job.Pid = rj.Pid
+	job.StartTime = rj.StartTime
+	job.EndTime = time.Time{}
+	job.Attempts = rj.Attempts
+	job.Exited = false
+	job.Exitcode = -1
+	job.Lost = false
+	job.Unlock()
+
+	errd := s.q.SetDelay(item.Key, ClientReleaseDelay)
+	if errd != nil {
+		s.Warn("adopt queue SetDelay failed", "err", errd)
+	}
+	s.Debug("adopted running job", "cmd", job.Cmd, "host", rj.Host)
+	return true
+}
@@ -3647,153 +3647,211 @@ EQAA
 
 	"/status.html": {
 		local:   "static/status.html",
-		size:    63693,
-		modtime: 1509012280,
+		size:    84494,
+		modtime: 1792165321,
 		compressed: `
-H4sIAAAJbogA/+x9f3fcthHg//kU8F6bXcW7Kzlprj1ZUp8tOY0vdq2z0/T69PRa7hLapcUlNyS4a13q
-734z+MGfIAlwuZLShi+xJBIYzAwGg8EAmDl5cvHu/Md/XL4iS7byz744wR/Ed4LF6YAGg7MvCDwnS+q4
-4lf+54oyh8yXThRTdjpI2M3kT4PcZ+Yxn579/T35wByWxCeH4kVaICv5ZDIhH/9PQqM7chNGZONEXpjE
-JGGe77G7MXEClwSUutQlszsyC0MWs8hZTz/GZDLJtRjPI2/NSBzNTweHH+PDjz8jzMnX06+nf5iuvAAq
-DM5ODkWxOkReKvAcl3VEYxoAAV4YcDxidud7waLYMOfEkrH1hP6ceJvTwf+d/O3F5DxcraHizKcDMg8D
-BnBOB69fnVJ3QQfl2oGzoqeDjUe36zBiuQpbz2XLU5duvDmd8D/GxAs85jn+JJ47Pj19lgcGyN2SiPqn
-A8SUxktKAdoyojfAk3kcH6bsm3wz/Wb6R84XeD9o4KOuigkrfwjC+W2YMM5JugFyyBJ4WOVfucFbWRHa
-+8P0yK490XcsJCvnlpJZwlgYxLzr2BIajsk2jG7J15OtA6JE2ZbSgKj2eLGUWgMcBVeeAVe+NsbyQ7ii
-JLwhYRKRcBuQBQ1o5PhkSf01jchNEsxR2lpkextNjoA1z2qabJeDFEDW+SeH2Qg/mYXunfg1A+p6G+K5
-p4PA2YCE+k4c899nTkTEj4lLb5zEh5aiECQTP3oLPnhy8pWCkhBQ1B0PmFAqUy4nm0ActWUFn9ZOUKow
-i6BbB3lNhIU0bR1CYyU0i69Kf1YZE/MGBm2UlcrTKAojqOU6zJnMvAA+wIihznx5THIlWtgDqiACCcZ/
-Jy5obpQl4BQoizperfMtMvqJHZPf4RsUqHUX/hSYUiB05rhAxIbWkZn73jeVucrQ7dQn/F8Y/1EA+qCm
-lrYmF73mOvh84IQ0FuHgURnchsS7OSaXUQjTxIqcnpLBoDDwGyEkCj03ZIy6BdayMPSZtz4mvxA+8R6T
-4esb1IExgf8+JjFwkTC6gunGgYkXRDWgoHg2MONCgTihY1F4RePYWVCy9XyfLELicMUJZVhM/ZvpkHwe
-nK28xZKBNiUuMOjkMGlHnxN/CNSb0Jrn1JP7YdWPSxoBzQ7MHGADiBaTGCcuzhQhq1Pymgm+BCEnHwaq
-i1NPlAQkZACCfAxnMRQLNjRmqAlBUBnMTEHi+D7w8IbchQnxvVvg9oziaCBLjzHRDiX/+gGBe+xfch4T
-3Ib2g5D4IRf+JHYAuf54rhnRhc+VMYHzRMuA+CvYNsdSNVc0Dn7kMxjq5JNZ1Azq9UUtoNcXFmAu68Fc
-moPZbQi/CWEM8ilizmrRuQCZmbIQf4wOUsza+1oIDGF3a5iGxR/ptDRjAYH/lf5cJ74/iXAIF0bF3Pfm
-tzAjRGAPTQHNGy9aXcD4FuptcPaaDWOwMLggi3EvmjFgmcnA33HQqxo0mIcJmNIRdWt5LMua93tNA8T5
-Nfaj1DE9dl+DDqn5tItpISeoGsMi/frrNyvmS+omgCF5jdOz1ax5jiI6OiBn5JnxlHkFggIKKqK4IG0W
-7u+wpF7Crx/vtFRDzNt4Ya4J3htw540jmDM6sFQAu/QgYq6Qq8WMA01xAeMHRktP2ttEb8mxYqS4XC9e
-gVn6VgznwdmF+Ltda92fMuILbemwOSbPjo5+/zwleUtByeI/k3gFFuJ6snKihVa55EGJQsfkiDgJC5/X
-qaLlt5UKz0EduahU4HeYqmGOWq19CuZnYYEMqy7gZVUuvODGx+4AeWWOn42Gw+W37dowR10eMgpxES6X
-5iNTTRmFiwg6f1AkFcY5dP/quBFOHawJOi7yf0xiFnlrHM24EqLFb0qzS9eG+gafCnRy9HApIeUgpdml
-vnN3OcdB/JQMf89NeSslXoREXcE/8yWQXgeUoWbqQL7oby3XosR5kUfQTWsauDRgPXWVhNZ7Z0m4+e6S
-r35lHQY0hZ17C6w8t59BxSH13EscZtZD2D8gmo++f7r3RhL00xdJgGO4794QULP+kC8efX8Uv4nlSec+
-8sO4H9WGgHruIQSZdY+f8488wj7asR9mSdSP4gJAXu/GgACa9YX4+9564X6MdkT2q6++4t7bO8qIhzby
-CmbQEqV5eYjCLRE2Z4sJn24B+ZNP8eTbOtv9JoxWBXlJZisPeiKiPyc0ZrCE+0sUJmtDK9kL1gmbLFpq
-8Fr5dUCu2gSWDaGy3Fm4WKBwSwe5fJvuasECAlfZwml+OniFXjACUD20QrwbD/5iIXH8OCQxpdyjLbaz
-cPvTgQURrEpWTuDGBBoFbbf12BJKOSwHYTo4y/4wWjRzYuTCE6U6XYMhqznyMGILY3Tj+AlFlrfyupFz
-sKQ1GcNyZVz24amNU4G4EAMYf/nGFv7deukBBST9bbIGG30y96K5n/Oim7nyWpjZOAaRl10HYVk3VAel
-VHFxGDHc3VCDIB4dTH0aLEBK6nTdybLksKnXSNUhrt1/1eCA70Zqn37kj6MD0O8RZUkUEH/quYBdhD/+
-TJ6RYzJ5Rj4ftCz0W30GTV5IK2eBYFKbw6BuesjNCEaOBFP/QUpLuw/BzHVQBrir+6AOXqe1KeGeLYcf
-DNJYD07kOROuk1ZecDo4KrxxPp0OQEwabYyqp2FMlDNt7USgTafxMtyCSHPFdSHW+WPiMBYhmGHWXhBu
-hwWAJmYKZ1ZuHHfzV3Aoejns7KpIMTPeem63FnmxX49o6LwbLeIhqzQKSAFsNyHp5inhcOrEZAcnSYrf
-oxMVdJjsW06qfpVGGXmPxRvkIweui2x08c1wGHq56OiWSbF6DBKx7/4veXKae1/4UZr6X4Hr1PudvEEc
-Sk3/d3UEpZg9BgnQOCP2LRUV31GjWOD5lgaZyIB1EYoO3icOQi8ROzieUsQeRCbup98rvqrGfn/JfUUN
-PZ+B69LznfxdHIi+7zu6ulK0HrDf97Z8oIyW+rtpbZCW7rg4gPr9Lg4QYGFxQMsuvUbMHka7J/M5/L7v
-oawOApgP53NZo0EGikC7SIGC0J8YKIiZHKg3DyII3R3e+NTXkzxM/VUuZY7nx+0OeK23RRxiq3eSFI7n
-xDEXhsK5NxAGvGRB8aTmUK7Kh+Tf/y68lUuw4VhVxhVNoSa30LPv68gDVO6KRYTNlhUSKrFQRqjyUvs4
-u2e15LArVFOCYrgp0/E8X1q/zRunObe14uqtyZtWwVA2EW5odOOH28mnY+4nNESSg4lXju+fnXh17sHz
-rfvSiXN+6NpiqYTNQz8EnQIK7i7nJvTwV96YGX1mepgXzemct3j6rX2kFKr3w0kOKuXmiuNRe0hPoNmd
-O7wxSw7tcwZMj2eSW3oHk4juaK4eiA3BLjt7wfB6C4sBSWZT0632gQKFveC6xlJpKr+2lL36tKZzPHX6
-/sXbHqhT4ADadDV7/epcHFB9TIT+6K1oj5QiODyMm0T8cuLe6M1pm/diQ5e6F158a2/k2HBOVGBnaZME
-27Rjn4Dh1unwAjWZifWXl+Zs5C3YsdJULQnkLWXtHEyoPnQFh7N/efoOzLz31InDYM+ClGuzan1ZtZ3h
-wM4uI7rht/yRjiSyHNwpYjYSUahYoOhJHxTJzsC77g9Ak04SMxGxEUcObn/j0lrQX33yUIXtXVtiO7BG
-dLt0m36u8RiC2x/veekSp7BFlGe76UUQYck0UYmdfWDuu4TZc03Ut+BMoWJhECMCnQZuCi5/miHngqm7
-joIOEmh2ip9G/NI8rCgFHkMwJr702XMs8uWCPTe96ldLZRd9UACgblH2wSikLAgDipTdP0l2I0nWsNYF
-3cfBqyh62HEACDyKcQB4PO5xsCuj/rPHQSfkOs26l9S5tV/GitqaSRfBdVzGcph2XOpCMFiceCezJ3ol
-tMLF0kdG8KvA7Y1cDusxE/t3x/eZta9C1NbQq8B19lVwyPsn+/zybz1SLaE9dqK/D+MOVq+W4u/l+YNH
-SCF5fdkjkSK6yv0sh3h7F7gYsggUlFHQ0QoUPLvobAbW8O3Clm8c3GOd9L2+JoRLcST91+rbeKK8G19+
-SUapd22AQSejDUapyu9cDtS5teJbfnbpYP+d9l9nuOwwl+t8pqKjOroX92UbpNj25kjtm8w33oYqUkUE
-lfsn9jdjotzsb8aEHQW/GRNmGP9mTKTPTsZENuvI463ipbXbq6Ol0M0R2skJ+sg8lr9e8blQN1z3LyBp
-U49YRlIc/8tlgp/VnHv0fsQibe1xS0aK5n+jcJgVtD7qFWysD99YcrpDXwNWu3XxPo4B7W24n2/v4ZzF
-95j/4HyJp7X7MtwQpIT4azbgXtKlg4eionvQtVlbj1jTZkj+h+jZzuelb4Al/J4fdaIb71OHGzYfvJXn
-O3bW/9O6M+kSWHbwU0S7V/F8Oq2ws8XLbueYeBSh2AElQ9WJLjKqoSN/RosTcsBTwETZUb4bcZRvf+ve
-bhUqzkUVEsNOc+wnuvh7ugo3lIcVGZyJP8xCEvXME3HP//Fw5JJiHpoHZEgWEOPxMAWs6YcVErWp8gg4
-gqH4RUD+B2GFvedeXmL6EXOifAxnxFmvYYKKeT6IMSYtEelS5mHiuzw/TEJ5SLhc4hmea4bEyXxJeLaV
-gDLM0IWxcKTufY55UjB4HLYA0Jw5E+lTbryAjjGhCs/BEtENxvAX6Vd4LJ2YU4Z3s1YO8+a8znZJAw5M
-ZXUBgDChUneqLlUZZX9IGbC//AyDs3PxB7kwzq5R6N/dBUL5Dq2vyGUMELHx8rRbmm3mDDZUOHgqvpvG
-scJJ3lk1QIpFfJqEH/boPODFvrYbzW3N9RzI0+HR+MgqdB3N9edyfD9e7Jj8Uml+48WYsfFYwnuL5X4S
-78aVwq7n+OHiHC9CDznESbwaVouJjHV4WRoxwJ++M6N+oY3veRnymXyu1sfLklgr4HmUhrlaL+HLj6BK
-fRixw7EEL75fyIvgGnhiMaGH+B3/1gazAPIzX3NXOk1mLMwCcR5iUtEBz9lSQ4IuSmIh8gcOjtEB34WT
-w0evnF5ElGfWihP5y9YJ+NRQsw4Q+OQyYSxpfVyBQs6MNISpDF5K89FPB7UBqFSkUQlmoB8hOdpp+y0Y
-Hjl16bi5dU9N+1jgPL/s4asenG4xxSqdO0lMa5G/KdwqEuj/uR7/RhVQ2L0yILFDO+0fy9J1aiVd9y4q
-xIFWc3m3qjxpJlln3tTy4RYt0vr+ExbTiIlseWiFgZHniHCMKqEdEjpfAdkxC9fQyXSeYIK758S5QZcG
-toDG2tYBoQV+eb6y9WIURXQWCjPkoPbWe7cujrgF0E4cL+f4GLM47UE51Da05PiQ8QWRnpCbmSvBlRhG
-VsDQZIXB04GQaoJXgaGZii3q9JaA1anNVhdythC1xSwpUV8G02rlsRecrsL2LYsSegA/ZDgn0cfTubP2
-mON7/4/y9FRvKAMmiJg3GHx6ODCIk7xnxG/AVLHE/Fkr3lZaV/UgDIgH7UI7TuzOAqNVhQrJzamRCaik
-6QiLMyeY04Z1utaO1Y3iqikbMzdM2CGNov7MWYBpa8v6izGRVi1zbcxa1ZaJTauqynznvPK7hGEI989G
-dmaVfa4MXBUL7Htgnruw550Nw4bplvIdEYcOhkYrARps6pcB7uIndMV05mG28d8bG+n6vvgIaPfBQrre
-gYezbDuvLw4CyD1zMNty64F/gO4O/APQvTFOobk/xr0KNl4UBpg+hPyEAfKgmT54CB+NedhoEOpaqbMF
-dZkQZGr4GltZu3hJs8nXJ9PsOE2W0zwUv8rdUo+jj7/q6BQ2+JfzcH33nHx99Ox/jvHfP5K/0AAXHe9p
-TJ1oviRvvBWuS6daqx0zYWAD2dsSQdn7at98dDaOeFvC7zachmu0keIpGCE0+tsaGAmK+JSbus/rKT88
-BJGnWxBg6vPtS7BaMEOI8oYnxa1ZlcOCu3yT+Ceo+hargkGoGUtORDDlO2Kx9OLqrWeeD975OfEiaE4m
-ejnltMzwOgsOiBdR5NyNDmrqijpg9gDiVhVnjisysls2qJID29VSS/JyrdoKMiajCqgJ9YbD5qLSBd9a
-7t2Lmu9bkFQMciUkJzIrhXwI6Ja0kA9F+ZiA0t98e1QtVcc1XG6/VHmnoXIqfSPP1QlcWjXrXgklS74i
-3tfVxkfmZZEZ+19f4FLHc/V39j9raP5sRZ/MT1ugbhUvGslTUlglDjM+82TPJgSmhadv4wVSCe32T6bK
-EAYU6lFKo34el0bH0cEUlB6YZ6NfSCpDx2WZ+nxQnS4lWBU2tGfAItZo30BlNKmewfLYpT3DlEFSe+8u
-kTJmb2KwB9gqS8UehGEPUGX8/D2Iwz54EPruP3nqJgB81CQz/8TouwkYwFCuqqVq5iSlla6Goo1rMTdL
-UG6mUusUqXdDRiVIRWyujeaYAoCM5OsaPax9q32JxheHBYTp8IQBfM09hJWPSmtqPwvdp/8kNZj2I9dD
-2i9Sm1zrzAfFaEHIGTlq4ilSvEow7aDvcXPh2dERORRMqI+4AwbwlsJc6Pj8oMn/+hM/brIJPZc4ZJYs
-iBfA6itkMYucdRqAvQncDBdf26UHKwF5zCQGrBAOblPwIw2TFV7Tg4JNcG7QD0ojvjWQMNxNoJ+8GAbU
-nI4J3fBTKWGyWCL+AR5laQImOIgRiJEtjTzkvHCBf2sazUEQPuDf0ehqlGPuVw0ydTAmLUVzEtZWOJW3
-1oKZ9LUVVbLYVi6TzIPrMUhGjYAqvoGljsFmMsa95y+ikWAoLBYbAOjYiUr1eiTBXh1d21TPzXkZiGcW
-INKpLav+tU11MYNllb+xqKwmqqz2Hyxqq/koq/1tXW29PsWn3vjWqmtc4tbrGanta0p8NpwnzddN6lre
-KbnSTCSFQm/C8JYvMH+pmykr+Szt1r7eIsA9XX0DlRegqWLKCGCEunJLZ3EIOrCaUwcnha0XuOF2+nc6
-+8ALwQrmlGCH4ym/5vVhzm8wXSfxcjT4R5hEZBaFW3hL3BBW+JjjN07WayCfpG3EA91KiFA/pk3tbdVC
-OQU0Gmzj48PDAcyHfjjn4QmmSxB7dPDBu8Fx4QtHAt4eCsT/udXikWtuGgYhqIDcqrLWkMnXilEK//eH
-d3+dYuqoYOHd3IFQytDNx2QwT6KIHwb+fFA3otrQmsPgLq52WxGr9tZ5GARUVIfZGkVl5QQOHplcOngI
-AChHHfKkjkv4HB5iJmeYO8VZ03UIUzUeamHRHT8SSidAM8i3F4ujF/O0zel0aqFNMtJXmqV+40L9I94p
-OCW8Q9ZgVdARnaKD9KC2Bo4LrDUFPrzbBpcRSEHE7kbD76JwxX1Ew4OmFgVj5DnYIFnN0MfDjy3MxW2p
-xprRArDF5q+GSlsMrxtr8HlTerkaCyJhEXdSDJ46vv900EYFxyfznxVUd3P8RTmcUwO/qCrLnI0WrQzV
-oZIq6StNG1fR4rpmBVBC0qrh9sL4DD1c2kcL/ZKrUno/zhtNM/tx5lQa2odzp9rIXpw9lWb24PyptLEX
-Z1ClFZmRa9/NpHl89k9Ona/LdjzsBKXBf2UuyTvVr/dJmcvfrpyUici6g8hlM9sFD75BUwYgrWtDIAZO
-M03DbU608lNj5OUfNe109q+VH24ApEAtXG1VzPhiLIPV6nUrP/WrxvxjVCjvpStRlzro8u+LvrnsS94t
-l3tb8Mhl73POuOxl5u0otSk0b/l9qiprHXflx9iRp2OSvWOv/Bg4+mxgVX2CZcefDbROPkINnFafoQ2w
-knvR1IdYfsx9itoRUPHS1YyHhnL1TkTtWGkoVes61I2jRszTUdVQKj/GWl2QOrbv5JIsP1aio4YWvzgp
-2salLw4ROzggcvy0vxI74jBYpt/Bet0LmOWYxYiQY+KGeJqbuHQeUTx8hdATcV7Gaqjh+eLn0k0UUXHl
-1IvVRYsl9ddW8AS/YjxJ5AWwAIchG+MAzoa02XwuweFVWjBJV6hK6hwWugfF5pbecedhZqeOSxbnOGc7
-jlMrcJzZc+PMMhvnbaxx0VoynHHxwcNJI8TOA9SOnsOPE/In+PH0qc1cgk/BlEBar7zra34pQTmMPSuL
-Qj1VmDl4djkfzEwMu5L7Z+DJfy4De7T5tJZn8wZCFUTThkL56b7BYE1f0bclvLWKXgP4Nb6witNs6tNg
-wZZkQp71gDRqS3mFEfQtbgT4vOlxepeO4CYICSO3YZM1B22VgOWGE4Nwmoq4BmBGiTuleMdLnrI0yDGO
-3tgQA1+P4ScCcXz4iYzlk2wAk0WqmU2AlVaWZl1S2QOy6tnmsdPqH76JwtUYiG0sGG89Nl+OhPM5c3Yb
-qaG5Az2fOTKNRiAipV+zmY3gGUyft+1FFWqp87MrcqmhvAf0pMu0G2rSNt8HWsrJ2hExtSDYA2rCMdsN
-L7EE2QNSypPbDS217OkNsR20RnY2i28+l7dsyjtUBxhCLFf+qlzgWg/hxzBVMm0Arko1rsmZ2ik7xwuV
-ZooK1LfcTucrjSELh4RFThB76Eobp7MYfA0W7YsPXHQALOko4LMb3wHlkwwfl8SZ8/uesIQE69EIv/ZJ
-yJJRkxKj2gWs1P0mjZyemrukxGLGkgxzF9m72Uc6Z1M0gZupOFBWkA3ypgT05QltLmG8i1mY3nPjzozo
-LhM8PmBg7TDF42OogPNI2k31WjQtJ/tOiNpM+hokrab9bghaTf86FO0MgE5IWhgCGgxtTIFO6FmZBBoE
-7YyCTihmW7bGbcizJE+szpLknxKVmZvWnE5z/0QHFSL3yh+MIal3+wH50TLxdDcuazchuQuH/Jk8I8dE
-c8kt/yDD0YI24TMugQO6lQY3/hgdkEkXm0hBObOwF3h7sqKBA8d4QseHuzZWFL3ycc6OjUGOA7BMI2+j
-jFNTcNyGfQ4G7ND3CcigsJPDgJIFHhOMcD9rjDauKcCVE91ir6ZmN4aBpHjdPY+xKTQeSpJH2kKKvYDg
-jWqz/T3svyfEZlFjM4YbTcGas7u6x2wUt9rnetryXp3eiLuqwL4mT61XHJx2G9HvhFc3tPpzXHNdcGSi
-BXbQvU3q1UCrstBENFgIBfmBhuIavKtHIndkVHv61vDkrf352XQopde70RUhDsrqbpIbehlQz+GJa36c
-msdjoi7GJ3MKhx1MfQJQy4mYN0/83Gnf58RxXa5aGQZB41gazWKCP3JQFAIymyoFQIkfaVZJApEyFQGY
-R5HDAMBmSUvQ4xHIfWSjCjibb1W7qrMVIqZjGoHM6MIJ5K0CkRDTvG4QbiuhCDI4hoAE6vlki7sf8sJH
-7m2lTHpKRiNAmBs9nOgDcogHAUwUET4m+1r4aOMbiH0OaL7+yH3+yc3SJUjWE1apPnBW3neJKXsdMOw2
-vxuD8UEpcHD/5410IdWQLzxMdluvun3mXFuddpzx0WJ45V3bi656DK0aXnRsJXM7TpH4FNW6EEQcc3ub
-pF5fGt0N8dgwJtTjUSwdrn5mjiujZYzBAsc9SX7oDNRpG6yspgiQ6cVcN+EFrtZpT+if1/FLxzXzBJYj
-gxhz1NhJqYlaotC8ABz31G9v40XHjuMRQBIf/pZXk3j/yY3qNnAwdYtTU3x9BUuuKNs2yIIINUJBJSFg
-4BE1HtGxtXwuAk8hFkrb/KfTSmkcFanmyNOnnumSPEY4CgBoIcNtCU/FWhFygX1n7MaGym+cmHFVJ2df
-+WebcOUgcFN3VDR7jepmHYUhpcx3+R7OUyNmY4m3cb+mUXHMb0xhLx7ne9Tw5D0PUcr7T9XO3pjCSEWg
-fPGgIiGGAIVQ6KEpgWkHZTi/pSOQK+Nc+KLOk5z2S/WtQfQiXLnMmUolwpdDkbgJi1lH1NGgSjU5C/CC
-77PAX6mpBopo9crnK4Y6mZyHQRz6dOqHi9FAgsLFCbRJxGW+gYqjodAA20cLyvBm71CErhuOiUL5uAyf
-3/nVDzjgFN6lxRNPdxQ4hu5wpA+0hTzRLq/njtOr1Uvd3GB4Q7zcK3yBGsv42TD33NxQvKXMA+fxI7S1
-UTtEtA4+N7T1KGaSU6voC7ERmO9VVbn52jnA4KX46jWtM872JnW3y6uvNAjJLb9eUVLbiB2Res9Ngf4Q
-EluGXZGR6/s+0eGGJPaZ8O3ivQ8vmPuJC1KX7h52wvYNXv3oD1W+T9iRcS/5Fl6PyMg9wY7onMu9th4R
-SrfvLFHKoOmQGYtL9K3ho9IVXpOBkpa29Cp0itmYf6TPgWeRTL0OWkya4dS4NHTRKtVTP8cX+Ta6sovu
-UtsdquemnlvnPuXnvFSyq0r4zabe4EEVwjVBwWlaFqVISMD11JU50RIsVFelKWiorvy7Fy2Fhe9u596o
-ISvXQfomdLTx7movzkkrM7+hmoFJpS705m2qHAljkTbtWAqUNqJKNytV2kOwQMfQvrnkADW6rBjov+IZ
-FpkW6hRhMXK/aYDbLGa/cQ0YOB9YYSJC+26MHvuWWD95DHmlptg5KWYjAHyFpesGoI55I55ZZE8deaFO
-3+s5VMw5YNeNMvS/XfBlkdk+DQqV9ktbj4jGsNg0rd/E4yJh+2Zxmg6ghlPFlASWbJbpAbrwOcuuYMNq
-0aDidQqjkd1FCvfK7yx5gJ72UvoCO26rZALW3M6lVbfgtWxudIXMzkA0qo8SfXvlNQ02epJLqQ7smKyy
-DVgz+VWwseGubIdLMlRtYmqJnr0wFX3eoXgt80iKtD6x9HjoQEmDAOsVN9L1TKrmk7TrmWquSNO5tpi7
-sc4NKkqVHYU1vkHBHcPCt/TOsGSUWlZGxWNhcRmVFckFLQpjfkTD4llGRMMK/BZGpazxchUGzY/hi1Kv
-5ofeWPbmWHZU41AsiIf8ayR+NA3LYjWZsUs2Z1wNRIOrgB/onXml1ImJNZUxbl6dSw2vK5Z5xhWVVAil
-xeVph8qYn9O8eiZiHMB36Z/mIESqN063t/Lw8M5T8szCSZLP3ZaXN8f36+SLh0LhC6icaq1dsTcASpGo
-W6g1uivSRVy9vLfsgZTc6DXy2AJELRDrRLKluhIaLYRUvFqAfJdTVc1i1gCoPlxo2976Q/bhDzgN1eig
-TsSae0BESCAqRkHi9eBC3M1pZulosnEyGTuYaoyiWiOoXi1ladRtLNDqJCpmzmGEkIbpL+0KNu+PGAo8
-pJv9XKaFNQXSZuK2sUClt++JDwhumP1mzQmsxTXOA7EClsiPiRPZtt5DMOMS2n5M3LiUeZIfRjAwP/Uj
-Yobagr5fZvyA+Sz64ALm8B6qn5Yc4Eio/dz7pf8CUOiVfgnXlgXnolpKPb/Hj8jtjw1GvhGBlsx27qij
-nV7M0863craS560lWZvpRoxsIj2TCYwXv7y+OCZZmrdau017rjOtZ7QKN+GezBdN8XSRPBdV40UWBauZ
-40Zx7fFF400rCTteAJfg32MijyyacEdiJE85tjKmaGryw3ebldydruSXLFa9DafOeu3fvfT4nBCPoOaY
-/G40/B8iWcDwoJgvpZgh9OQQ86mefXHCk52effH/AwAA//9BfgWuzfgAAA==
+H4sIAAAAAAAC/+19bXfbNrLw9/wKRN1bSY0k23lpt7blnsZO2myTNk+Sttvj49NLiZDEmCJVErKs7fq/
+PzMD8E0iSJCmHPfu5rSWRAKDwWAwmBkAM8cPz346/fDb2xdsJubuyYNj/GCu5U2HLe61Th4w+Hc845Yt
+v9LPORcWG8+sIORi2FqKSf/vrdRr4QiXn/z6jr0XlliGx3vyQVwgKfmw32cf/9+SB2s28QN2ZQWOvwzZ
+UjiuI9Y9Znk28zi3uc1GazbyfRGKwFoMPoas30+1GI4DZyFYGIyHrb2P4d7HPxBm//Hg8eDpYO54UKF1
+crwni+kQeR6BJ1wWAQ+5Bx1wfI/wCMXadbxptmGixEyIRZ//sXSuhq1/9n/+tn/qzxdQceTyFhv7ngA4
+w9arF0NuT3lrs7ZnzfmwdeXw1cIPRKrCyrHFbGjzK2fM+/SjxxzPEY7l9sOx5fLhQRoYIHfJAu4OW4gp
+D2ecA7RZwCdAk3EY7sXk6z8ZPBl8RXSB560COuZVMSHlD54/vvSXgijJr6A7bAY03KbfZoOXqiK093Sw
+X609OXbCZ3PrkrPRUgjfC2noxAwaDtnKDy7Z4/7KAlbiYsW5x6L2qFjcWwMcJVUOgCqPjbF878858yfM
+XwbMX3lsyj0eWC6bcXfBAzZZemPkthLeXgX9fSDNgabJcj6IASSDf7yXzPDjkW+v5dcEqO1cMccetjzr
+CjjUtcKQvo+sgMmPvs0n1tKFlgIfOBNfOlOaPCn+ikEpCMjqlgNE2CizWU41gTjmlpV0WljeRoVRAMPa
+SksiLJTT1h40toFm9tHGz23ChNRAq6xnG+V5EPgB1LItYfVHjgcvYMZwazw7ZKkSJeQBURAAB+Pfvg2S
+G3kJKAXCQkerRbpFwa/FIfsbPkGGWtShT4YomY6OLBs6ccV13Uy9b7qXqcow7Nxl9Bfmf+CBPNDUyq1J
+rFdcB/+9p44UFomFwaXPnMkhexv4sEzM2XDIWq3MxC+EsIzQs30huJ0hrfB9VziLQ/Yno4X3kLVfTVAG
+hgz++7gMgYpM8DksNxYsvMCqHgfBcwUrLhQIl7wnC895GFpTzlaO67KpzywSnFBGhNydDNrspnUyd6Yz
+AdKU2UCg473liVnn96D3Jn1NU+rh3ZDqw4wH0GcLVg7QAWSLyxAXLiKK5NUBeyUkXTyfug8T1calJ1h6
+zBcAgn30RyEU8654KFASAqMKWJm8peW6QMMJW/tL5jqXQO0Rx9nAZo4Qsh3O/vcHBO6I/1XrmKQ2tO/5
+zPWJ+ZehBcg1R/OcGV08J3CdKJkQP4Juc6hE85bEwZe0gqFMPh4FxaBenWkBvTqrAOatHsxbczC3m8Kv
+fZiDtESMhRadM+CZgfDxo9ONMSsfa8kwTKwXsAzLH/GyNBIeg/8j+blYum4/wCmcmRVj1xlfwooQgD40
+ADQnTjA/g/ktxVvr5JVoh6BhECPLeS+bMSCZycS/5aSPanBv7C9BlQ64raWxKms+7poGmPVXHEclYxoc
+vgIZonl1G9VCLVAaxSJ++9dXK8Yzbi8BQ/YKl+dKq+Ypsminy07YgfGSeQ6MAgIq4GiQFjP3SyyZz+EX
+93dZ0nTmTTg1lwTvDKjz2pLE6XQrCoDbjCBiHiGnxYyAxriA8gOzpSHpbSK31FwxEly2E85BLX0jp3Pr
+5Ez+LpdadyeMyNBWDptDdrC//z9HcZdXHIQs/umHc9AQF/25FUxzhUsalCx0yPaZtRT+kU4UzZ5tVTgC
+cWSjUIHvsFTDGjVfuBzUz4yBDFYX0HKbLxxv4uJwAL8Ky01mw97sWbk0TPUuDRmZOAuXuHnfVFIG/jSA
+wW9luwrzHIZ/flgIRwerj46L9I9+KAJngbMZLSGefRdJduXaiN7Bq0w/CT00JRQfxH22uWut345xEj9i
+7f8hVb6SEM9C4rakn7kJlC8DNqEm4kA9aM6WKxHi92WYFtyzuScaGioFrfHBUnDTw6Ue/cUGDPrk1x4t
+0PLsZiYVQWp4lAhmMkI4PsCa93586o/G0mtmLJYezuGmR0NCTcZDPfiLzRdpntQeI9cPmxFtCKjhEUKQ
+yfC4Kf/IPRyjW47DaBk0I7gAkNO4MiCBJmMhf9/ZKNyN0o7IfvHFF+S9XXPBHNSR57CCbvQ0zQ+Bv2JS
+5yxR4eMtILd/Hfaf6XT3iR/MM/yyHM0dGImA/7HkoQAT7rvAXy4MtWTHWyxFf1pSY2ujLFWtD2aDH2nu
+wp9OkbmVg1w9jXe1wIBAK1s6zYetF+gFYwDVQS3EmTjwS/jMckOfhZyTR1tuZ+H2pwUGEVglc8uzQwaN
+grRbOWIGpSyRgjBonSQ/jIxm6owyPJGrYxsMSU3Iw4zNzNEry11yJHkprQspByZty9yjt+nDizZOJeKS
+DWD+pRubuuvFzIEesPhbfwE6en/sBGM35UU3c+WVELNwDiIt607CTdnwQCfiQj8QuLsRTYKw0x243JsC
+l+hk3fFsw2Gjl0jbUzx3/zUHB3zWifbpO24v6IJ8D7hYBh5zB44N2AX48Q07YIesf8BuuiWGfqnPoMgL
+WclZYOYw0C0PqRXByJGQ9R9k65B7B0nL8vga9+z9ea4/aGEFIBAG4cxffRdYi1mP5WzgvYe3JHEis2i8
+ZlMsjbIHnoc8lj609pa6OCq4Ocy8G017OBo1n1k8OrkKjhU4Vp/E5tzxhq39zBPretgCTi5Ug7adIT2W
+M74w60i2nklXRI9ZQgQIpp205/mrdgagiSa1KWrquVQKNKna3pTqu+PlCu1fjDXyHDAl7KGqFDJIBmw9
+JqnnzClkk1v4ce4vq6BPZ9d8su36KeSRd1i8gD9S4OrwRh33UQFf1PQc3SuO2PX4bzibikdfunqKxj8C
+V2v0azmsisa/rq/q/soEta2+Y67Ycm8VsgUewSngiQRYHaao4SAr4Ihb+MY+LU/czbhvudMKx/05ubMK
+Rj4BV2fka7nkCsa+pjfuPoz7zswHLvjGeBfZBnHpmsYB1G/WOECAGeOAi/tvHCzHY/i+66kcnVUwn86n
+qkYBD2SB1uGCCEJzbBBBTPggevJJGKG+T37LnZZHw9ilZnNhOW5YvkeQ622R5+z0TpKMxygMiRkyR/OA
+GfAeCMfDpG1llbfZv/+deapMsHYvqowWTaYmaejJ+0XgACrrbBGpsyWFpEjMlJGifKN9XN2TWmraZapF
+jGK4b1TzyKGxwzDnaNmcxFuRN03nyPSveDBx/VX/+pBcma0qE21uue7JsaPzYJ6u7OdWmHKVa4vFHDb2
+XR9kCgi4dcpN6OBXasysf2ZyeFPmvMEDemE1WdMMJbPUnBMe2nOEEs361KlDoV2ugPEJUnbJ17CIhKbz
+xK7SYVucfCvwBo4IAUlRpaa9PQYRKBwF2zbmSndHPXtxveBjPBj77ts3DfQuAgfQBvPRqxen8gztfero
+B2fOG+wpgsPzwsuA7k/urL8pafNO7jlz+8wJL6srOVUoF1EvbpJhm9XIp0iok+GZ3iQq1nfPzclYg5Sm
+YqkWr52CCtWErCA4u+enl6DmveNW6Hs7ZqRUm9vaV6W209R+G/ArCkSA/VgGvAZ3VuUIfY8eNtEjNRh4
+Hf8T9CmPExMWqcKOO56XlRn9xbWDImzn0hLbARvR5rUEZd5a4wgEtzva51EKW0R+3q/BQm49xn8v7J+W
+ojrVoiWmcqXtSYwI1Jq4ueeOUi4Y3Y0ZdJBAswN81aF7/WBRSjzaoEx87oojLPL5VByZ3kZsVB7kkelh
+E4TCnnm+x7Fnd9+lajOp+my67Tx4EQSfdh4AAvdiHgAe93se3JZQ/7fnQS3kaq26b7l1Wd2M1S66CK6m
+GVuDSnU6DBonXhttqL8KWubu6z3r8AvPbqy7BOs+d/ZXy3VFZV+Ftr8RuNq+ijvq9unbnxvstYJ23zv9
+vR+Khnr8vTp/cA97yF69bbCTMgDM3ZhD1N4ZGkMVYhndWguUNDurrQZq6HZWlW73etF3mloQ3spT839V
+38bDyLvx+eesE3vXWhgXM7jCQFrpnctWdG4t+5TOLnV3P2j/cYrLLdbyPJ+pHKia7sVd6QbNO1Kb7uZr
+54pHXZVBXu6+s/9VJv6rTPxXmfivMtEkQ91WYNbmKRSo7KelWNy9z76Gc/GD5bjSj4jn2m7jR7x3HH+3
+6mWih6gDz/JhZUdoTd2xnmu8FqvdMx/2X9c6OYuuZe+eQeKm7jGPxDj+h/OEvPHt8Lthi7i1+80ZMZrN
+bHrVweIf/oiu7EsM6D7+fw5/7uj8oXdV+URY1WPA1QcasLodl+3ibNrOJM7p6g4O/3yPeUNOZ3iFwG7M
+Qp1zBfGv7KJ8zmcWntQL7kDcJ23dY2GfIPmfrAecWguxDLgNevLu+SK6glbbbL0z5kiRJWtPhML2U2fD
+6NddKwhFyPGUsUO//gNZ2l+AXfzScUGzBds49TMKjHUHjI5tMmr0rrh88wZhmgy1j1tZTFjBFFPX/T5y
+Le8yw5jRPVJMmJXkToha/fnda8mYXbyYupE5yTLLH9HcFsd94Obat6wmQFmKDsCtYOJc17iX+96ZO65V
+zUP0SHeTTQFLrovIND5RqLDaZ56lg+t2p58pPGJogRbIo3PgrKPpR/pkN3WkS7ntguQCwEReALhHvsP8
+LckokFY1ybabtCnv+Ny/4hSMrHUif5jFWmyYJjI60P2hyFuOCfY+IUGSMFr3iU0Wn5ZJoqMY94AimGNI
+Zhr6JKSovn2lrj5/wGRvH/0RsxYLWKBCSnTVw2xsMg/c2F+6NiW+W3KKdZvKqEdJ9Fi4HM8YpZHzuMDU
+oxhBT8neI0wAhzEqsQWAZo2FzAs3cTzew0xxlFwu4FeYnEjmlaMIfCH1DG90zy3hjKnOasY9AhalqwOA
+sKByexBdxa6kluww8VQLdEj6wc6M04Y1zBDR/lLli/UJAWTQ33TfK6pt5gQ2FDgvaR+yjsSphJOKdGGA
+lAhomYSP6uh8wnAAZXFQypprOEK5RWGG2dy3rZygKZuBi6kYWC5bzV85IaaiPlTw3mC5X+Sz3lZh27Fc
+f3qK4VPaBLEfztvbxWQqXrSTEAP8dK0RdzNtfE9l2A1YSlv1McQC1vIoQWQ7Ves5vPkAotSFGdvuKfDy
+/ZkKH5MDTxoT+RBf0rsymBmQFBZ4e9BUKuYkwvgeZktvUTI6TRfywj9n4oXh5Oh06ayFmj75wunbgFPK
+0HCpvqwsj5YGjR0g8Uml+JpxfTSiTDKwODa7isrO02HdW9qwlVEIdQWm9aBMKPPyu7MUEn5m2Sm7R9M+
+FjhNmz1k9eByi7nj+dhahlyL/CRzF1mi/82DeiIgc8LBoIs12il/ucldw0rcdeeswixoNZVQ9JuKXc5T
+b7R0uESNVD9+UmPqCJkGGLUwUPIsGcQ5ytSLHR3Poduh8BcwyHy8xMy9R8yaoEsDW0BlbWUB0wK9HDfS
+9UJkRdzNkWpIVxsrp94QB6QBlHeOylkuBkSPR1BNtSu+4fhQUYmxPz6pmXNJlRBmlidQZYXJU6Mj25nr
+q4jYrEwvycQR62yt8jk7Nsy22JTCNJ874lvqV8brLYIl70YOxmiMB2Nr4QjLdf7FKe/may6ACDJSHmbV
+aLcMEkDsGPEJqCoVMT8oxbuS1I1GECbEJx3CapS4PQmMrIoo1wj1RmXWVKojGGeWN+YFdnquHps3i7dV
+WbmntMeDoDl1FmBW1WXdaY8prVbYVdTaqC0TnTaqikF6QURSZbk3iPUM9Mxt8sVJPDfp2AAZx8nG252R
+M9WmUsAHM3NrYRPjXJNhu4nb6/hFDZco+jBivn6nojghdU6QRQKX24Fy7WGDQyLFjpi1GGQc3ppCSBr1
+Bsvyupim2pyj3qXXa6rtNyNSqf1mubOMD6mZos3liurM9kx28YD7DmYxcvidTd926ph+22jKxujlzlV8
+29gkzW3qrzM7Z0/jmEXw1XD2SobStyiB0in3SkChI0YSYeK7rr+6W5ng+cKo4cXJMZ+f/OiD2CKfdVIF
+jTJfLswdMumkyYP+c43pSu73ZGf1eA8gH+8tdiAnbBXgOmxMQtjT6vKhklA4S/JtyaPoZqKBe1d6yWBP
+f8HNl3pak50+Dt4YGfnirugIaDdBQr5ogoZRErWm6EjQdkvJpIkKruikUi416XVja1V+Y3+dxeoTK4We
+b3OD1JAp19cpqoHMikW98NnMmc4o9a10680sx8s4xhyBC0Io0OmFHj3pCTuSWQnwrTXFKsKXR5kG7DTj
+FLWA4uGM27jSuI7HpY8NnjqTCcd8BimXaY+c1qp8RnpRpRHnHgst4YRQONnd1fT3alqSsDA66aaSL9BH
+D2YT0uJQfRYGmT+2+SQs2VGDdi9h5sXM3reA3VYtkAN89dy/Hrb2YdAO8L8WC/jkn5gkgr79Nmw9azFZ
+/VdEbdj6Mvr9PeFGD/zAAepBZ8yCnB8vLBgTQOYNw4ZfY9PP4INw+FeLTWCUh63Pvv76a+ROLFy2Yygx
+KkyeUEil42lu9lFuT3lZ8PFj4ieJQJ9j9WXgdj5L0bmbO+DXB4fwf4+t4XMNn9eP4fdj+A2fa/gMReBf
+8shYInAvAJ3vo4nC7egM5Des/Zn99bMnTydtBrIT6damI7sIoE8c1TaD8wTqHyRVcQ5AD6w15WcLffeK
+Y1bV9tPeU2oJo9sZZkCgvKDbYu/KoTOb9LZsjJHORSM8rTy8JLnKhndawaQl6r7nLh+n0lqJwPJCTNiL
+Fhh+x9Wl02aP2DV6Xnv4bY3fujBq/sIaO2KdAfgjoHnmzOfpkdof0FiZET8AfFgA0/wpTOo1feglUJTg
+BYnza1YapV/J2d+juYqOC9dfBimmjbLEEC0od89wiNH8iVn39/clp3755Zd5nFpQuYBDQbSHIMVzGfR4
+D2lgwqfAluyahBoQ6gA+Jr4n+qHzL9AbDh7nrNyk5hATw8/KLTx5nG3hIPopM0iQk3rcKrCBTFvNn3/j
+uU3e/3NkwoAvfp8GC3xw0TaeltMacxIWzavpDuy1UXITqSkNGUDu2NJIbgs1YGcAurewMwB0Y4SL0Nwd
+4V54V07ge3NU337BhFPQTBM0hJfGNCw0LPJaaZU7fuL1Sd1pKPC2bk3o+BqEzASTuydfbwNpM7N79q26
+R+AQ+vg1r59SU/587C/WR+zx/sGXPfz7FfuOe7gd/46HoLqPZ+y1M8cTG/m69fGebCB5utGhBwVj89G6
+suTTDfwu/YG/wN3DcACaIg9+XgAhQeUf0ibwkb7ne3tksYCtYaFfy/fcNQOhfRmiLbIM8YjpipNdBroT
+HVpdQEFplTgi3IIFq5rH0PhZBuznd697zHUuOdv7hl4Mr6+vUbFgIdJr4Tu4seWQzeSxKVgnm+CWIZlZ
+/qXD5dkCBBvIzBxgTi0XC0DX8UIBk6ywj2gpwCTlLl1esJ0QmHkdnYVdZrsxWXryzNx7evcLVH2DVWEd
+z5EXVsBgoZ8gpYE625GS8eXA+mPpBNCcbA3K4niNMAQeTvpvUQPodDV1ZR1pr1eqOLJsCrIXVGxwzsMQ
+xrhirehAzmYtbQWVxy1Kwgf12u3iouoAbmm5n77VvF/BbMTEOHJ2BGalkA4eX7GS7kNRmvdQ+smz/e1S
+OqrhYZvnlv2eRgoqx9zXcew8hssZXgWlE1XtyOe62vgv4GIZeEwWHLw6Qw3VsfPjfN/k9PmmUv/eSI7K
+9G4eTgu7F3HhdufGM26/wtPxJh2MCw/ehFPsJbTbfDcdbyK9P0MNSnGmwMON2bHfHYBgB7u78yeLeehw
+k6duuj0d2CjVYMOAZX7CpoGqDDQNg6V8hw3DVIkVGx8u4IK3Y7EzNtgB7Ciz/Q6YYQdQVc7tHbDDLmjg
+u/bvwgd9CwDvF/HM73h4ZwlKPpTbllJHxVLpvC3buJBrswJlJyJVJ0hB+etsQMpic2G0xmQAJF2+0Mjh
+3Ke5D1H5IljQsTw8YQJf0PnArZeR1Mx9LWVf/islwXJfkhzKfaOkyUWe+hARWnbkhO0X0RR7PF+6wlm4
+DqkLB/v7bE8SQZ+lAxRgUOLDseXSNbOv/06Xza58xwb1erScosI+AqskFIG1iJM2F4EboYG5mjlg7ahL
+ZiFghXDQmqALTf05hvaEgkVwJuh+5AFthywFbpnwawfUeW/Me4xf0Z00fzmdIf4eXmQrAiYpiFlLkSyF
+NCRa2EC/BQ/GwAjv8XfQOe+kiPtFAU91e6ykaIrDygrH/FZaMOG+sqIRL5aVSzize9EDzugeFdINNHVM
+UJEQ7h09CDqSoGAQFwDIIycK1YuOAnu+f1GlemrNS0AcVAARL21J9cdVqssVLKn8pELlaKFKaj+tUDta
+j5Laz3S1b6rl3NaLazRx9XJGSXtNiRvDddLcbooCNw7Z+UWJSfra9y/JwPxTt1KGfiBwPX+XAlvB9nWm
+Hp4szG/gQY6kCrlggBHKyhUfhT7IQPEgb1FYOZ7trwa/8tF7KgQWzJDhgOMd32L7MOU3GCyW4azT+g29
+J6PAX8FTZvtg4Xu+IC8KdJ/FbYStPEuIcTfkmvZQMqzCn2A+kmigQ+tHesxWkUkdN9lprcLwcG+vBUun
+648p+vlgBjME/Z3wrHWYeUP4wtM92cffV7kop9ob+J4P2KUM0E7RKpvqy7b7LA98iJz9j/c//QhUx8XM
+mayB0VUK2UPWGi+DgMIL3HR1s7QM/zEIjKwFXdQD5J2HUTeKChawyyndTkcWGfueh3twoDWknIVHdNlr
+bq1hLDmdglAOuTXyWewJxBo/v3uNa3vsP9x2C7a6xUnGpHjRl7l5ULl31CekZLZbbGaF8pQEiuiHRYjt
+7X3xxReomsiL/AsfNCE6EBKs6b4978Pwg/hwQnmvbRy3ORgMKgjrhAvmOZ6UQj/IRwzYMmTEmwtQ2niH
+D2gTtpB1sNYA6PDTynsbwMwJxLrTfhn4c3LBtbtlHIUijpx13nI+QhcaHT0Zy1iBxeM8BWyx+fN2JIzb
+F4U1SC1RTsTCgtixgHxArUeW6z5qlfVC8l3snsysjMXcqqRlbD9lV6JNygbTbh1U4jXwPKeN82B6cWGE
+ZKWG/zS6Ut920HMSTHtmpXfjG7szX9md+M7uyJd2F761u/G15XEZF7tvJrpldQfd0bkSq86HW0EpcA+a
+c/Kt6utdfub8d1tK4ojfCkTENrfEg/a/NgEo48UQiIFPsoaP0lDfzVt2arsvcxWAGGgFT6bG1k1glTo1
+zdXVUgO9yAm60bvY/5l+nnV9Jm/SXs/U04zDM3me8nUmDxNn0kabUvJuPo9FpdYvWttP2ozftIYftQqs
+bZfrpl+1CrRaLtg6LtkqwDa8t6Yu2vou29wZsOUE1cyHgnJ6H23uXCkopfXM5s2jQszjWVVQKj3HSj28
+jXt8awm02JuvphZFpZNto+mLU6QaHGA5CqUSsR2eQLK8tTyAVHHOYpK+HrN9DJXBbD4OOJ7fQ+hLeeSq
+0lTD4A1HygsXcBnPzwmjKDYz7i4qwZP0osNVeCgKIzGEOIGTKd2rJJ9g+oNKOkdRonNY6Njmkq/JN5vo
+qb0NjbOX0h17sRbYS/S5XqKZ9dI6Vi+rLV2Ysx+e/eogdg6gtn8EH8fs7/Dx6FGVtWRLlcC+njsXFxTx
+JfLHOxdVYWZ0nhhmCt5RJXA3D5ovuXsCHv/fJWCDOl+u5lm8P1Ntv6a5/ZvK/cv6tqS3NuqvAXyNL2zL
+aRZHvGcHDSCN0lLFhwN5i/5vl5ruxYHKGO4xMT+weWACbb4EzY187+Q0lUFjQY2SAfswgJY6xFriT428
+sT7mIu7BJwKxXPhEwtIii176WDKbANuwLM2GZGuLrdLIFs+dUv/wJPDnPehs8TbIyhHjWUc6nxNnt5EY
+Glsw8okj02gGIlL5NpvZDB7B8nl5ZIxa7Pysi1ysKO8APeUyrYea0s13gVbkZK2JWGQQ7AA16Zith5c0
+QXaAVOTJrYdWZPY0htgtpEZy9I329je3bDZ3qLqYnyFV/nyzwEU+hA9+LGTKAJxv1LhgJ9FO2SlGqzMT
+VCC+1WkFsjTawm/LG54OutJ68SoGb71paAIOw24qRwGtbrQDSosMzUtmjSmYHpiQoD0a4SfMVhRzQvU3
+CFXOYBvDb9LIcGjukpLGTMVumLvIfhp95GMxQBW4uBdx1IMqyJt2oClP6E0zu5iZ5T0178w6XWeBx3+g
+YN1iia8ggOsv9bloVlzsayFaZdHPQbLSsl8PwUrLfx6K1RSAWkhWUARyMKyiCtRCr5JKkINgNaWgForJ
+lq1xG+osycNKZ0kKepm4aY924LapIULUXvknI0js3f6E9LjZlXKp3YQkFw77hh2wQ7Z/VKqgogZtQmc0
+gT2+Ugo3fnS6rF9HJ4qgnFTQF6g9VdHAgWO8oMeujTlHr3yY0mND4GMPNNMAQ0xK5dQUHOmwR6DAtl2X
+AQ9KPdn3OJviMcEA97MoxJIpQAytg6Maq92YY4djxIQ0xqbQKE8PpTHAHjsew0v5gbFm+JBVMWqqzOFC
+VVBzNLr+LC7Vz/P7lvbqNNa58y3YF+xRZYujMuvXwqseWs05rkkW7Hd3K3uLxKuBVBW+CWsIHwrSgYas
+DV7XI5E6Mpp7+tbw5G3187PxVIpvz6MrQh6Uzbuob+hlQDmHJ67pODVFmcLwdSCUM4cdTH0CUMsKhDNe
+uqnTvkfMsuncuSMwwwRhabSKSfqoSZHJdtc1X3foSPOvoOvg4oM9i9KrUYoODCXXNwXleGof2fjAzypq
+NxrsCBHTOY1ARnxqeeoqxhkG9eqa1/X81VakhwSOISCJOsaBTtC/7SGv1N5WTKRHrNMBhEnpoU532R4e
+BNg3xPPGsFxu+Ai5zwHNd6uu0huQKi9YG/WBsuo6UcjFK0/gsLn1CBxxAcUue61cSJruSw9Tta3XvH3m
+VFu1dpy1A3TuXFRn3Zg1KtgnvUo89+D2JbJiXTIizrmdLVKv3hrdDXFEO2TcoRRBFomfkWWrYCQ90MBx
+T5IOnYE4LYOV1JQRgpyQZBPej3tgtg68Cp9btpkncDPwijFFjZ2UOUFhIjTPAMcdjdubcFpz4CjAytKF
+3+pqEo2f2qguA4chpmgSkn0FJleQbBskMZpKd3cljPjmYWn5VICjTKiZsvUvTyrFYWqUmGOPHjmmJnmI
+cCIAIIUMtyWcKJSN5AscO2M3NlR+bYWCRJ1afdXPMuZKQSBVt5NVe43qJgOF1yrNd/k+nadGrsYKb+Nx
+jYMOmd+YwlE8TI+o4cl7yv9E4xfVTp6YwohZYPPiwRaHGAKUTJEPLWKYXlPrWzwDSRinokPVXuQMb2be
+GF31tsYiytNM5lAcvc6Kjwbpbq9TwXdJXLVYVcNovC9cshh0PDn2vdB3+cD1p52WAoXGCbTJ5GW+VhSm
+JEIDdJ/C26cll5zbMvphu8cilA834dP15/wJB5TCu7R44mnNgWLoDsf+gbRQJ9rV9dxefHN9lrc2GF7A
+3xwVMlBDlZwwFVYdYy/SEVptUBQZDIXWhrIRDWf+KrKiz+RGYHpUo8rFt/oBBpUi6zWu00v2JvMu7x+Z
+IKS2/BpFKdpGrInUO1IFmkNIbhnWRUbZ902iQ4okjpn07eK9D8cbu0sbuC7ePayF7Wu8+tEcqrRPWJNw
+z2kLr0Fk1J5gTXROozx4zSEUb99VRCmBlodMT16iL43OFVt4ZREh6ngVaoXETP9TPgfKaxF7HXIxOaqM
+iCYYaPkan6Vb57xa8BztcEQjN3BsnfuUznnJER9uRzctGg0KquAvGDJOkVkUI6EA63u3SYmSWKx5VYpi
+suYTu6RwUaCPCqOh6VZqgI4emPaNhqu8OHVtk/hHt1Kpogu9aZ0q1YUehSjmKpx+fnCZm9voQ2CgY+Tk
+VK5BXYziTNrPLc+wTGN7VFxZ5VcyjR+cJEQ1rgET573ILESo3/XQY18SSimNIVUqijcUY9YBwOdY+qKk
+eJp4HUrbvKOBVBnqtvLAgoZr5YGDDvSi+6icTbgYYzKi8WzpXdItOhZtfth8nrcnTh3UJIetxiU5qVh1
+xnUUcn+DK3o6Q0km2M2Wbrc15VWKqsNc3POrYD7QKuUpTFEOQiaqBfofUqQ6pZEasi+fPXvyZWlpEDwF
+UyeV7DQ9hUBycWteOImoGTtyt22PZP7kGNsqn2+nHatsmOdDtYjrZ1uml2xj0hSZm5Lypsjcl22dLx8A
+q1FHqmrL0DAUlMjQDZkRLz0eIh0GP/A1JZMBNA9ZRCB/Mgm5wKAKeHHUJxZFLeoDvxZn8gGayAWSQjOR
+dBIjrreRqrZjJF3yapoEUKs32DKKE5KxjB/V6Kh5WNR3KIVTTytXlIzrgGSbcpm5LbBWbLTGzAmhL/cT
+6F51H5/hVawA5CvX3D9G/WzhOoKNuFhh/DASkyEdkJcDboO0CTCTj7vuaqlwPQuUev3PN6+/F2Kh1AGd
+ng3lBxjcrtP+7sUHvBy7B2al2Ls62IPJsYcThnvY+M/vXuE08j3keyA1Hg/H4wvtbySDDmUOnT8GanrB
+m88ly8Zv5E96I93d9GZT3BTgCZgBAiH/AGsi6oG0pzdaorelXdA7j9IqGwbvk3r4H+zhcJOXSs9O1I4x
+l+LHImaL0MNOqVQQD4fs8X7pUbpEGiVB+SKWlclnD0k0bomTgWS8Tpr43Z2F2kP2nfqokKXbG+DkeV2y
+C5xmryECOSoiNk1ramloyn4bgj/1tau4WwnlPJL1QLhH4jxqvls5mKPk5SiTRyVmHlZm5gosacZh7W5V
+liggAxk+O1ByZV7mdFJm1GyjozklGm46CKQc7SgLDkWOwFNG/soriNiKGWs84bgECQCTvR7GB4PkxqgM
+5Elt4Rqev+5uZmKvpiunU6HrlGSp/BurvVLRqlCc7FLD0nE27bvUkpE8v74v0Hc/QIH0PC1RcEWs86TI
+nz8DhD2Q5Ndrl7II9LGkCG3kgsYpvUfagiW6LJSIx6BIo6LjkUS3IvEjSwyI0TuVHGsykHBT0YERj99X
+4Teg6Axb+XqQUtZ1glz2BA9OaUZSE5S1OCYrBXYOSUEpJ+btV2SRG/+1WEMRwFDIoGWYJZycfMP1NK5f
+B+kYAeBaMwRwniTfYgSwfh0E4pklCufVjZ4lKi/yyPXG/CByFuutla/Geq3vz2b06cb7k4ifMm3lpmrg
+5M211NzBllt9EC5HmINvlMpJpbJGFu3UPFRl0P9fTpVYEOpWqS0JFQeFPyoIa15VJhsF5C/3Op5FMT80
+adum9VUde0q5Lqtl1IPF/SwV6d9khU81RotGXL/I75Lt2E4du2epRO+65HiLW5CZL2rTOUlAX4XUssGI
+1jGMQnJne7hzR3p0vGG8ZpToWutFj2MA+dLTnr7MAB3Mpx6BPKXE1KFJXrn2Z5N9y37K2wap4srKRhGs
+2589G4337YJy6gADFn3y5Ctr9FW7NPdbWUkVcjpOCl+Woa2sYCoiM/RnPPr7s7H5kQoahfrzJqlecXeL
+KpbZcDX2InKtJk1Zyi9fJZYwt6fVKqic7WbhjqM07mal4/zvh+hg05f5XkF9qikTZXE3NmbHM8vxNkv/
+edOteDAE5UnVAyrID1Szk9kkzjX7bvSN/8MfbbVfJq+TplFiv4tPsEQGVqUdh+3eT3uM3NV683sam9/p
+qZO/WkxLDWIoQdyvPzuBMJDdC0tEzFPYEDEMcoi2hMFeR6606bTPkiXqu2iJapE7f4pu/FbbDJzBRtPf
+BlMu0MzsJBsQBEC7BTHt9pIRpqJFGvHU3J8p+chagx0kWVICL7Z4C9hrmyjvaVw7ascP/hReEdNYLN3B
+BFg0MSKuZ0FDBJgWOHM3lRa5cZDZnOHXOrv9prY6BcNBzdOsoiv5jsfGvruce2GPhUtyv1oCA0wHayqF
+N3mgTK5CJStGiXso1jUpVq6basQRqrN4rvdBGZOkxU0hM1YVNlTeWvwTd+H3C4v8hnHJn+mLgLWhdr61
+t+nzrqgQilJYxbdUSu7PIQSsAEBStQuvqEQ3JSSKmzeMsP5A+KV3jKL656rChSa/W7kPZwuSvB9Avwoi
+UVTMPqo4uGBEklI/TdI8dolHBNAngMfyy7wpqplNqqLMMvMdRohiWNyLuo5ENesKL9EjtjomIBH7+efs
+YdxvQqesB5nCpSnZMrPgo5wFH2EWxPyAUOJ58NHsHmnc8zeWmA3m1rUakV48tJ0M/POPF+nBfcQObhXf
+VEVbyxCi1jZ1ignwpAX9LDuKWVhMw/T6Ix7+KiyY0lhk4UcxXIonlRTyelh6UUg1q4hCWo6G6dqFojAz
+V2IGwXoDmvV/3pRmWcXL9Uirc1n9gv3730WzLlOUaiLP6csTLtcxduwL1lEaL5lMUBlXrK4BkLVqLoEg
+DSoJ4jcTEK414rjRRj/GczuKSXjCnuyzb5LH6HelU7Kd/R57/BWdXRkMBnjiKypT0tKYvCmZpVs5WM7p
+PTn9idbtzyaTSbsEHKgOoUOcQT/B5vp9GizwSIfkFPVADyVm9fOIOS4UsOKW45gCNm9mGaPlvdZc2rVa
+EUVvxvwTMbXiRbykIsUU26gGmoC+EpW4PlBBsmCGPGLpeVFScR1XXOMxxex82CtMlCEbfkzxvwbXZe2o
+crVaCRM96Pa8A42TC6eDAiiatzph0pdq8CMtilM88Ind6ETL7MDChKIdb+m6PRJyXb2g6UsVuhC8tNjp
+b5nhT39rmzszQI3yRMoT12jho4HiePHha7l8xSo9ZUfl+bmCpQ8B78jT5Xq6SUNwk1ZA7QJzZ2WF0f35
+IkgPygzbtJ5KQqYhUwiVw7RXpIvntyLRV2L7lrtSzNwpZfvpVQ0AGtpiTUX4NgqiRMgflQrimFc1vVjN
+HJdjqCrbT5bL/TLZS/KQ6oQzZyKKbm7lSfxKsn5D3pdL+ohBlJRG1oC/ZC7Qo4CHvnsF84AeEd1zrUwZ
+FBEKxb9wRTbhsowHN7XIGNkctBmLpDUyM8t19Srb3DHX018Dd2RMDWO3LE3uH6HamTOfZy9zNikhlMmR
+FRIP8cYYjujW46gfkidiMnQ3GaNmf1/AKH4fydhsp2n93KVYhB6DHprlfHhQ1MuE6Yq4XJG4IEn7TdHQ
+pKZnFmdArgQ14e9qN/Y5n1kYUTPQ7H2P+C328KByvb3vBKsqOymquc45bp0kIAqvkG3073Y73xNYWkLp
+jaVQdmN/4cj87ukDshhgdcSZ7a/odL5Oq5CVXwJIzAGfIsMCVDwdHRSrJRsJEgrtJGC9Ad2z6LT32l3Q
+Ehed7d2F7uCjD3yIJXbEcty7yu8xvKjPa1C5Hq+98K6qMJlqhzbroGoRb230ZyenKjD8k9SAsQRmtR8t
+hUAfkAz+kQdK3Y1VBypSMSXziSTh1h+ZVP2KW/myZtlevixluDMvqWNYWO5RmZQM4kvGRsVzD3nrjgZc
+Ozmb6AWFT2FGGxbHbbR33AqNKUIJSbbKGm/Qw6T54H+7MarpqddTo9lTA1U4FTPsoX515EfRtMxWk+10
+VHPG1YA1Ovodek2lOJ5PeqffvLrZmfWcihFXSKFF/HSLymO98yynesJiBOBl/NMcxFhGTcN+O3MHj37l
+bwvouG7sz+eOkGyX5jfLdXX8RUfP6PJgSrRqVdACQDESupgFhUZOHM9Az+8l4cA2Ikpp+LEESBQrQceS
+JdUjpjksZK8SIC9ToqqYzQoA6e+dlYWZ/JRj+AMuQxoZVKuz5o4amR1bHYBYOg1E07ld/JiKMVeqxFsx
+jrWiUYq0SpBeLHkTJ5i/4yJYV9FAtxdRuXK2A4TUjr90zdCPDjxJPFTEKTAHMCpFaAqkTMUtIwGGbX1p
+fmusjA4Irp18q0wJrEUS5xOR4owv7hMlkgh3n4IYb6Ht+0QNxAcvpX4axsDjYPeKNWQ0xrslxg+O24yo
+uARA7eizIgUIiSi04d32/4xbdqP9V3CrkuBUVot7TxE7ELndkcHINyLRUq44K4py7mCuC8supawMHp6J
+1ENPCklsEJNMNRGHJwfCyy+vzg4VjoNXZ3q9LTfEeVyv2xT1bCecO2HIMdCuuqCrudokC77ZvsQbOrel
+VQQ7nAKV4O8hU9G7TaijMFIBv0sJk1U1KQ711VxdpX5PwUZ+cfgK+JW7m66qS19utD93aE0IO1Czx/7W
+aX8mo5S0u+f7aQ33eA8vQC7EyQP5a+Tb65MHx3szMXdPHvx/WNUKuQ5KAQA=
 `,
 	},
 
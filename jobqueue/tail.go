@@ -0,0 +1,289 @@
+// Copyright © 2018 Genome Research Limited
+// Author: Sendu Bala <sb10@sanger.ac.uk>.
+//
+//  This file is part of wr.
+//
+//  wr is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU Lesser General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  wr is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU Lesser General Public License for more details.
+//
+//  You should have received a copy of the GNU Lesser General Public License
+//  along with wr. If not, see <http://www.gnu.org/licenses/>.
+
+package jobqueue
+
+// This file contains the code for tailing the output of running Jobs: while
+// anyone wants to see it, runners forward chunks of their Cmd's output to the
+// server, which holds on to a bounded amount of the most recent output for
+// Client.Tail() and the web interface to pick up.
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// tailBufferSize is the most recent output of each of a Cmd's STDOUT and
+	// STDERR that runners and the server hold on to for tailers. This limits
+	// the memory a noisy Cmd can use up; tailers of a Cmd that outputs more
+	// than this in between their requests will miss some of it.
+	tailBufferSize = 64 * 1024
+
+	// tailExpiry is how long the server will continue to ask for a Job's output
+	// after the last request for it from a tailer.
+	tailExpiry = 30 * time.Second
+)
+
+// outputChunk holds some of the output of a Job's Cmd, along with the positions
+// in its complete output that the Stdout and Stderr start at. Runners send
+// these to the server, and tailers get them back, along with the current
+// State of the Job. In tail requests, the offsets are the positions the tailer
+// wants output from, or -1 to get all the recent output the server has.
+type outputChunk struct {
+	Stdout       []byte
+	Stderr       []byte
+	StdoutOffset int64
+	StderrOffset int64
+	State        JobState
+}
+
+// tailBuffer holds the most recent tailBufferSize bytes of a stream of output,
+// along with the position in the stream that they end at.
+type tailBuffer struct {
+	data []byte
+	end  int64
+}
+
+// add stores data that starts at the given position in the stream, ignoring
+// any part of it we already have. If it starts after the end of what we
+// already have (because some output was never sent to us), we discard what we
+// have, since it is no longer contiguous with the new data.
+func (tb *tailBuffer) add(offset int64, data []byte) {
+	if excess := len(data) - tailBufferSize; excess > 0 {
+		offset += int64(excess)
+		data = data[excess:]
+	}
+	if offset < tb.end {
+		if offset+int64(len(data)) <= tb.end {
+			return
+		}
+		data = data[tb.end-offset:]
+		offset = tb.end
+	}
+	if offset > tb.end {
+		tb.data = tb.data[:0]
+	}
+	tb.data = append(tb.data, data...)
+	tb.end = offset + int64(len(data))
+	if excess := len(tb.data) - tailBufferSize; excess > 0 {
+		tb.data = append(tb.data[:0], tb.data[excess:]...)
+	}
+}
+
+// since returns a copy of the data we have from the given position onwards,
+// along with the position the returned data starts at. If we no longer have
+// the data at that position (or the position is negative), all the data we
+// have is returned.
+func (tb *tailBuffer) since(offset int64) ([]byte, int64) {
+	start := tb.end - int64(len(tb.data))
+	if offset < start {
+		offset = start
+	}
+	if offset >= tb.end {
+		return nil, tb.end
+	}
+	data := make([]byte, tb.end-offset)
+	copy(data, tb.data[offset-start:])
+	return data, offset
+}
+
+// outputStreamer is written to with the output of a running Cmd, holding on to
+// the most recent output and keeping track of how much of it has been sent to
+// the server.
+type outputStreamer struct {
+	stdout  tailBuffer
+	stderr  tailBuffer
+	sentOut int64
+	sentErr int64
+	active  bool
+	sync.Mutex
+}
+
+// streamWriter is an io.Writer for one of the streams of an outputStreamer.
+type streamWriter struct {
+	os     *outputStreamer
+	stderr bool
+}
+
+// Write implements io.Writer.
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.os.Lock()
+	defer sw.os.Unlock()
+	tb := &sw.os.stdout
+	if sw.stderr {
+		tb = &sw.os.stderr
+	}
+	tb.add(tb.end, p)
+	return len(p), nil
+}
+
+// writer returns an io.Writer for the Cmd's STDOUT, or STDERR if stderr is
+// true.
+func (o *outputStreamer) writer(stderr bool) io.Writer {
+	return &streamWriter{os: o, stderr: stderr}
+}
+
+// setActive records if the server wants us to send it output.
+func (o *outputStreamer) setActive(active bool) {
+	o.Lock()
+	defer o.Unlock()
+	o.active = active
+}
+
+// unsent returns the output that hasn't been sent to the server yet, if the
+// server wants it, noting it as sent. Returns nil if there is nothing to send.
+func (o *outputStreamer) unsent() *outputChunk {
+	o.Lock()
+	defer o.Unlock()
+	if !o.active || (o.sentOut == o.stdout.end && o.sentErr == o.stderr.end) {
+		return nil
+	}
+	chunk := &outputChunk{}
+	chunk.Stdout, chunk.StdoutOffset = o.stdout.since(o.sentOut)
+	chunk.Stderr, chunk.StderrOffset = o.stderr.since(o.sentErr)
+	o.sentOut = o.stdout.end
+	o.sentErr = o.stderr.end
+	return chunk
+}
+
+// outputTail holds the recent output of a Job that someone wants to tail.
+type outputTail struct {
+	stdout     tailBuffer
+	stderr     tailBuffer
+	lastWanted time.Time
+}
+
+// tailWanted tells you if anyone is currently tailing the Job with the given
+// key.
+func (s *Server) tailWanted(key string) bool {
+	s.tailMutex.Lock()
+	defer s.tailMutex.Unlock()
+	return s.tailExists(key)
+}
+
+// tailExists returns true if we have an unexpired outputTail for the given key,
+// removing it if it has expired. You must hold the tailMutex lock.
+func (s *Server) tailExists(key string) bool {
+	t, exists := s.tails[key]
+	if !exists {
+		return false
+	}
+	if time.Since(t.lastWanted) > tailExpiry {
+		delete(s.tails, key)
+		return false
+	}
+	return true
+}
+
+// tailReceive stores output that a runner sent us for the Job with the given
+// key, returning true if someone still wants it.
+func (s *Server) tailReceive(key string, chunk *outputChunk) bool {
+	s.tailMutex.Lock()
+	defer s.tailMutex.Unlock()
+	if !s.tailExists(key) {
+		return false
+	}
+	t := s.tails[key]
+	t.stdout.add(chunk.StdoutOffset, chunk.Stdout)
+	t.stderr.add(chunk.StderrOffset, chunk.Stderr)
+	return true
+}
+
+// tailRead returns the output we have of the Job with the given key from the
+// offsets in the given chunk onwards, along with the Job's current state. If
+// the Job could still run, this registers interest in its output, so that its
+// runner will start or continue to send it to us. Since we hold on to output
+// for every Job being tailed, we refuse (returning ErrTooManyTails) to start
+// tailing a Job when ServerMaxTails other Jobs are already being tailed.
+func (s *Server) tailRead(key string, from *outputChunk) (*outputChunk, string) {
+	state := s.tailState(key)
+
+	s.tailMutex.Lock()
+	defer s.tailMutex.Unlock()
+	chunk := &outputChunk{State: state, StdoutOffset: from.StdoutOffset, StderrOffset: from.StderrOffset}
+	if !s.tailExists(key) {
+		if tailFinished(state, false) {
+			return chunk, ""
+		}
+		if s.tailSweep() >= ServerMaxTails {
+			return nil, ErrTooManyTails
+		}
+		s.tails[key] = &outputTail{}
+	}
+	t := s.tails[key]
+	t.lastWanted = time.Now()
+	chunk.Stdout, chunk.StdoutOffset = t.stdout.since(from.StdoutOffset)
+	chunk.Stderr, chunk.StderrOffset = t.stderr.since(from.StderrOffset)
+	return chunk, ""
+}
+
+// tailSweep forgets about all expired tails, returning the number of tails
+// that remain. You must hold the tailMutex lock.
+func (s *Server) tailSweep() int {
+	for key := range s.tails {
+		s.tailExists(key)
+	}
+	return len(s.tails)
+}
+
+// tailState returns the current state of the Job with the given key.
+func (s *Server) tailState(key string) JobState {
+	item, err := s.q.Get(key)
+	if err == nil && item != nil {
+		return s.itemToJob(item, false, false).State
+	}
+	complete, err := s.db.retrieveCompleteJobsByKeys([]string{key})
+	if err == nil && len(complete) == 1 {
+		return JobStateComplete
+	}
+	return JobStateUnknown
+}
+
+// tailFinished tells you if there's no point tailing a Job any more, given its
+// current state and whether you've seen it running before.
+func tailFinished(state JobState, seenRunning bool) bool {
+	switch {
+	case tailRunning(state):
+		return false
+	case state == JobStateComplete, state == JobStateBuried, state == JobStateDeleted, state == JobStateUnknown:
+		return true
+	}
+	return seenRunning
+}
+
+// tailRunning tells you if a Job in the given state is running.
+func tailRunning(state JobState) bool {
+	return state == JobStateReserved || state == JobStateRunning || state == JobStateLost
+}
+
+// tailWrite writes the data of a chunk that starts at the given position in
+// the stream to w, first noting if any output was missed since the wanted
+// position.
+func tailWrite(w io.Writer, wanted int64, start int64, data []byte) error {
+	if wanted >= 0 && start > wanted {
+		_, err := fmt.Fprintf(w, "\n[... %d bytes of output were missed ...]\n", start-wanted)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(data)
+	return err
+}
@@ -34,6 +34,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/dgryski/go-farm"
 	multierror "github.com/hashicorp/go-multierror"
@@ -53,6 +54,16 @@ var cr = []byte("\r")
 var lf = []byte("\n")
 var ellipses = []byte("[...]\n")
 
+// hostName returns the host name of the machine we're running on, or
+// "localhost" if that can't be determined.
+func hostName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return host
+}
+
 // CurrentIP returns the IP address of the machine we're running on right now.
 // The cidr argument can be an empty string, but if set to the CIDR of the
 // machine's primary network, it helps us be sure of getting the correct IP
@@ -215,7 +226,18 @@ func decompress(compressed []byte) ([]byte, error) {
 // get the current memory usage of a pid, relying on modern linux /proc/*/smaps
 // (based on http://stackoverflow.com/a/31881979/675083).
 func currentMemory(pid int) (int, error) {
-	var err error
+	kb, err := pssKB(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	// convert kB to MB
+	return int(kb / 1024), nil
+}
+
+// pssKB sums the Pss lines of /proc/[pid]/smaps, giving the proportional set
+// size of a pid in kB.
+func pssKB(pid int) (kb uint64, err error) {
 	f, err := os.Open(fmt.Sprintf("/proc/%d/smaps", pid))
 	if err != nil {
 		return 0, err
@@ -231,7 +253,6 @@ func currentMemory(pid int) (int, error) {
 		}
 	}()
 
-	kb := uint64(0)
 	r := bufio.NewScanner(f)
 	for r.Scan() {
 		line := r.Bytes()
@@ -244,14 +265,48 @@ func currentMemory(pid int) (int, error) {
 			kb += size
 		}
 	}
-	if err = r.Err(); err != nil {
-		return 0, err
-	}
+	err = r.Err()
+	return kb, err
+}
 
-	// convert kB to MB
-	mem := int(kb / 1024)
+// diskUsage returns the disk space (in MB) used by the files in the given
+// directories. Like du -x, it does not descend in to other file systems, so
+// any remote file systems mounted within (or at) the directories are not
+// counted. Files that can't be read or are removed while we look are ignored.
+func diskUsage(dirs ...string) int {
+	var blocks int64
+	for _, dir := range dirs {
+		parent, err := os.Stat(filepath.Dir(dir))
+		if err != nil {
+			continue
+		}
+		pst, ok := parent.Sys().(*syscall.Stat_t)
+		if !ok {
+			continue
+		}
+		dev := uint64(pst.Dev)
+
+		_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			st, ok := info.Sys().(*syscall.Stat_t)
+			if !ok {
+				return nil
+			}
+			if uint64(st.Dev) != dev {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			blocks += st.Blocks
+			return nil
+		})
+	}
 
-	return mem, err
+	// Blocks are always 512 bytes
+	return int(blocks * 512 / 1024 / 1024)
 }
 
 // this prefixSuffixSaver-related code is taken from os/exec, since they are not
@@ -490,7 +545,7 @@ func rmEmptyDirs(leafDir, baseDir string) error {
 }
 
 // removeAllExcept deletes the contents of a given directory (absolute path),
-// except for the given folders (relative paths).
+// except for the given folders or files (relative paths).
 func removeAllExcept(path string, exceptions []string) error {
 	keepDirs := make(map[string]bool)
 	checkDirs := make(map[string]bool)
@@ -520,6 +575,10 @@ func removeWithExceptions(path string, keepDirs map[string]bool, checkDirs map[s
 	}
 	for _, entry := range entries {
 		abs := filepath.Join(path, entry.Name())
+		if keepDirs[abs] {
+			continue
+		}
+
 		if !entry.IsDir() {
 			err := os.Remove(abs)
 			if err != nil {
@@ -528,10 +587,6 @@ func removeWithExceptions(path string, keepDirs map[string]bool, checkDirs map[s
 			continue
 		}
 
-		if keepDirs[abs] {
-			continue
-		}
-
 		if checkDirs[abs] {
 			err := removeWithExceptions(abs, keepDirs, checkDirs)
 			if err != nil {
@@ -62,6 +62,7 @@ type Item struct {
 	remainingDeps map[string]bool
 	mutex         sync.RWMutex
 	queueIndexes  [5]int
+	held          string
 }
 
 // ItemStats holds information about the Item's state. Remaining is the time
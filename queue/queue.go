@@ -763,6 +763,8 @@ func (queue *Queue) SetReserveGroup(key string, newGroup string) error {
 // call. If you know you can't handle it right now, but someone else might be
 // able to later, you can manually call Release(), which moves it to the delay
 // sub-queue.
+//
+// Ready items being held by a ReserveFiltered() accept function are skipped.
 func (queue *Queue) Reserve(reserveGroup ...string) (*Item, error) {
 	queue.mutex.Lock()
 
@@ -794,6 +796,51 @@ func (queue *Queue) Reserve(reserveGroup ...string) (*Item, error) {
 	return item, nil
 }
 
+// ReserveFiltered is like Reserve(), but you only get the next ready item that
+// your accept function returns true for; items it returns false for remain in
+// the ready sub-queue in their original order. You get an ErrNothingReady
+// error if no ready item was accepted. Your accept function must not call any
+// methods on the queue.
+//
+// If your accept function rejects an item along with a non-empty hold key, the
+// item is held: it stays in the ready sub-queue, but will not be considered by
+// any Reserve() or ReserveFiltered() call until you call ReleaseHeld() with the
+// same key. Use this when an item can't be accepted until something else
+// happens, so that it isn't needlessly checked on every reserve.
+func (queue *Queue) ReserveFiltered(accept func(item *Item) (bool, string), reserveGroup ...string) (*Item, error) {
+	queue.mutex.Lock()
+
+	if queue.closed {
+		queue.mutex.Unlock()
+		return nil, Error{queue.Name, "ReserveFiltered", "", ErrQueueClosed}
+	}
+
+	// pop an accepted item from the ready queue and add it to the run queue
+	item := queue.readyQueue.popFiltered(accept, reserveGroup...)
+	if item == nil {
+		queue.mutex.Unlock()
+		return item, Error{queue.Name, "ReserveFiltered", "", ErrNothingReady}
+	}
+
+	item.touch()
+	queue.runQueue.push(item)
+	item.switchReadyRun()
+
+	queue.mutex.Unlock()
+	queue.ttrNotificationTrigger(item)
+	queue.changed(SubQueueReady, SubQueueRun, []*Item{item})
+
+	return item, nil
+}
+
+// ReleaseHeld makes ready items that were held by a ReserveFiltered() accept
+// function with the given hold key available to be reserved again.
+func (queue *Queue) ReleaseHeld(key string) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.readyQueue.release(key)
+}
+
 // Touch is a thread-safe way to extend the amount of time a Reserve()d item
 // is allowed to run.
 func (queue *Queue) Touch(key string) error {
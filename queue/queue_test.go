@@ -258,6 +258,77 @@ func TestQueue(t *testing.T) {
 			So(numReadyAdded, ShouldEqual, 3)
 			callBackLock.Unlock()
 
+			Convey("Once ready you can reserve just the ones you accept", func() {
+				var considered []string
+				item, err := queue.ReserveFiltered(func(item *Item) (bool, string) {
+					considered = append(considered, item.Key)
+					return item.Key == "key_1", ""
+				})
+				So(err, ShouldBeNil)
+				So(item, ShouldNotBeNil)
+				So(item.Key, ShouldEqual, "key_1")
+				So(considered, ShouldResemble, []string{"key_0", "key_1"})
+
+				item, err = queue.ReserveFiltered(func(item *Item) (bool, string) {
+					return false, ""
+				})
+				So(err, ShouldNotBeNil)
+				So(item, ShouldBeNil)
+				qerr, ok := err.(Error)
+				So(ok, ShouldBeTrue)
+				So(qerr.Err, ShouldEqual, ErrNothingReady)
+
+				stats = queue.Stats()
+				So(stats.Ready, ShouldEqual, 2)
+				So(stats.Running, ShouldEqual, 1)
+
+				item, err = queue.Reserve()
+				So(err, ShouldBeNil)
+				So(item.Key, ShouldEqual, "key_0")
+				item, err = queue.Reserve()
+				So(err, ShouldBeNil)
+				So(item.Key, ShouldEqual, "key_2")
+			})
+
+			Convey("Items rejected with a hold key are skipped until released", func() {
+				var considered []string
+				item, err := queue.ReserveFiltered(func(item *Item) (bool, string) {
+					considered = append(considered, item.Key)
+					if item.Key == "key_0" {
+						return false, "limited"
+					}
+					return false, ""
+				})
+				So(err, ShouldNotBeNil)
+				So(item, ShouldBeNil)
+				So(considered, ShouldResemble, []string{"key_0", "key_1", "key_2"})
+
+				considered = nil
+				item, err = queue.ReserveFiltered(func(item *Item) (bool, string) {
+					considered = append(considered, item.Key)
+					return false, ""
+				})
+				So(err, ShouldNotBeNil)
+				So(considered, ShouldResemble, []string{"key_1", "key_2"})
+
+				stats = queue.Stats()
+				So(stats.Ready, ShouldEqual, 3)
+
+				item, err = queue.Reserve()
+				So(err, ShouldBeNil)
+				So(item.Key, ShouldEqual, "key_1")
+
+				queue.ReleaseHeld("other")
+				item, err = queue.Reserve()
+				So(err, ShouldBeNil)
+				So(item.Key, ShouldEqual, "key_2")
+
+				queue.ReleaseHeld("limited")
+				item, err = queue.Reserve()
+				So(err, ShouldBeNil)
+				So(item.Key, ShouldEqual, "key_0")
+			})
+
 			Convey("Once ready you should be able to reserve them in the expected order", func() {
 				item1, err := queue.Reserve()
 				So(err, ShouldBeNil)
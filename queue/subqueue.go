@@ -23,9 +23,15 @@ package queue
 
 import (
 	"container/heap"
+	"strings"
 	"sync"
 )
 
+// heldSep separates an item's ReserveGroup from its hold key in the keys of
+// the ready subQueue's groupedItems, so that held items are kept out of the
+// lists that pop() takes from.
+const heldSep = "\x00"
+
 type subQueue struct {
 	mutex        sync.RWMutex
 	items        []*Item
@@ -46,12 +52,21 @@ func newSubQueue(sqIndex int) *subQueue {
 	return queue
 }
 
+// listKey returns the key of the groupedItems list that the given item belongs
+// in: its ReserveGroup, or if it is held, that plus its hold key.
+func listKey(item *Item) string {
+	if item.held == "" {
+		return item.ReserveGroup
+	}
+	return item.ReserveGroup + heldSep + item.held
+}
+
 // push adds an item to the queue
 func (q *subQueue) push(item *Item) {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 	if q.sqIndex == 1 {
-		q.reserveGroup = item.ReserveGroup
+		q.reserveGroup = listKey(item)
 	}
 	heap.Push(q, item)
 }
@@ -80,14 +95,76 @@ func (q *subQueue) pop(reserveGroup ...string) *Item {
 	return heap.Pop(q).(*Item)
 }
 
+// popFiltered removes the next item from the queue according to its
+// "priority" that your accept function returns true for. Items that were
+// rejected are left in the queue, but those rejected with a hold key are held
+// (skipped by future pops) until release() is called with that key. Your
+// accept function must not call methods on the queue.
+func (q *subQueue) popFiltered(accept func(*Item) (bool, string), reserveGroup ...string) *Item {
+	var rejected []*Item
+	var item *Item
+	for {
+		item = q.pop(reserveGroup...)
+		if item == nil {
+			break
+		}
+		ok, holdKey := accept(item)
+		if ok {
+			break
+		}
+		if holdKey != "" {
+			q.hold(item, holdKey)
+			continue
+		}
+		rejected = append(rejected, item)
+	}
+	for _, ritem := range rejected {
+		q.push(ritem)
+	}
+	return item
+}
+
+// hold adds a popped item back to the queue, but under the given hold key, so
+// that it won't be popped again until release() is called with that key.
+func (q *subQueue) hold(item *Item, key string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	item.held = key
+	q.reserveGroup = listKey(item)
+	heap.Push(q, item)
+}
+
+// release makes all items held with the given key poppable again.
+func (q *subQueue) release(key string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	suffix := heldSep + key
+	var heldKeys []string
+	for lk := range q.groupedItems {
+		if strings.HasSuffix(lk, suffix) {
+			heldKeys = append(heldKeys, lk)
+		}
+	}
+	for _, lk := range heldKeys {
+		items := q.groupedItems[lk]
+		delete(q.groupedItems, lk)
+		for _, item := range items {
+			item.held = ""
+			q.reserveGroup = item.ReserveGroup
+			heap.Push(q, item)
+		}
+	}
+}
+
 // remove removes a given item from the queue
 func (q *subQueue) remove(item *Item) {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 	if q.sqIndex == 1 {
-		q.reserveGroup = item.ReserveGroup
+		q.reserveGroup = listKey(item)
 	}
 	heap.Remove(q, item.queueIndexes[q.sqIndex])
+	item.held = ""
 }
 
 // len tells you how many items are in the queue
@@ -129,12 +206,19 @@ func (q *subQueue) firstItem() *Item {
 func (q *subQueue) update(item *Item, oldGroup ...string) {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
-	if q.sqIndex == 1 && len(oldGroup) == 1 && oldGroup[0] != item.ReserveGroup {
-		q.reserveGroup = oldGroup[0]
-		heap.Remove(q, item.queueIndexes[q.sqIndex])
-		q.reserveGroup = item.ReserveGroup
-		heap.Push(q, item)
-		return
+	if q.sqIndex == 1 {
+		if len(oldGroup) == 1 && oldGroup[0] != item.ReserveGroup {
+			q.reserveGroup = oldGroup[0]
+			if item.held != "" {
+				q.reserveGroup += heldSep + item.held
+			}
+			heap.Remove(q, item.queueIndexes[q.sqIndex])
+			item.held = ""
+			q.reserveGroup = item.ReserveGroup
+			heap.Push(q, item)
+			return
+		}
+		q.reserveGroup = listKey(item)
 	}
 	heap.Fix(q, item.queueIndexes[q.sqIndex])
 }
@@ -41,6 +41,7 @@ type Protector struct {
 	lastProcess    time.Time
 	reprocessing   bool
 	availabilityCb AvailabilityCallback
+	releaseCb      func()
 	disabled       bool
 	mu             sync.RWMutex
 }
@@ -95,6 +96,16 @@ func (p *Protector) SetAvailabilityCallback(callback AvailabilityCallback) {
 	p.availabilityCb = callback
 }
 
+// SetReleaseCallback sets a callback that will be called whenever the tokens
+// of a granted request are returned to the pool, be that due to Release() or
+// the releaseTimeout supplied to New(). It is useful for knowing when it is
+// worth trying a TryRequest() again.
+func (p *Protector) SetReleaseCallback(callback func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.releaseCb = callback
+}
+
 // Request lets you request that a desired number of tokens be granted to you
 // for use.
 //
@@ -102,7 +113,10 @@ func (p *Protector) SetAvailabilityCallback(callback AvailabilityCallback) {
 // WaitUntilGranted(), then to Touch() periodically until you're no longer using
 // the resource, then finally to Release().
 func (p *Protector) Request(numTokens int) (Receipt, error) {
-	if numTokens > p.maxTokens {
+	p.mu.RLock()
+	maxTokens := p.maxTokens
+	p.mu.RUnlock()
+	if numTokens > maxTokens {
 		return Receipt(""), Error{p.Name, "Request", Receipt(""), ErrOverMaximumTokens}
 	}
 
@@ -136,6 +150,65 @@ func (p *Protector) Request(numTokens int) (Receipt, error) {
 	return r.id, nil
 }
 
+// TryRequest is a non-blocking alternative to Request(). If the desired number
+// of tokens can be granted right now (no earlier requests are still pending,
+// there are enough free tokens, and at least delayBetween has passed since
+// the last grant), they are granted immediately and you get back a Receipt for
+// the granted request along with true. You should then Touch() and Release()
+// as with Request(). Otherwise nothing is queued and you get back false.
+func (p *Protector) TryRequest(numTokens int) (Receipt, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.disabled || len(p.pending) > 0 || p.usedTokens+numTokens > p.maxTokens {
+		return Receipt(""), false
+	}
+	if !p.lastProcess.IsZero() && time.Since(p.lastProcess) < p.delayBetween {
+		return Receipt(""), false
+	}
+	if availableTokens, checked := p.availableTokens(); checked && availableTokens < numTokens {
+		return Receipt(""), false
+	}
+
+	u, _ := uuid.NewV4()
+	r := &request{
+		id:        Receipt(u.String()),
+		grantedCh: make(chan bool, 1),
+		cancelCh:  make(chan bool, 1),
+		releaseCh: make(chan bool, 1),
+		touchCh:   make(chan bool, 1),
+		numTokens: numTokens,
+	}
+	p.requests[r.id] = r
+	p.usedTokens += numTokens
+	p.lastProcess = time.Now()
+	r.grant()
+	go p.manageRelease(r)
+	return r.id, true
+}
+
+// SetLimits lets you change the delayBetween and maxSimultaneous values you
+// supplied to New(). Tokens that have already been granted are unaffected, even
+// if that means more than the new maxSimultaneous are currently in use.
+func (p *Protector) SetLimits(delayBetween time.Duration, maxSimultaneous int) {
+	p.mu.Lock()
+	p.delayBetween = delayBetween
+	p.maxTokens = maxSimultaneous
+	pending := len(p.pending) > 0
+	p.mu.Unlock()
+	if pending {
+		go p.reprocess()
+	}
+}
+
+// Usage tells you how many tokens are currently in use, and the maximum that
+// can be in use at once.
+func (p *Protector) Usage() (used, max int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.usedTokens, p.maxTokens
+}
+
 // WaitUntilGranted will block until the request corresponding to the given
 // Receipt has been granted its tokens, whereupon you can start using the
 // protected resource.
@@ -271,7 +344,7 @@ func (p *Protector) process() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	pendingLen := len(p.pending)
-	if p.usedTokens == p.maxTokens || pendingLen == 0 {
+	if p.usedTokens >= p.maxTokens || pendingLen == 0 {
 		return
 	}
 	availableTokens, checked := p.availableTokens()
@@ -299,35 +372,7 @@ func (p *Protector) process() {
 	// manage the deliberate or automatic release of these resource "tokens" in
 	// a goroutine. (not sure if having 1 goroutine per active request will be
 	// an issue...)
-	go func() {
-		for {
-			limit := time.After(p.releaseTimeout)
-			select {
-			case <-r.releaseCh:
-				// released on request
-			case <-limit:
-				// released after releaseTimeout
-				r.finish()
-			case <-r.touchCh:
-				// Touch() was called, loop to reset the timeout
-				continue
-			}
-
-			// return the used tokens to the pool for future use
-			p.mu.Lock()
-			p.usedTokens -= r.numTokens
-			delete(p.requests, r.id)
-			if len(p.pending) > 0 {
-				// now that we've released tokens, call process() again, making
-				// sure we obey delayBetween
-				p.mu.Unlock()
-				p.reprocess()
-			} else {
-				p.mu.Unlock()
-			}
-			break
-		}
-	}()
+	go p.manageRelease(r)
 
 	if pendingLen > 1 {
 		// arrange for the next request to be taken care of after the desired
@@ -336,6 +381,42 @@ func (p *Protector) process() {
 	}
 }
 
+// manageRelease waits for a granted request to be released, or to time out,
+// then returns its tokens to the pool.
+func (p *Protector) manageRelease(r *request) {
+	for {
+		limit := time.After(p.releaseTimeout)
+		select {
+		case <-r.releaseCh:
+			// released on request
+		case <-limit:
+			// released after releaseTimeout
+			r.finish()
+		case <-r.touchCh:
+			// Touch() was called, loop to reset the timeout
+			continue
+		}
+
+		// return the used tokens to the pool for future use
+		p.mu.Lock()
+		p.usedTokens -= r.numTokens
+		delete(p.requests, r.id)
+		releaseCb := p.releaseCb
+		if len(p.pending) > 0 {
+			// now that we've released tokens, call process() again, making
+			// sure we obey delayBetween
+			p.mu.Unlock()
+			p.reprocess()
+		} else {
+			p.mu.Unlock()
+		}
+		if releaseCb != nil {
+			releaseCb()
+		}
+		break
+	}
+}
+
 // reprocess calls process() after at least the desired delay, throwing away
 // additional requests during that time.
 func (p *Protector) reprocess() {
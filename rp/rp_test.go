@@ -327,5 +327,64 @@ func TestRP(t *testing.T) {
 			So(rp.WaitUntilGranted(r), ShouldBeTrue)
 			So(time.Now(), ShouldHappenOnOrBetween, begin.Add(time.Duration(delayInt*tooBusyFor)*time.Millisecond), begin.Add(time.Duration(delayInt*tooBusyFor)*time.Millisecond).Add(halfDelay))
 		})
+
+		Convey("TryRequest() grants immediately or not at all, obeying the delay and max", func() {
+			r, ok := rp.TryRequest(1)
+			So(ok, ShouldBeTrue)
+			granted, _ := rp.Granted(r)
+			So(granted, ShouldBeTrue)
+
+			_, ok = rp.TryRequest(1)
+			So(ok, ShouldBeFalse)
+
+			<-time.After(delayBetween)
+			r2, ok := rp.TryRequest(2)
+			So(ok, ShouldBeTrue)
+			used, max := rp.Usage()
+			So(used, ShouldEqual, 3)
+			So(max, ShouldEqual, maxSimultaneous)
+
+			<-time.After(delayBetween)
+			_, ok = rp.TryRequest(1)
+			So(ok, ShouldBeFalse)
+
+			rp.Release(r2)
+			<-time.After(halfDelay)
+			used, _ = rp.Usage()
+			So(used, ShouldEqual, 1)
+
+			Convey("SetLimits() changes the max and delay", func() {
+				rp.SetLimits(0, 1)
+				_, ok = rp.TryRequest(1)
+				So(ok, ShouldBeFalse)
+
+				rp.Release(r)
+				<-time.After(halfDelay)
+				_, ok = rp.TryRequest(1)
+				So(ok, ShouldBeTrue)
+				used, max = rp.Usage()
+				So(used, ShouldEqual, 1)
+				So(max, ShouldEqual, 1)
+			})
+		})
+
+		Convey("ReleaseCallbacks are called when tokens are returned", func() {
+			released := make(chan bool, 1)
+			rp.SetReleaseCallback(func() {
+				used, _ := rp.Usage()
+				released <- used == 0
+			})
+			r, ok := rp.TryRequest(1)
+			So(ok, ShouldBeTrue)
+			rp.Release(r)
+			So(<-released, ShouldBeTrue)
+		})
+
+		Convey("TryRequest() doesn't jump the queue of pending Request()s", func() {
+			_, err := rp.Request(maxSimultaneous)
+			So(err, ShouldBeNil)
+			_, ok := rp.TryRequest(1)
+			So(ok, ShouldBeFalse)
+		})
 	})
 }